@@ -146,4 +146,31 @@ var (
 		Name: "is_test_net",
 		Usage: "start the test net",
 	}
+
+	MnemonicLangFlag = cli.StringFlag{
+		Name: "lang",
+		Usage: "Mnemonic word list language: english, chinese_simplified, chinese_traditional, japanese, korean, spanish, french, italian",
+		Value: "english",
+	}
+
+	SharesFlag = cli.BoolFlag{
+		Name: "shares",
+		Usage: "Recover wallet key by Shamir seed shares instead of mnemonic",
+	}
+
+	OfflineFileFlag = cli.StringFlag{
+		Name: "file",
+		Usage: "Offline transaction envelope file, input the QR chunks if it is not set",
+	}
+
+	ChunkSizeFlag = cli.IntFlag{
+		Name: "chunk",
+		Usage: "Max length of each QR chunk of the signed envelope",
+		Value: 300,
+	}
+
+	QuarantineFlag = cli.BoolFlag{
+		Name: "quarantine",
+		Usage: "Move the corrupt key files into the quarantine directory of the key dir",
+	}
 )
\ No newline at end of file
@@ -16,9 +16,16 @@
 package commands
 
 import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
 	"github.com/blocktree/openwallet/v2/assets"
 	"github.com/blocktree/openwallet/v2/cmd/utils"
+	"github.com/blocktree/openwallet/v2/console"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/blocktree/openwallet/v2/wmd"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -160,6 +167,111 @@ This command will Backup wallet key in filePath: ./data/<symbol>/key/.
 					utils.SymbolFlag,
 				},
 			},
+			{
+				//创建助记词备份的钱包密钥
+				Name:     "mnemonic",
+				Usage:    "Create a wallet key with BIP39 mnemonic paper backup",
+				Action:   createMnemonicWalletKey,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.SymbolFlag,
+					utils.MnemonicLangFlag,
+				},
+				Description: `
+	wmd wallet mnemonic -s <symbol> --lang english
+
+This command will create a wallet key in filePath: ./data/<symbol>/key/,
+and print the mnemonic words, please write them down on paper.
+
+	`,
+			},
+			{
+				//通过助记词恢复钱包密钥
+				Name:     "recover",
+				Usage:    "Recover a wallet key by BIP39 mnemonic",
+				Action:   recoverMnemonicWalletKey,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.SymbolFlag,
+					utils.MnemonicLangFlag,
+					utils.SharesFlag,
+				},
+				Description: `
+	wmd wallet recover -s <symbol> --lang english
+
+This command will recover the wallet key by mnemonic words and passphrase,
+the key file is saved in filePath: ./data/<symbol>/key/.
+
+	wmd wallet recover -s <symbol> --shares
+
+This command will recover the wallet key by Shamir seed shares created by
+wmd wallet split, the key file is saved in filePath: ./data/<symbol>/key/.
+
+	`,
+			},
+			{
+				//分割钱包种子
+				Name:     "split",
+				Usage:    "Split a wallet seed into Shamir shares",
+				Action:   splitWalletKey,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.SymbolFlag,
+				},
+				Description: `
+	wmd wallet split -s <symbol>
+
+This command will split the wallet seed into N shares, any M of them can
+recover the wallet key by wmd wallet recover -s <symbol> --shares.
+Please keep the shares in different places.
+
+	`,
+			},
+			{
+				//离线签名交易单
+				Name:     "offlinesign",
+				Usage:    "Sign an offline transaction envelope on an air-gapped machine",
+				Action:   offlineSignTransaction,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.SymbolFlag,
+					utils.OfflineFileFlag,
+					utils.ChunkSizeFlag,
+				},
+				Description: `
+	wmd wallet offlinesign -s <symbol> --file <envelope file>
+
+This command will sign the transaction envelope exported by the online host
+with the wallet key in filePath: ./data/<symbol>/key/. The signed envelope is
+saved in <envelope file>.signed and printed as QR chunks.
+
+	wmd wallet offlinesign -s <symbol> --chunk 300
+
+Without --file, input the QR chunks of the envelope one by one.
+
+	`,
+			},
+			{
+				//审计钱包密钥目录
+				Name:     "audit",
+				Usage:    "Audit the integrity of wallet key files",
+				Action:   auditWalletKeys,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.SymbolFlag,
+					utils.QuarantineFlag,
+				},
+				Description: `
+	wmd wallet audit -s <symbol> [--quarantine]
+
+This command will check every key file in filePath: ./data/<symbol>/key/.
+With the wallet password the MAC and keyid are verified, an empty password
+only checks the JSON structure. Duplicate keyid and wallet db files in
+./data/<symbol>/db/ without key file are also reported.
+With --quarantine, the corrupt key files are moved into ./data/<symbol>/key/quarantine/.
+
+	`,
+			},
 		},
 	}
 )
@@ -323,3 +435,416 @@ func restoreWallet(c *cli.Context) error {
 	}
 	return err
 }
+
+//createMnemonicWalletKey 创建助记词备份的钱包密钥
+func createMnemonicWalletKey(c *cli.Context) error {
+	symbol := c.String("symbol")
+	if len(symbol) == 0 {
+		log.Error("Argument -s <symbol> is missing")
+		return nil
+	}
+	language := c.String("lang")
+
+	mnemonic, err := hdkeystore.NewMnemonic(hdkeystore.DefaultMnemonicBitSize, language)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	key, keyFile, err := storeMnemonicWalletKey(symbol, mnemonic, language)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("\nWallet key has been created successfully.\n")
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	fmt.Printf("Key file: %s\n", keyFile)
+	fmt.Printf("\nPlease write down the mnemonic words on paper and keep them safe:\n\n")
+	fmt.Printf("%s\n\n", mnemonic)
+	return nil
+}
+
+//recoverMnemonicWalletKey 通过助记词恢复钱包密钥
+func recoverMnemonicWalletKey(c *cli.Context) error {
+	symbol := c.String("symbol")
+	if len(symbol) == 0 {
+		log.Error("Argument -s <symbol> is missing")
+		return nil
+	}
+	if c.Bool("shares") {
+		return recoverSharesWalletKey(symbol)
+	}
+
+	language := c.String("lang")
+
+	mnemonic, err := console.InputText("Enter mnemonic words: ", true)
+	if err != nil {
+		return err
+	}
+
+	key, keyFile, err := storeMnemonicWalletKey(symbol, mnemonic, language)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("\nWallet key has been recovered successfully.\n")
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	fmt.Printf("Key file: %s\n", keyFile)
+	return nil
+}
+
+//storeMnemonicWalletKey 输入钱包别名，密码和助记词密码短语，保存助记词生成的钱包密钥
+func storeMnemonicWalletKey(symbol, mnemonic, language string) (*hdkeystore.HDKey, string, error) {
+
+	alias, err := console.InputText("Enter wallet's name: ", true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	password, err := console.InputPassword(true, 8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//密码短语不回显，输入错误会恢复出其他密钥，需要二次确认
+	passphrase, err := console.Stdin.PromptPassword("Enter mnemonic passphrase (optional): ")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(passphrase) > 0 {
+		confirm, err := console.Stdin.PromptPassword("Confirm mnemonic passphrase: ")
+		if err != nil {
+			return nil, "", err
+		}
+		if confirm != passphrase {
+			return nil, "", fmt.Errorf("the two passphrase is not equal")
+		}
+	}
+
+	return hdkeystore.StoreHDKeyWithMnemonic(openwallet.GetKeyDir(symbol), alias, password, mnemonic, passphrase, language, hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+}
+
+//splitWalletKey 把钱包种子分割为Shamir分片
+func splitWalletKey(c *cli.Context) error {
+	symbol := c.String("symbol")
+	if len(symbol) == 0 {
+		log.Error("Argument -s <symbol> is missing")
+		return nil
+	}
+
+	wallets, err := openwallet.GetWalletsByKeyDir(openwallet.GetKeyDir(symbol))
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+	if len(wallets) == 0 {
+		log.Error("No wallet key in ", openwallet.GetKeyDir(symbol))
+		return nil
+	}
+	for _, w := range wallets {
+		fmt.Printf("%s\t%s\n", w.WalletID, w.Alias)
+	}
+
+	walletID, err := console.InputText("Enter wallet ID: ", true)
+	if err != nil {
+		return err
+	}
+
+	var wallet *openwallet.Wallet
+	for _, w := range wallets {
+		if w.WalletID == walletID {
+			wallet = w
+		}
+	}
+	if wallet == nil {
+		log.Error("The wallet: ", walletID, " is not exist")
+		return nil
+	}
+
+	password, err := console.InputPassword(false, 0)
+	if err != nil {
+		return err
+	}
+
+	key, err := wallet.HDKey(password)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	total, err := console.InputNumber("Enter the number of shares: ", false)
+	if err != nil {
+		return err
+	}
+
+	threshold, err := console.InputNumber("Enter the number of shares required to recover: ", false)
+	if err != nil {
+		return err
+	}
+
+	shares, err := hdkeystore.SplitHDKey(key, int(total), int(threshold))
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("\nWallet seed has been split into %d shares, any %d of them can recover the wallet key.\n", total, threshold)
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	fmt.Printf("\nPlease keep the shares in different places:\n\n")
+	for i, share := range shares {
+		fmt.Printf("Share %d: %s\n", i+1, share)
+	}
+	fmt.Printf("\n")
+	return nil
+}
+
+//recoverSharesWalletKey 输入Shamir分片恢复钱包密钥
+func recoverSharesWalletKey(symbol string) error {
+
+	first, err := console.InputText("Enter share 1: ", true)
+	if err != nil {
+		return err
+	}
+
+	share, err := hdkeystore.ParseSeedShare(first)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	shares := []string{first}
+	for i := 2; i <= share.Threshold; i++ {
+		s, err := console.InputText(fmt.Sprintf("Enter share %d: ", i), true)
+		if err != nil {
+			return err
+		}
+		shares = append(shares, s)
+	}
+
+	alias, err := console.InputText("Enter wallet's name: ", true)
+	if err != nil {
+		return err
+	}
+
+	password, err := console.InputPassword(true, 8)
+	if err != nil {
+		return err
+	}
+
+	key, keyFile, err := hdkeystore.StoreHDKeyWithShares(openwallet.GetKeyDir(symbol), alias, password, shares, hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("\nWallet key has been recovered successfully.\n")
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	fmt.Printf("Key file: %s\n", keyFile)
+	return nil
+}
+
+//offlineSignTransaction 离线签名交易单信封
+func offlineSignTransaction(c *cli.Context) error {
+	symbol := c.String("symbol")
+	if len(symbol) == 0 {
+		log.Error("Argument -s <symbol> is missing")
+		return nil
+	}
+
+	file := c.String("file")
+	text, err := readOfflineEnvelope(file)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	env, err := openwallet.DecodeOfflineTxEnvelope(text)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	account := env.Account()
+	if !strings.EqualFold(account.Symbol, symbol) {
+		log.Error("The envelope symbol: ", account.Symbol, " is not ", symbol)
+		return nil
+	}
+
+	//核对交易内容
+	fmt.Printf("\nWalletID: %s\n", env.WalletID)
+	fmt.Printf("AccountID: %s\n", env.AccountID)
+	if env.RawTx != nil {
+		for to, amount := range env.RawTx.To {
+			fmt.Printf("To: %s\tAmount: %s\n", to, amount)
+		}
+		fmt.Printf("Fees: %s\n", env.RawTx.Fees)
+	} else {
+		fmt.Printf("Contract: %s\tABI: %v\tValue: %s\n", env.ContractTx.TxTo, env.ContractTx.ABIParam, env.ContractTx.Value)
+		fmt.Printf("Fees: %s\n", env.ContractTx.Fees)
+	}
+	for _, ks := range env.Signatures()[openwallet.SignatureOwnerKey(account)] {
+		fmt.Printf("Sign: %s\t%s\n", ks.Address.HDPath, ks.Message)
+	}
+
+	confirm, err := console.Stdin.PromptConfirm("Confirm to sign the transaction")
+	if err != nil || !confirm {
+		return err
+	}
+
+	wallets, err := openwallet.GetWalletsByKeyDir(openwallet.GetKeyDir(symbol))
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+	var wallet *openwallet.Wallet
+	for _, w := range wallets {
+		if w.WalletID == env.WalletID {
+			wallet = w
+		}
+	}
+	if wallet == nil {
+		log.Error("The wallet: ", env.WalletID, " key is not in ", openwallet.GetKeyDir(symbol))
+		return nil
+	}
+
+	password, err := console.InputPassword(false, 0)
+	if err != nil {
+		return err
+	}
+
+	key, err := wallet.HDKey(password)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	if err = env.Sign(key); err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	signed, err := env.Encode()
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	if len(file) > 0 {
+		if err = ioutil.WriteFile(file+".signed", []byte(signed), 0600); err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+		fmt.Printf("\nSigned envelope has been saved in: %s.signed\n", file)
+	}
+
+	chunks, err := openwallet.SplitOfflineTxEnvelope(signed, c.Int("chunk"))
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+	fmt.Printf("\nSigned envelope QR chunks:\n\n")
+	for _, chunk := range chunks {
+		fmt.Printf("%s\n\n", chunk)
+	}
+	return nil
+}
+
+//readOfflineEnvelope 读取信封文件，或输入全部二维码分片
+func readOfflineEnvelope(file string) (string, error) {
+
+	if len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	first, err := console.InputText("Enter chunk 1: ", true)
+	if err != nil {
+		return "", err
+	}
+
+	//分片格式：owtx:序号/总数:信封标识:内容
+	var index, total int
+	fields := strings.SplitN(first, ":", 4)
+	if len(fields) != 4 {
+		return "", fmt.Errorf("offline transaction chunk is invalid")
+	}
+	if _, err = fmt.Sscanf(fields[1], "%d/%d", &index, &total); err != nil {
+		return "", fmt.Errorf("offline transaction chunk is invalid")
+	}
+
+	chunks := []string{first}
+	for i := 2; i <= total; i++ {
+		chunk, err := console.InputText(fmt.Sprintf("Enter chunk %d: ", i), true)
+		if err != nil {
+			return "", err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return openwallet.CombineOfflineTxChunks(chunks)
+}
+
+//auditWalletKeys 审计钱包密钥目录
+func auditWalletKeys(c *cli.Context) error {
+	symbol := c.String("symbol")
+	if len(symbol) == 0 {
+		log.Error("Argument -s <symbol> is missing")
+		return nil
+	}
+
+	fmt.Printf("Enter the wallet password to verify the MAC, or press enter to check the structure only.\n")
+	password, err := console.InputPassword(false, 0)
+	if err != nil {
+		return err
+	}
+
+	report, err := hdkeystore.AuditKeyDir(openwallet.GetKeyDir(symbol), hdkeystore.AuditOptions{
+		Password:   password,
+		DBDir:      openwallet.GetDBDir(symbol),
+		Quarantine: c.Bool("quarantine"),
+	})
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("\nKey directory: %s\n\n", report.Dir)
+	for _, k := range report.Keys {
+		fmt.Printf("[%s]\t%s\n", k.Status, k.Path)
+		if len(k.KeyID) > 0 {
+			fmt.Printf("\tAlias: %s\tKeyID: %s\tVersion: %d\tKDF: %s\n", k.Alias, k.KeyID, k.Version, k.KDF)
+		}
+		if len(k.Err) > 0 {
+			fmt.Printf("\tError: %s\n", k.Err)
+		}
+		if len(k.Quarantined) > 0 {
+			fmt.Printf("\tQuarantined: %s\n", k.Quarantined)
+		}
+	}
+
+	for keyID, paths := range report.Duplicates {
+		fmt.Printf("\nDuplicate KeyID: %s\n", keyID)
+		for _, path := range paths {
+			fmt.Printf("\t%s\n", path)
+		}
+	}
+
+	if len(report.OrphanDBFiles) > 0 {
+		fmt.Printf("\nWallet db files without key file:\n")
+		for _, path := range report.OrphanDBFiles {
+			fmt.Printf("\t%s\n", path)
+		}
+	}
+
+	if report.Healthy() {
+		fmt.Printf("\nAll %d key files are healthy.\n", len(report.Keys))
+	} else {
+		fmt.Printf("\nThe key directory has problems, please check the report above.\n")
+	}
+	return nil
+}
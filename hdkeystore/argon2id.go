@@ -0,0 +1,102 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// KDFArgon2id 密钥文件kdf字段的argon2id标识
+	KDFArgon2id = "argon2id"
+
+	argon2idDKLen = 32
+
+	// 密钥文件中argon2id参数的上限，防止构造的密钥文件消耗过多内存和计算
+	maxArgon2idTime    = 16
+	maxArgon2idMemory  = 1024 * 1024 //1GB
+	maxArgon2idThreads = 64
+	maxArgon2idDKLen   = 64
+)
+
+// Argon2idParams argon2id的计算参数
+type Argon2idParams struct {
+	// Time 迭代次数
+	Time uint32
+	// Memory 内存开销，单位KiB
+	Memory uint32
+	// Threads 并行线程数
+	Threads uint8
+}
+
+var (
+	// StandardArgon2idParams 使用64MB内存，RFC 9106推荐的第二组参数
+	StandardArgon2idParams = Argon2idParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// LightArgon2idParams 使用4MB内存，用于测试或低配置设备
+	LightArgon2idParams = Argon2idParams{Time: 1, Memory: 4 * 1024, Threads: 4}
+)
+
+// validate 检查参数在允许的范围内
+func (params Argon2idParams) validate() error {
+	if params.Time == 0 || params.Time > maxArgon2idTime ||
+		params.Memory == 0 || params.Memory > maxArgon2idMemory ||
+		params.Threads == 0 || params.Threads > maxArgon2idThreads {
+		return fmt.Errorf("Invalid Argon2id params: %+v", params)
+	}
+	return nil
+}
+
+// argon2idParamsOf 读取密钥文件中的argon2id参数，超出范围返回错误
+func argon2idParamsOf(kdfParams map[string]interface{}) (Argon2idParams, int, error) {
+	t := ensureInt(kdfParams["t"])
+	m := ensureInt(kdfParams["m"])
+	p := ensureInt(kdfParams["p"])
+	dkLen := ensureInt(kdfParams["dklen"])
+	if t <= 0 || t > maxArgon2idTime || m <= 0 || m > maxArgon2idMemory || p <= 0 || p > maxArgon2idThreads ||
+		dkLen <= 0 || dkLen > maxArgon2idDKLen {
+		return Argon2idParams{}, 0, fmt.Errorf("Invalid Argon2id params: t=%d, m=%d, p=%d, dklen=%d", t, m, p, dkLen)
+	}
+	return Argon2idParams{Time: uint32(t), Memory: uint32(m), Threads: uint8(p)}, dkLen, nil
+}
+
+// EncryptKeyArgon2id 使用argon2id派生密钥加密HDKey，生成版本为2的密钥文件内容
+func EncryptKeyArgon2id(hdkey *HDKey, auth string, params Argon2idParams) ([]byte, error) {
+
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		panic("reading from crypto/rand failed: " + err.Error())
+	}
+	derivedKey := argon2.IDKey([]byte(auth), salt, params.Time, params.Memory, params.Threads, argon2idDKLen)
+
+	argon2idParamsJSON := make(map[string]interface{}, 5)
+	argon2idParamsJSON["t"] = params.Time
+	argon2idParamsJSON["m"] = params.Memory
+	argon2idParamsJSON["p"] = params.Threads
+	argon2idParamsJSON["dklen"] = argon2idDKLen
+	argon2idParamsJSON["salt"] = hex.EncodeToString(salt)
+
+	return encryptKeyWithDerivedKey(hdkey, derivedKey, KDFArgon2id, argon2idParamsJSON, versionArgon2id)
+}
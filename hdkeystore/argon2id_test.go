@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptKeyArgon2id(t *testing.T) {
+
+	seed, _ := GenerateSeed(SeedLen)
+	key, err := NewHDKey(seed, "hello", OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed unexpected error: %v", err)
+	}
+
+	keyjson, err := EncryptKeyArgon2id(key, "1234qwer", LightArgon2idParams)
+	if err != nil {
+		t.Fatalf("EncryptKeyArgon2id failed unexpected error: %v", err)
+	}
+
+	var k encryptedHDKeyJSON
+	json.Unmarshal(keyjson, &k)
+	if k.Version != versionArgon2id || k.Crypto.KDF != KDFArgon2id || ensureInt(k.Crypto.KDFParams["m"]) != 4*1024 {
+		t.Errorf("argon2id key json = %s", keyjson)
+	}
+
+	decrypted, err := DecryptHDKey(keyjson, "1234qwer")
+	if err != nil {
+		t.Fatalf("DecryptHDKey failed unexpected error: %v", err)
+	}
+	if decrypted.KeyID != key.KeyID {
+		t.Errorf("decrypted KeyID = %s, want %s", decrypted.KeyID, key.KeyID)
+	}
+
+	if _, err = DecryptHDKey(keyjson, "wrong"); err != ErrDecrypt {
+		t.Errorf("DecryptHDKey with wrong password err = %v, want ErrDecrypt", err)
+	}
+
+	//超出上限的参数直接拒绝，不执行argon2计算
+	for _, param := range []string{"t", "m", "p", "dklen"} {
+		var crafted map[string]interface{}
+		json.Unmarshal(keyjson, &crafted)
+		crafted["crypto"].(map[string]interface{})["kdfparams"].(map[string]interface{})[param] = 1 << 30
+		b, _ := json.Marshal(crafted)
+		if _, err = DecryptHDKey(b, "1234qwer"); err == nil {
+			t.Errorf("DecryptHDKey should reject kdfparams.%s = %d", param, 1<<30)
+		}
+	}
+
+	if _, err = EncryptKeyArgon2id(key, "1234qwer", Argon2idParams{Time: 1, Memory: 1 << 30, Threads: 1}); err == nil {
+		t.Errorf("EncryptKeyArgon2id should reject memory out of range")
+	}
+}
+
+func TestHDKeystore_ChangePassword(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hdkeystore")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//旧版本scrypt加密的密钥文件
+	key, keyPath, err := StoreHDKey(dir, "hello", "old", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKey failed unexpected error: %v", err)
+	}
+
+	ks := NewHDKeystoreWithArgon2id(dir, LightArgon2idParams)
+	filename := filepath.Base(keyPath)
+
+	if err = ks.ChangePassword(key.KeyID, filename, "wrong", "new"); err != ErrDecrypt {
+		t.Errorf("ChangePassword with wrong password err = %v, want ErrDecrypt", err)
+	}
+
+	if err = ks.ChangePassword(key.KeyID, filename, "old", "new"); err != nil {
+		t.Fatalf("ChangePassword failed unexpected error: %v", err)
+	}
+
+	if _, err = ks.GetKey(key.KeyID, filename, "old"); err != ErrDecrypt {
+		t.Errorf("GetKey with old password err = %v, want ErrDecrypt", err)
+	}
+	newKey, err := ks.GetKey(key.KeyID, filename, "new")
+	if err != nil {
+		t.Fatalf("GetKey with new password failed unexpected error: %v", err)
+	}
+	if newKey.Alias != key.Alias || newKey.RootPath != key.RootPath {
+		t.Errorf("GetKey = %+v, want %+v", newKey, key)
+	}
+
+	//重新加密为argon2id，校验通过后删除备份
+	keyjson, _ := ioutil.ReadFile(keyPath)
+	var k encryptedHDKeyJSON
+	json.Unmarshal(keyjson, &k)
+	if k.Crypto.KDF != KDFArgon2id || k.Version != versionArgon2id {
+		t.Errorf("key file kdf = %s, version = %d", k.Crypto.KDF, k.Version)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("key dir has %d files, backup should be removed", len(files))
+	}
+}
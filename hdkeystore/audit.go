@@ -0,0 +1,370 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+)
+
+const (
+	// QuarantineDirName 隔离损坏密钥文件的子目录
+	QuarantineDirName = "quarantine"
+
+	// KeyFileStatusOK 密钥文件结构完整，提供密码时MAC和KeyID校验通过
+	KeyFileStatusOK = "ok"
+	// KeyFileStatusUnchecked 密钥文件结构完整，没有提供密码，未校验MAC
+	KeyFileStatusUnchecked = "unchecked"
+	// KeyFileStatusCorrupt 密钥文件无法解析或缺少必要字段
+	KeyFileStatusCorrupt = "corrupt"
+	// KeyFileStatusBadMAC MAC校验失败，密码错误或密文被修改
+	KeyFileStatusBadMAC = "bad_mac"
+	// KeyFileStatusMismatch 解密后计算的KeyID与文件记录的不一致
+	KeyFileStatusMismatch = "mismatch"
+)
+
+// AuditOptions 密钥目录审计的参数
+type AuditOptions struct {
+	// Password 所有密钥文件共用的密码，为空时只校验文件结构。
+	// 多个钱包的密码不同，MAC校验失败时视为该文件不使用此密码，状态为unchecked
+	Password string
+	// Passwords 按KeyID或别名指定的密码，优先于Password，MAC校验失败时状态为bad_mac
+	Passwords map[string]string
+	// DBDir 钱包数据库目录，不为空时检查没有对应密钥文件的数据库文件
+	DBDir string
+	// Quarantine 把结构损坏和KeyID不一致的文件移到隔离目录
+	Quarantine bool
+}
+
+// KeyFileReport 单个密钥文件的审计结果
+type KeyFileReport struct {
+	Path        string `json:"path"`
+	Alias       string `json:"alias"`
+	KeyID       string `json:"keyid"`
+	Version     int    `json:"version"`
+	KDF         string `json:"kdf"`
+	Status      string `json:"status"`
+	Err         string `json:"error,omitempty"`
+	Duplicate   bool   `json:"duplicate"`
+	Quarantined string `json:"quarantined,omitempty"` // 隔离后的文件路径
+}
+
+// AuditReport 密钥目录的审计报告
+type AuditReport struct {
+	Dir           string              `json:"dir"`
+	Keys          []*KeyFileReport    `json:"keys"`
+	Duplicates    map[string][]string `json:"duplicates"`    // KeyID -> 重复的密钥文件
+	OrphanDBFiles []string            `json:"orphanDBFiles"` // 没有密钥文件的数据库文件
+}
+
+// Healthy 所有密钥文件都通过校验，没有重复和孤立的数据库文件
+func (r *AuditReport) Healthy() bool {
+	for _, k := range r.Keys {
+		if k.Status != KeyFileStatusOK && k.Status != KeyFileStatusUnchecked {
+			return false
+		}
+	}
+	return len(r.Duplicates) == 0 && len(r.OrphanDBFiles) == 0
+}
+
+// AuditKeyDir 扫描密钥目录，校验每个密钥文件的JSON结构，提供密码时校验MAC和KeyID，
+// 标记KeyID重复的文件和没有密钥文件的数据库文件，可选把损坏的文件移到隔离目录。
+// 隐藏文件和子目录（包括隔离目录）不会被扫描。
+func AuditKeyDir(dir string, opts AuditOptions) (*AuditReport, error) {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{
+		Dir:        dir,
+		Keys:       make([]*KeyFileReport, 0),
+		Duplicates: make(map[string][]string),
+	}
+
+	keyNames := make(map[string]bool)
+	byKeyID := make(map[string][]*KeyFileReport)
+
+	for _, fi := range files {
+		if !isAuditFile(fi) {
+			continue
+		}
+
+		path := filepath.Join(dir, fi.Name())
+		keyNames[strings.TrimSuffix(fi.Name(), ".key")] = true
+
+		r := auditKeyFile(path, opts)
+		report.Keys = append(report.Keys, r)
+
+		if len(r.KeyID) > 0 && r.Status != KeyFileStatusCorrupt {
+			byKeyID[r.KeyID] = append(byKeyID[r.KeyID], r)
+		}
+
+		if opts.Quarantine && (r.Status == KeyFileStatusCorrupt || r.Status == KeyFileStatusMismatch) {
+			dst, err := quarantineFile(dir, path)
+			if err != nil {
+				return nil, fmt.Errorf("quarantine key file %s failed: %v", path, err)
+			}
+			r.Quarantined = dst
+		}
+	}
+
+	for keyID, list := range byKeyID {
+		if len(list) < 2 {
+			continue
+		}
+		paths := make([]string, 0, len(list))
+		for _, r := range list {
+			r.Duplicate = true
+			paths = append(paths, r.Path)
+		}
+		sort.Strings(paths)
+		report.Duplicates[keyID] = paths
+	}
+
+	if len(opts.DBDir) > 0 {
+		orphans, err := findOrphanDBFiles(opts.DBDir, keyNames)
+		if err != nil {
+			return nil, err
+		}
+		report.OrphanDBFiles = orphans
+	}
+
+	return report, nil
+}
+
+// auditKeyFile 校验单个密钥文件
+func auditKeyFile(path string, opts AuditOptions) *KeyFileReport {
+
+	r := &KeyFileReport{Path: path}
+
+	keyjson, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.Status = KeyFileStatusCorrupt
+		r.Err = err.Error()
+		return r
+	}
+
+	k := new(encryptedHDKeyJSON)
+	if err := json.Unmarshal(keyjson, k); err != nil {
+		r.Status = KeyFileStatusCorrupt
+		r.Err = err.Error()
+		return r
+	}
+
+	r.Alias = k.Alias
+	r.KeyID = k.KeyID
+	r.Version = k.Version
+	r.KDF = k.Crypto.KDF
+
+	if err := validateKeyJSON(k); err != nil {
+		r.Status = KeyFileStatusCorrupt
+		r.Err = err.Error()
+		return r
+	}
+
+	password, shared := opts.password(k.KeyID, k.Alias)
+	if len(password) == 0 {
+		r.Status = KeyFileStatusUnchecked
+		return r
+	}
+
+	seed, err := decryptHDKey(k, password)
+	if err != nil {
+		if err == ErrDecrypt && shared {
+			//共用的密码不属于该钱包
+			r.Status = KeyFileStatusUnchecked
+			r.Err = "the password does not match this key file"
+			return r
+		}
+		if err == ErrDecrypt {
+			r.Status = KeyFileStatusBadMAC
+		} else {
+			r.Status = KeyFileStatusCorrupt
+		}
+		r.Err = err.Error()
+		return r
+	}
+
+	if keyID := computeKeyID(seed); keyID != k.KeyID {
+		r.Status = KeyFileStatusMismatch
+		r.Err = fmt.Sprintf("keyid in file is %s, but computed %s", k.KeyID, keyID)
+		return r
+	}
+
+	r.Status = KeyFileStatusOK
+	return r
+}
+
+// password 密钥文件的校验密码，shared表示使用的是共用的密码
+func (opts AuditOptions) password(keyID, alias string) (password string, shared bool) {
+	if pw, ok := opts.Passwords[keyID]; ok && len(keyID) > 0 {
+		return pw, false
+	}
+	if pw, ok := opts.Passwords[alias]; ok && len(alias) > 0 {
+		return pw, false
+	}
+	return opts.Password, true
+}
+
+// validateKeyJSON 校验密钥文件的必要字段，避免解密时因格式错误的参数panic
+func validateKeyJSON(k *encryptedHDKeyJSON) error {
+
+	if len(k.KeyID) == 0 {
+		return fmt.Errorf("keyid is empty")
+	}
+	if k.Version < 0 || k.Version > versionArgon2id {
+		return fmt.Errorf("unsupported key version: %d", k.Version)
+	}
+	if k.Crypto.Cipher != "aes-128-ctr" {
+		return fmt.Errorf("cipher not supported: %v", k.Crypto.Cipher)
+	}
+
+	hexFields := []struct {
+		name  string
+		value string
+		size  int
+	}{
+		{"mac", k.Crypto.MAC, 32},
+		{"cipherparams.iv", k.Crypto.CipherParams.IV, 16},
+		{"ciphertext", k.Crypto.CipherText, 0},
+	}
+	for _, f := range hexFields {
+		b, err := hex.DecodeString(f.value)
+		if err != nil || len(b) == 0 || (f.size > 0 && len(b) != f.size) {
+			return fmt.Errorf("%s is invalid", f.name)
+		}
+	}
+
+	params := k.Crypto.KDFParams
+	salt, ok := params["salt"].(string)
+	if !ok {
+		return fmt.Errorf("kdfparams.salt is invalid")
+	}
+	if _, err := hex.DecodeString(salt); err != nil {
+		return fmt.Errorf("kdfparams.salt is invalid")
+	}
+
+	var intParams []string
+	switch k.Crypto.KDF {
+	case keyHeaderKDF:
+		intParams = []string{"dklen", "n", "r", "p"}
+	case "pbkdf2":
+		intParams = []string{"dklen", "c"}
+		if prf, _ := params["prf"].(string); prf != "hmac-sha256" {
+			return fmt.Errorf("kdfparams.prf is invalid")
+		}
+	case KDFArgon2id:
+		intParams = []string{"dklen", "t", "m", "p"}
+	default:
+		return fmt.Errorf("unsupported KDF: %s", k.Crypto.KDF)
+	}
+	for _, name := range intParams {
+		v, ok := params[name].(float64)
+		if !ok || v <= 0 || v != float64(int(v)) {
+			return fmt.Errorf("kdfparams.%s is invalid", name)
+		}
+	}
+	if dkLen := params["dklen"].(float64); dkLen < 32 {
+		return fmt.Errorf("kdfparams.dklen is invalid")
+	}
+	if k.Crypto.KDF == KDFArgon2id {
+		if _, _, err := argon2idParamsOf(params); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findOrphanDBFiles 查找文件名（不含扩展名）没有对应密钥文件的数据库文件。
+// 只检查按密钥文件命名（别名-KeyID）的数据库文件，watch-only钱包的数据库以钱包ID命名，没有密钥文件
+func findOrphanDBFiles(dbDir string, keyNames map[string]bool) ([]string, error) {
+
+	files, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	orphans := make([]string, 0)
+	for _, fi := range files {
+		if !isAuditFile(fi) || filepath.Ext(fi.Name()) != ".db" {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), ".db")
+		if isKeyFileName(name) && !keyNames[name] {
+			orphans = append(orphans, filepath.Join(dbDir, fi.Name()))
+		}
+	}
+	return orphans, nil
+}
+
+// isKeyFileName 文件名是否为KeyFileName生成的：别名-KeyID
+func isKeyFileName(name string) bool {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return false
+	}
+	keyID := name[i+1:]
+	//KeyID为版本号、20字节的哈希和4字节的校验码
+	b, err := owkeychain.Decode(keyID, owkeychain.BitcoinAlphabet)
+	if err != nil || len(b) != len(KeyIDVer)+20+4 {
+		return false
+	}
+	_, err = owkeychain.Base58checkDecode(keyID, KeyIDVer)
+	return err == nil
+}
+
+// quarantineFile 把文件移到隔离目录，同名文件已存在时加上序号
+func quarantineFile(dir, path string) (string, error) {
+
+	qdir := filepath.Join(dir, QuarantineDirName)
+	if err := os.MkdirAll(qdir, 0700); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(path)
+	dst := filepath.Join(qdir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			break
+		}
+		dst = filepath.Join(qdir, fmt.Sprintf("%s.%d", name, i))
+	}
+
+	if err := os.Rename(path, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// isAuditFile 跳过子目录、隐藏文件（写入中的临时文件）和编辑器的备份文件
+func isAuditFile(fi os.FileInfo) bool {
+	name := fi.Name()
+	return !fi.IsDir() && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "~")
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditKeyDir(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hdkeystore")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyDir := filepath.Join(dir, "key")
+	dbDir := filepath.Join(dir, "db")
+
+	key, keyPath, err := StoreHDKey(keyDir, "hello", "1234qwer", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKey failed unexpected error: %v", err)
+	}
+	keyjson, _ := ioutil.ReadFile(keyPath)
+
+	//相同KeyID的备份文件
+	dupPath := filepath.Join(keyDir, "backup-"+key.KeyID+".key")
+	ioutil.WriteFile(dupPath, keyjson, 0600)
+
+	//缺少kdf参数，直接解密会panic
+	var k map[string]interface{}
+	json.Unmarshal(keyjson, &k)
+	delete(k["crypto"].(map[string]interface{})["kdfparams"].(map[string]interface{}), "salt")
+	brokenJSON, _ := json.Marshal(k)
+	brokenPath := filepath.Join(keyDir, "broken.key")
+	ioutil.WriteFile(brokenPath, brokenJSON, 0600)
+
+	//不是JSON
+	garbagePath := filepath.Join(keyDir, "garbage.key")
+	ioutil.WriteFile(garbagePath, []byte("not a key"), 0600)
+
+	//KeyID被修改
+	json.Unmarshal(keyjson, &k)
+	k["keyid"] = "W0000000000000000000000000000000"
+	mismatchJSON, _ := json.Marshal(k)
+	mismatchPath := filepath.Join(keyDir, "mismatch.key")
+	ioutil.WriteFile(mismatchPath, mismatchJSON, 0600)
+
+	//写入中的临时文件不扫描
+	ioutil.WriteFile(filepath.Join(keyDir, ".hello.key.tmp"), []byte("{"), 0600)
+
+	os.MkdirAll(dbDir, 0700)
+	ioutil.WriteFile(filepath.Join(dbDir, key.FileName()+".db"), nil, 0600)
+	lostSeed, _ := GenerateSeed(SeedLen)
+	orphanPath := filepath.Join(dbDir, KeyFileName("lost", computeKeyID(lostSeed))+".db")
+	ioutil.WriteFile(orphanPath, nil, 0600)
+	//watch-only钱包的数据库没有密钥文件
+	ioutil.WriteFile(filepath.Join(dbDir, "watch-W1.db"), nil, 0600)
+
+	//没有密码只校验结构
+	report, err := AuditKeyDir(keyDir, AuditOptions{DBDir: dbDir})
+	if err != nil {
+		t.Fatalf("AuditKeyDir failed unexpected error: %v", err)
+	}
+	want := map[string]string{
+		keyPath:      KeyFileStatusUnchecked,
+		dupPath:      KeyFileStatusUnchecked,
+		brokenPath:   KeyFileStatusCorrupt,
+		garbagePath:  KeyFileStatusCorrupt,
+		mismatchPath: KeyFileStatusUnchecked,
+	}
+	if len(report.Keys) != len(want) {
+		t.Fatalf("AuditKeyDir got %d keys, want %d", len(report.Keys), len(want))
+	}
+	for _, r := range report.Keys {
+		if r.Status != want[r.Path] {
+			t.Errorf("key file %s status = %s, want %s", r.Path, r.Status, want[r.Path])
+		}
+	}
+	if dup := report.Duplicates[key.KeyID]; len(dup) != 2 {
+		t.Errorf("duplicates = %v", report.Duplicates)
+	}
+	if len(report.OrphanDBFiles) != 1 || report.OrphanDBFiles[0] != orphanPath {
+		t.Errorf("orphan db files = %v, want [%s]", report.OrphanDBFiles, orphanPath)
+	}
+	if report.Healthy() {
+		t.Errorf("report should not be healthy")
+	}
+
+	//共用的密码不属于该钱包，不视为失败
+	report, _ = AuditKeyDir(keyDir, AuditOptions{Password: "wrong"})
+	for _, r := range report.Keys {
+		if r.Path == keyPath && r.Status != KeyFileStatusUnchecked {
+			t.Errorf("key file %s status = %s, want %s", r.Path, r.Status, KeyFileStatusUnchecked)
+		}
+	}
+
+	//指定钱包的密码错误
+	report, _ = AuditKeyDir(keyDir, AuditOptions{Password: "1234qwer", Passwords: map[string]string{key.KeyID: "wrong"}})
+	for _, r := range report.Keys {
+		if (r.Path == keyPath || r.Path == dupPath) && r.Status != KeyFileStatusBadMAC {
+			t.Errorf("key file %s status = %s, want %s", r.Path, r.Status, KeyFileStatusBadMAC)
+		}
+	}
+
+	//校验MAC并隔离损坏的文件
+	report, err = AuditKeyDir(keyDir, AuditOptions{Password: "1234qwer", Quarantine: true})
+	if err != nil {
+		t.Fatalf("AuditKeyDir failed unexpected error: %v", err)
+	}
+	want[keyPath] = KeyFileStatusOK
+	want[dupPath] = KeyFileStatusOK
+	want[mismatchPath] = KeyFileStatusMismatch
+	for _, r := range report.Keys {
+		if r.Status != want[r.Path] {
+			t.Errorf("key file %s status = %s, want %s", r.Path, r.Status, want[r.Path])
+		}
+		quarantined := r.Status == KeyFileStatusCorrupt || r.Status == KeyFileStatusMismatch
+		if quarantined != (len(r.Quarantined) > 0) {
+			t.Errorf("key file %s quarantined = %s", r.Path, r.Quarantined)
+		}
+		if _, err := os.Stat(r.Path); quarantined == (err == nil) {
+			t.Errorf("key file %s should be moved: %v", r.Path, quarantined)
+		}
+	}
+
+	report, _ = AuditKeyDir(keyDir, AuditOptions{Password: "1234qwer"})
+	if len(report.Keys) != 2 {
+		t.Errorf("AuditKeyDir after quarantine got %d keys, want 2", len(report.Keys))
+	}
+}
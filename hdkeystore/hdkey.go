@@ -35,6 +35,7 @@ import (
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/blocktree/openwallet/v2/crypto/sha3"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/scrypt"
 )
@@ -44,6 +45,9 @@ const (
 	// HDKey的规范版本号
 	version = 1
 
+	// 使用argon2id加密的HDKey的规范版本号，旧版本无法解析argon2id参数
+	versionArgon2id = 2
+
 	// maxCoinType is the maximum allowed coin type used when structuring
 	// the BIP0044 multi-account hierarchy.  This value is based on the
 	// limitation of the underlying hierarchical deterministic key
@@ -152,6 +156,10 @@ type miningJSON struct {
 // ECC_CURVE_SECP256R1
 // ECC_CURVE_ED25519
 func (k *HDKey) DerivedKeyWithPath(path string, curveType uint32) (*owkeychain.ExtendedKey, error) {
+	//已清零的密钥
+	if len(k.seed) == 0 {
+		return nil, fmt.Errorf("HDKey seed is empty")
+	}
 	return owkeychain.DerivedPrivateKeyWithPath(k.seed, path, curveType)
 }
 
@@ -265,6 +273,14 @@ func (k *HDKey) Seed() []byte {
 	return k.seed
 }
 
+//Zero 清零内存中的种子，清零后密钥不能再衍生私钥
+func (k *HDKey) Zero() {
+	for i := range k.seed {
+		k.seed[i] = 0
+	}
+	k.seed = nil
+}
+
 // EncryptKey encrypts a key using the specified scrypt parameters into a json
 // blob that can be decrypted later on.
 func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error) {
@@ -279,6 +295,21 @@ func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
+
+	scryptParamsJSON := make(map[string]interface{}, 5)
+	scryptParamsJSON["n"] = scryptN
+	scryptParamsJSON["r"] = scryptR
+	scryptParamsJSON["p"] = scryptP
+	scryptParamsJSON["dklen"] = scryptDKLen
+	scryptParamsJSON["salt"] = hex.EncodeToString(salt)
+
+	return encryptKeyWithDerivedKey(hdkey, derivedKey, keyHeaderKDF, scryptParamsJSON, version)
+}
+
+// encryptKeyWithDerivedKey encrypts the seed with the key derived by kdf and
+// marshals it together with the kdf parameters.
+func encryptKeyWithDerivedKey(hdkey *HDKey, derivedKey []byte, kdf string, kdfParams map[string]interface{}, ver int) ([]byte, error) {
+
 	encryptKey := derivedKey[:16]
 
 	keyBytes := hdkey.seed
@@ -293,13 +324,6 @@ func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error)
 	}
 	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
 
-	scryptParamsJSON := make(map[string]interface{}, 5)
-	scryptParamsJSON["n"] = scryptN
-	scryptParamsJSON["r"] = scryptR
-	scryptParamsJSON["p"] = scryptP
-	scryptParamsJSON["dklen"] = scryptDKLen
-	scryptParamsJSON["salt"] = hex.EncodeToString(salt)
-
 	cipherParamsJSON := cipherparamsJSON{
 		IV: hex.EncodeToString(iv),
 	}
@@ -308,8 +332,8 @@ func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error)
 		Cipher:       "aes-128-ctr",
 		CipherText:   hex.EncodeToString(cipherText),
 		CipherParams: cipherParamsJSON,
-		KDF:          keyHeaderKDF,
-		KDFParams:    scryptParamsJSON,
+		KDF:          kdf,
+		KDFParams:    kdfParams,
 		MAC:          hex.EncodeToString(mac),
 	}
 
@@ -318,7 +342,7 @@ func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error)
 		KeyID:    hdkey.KeyID,
 		Crypto:   cryptoStruct,
 		RootPath: hdkey.RootPath,
-		Version:  version,
+		Version:  ver,
 	}
 	return json.MarshalIndent(encryptedHDKeyJSON, "", "\t")
 }
@@ -339,6 +363,9 @@ func DecryptHDKey(keyjson []byte, auth string) (*HDKey, error) {
 	if err := json.Unmarshal(keyjson, k); err != nil {
 		return nil, err
 	}
+	if k.Version > versionArgon2id {
+		return nil, fmt.Errorf("Unsupported key version: %d", k.Version)
+	}
 
 	seed, err = decryptHDKey(k, auth)
 	// Handle any decryption errors and return the key
@@ -419,6 +446,13 @@ func getKDFKey(cryptoJSON cryptoJSON, auth string) ([]byte, error) {
 		}
 		key := pbkdf2.Key(authArray, salt, c, dkLen, sha256.New)
 		return key, nil
+
+	} else if cryptoJSON.KDF == KDFArgon2id {
+		params, dkLen, err := argon2idParamsOf(cryptoJSON.KDFParams)
+		if err != nil {
+			return nil, err
+		}
+		return argon2.IDKey(authArray, salt, params.Time, params.Memory, params.Threads, uint32(dkLen)), nil
 	}
 
 	return nil, fmt.Errorf("Unsupported KDF: %s", cryptoJSON.KDF)
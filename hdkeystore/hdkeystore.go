@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 
 	"github.com/blocktree/openwallet/v2/crypto/sha3"
@@ -64,12 +65,21 @@ type HDKeystore struct {
 	//MasterKey   string
 	scryptN int
 	scryptP int
+	//不为空时使用argon2id加密密钥文件
+	argon2id *Argon2idParams
 }
 
 // NewHDKeystore 实例化HDKeystore
 func NewHDKeystore(keydir string, scryptN, scryptP int) *HDKeystore {
 	keydir, _ = filepath.Abs(keydir)
-	ks := &HDKeystore{keydir, scryptN, scryptP}
+	ks := &HDKeystore{keysDirPath: keydir, scryptN: scryptN, scryptP: scryptP}
+	return ks
+}
+
+// NewHDKeystoreWithArgon2id 实例化使用argon2id加密密钥文件的HDKeystore
+func NewHDKeystoreWithArgon2id(keydir string, params Argon2idParams) *HDKeystore {
+	keydir, _ = filepath.Abs(keydir)
+	ks := &HDKeystore{keysDirPath: keydir, argon2id: &params}
 	return ks
 }
 
@@ -91,7 +101,7 @@ func StoreHDKey(dir, alias, auth string, scryptN, scryptP int) (*HDKey, string,
 
 // StoreHDKey 创建HDKey
 func StoreHDKeyWithSeed(dir, alias, auth string, seed []byte, scryptN, scryptP int) (*HDKey, string, error) {
-	key, filePath, err := storeNewKey(&HDKeystore{dir, scryptN, scryptP}, alias, auth, seed)
+	key, filePath, err := storeNewKey(&HDKeystore{keysDirPath: dir, scryptN: scryptN, scryptP: scryptP}, alias, auth, seed)
 	return key, filePath, err
 }
 
@@ -132,13 +142,63 @@ func (ks HDKeystore) GetKey(rootId, filename, auth string) (*HDKey, error) {
 
 //StoreKey 把HDKey重写加密写入到文件中
 func (ks *HDKeystore) StoreKey(filename string, key *HDKey, auth string) error {
-	keyjson, err := EncryptKey(key, auth, ks.scryptN, ks.scryptP)
+	keyjson, err := ks.encryptKey(key, auth)
 	if err != nil {
 		return err
 	}
 	return writeKeyFile(filename, keyjson)
 }
 
+//encryptKey 按keystore配置的kdf加密HDKey
+func (ks *HDKeystore) encryptKey(key *HDKey, auth string) ([]byte, error) {
+	if ks.argon2id != nil {
+		return EncryptKeyArgon2id(key, auth, *ks.argon2id)
+	}
+	return EncryptKey(key, auth, ks.scryptN, ks.scryptP)
+}
+
+//ChangePassword 修改密钥文件的密码，并按keystore配置的kdf重新加密。
+//旧文件先备份为同目录的隐藏文件，新文件写入后用新密码解密校验通过才删除备份，
+//校验失败则用备份恢复旧文件。oldAuth与newAuth相同时只重新加密。
+func (ks *HDKeystore) ChangePassword(rootId, filename, oldAuth, newAuth string) error {
+
+	keyPath := ks.JoinPath(filename)
+	oldjson, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	key, err := ks.GetKey(rootId, keyPath, oldAuth)
+	if err != nil {
+		return err
+	}
+
+	newjson, err := ks.encryptKey(key, newAuth)
+	if err != nil {
+		return err
+	}
+
+	backupPath := filepath.Join(filepath.Dir(keyPath), "."+filepath.Base(keyPath)+".bak")
+	if err = writeKeyFile(backupPath, oldjson); err != nil {
+		return fmt.Errorf("backup key file failed: %v", err)
+	}
+
+	if err = writeKeyFile(keyPath, newjson); err != nil {
+		return fmt.Errorf("write key file failed: %v, backup is kept at %s", err, backupPath)
+	}
+
+	//校验新文件可以被新密码解密为同一个HDKey（KeyID由种子计算）
+	_, err = ks.GetKey(key.KeyID, keyPath, newAuth)
+	if err != nil {
+		if rerr := os.Rename(backupPath, keyPath); rerr != nil {
+			return fmt.Errorf("verify new key file failed: %v, restore backup %s failed: %v", err, backupPath, rerr)
+		}
+		return fmt.Errorf("verify new key file failed: %v", err)
+	}
+
+	return os.Remove(backupPath)
+}
+
 //JoinPath 文件路径组合
 func (ks *HDKeystore) JoinPath(filename string) string {
 	if filepath.IsAbs(filename) {
@@ -33,7 +33,7 @@ func TestStoreHDKey(t *testing.T) {
 
 func TestGetKey(t *testing.T) {
 	path := filepath.Join(".", "keys")
-	ks := &HDKeystore{path, StandardScryptN, StandardScryptP}
+	ks := NewHDKeystore(path, StandardScryptN, StandardScryptP)
 
 	key, err := ks.GetKey("WAeAP5ggYYZ1euSJqURNEoGBRP6ucfPq2g",
 		"sogosdfo-WAeAP5ggYYZ1euSJqURNEoGBRP6ucfPq2g.key",
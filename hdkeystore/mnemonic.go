@@ -0,0 +1,222 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+	"github.com/tyler-smith/go-bip39/wordlists"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// BIP39助记词的语言
+const (
+	MnemonicEnglish            = "english"
+	MnemonicChineseSimplified  = "chinese_simplified"
+	MnemonicChineseTraditional = "chinese_traditional"
+	MnemonicJapanese           = "japanese"
+	MnemonicKorean             = "korean"
+	MnemonicSpanish            = "spanish"
+	MnemonicFrench             = "french"
+	MnemonicItalian            = "italian"
+)
+
+const (
+	// DefaultMnemonicBitSize 默认助记词熵长度，256位为24个单词
+	DefaultMnemonicBitSize = 256
+
+	// 日文助记词按BIP39规范以全角空格分隔
+	japaneseMnemonicSeparator = "\u3000"
+)
+
+// mnemonicWordList 助记词单词表，以NFKD规范化的单词查找序号
+type mnemonicWordList struct {
+	words []string
+	index map[string]int
+}
+
+var (
+	mnemonicWordLists = map[string]*mnemonicWordList{
+		MnemonicEnglish:            newMnemonicWordList(wordlists.English),
+		MnemonicChineseSimplified:  newMnemonicWordList(wordlists.ChineseSimplified),
+		MnemonicChineseTraditional: newMnemonicWordList(wordlists.ChineseTraditional),
+		MnemonicJapanese:           newMnemonicWordList(wordlists.Japanese),
+		MnemonicKorean:             newMnemonicWordList(wordlists.Korean),
+		MnemonicSpanish:            newMnemonicWordList(wordlists.Spanish),
+		MnemonicFrench:             newMnemonicWordList(wordlists.French),
+		MnemonicItalian:            newMnemonicWordList(wordlists.Italian),
+	}
+)
+
+// newMnemonicWordList 创建单词表
+func newMnemonicWordList(words []string) *mnemonicWordList {
+	list := &mnemonicWordList{words: words, index: make(map[string]int, len(words))}
+	for i, w := range words {
+		list.index[norm.NFKD.String(w)] = i
+	}
+	return list
+}
+
+// MnemonicLanguages 支持的助记词语言
+func MnemonicLanguages() []string {
+	return []string{
+		MnemonicEnglish,
+		MnemonicChineseSimplified,
+		MnemonicChineseTraditional,
+		MnemonicJapanese,
+		MnemonicKorean,
+		MnemonicSpanish,
+		MnemonicFrench,
+		MnemonicItalian,
+	}
+}
+
+// getMnemonicWordList 指定语言的单词表，language为空使用英文
+func getMnemonicWordList(language string) (*mnemonicWordList, error) {
+	if len(language) == 0 {
+		language = MnemonicEnglish
+	}
+	list, ok := mnemonicWordLists[strings.ToLower(language)]
+	if !ok {
+		return nil, fmt.Errorf("mnemonic language: %s is not supported", language)
+	}
+	return list, nil
+}
+
+// NewMnemonic 生成BIP39助记词
+// @param bitSize 熵长度，128到256之间32的倍数，对应12到24个单词
+// @param language 助记词语言，为空使用英文
+func NewMnemonic(bitSize int, language string) (string, error) {
+
+	list, err := getMnemonicWordList(language)
+	if err != nil {
+		return "", err
+	}
+
+	entropy, err := bip39.NewEntropy(bitSize)
+	if err != nil {
+		return "", err
+	}
+
+	//熵后接sha256的前bitSize/32位校验和，每11位对应一个单词
+	checksumBits := uint(bitSize / 32)
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	hash := sha256.Sum256(entropy)
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	count := (bitSize + bitSize/32) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = list.words[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+
+	separator := " "
+	if list == mnemonicWordLists[MnemonicJapanese] {
+		separator = japaneseMnemonicSeparator
+	}
+
+	return strings.Join(words, separator), nil
+}
+
+// MnemonicToSeed 校验助记词，并按BIP39规范通过助记词和密码短语计算种子。
+// 助记词和密码短语先做NFKD规范化，单词之间可以用空格或全角空格分隔，与其他BIP39钱包得到相同的种子。
+// @param passphrase 可选的密码短语，不同的密码短语生成不同的种子
+func MnemonicToSeed(mnemonic, passphrase, language string) ([]byte, error) {
+
+	list, err := getMnemonicWordList(language)
+	if err != nil {
+		return nil, err
+	}
+
+	//全角空格NFKD规范化后为空格
+	words := strings.Fields(norm.NFKD.String(mnemonic))
+
+	//校验单词和校验和，避免抄写错误的助记词恢复出其他密钥
+	if err := list.checkMnemonic(words); err != nil {
+		return nil, fmt.Errorf("mnemonic is invalid: %v", err)
+	}
+
+	sentence := strings.Join(words, " ")
+	salt := "mnemonic" + norm.NFKD.String(passphrase)
+
+	return pbkdf2.Key([]byte(sentence), []byte(salt), 2048, 64, sha512.New), nil
+}
+
+// checkMnemonic 检查单词数量、单词是否在单词表中和校验和
+func (list *mnemonicWordList) checkMnemonic(words []string) error {
+
+	count := len(words)
+	if count < 12 || count > 24 || count%3 != 0 {
+		return fmt.Errorf("invalid number of words: %d", count)
+	}
+
+	data := new(big.Int)
+	for _, w := range words {
+		i, ok := list.index[w]
+		if !ok {
+			return fmt.Errorf("word `%s` not found in word list", w)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(i)))
+	}
+
+	checksumBits := uint(count / 3)
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1))
+	data.Rsh(data, checksumBits)
+
+	//高位为0时Bytes会更短，补齐熵长度
+	entropy := make([]byte, (count*11-int(checksumBits))/8)
+	b := data.Bytes()
+	copy(entropy[len(entropy)-len(b):], b)
+
+	hash := sha256.Sum256(entropy)
+	if uint64(hash[0]>>(8-checksumBits)) != checksum.Uint64() {
+		return fmt.Errorf("checksum incorrect")
+	}
+
+	return nil
+}
+
+// NewHDKeyWithMnemonic 通过助记词恢复HDKey，相同的助记词和密码短语得到相同的KeyID
+func NewHDKeyWithMnemonic(mnemonic, passphrase, language, alias, rootPath string) (*HDKey, error) {
+
+	seed, err := MnemonicToSeed(mnemonic, passphrase, language)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHDKey(seed, alias, rootPath)
+}
+
+// StoreHDKeyWithMnemonic 通过助记词创建HDKey，并加密保存到密钥文件
+func StoreHDKeyWithMnemonic(dir, alias, auth, mnemonic, passphrase, language string, scryptN, scryptP int) (*HDKey, string, error) {
+
+	seed, err := MnemonicToSeed(mnemonic, passphrase, language)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return StoreHDKeyWithSeed(dir, alias, auth, seed, scryptN, scryptP)
+}
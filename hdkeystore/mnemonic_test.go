@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMnemonic_Restore(t *testing.T) {
+
+	for _, language := range MnemonicLanguages() {
+
+		mnemonic, err := NewMnemonic(DefaultMnemonicBitSize, language)
+		if err != nil {
+			t.Fatalf("[%s] NewMnemonic failed unexpected error: %v", language, err)
+		}
+		if words := strings.Fields(mnemonic); len(words) != 24 {
+			t.Errorf("[%s] mnemonic has %d words, want 24", language, len(words))
+		}
+
+		seed, err := MnemonicToSeed(mnemonic, "paper", language)
+		if err != nil {
+			t.Fatalf("[%s] MnemonicToSeed failed unexpected error: %v", language, err)
+		}
+		key, err := NewHDKey(seed, "hello", OpenwCoinTypePath)
+		if err != nil {
+			t.Fatalf("[%s] NewHDKey failed unexpected error: %v", language, err)
+		}
+
+		//相同的助记词和密码短语恢复相同的KeyID
+		restored, err := NewHDKeyWithMnemonic(" "+mnemonic+" ", "paper", language, "hello", OpenwCoinTypePath)
+		if err != nil {
+			t.Fatalf("[%s] NewHDKeyWithMnemonic failed unexpected error: %v", language, err)
+		}
+		if restored.KeyID != key.KeyID {
+			t.Errorf("[%s] restored KeyID = %s, want %s", language, restored.KeyID, key.KeyID)
+		}
+
+		//不同的密码短语得到不同的密钥
+		other, err := NewHDKeyWithMnemonic(mnemonic, "", language, "hello", OpenwCoinTypePath)
+		if err != nil {
+			t.Fatalf("[%s] NewHDKeyWithMnemonic failed unexpected error: %v", language, err)
+		}
+		if other.KeyID == key.KeyID {
+			t.Errorf("[%s] KeyID should be different with other passphrase", language)
+		}
+	}
+
+	mnemonic, _ := NewMnemonic(128, "")
+	if _, err := MnemonicToSeed(mnemonic, "", MnemonicFrench); err == nil {
+		t.Errorf("english mnemonic should be invalid in french word list")
+	}
+	if _, err := MnemonicToSeed(strings.Repeat("abandon ", 11)+"about", "", ""); err != nil {
+		t.Errorf("MnemonicToSeed failed unexpected error: %v", err)
+	}
+	if _, err := MnemonicToSeed(strings.Repeat("abandon ", 12), "", ""); err == nil {
+		t.Errorf("mnemonic with wrong checksum should be invalid")
+	}
+	if _, err := NewMnemonic(128, "klingon"); err == nil {
+		t.Errorf("unsupported language should fail")
+	}
+}
+
+func TestStoreHDKeyWithMnemonic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdkeystore_mnemonic")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mnemonic, err := NewMnemonic(DefaultMnemonicBitSize, MnemonicChineseSimplified)
+	if err != nil {
+		t.Fatalf("NewMnemonic failed unexpected error: %v", err)
+	}
+
+	key, path, err := StoreHDKeyWithMnemonic(dir, "paper", "1234qwer", mnemonic, "", MnemonicChineseSimplified, LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKeyWithMnemonic failed unexpected error: %v", err)
+	}
+
+	ks := NewHDKeystore(dir, LightScryptN, LightScryptP)
+	stored, err := ks.GetKey(key.KeyID, path, "1234qwer")
+	if err != nil {
+		t.Fatalf("GetKey failed unexpected error: %v", err)
+	}
+	if stored.KeyID != key.KeyID {
+		t.Errorf("stored KeyID = %s, want %s", stored.KeyID, key.KeyID)
+	}
+}
+
+func TestMnemonicToSeed_Vectors(t *testing.T) {
+
+	//BIP39标准测试向量，日文助记词以全角空格分隔，密码短语需要NFKD规范化
+	vectors := []struct {
+		mnemonic   string
+		passphrase string
+		language   string
+		seed       string
+	}{
+		{
+			strings.Repeat("abandon ", 11) + "about", "TREZOR", MnemonicEnglish,
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			strings.Repeat("あいこくしん　", 11) + "あおぞら", "㍍ガバヴァぱばぐゞちぢ十人十色", MnemonicJapanese,
+			"a262d6fb6122ecf45be09c50492b31f92e9beb7d9a845987a02cefda57a15f9c467a17872029a9e92299b5cbdf306e3a0ee620245cbd508959b6cb7ca637bd55",
+		},
+	}
+
+	for i, v := range vectors {
+		seed, err := MnemonicToSeed(v.mnemonic, v.passphrase, v.language)
+		if err != nil {
+			t.Fatalf("[%d] MnemonicToSeed failed unexpected error: %v", i, err)
+		}
+		if hex.EncodeToString(seed) != v.seed {
+			t.Errorf("[%d] seed = %x, want %s", i, seed, v.seed)
+		}
+	}
+
+	//日文助记词以全角空格分隔
+	mnemonic, err := NewMnemonic(128, MnemonicJapanese)
+	if err != nil {
+		t.Fatalf("NewMnemonic failed unexpected error: %v", err)
+	}
+	if words := strings.Split(mnemonic, "　"); len(words) != 12 {
+		t.Errorf("japanese mnemonic has %d words separated by ideographic space, want 12", len(words))
+	}
+}
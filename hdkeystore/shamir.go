@@ -0,0 +1,293 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+)
+
+// 种子分片的编码格式
+//
+// 分片使用GF(2^8)上的Shamir秘密共享，种子的每个字节独立分割，x坐标为分片序号1~255。
+// 分片文本为base58编码的以下内容：
+//
+//	version(1) | threshold(1) | index(1) | len(keyID)(1) | keyID | value | checksum(4)
+//
+// checksum为前面所有字节的double sha256的前4字节，keyID为HDKey的KeyID，
+// 恢复时用于检查分片属于同一个HDKey，并校验恢复出的种子。
+const (
+	// shareVersion 分片编码的版本号
+	shareVersion = 1
+
+	// MaxShares 最多的分片数量
+	MaxShares = 255
+)
+
+var (
+	// ErrInvalidShare 分片格式或校验和错误
+	ErrInvalidShare = errors.New("invalid seed share")
+
+	// ErrShareKeyIDMismatch 恢复的种子与分片的KeyID不一致
+	ErrShareKeyIDMismatch = errors.New("recovered seed does not match the share keyID")
+
+	// gf(2^8)的指数表和对数表，生成元为3，不可约多项式为x^8+x^4+x^3+x+1
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		x = gfMulNoTable(x, 3)
+	}
+}
+
+// SeedShare 种子分片
+type SeedShare struct {
+	// KeyID 被分割的HDKey的KeyID
+	KeyID string
+	// Threshold 恢复需要的最少分片数
+	Threshold int
+	// Index 分片序号，即多项式的x坐标
+	Index int
+	// Value 分片数据，长度与种子相同
+	Value []byte
+}
+
+// String 分片的文本编码
+func (s *SeedShare) String() string {
+	buf := []byte{shareVersion, byte(s.Threshold), byte(s.Index), byte(len(s.KeyID))}
+	buf = append(buf, s.KeyID...)
+	buf = append(buf, s.Value...)
+	buf = append(buf, shareChecksum(buf)...)
+	return owkeychain.Encode(buf, owkeychain.BitcoinAlphabet)
+}
+
+// ParseSeedShare 解析分片文本，并检查校验和
+func ParseSeedShare(share string) (*SeedShare, error) {
+
+	buf, err := owkeychain.Decode(strings.TrimSpace(share), owkeychain.BitcoinAlphabet)
+	if err != nil || len(buf) < 4+4 {
+		return nil, ErrInvalidShare
+	}
+
+	payload, checksum := buf[:len(buf)-4], buf[len(buf)-4:]
+	if !bytes.Equal(shareChecksum(payload), checksum) {
+		return nil, ErrInvalidShare
+	}
+
+	if payload[0] != shareVersion {
+		return nil, fmt.Errorf("unsupported seed share version: %d", payload[0])
+	}
+
+	keyIDLen := int(payload[3])
+	if len(payload) <= 4+keyIDLen {
+		return nil, ErrInvalidShare
+	}
+
+	s := &SeedShare{
+		Threshold: int(payload[1]),
+		Index:     int(payload[2]),
+		KeyID:     string(payload[4 : 4+keyIDLen]),
+		Value:     payload[4+keyIDLen:],
+	}
+	if s.Index == 0 || s.Threshold == 0 {
+		return nil, ErrInvalidShare
+	}
+
+	return s, nil
+}
+
+// SplitHDKey 把HDKey的种子分割为total个分片，任意threshold个分片可以恢复种子
+func SplitHDKey(key *HDKey, total, threshold int) ([]string, error) {
+
+	if threshold < 2 || threshold > total || total > MaxShares {
+		return nil, fmt.Errorf("invalid shares: total = %d, threshold = %d, require 2 <= threshold <= total <= %d", total, threshold, MaxShares)
+	}
+	if len(key.seed) == 0 {
+		return nil, fmt.Errorf("HDKey seed is empty")
+	}
+
+	shares := make([]*SeedShare, total)
+	for i := range shares {
+		shares[i] = &SeedShare{
+			KeyID:     key.KeyID,
+			Threshold: threshold,
+			Index:     i + 1,
+			Value:     make([]byte, len(key.seed)),
+		}
+	}
+
+	//每个字节一个threshold-1次的随机多项式，常数项为种子字节
+	coeffs := make([]byte, threshold)
+	for j, b := range key.seed {
+		if _, err := io.ReadFull(rand.Reader, coeffs[1:]); err != nil {
+			return nil, err
+		}
+		coeffs[0] = b
+		for _, s := range shares {
+			s.Value[j] = gfEval(coeffs, byte(s.Index))
+		}
+	}
+	for i := range coeffs {
+		coeffs[i] = 0
+	}
+
+	result := make([]string, total)
+	for i, s := range shares {
+		result[i] = s.String()
+	}
+	return result, nil
+}
+
+// CombineSeedShares 通过分片恢复种子，并校验种子计算的KeyID与分片一致
+func CombineSeedShares(shares []string) ([]byte, string, error) {
+
+	if len(shares) == 0 {
+		return nil, "", fmt.Errorf("seed shares is empty")
+	}
+
+	parsed := make([]*SeedShare, 0, len(shares))
+	seen := make(map[int]bool)
+	for _, share := range shares {
+		s, err := ParseSeedShare(share)
+		if err != nil {
+			return nil, "", err
+		}
+		first := s
+		if len(parsed) > 0 {
+			first = parsed[0]
+		}
+		if s.KeyID != first.KeyID || s.Threshold != first.Threshold || len(s.Value) != len(first.Value) {
+			return nil, "", fmt.Errorf("seed shares do not belong to the same key")
+		}
+		if seen[s.Index] {
+			continue
+		}
+		seen[s.Index] = true
+		parsed = append(parsed, s)
+	}
+
+	keyID, threshold := parsed[0].KeyID, parsed[0].Threshold
+	if len(parsed) < threshold {
+		return nil, "", fmt.Errorf("seed shares are not enough: have %d, want %d", len(parsed), threshold)
+	}
+	parsed = parsed[:threshold]
+
+	//拉格朗日插值计算x=0处的值
+	seed := make([]byte, len(parsed[0].Value))
+	for i, si := range parsed {
+		basis := byte(1)
+		for j, sj := range parsed {
+			if i == j {
+				continue
+			}
+			xi, xj := byte(si.Index), byte(sj.Index)
+			basis = gfMul(basis, gfDiv(xj, xj^xi))
+		}
+		for k := range seed {
+			seed[k] ^= gfMul(si.Value[k], basis)
+		}
+	}
+
+	if computeKeyID(seed) != keyID {
+		return nil, "", ErrShareKeyIDMismatch
+	}
+
+	return seed, keyID, nil
+}
+
+// RecoverHDKeyWithShares 通过分片恢复HDKey
+func RecoverHDKeyWithShares(shares []string, alias, rootPath string) (*HDKey, error) {
+
+	seed, _, err := CombineSeedShares(shares)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHDKey(seed, alias, rootPath)
+}
+
+// StoreHDKeyWithShares 通过分片恢复HDKey，并加密保存到密钥文件
+func StoreHDKeyWithShares(dir, alias, auth string, shares []string, scryptN, scryptP int) (*HDKey, string, error) {
+
+	seed, _, err := CombineSeedShares(shares)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return StoreHDKeyWithSeed(dir, alias, auth, seed, scryptN, scryptP)
+}
+
+// shareChecksum double sha256的前4字节
+func shareChecksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+// gfEval 霍纳法则计算多项式在x处的值
+func gfEval(coeffs []byte, x byte) byte {
+	y := byte(0)
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// gfMul gf(2^8)乘法
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfDiv gf(2^8)除法，b不能为0
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfMulNoTable 不查表的gf(2^8)乘法，用于生成表
+func gfMulNoTable(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
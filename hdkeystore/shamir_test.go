@@ -0,0 +1,91 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitHDKey(t *testing.T) {
+
+	seed, _ := GenerateSeed(SeedLen)
+	key, err := NewHDKey(seed, "hello", OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed unexpected error: %v", err)
+	}
+
+	shares, err := SplitHDKey(key, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitHDKey failed unexpected error: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("SplitHDKey got %d shares, want 5", len(shares))
+	}
+
+	//任意3个分片都可以恢复
+	for _, group := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		selected := make([]string, 0)
+		for _, i := range group {
+			selected = append(selected, shares[i])
+		}
+		recovered, err := RecoverHDKeyWithShares(selected, "hello", OpenwCoinTypePath)
+		if err != nil {
+			t.Fatalf("RecoverHDKeyWithShares %v failed unexpected error: %v", group, err)
+		}
+		if recovered.KeyID != key.KeyID || !bytes.Equal(recovered.Seed(), seed) {
+			t.Errorf("RecoverHDKeyWithShares %v KeyID = %s, want %s", group, recovered.KeyID, key.KeyID)
+		}
+	}
+
+	//分片不足，重复的分片不计数
+	if _, _, err = CombineSeedShares([]string{shares[0], shares[1], shares[1]}); err == nil {
+		t.Errorf("CombineSeedShares should fail with 2 distinct shares")
+	}
+
+	//篡改分片，校验和错误
+	tampered := []byte(shares[2])
+	if tampered[10] == 'a' {
+		tampered[10] = 'b'
+	} else {
+		tampered[10] = 'a'
+	}
+	if _, _, err = CombineSeedShares([]string{shares[0], shares[1], string(tampered)}); err != ErrInvalidShare {
+		t.Errorf("CombineSeedShares with tampered share err = %v, want ErrInvalidShare", err)
+	}
+
+	//其他密钥的分片
+	otherSeed, _ := GenerateSeed(SeedLen)
+	other, _ := NewHDKey(otherSeed, "other", OpenwCoinTypePath)
+	otherShares, _ := SplitHDKey(other, 3, 3)
+	if _, _, err = CombineSeedShares([]string{shares[0], shares[1], otherShares[2]}); err == nil {
+		t.Errorf("CombineSeedShares should fail with shares of other key")
+	}
+
+	//序号相同，数值错误的分片恢复出的种子与KeyID不一致
+	s, _ := ParseSeedShare(shares[2])
+	s.Value[0] ^= 0xff
+	if _, _, err = CombineSeedShares([]string{shares[0], shares[1], s.String()}); err != ErrShareKeyIDMismatch {
+		t.Errorf("CombineSeedShares with wrong value err = %v, want ErrShareKeyIDMismatch", err)
+	}
+
+	if _, err = SplitHDKey(key, 3, 1); err == nil {
+		t.Errorf("SplitHDKey should fail with threshold 1")
+	}
+	if _, err = SplitHDKey(key, 2, 3); err == nil {
+		t.Errorf("SplitHDKey should fail with threshold greater than total")
+	}
+}
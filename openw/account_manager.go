@@ -20,6 +20,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/blocktree/go-owcdrivers/owkeychain"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
@@ -39,6 +40,10 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 		return nil, nil, fmt.Errorf("account symbol is empty")
 	}
 
+	if account.IsMultiSig {
+		return nil, nil, fmt.Errorf("multi-signature account should be created by CreateMultiSigAssetsAccount")
+	}
+
 	if account.Required == 0 {
 		account.Required = 1
 	}
@@ -77,7 +82,6 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 		}
 		account.PublicKey = childKey.GetPublicKey().OWEncode()
 		account.Index = uint64(newAccIndex)
-		account.AccountID = account.GetAccountID()
 
 		wallet.AccountIndex = newAccIndex
 	} else {
@@ -115,38 +119,99 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 		return nil, nil, fmt.Errorf("account publicKey is empty")
 	}
 
+	account.AccountID = account.GetAccountID()
+
 	//保存钱包到本地应用数据库
-	db, err := wm.OpenDB(appID)
+	db, err := wm.OpenDataStore(appID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	tx, err := db.Begin(true)
+	err = db.Update(func(tx DataStore) error {
+
+		err := tx.Save(wallet)
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(account)
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	defer tx.Rollback()
+	log.Debug("new account create success:", account.AccountID)
 
-	err = tx.Save(wallet)
+	addresses, err := wm.CreateAddress(appID, walletID, account.GetAccountID(), 1)
 	if err != nil {
+		log.Debug("new address create failed, unexpected error:", err)
+	}
+
+	var addr *openwallet.Address
+	if len(addresses) > 0 {
+		addr = addresses[0]
+		account.AddressIndex++
+	}
+
+	return account, addr, nil
+}
+
+// CreateMultiSigAssetsAccount 创建多重签名资产账户，只保存账户，不改变创建者钱包的账户索引
+//@param wallets	拥有者钱包，RootPub为拥有者提供的扩展公钥
+//@param required	必要签名数
+//@param creator	创建者钱包，账户归属于创建者
+func (wm *WalletManager) CreateMultiSigAssetsAccount(appID, alias, symbol string, wallets []*openwallet.Wallet, required uint, creator *openwallet.Wallet) (*openwallet.AssetsAccount, *openwallet.Address, error) {
+
+	if len(alias) == 0 {
+		return nil, nil, fmt.Errorf("account alias is empty")
+	}
+
+	if len(symbol) == 0 {
+		return nil, nil, fmt.Errorf("account symbol is empty")
+	}
 
+	if _, err := GetSymbolInfo(symbol); err != nil {
 		return nil, nil, err
 	}
 
-	err = tx.Save(account)
+	account, err := openwallet.NewMultiSigAccount(wallets, required, creator)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = tx.Commit()
+	account.Alias = alias
+	account.Symbol = symbol
+
+	//同一组拥有者和必要签名数的账户已存在，不重复创建，避免重置地址索引
+	if _, err = wm.GetAssetsAccountInfo(appID, "", account.AccountID); err == nil {
+		return nil, nil, fmt.Errorf("multi-signature account: %s already exists", account.AccountID)
+	}
+
+	if _, err = wm.GetWalletInfo(appID, creator.WalletID); err != nil {
+		//创建者钱包不在应用中，创建非托管钱包
+		_, _, err = wm.CreateWallet(appID, &openwallet.Wallet{
+			Alias:    "imported",
+			WalletID: creator.WalletID,
+			IsTrust:  false,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	db, err := wm.OpenDataStore(appID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	log.Debug("new account create success:", account.AccountID)
+	err = db.Save(account)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	addresses, err := wm.CreateAddress(appID, walletID, account.GetAccountID(), 1)
+	log.Debug("new multi-signature account create success:", account.AccountID)
+
+	addresses, err := wm.CreateAddress(appID, creator.WalletID, account.AccountID, 1)
 	if err != nil {
 		log.Debug("new address create failed, unexpected error:", err)
 	}
@@ -160,6 +225,83 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 	return account, addr, nil
 }
 
+// GetAssetsAccountXPub 导出资产账户的扩展公钥，BIP32序列化，版本字节按账户的曲线类型选择
+func (wm *WalletManager) GetAssetsAccountXPub(appID, accountID string, isTestNet bool) (string, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return "", err
+	}
+
+	account, err := wrapper.GetAssetsAccountInfo(accountID)
+	if err != nil {
+		return "", err
+	}
+
+	if account.IsMultiSig {
+		return "", fmt.Errorf("multi-signature account has no single extended public key")
+	}
+
+	return openwallet.EncodeXPub(account.PublicKey, isTestNet)
+}
+
+// CreateWatchOnlyAssetsAccount 通过扩展公钥创建观察资产账户，应用不持有私钥也可以衍生新的收款地址
+//@param xpub	BIP32序列化或OW编码的账户扩展公钥
+//@param hdPath	扩展公钥的衍生路径，用于记录地址的衍生路径，深度和最后一级序号需与扩展公钥一致
+func (wm *WalletManager) CreateWatchOnlyAssetsAccount(appID, walletID, alias, symbol, xpub, hdPath string) (*openwallet.AssetsAccount, *openwallet.Address, error) {
+
+	if len(hdPath) == 0 {
+		return nil, nil, fmt.Errorf("account hdPath is empty")
+	}
+
+	symbolInfo, err := GetSymbolInfo(symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := openwallet.ParseXPub(xpub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.CurveType != symbolInfo.CurveType() {
+		return nil, nil, fmt.Errorf("xpub curve type %x is not match %s curve type %x", info.CurveType, symbol, symbolInfo.CurveType())
+	}
+
+	if err = info.CheckPath(hdPath); err != nil {
+		return nil, nil, err
+	}
+
+	wallet, err := wm.GetWalletInfo(appID, walletID)
+	if err != nil {
+		//观察钱包，没有私钥文件
+		wallet, _, err = wm.CreateWallet(appID, &openwallet.Wallet{
+			WalletID:  walletID,
+			Alias:     alias,
+			IsTrust:   false,
+			WatchOnly: true,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if wallet.IsTrust {
+		return nil, nil, fmt.Errorf("wallet[%s] is trusted, can not create watch-only account", walletID)
+	}
+
+	account := &openwallet.AssetsAccount{
+		WalletID:  walletID,
+		Alias:     alias,
+		Symbol:    symbol,
+		HDPath:    hdPath,
+		PublicKey: info.OWPub,
+		Index:     uint64(info.ChildNumber &^ owkeychain.HardenedKeyStart),
+		Required:  1,
+		IsTrust:   false,
+	}
+
+	return wm.CreateAssetsAccount(appID, walletID, "", account, nil)
+}
+
 // GetAssetsAccountInfo
 func (wm *WalletManager) GetAssetsAccountInfo(appID, walletID, accountID string) (*openwallet.AssetsAccount, error) {
 
@@ -288,34 +430,25 @@ func (wm *WalletManager) CreateAddress(appID, walletID string, accountID string,
 	}
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
 	defer wrapper.CloseDB()
 
-	tx, err := db.Begin(true)
-	if err != nil {
-		return nil, err
-	}
+	err = db.Update(func(tx DataStore) error {
 
-	defer tx.Rollback()
-
-	for _, addr := range addrs {
-		err = tx.Save(addr)
-		if err != nil {
-			return nil, err
+		for _, addr := range addrs {
+			err := tx.Save(addr)
+			if err != nil {
+				return err
+			}
 		}
-	}
-
-	account.AddressIndex = account.AddressIndex + int(count)
 
-	err = tx.Save(account)
-	if err != nil {
-		return nil, err
-	}
+		account.AddressIndex = account.AddressIndex + int(count)
 
-	err = tx.Commit()
+		return tx.Save(account)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -423,45 +556,41 @@ func (wm *WalletManager) ImportWatchOnlyAddress(appID, walletID, accountID strin
 	createdAt := time.Now()
 
 	//保存钱包到本地应用数据库
-	db, err := wm.OpenDB(appID)
+	db, err := wm.OpenDataStore(appID)
 	if err != nil {
 		return err
 	}
 
-	tx, err := db.Begin(true)
-	if err != nil {
-		return err
-	}
-
-	defer tx.Rollback()
-
 	//importAddrs := make([]openwallet.ImportAddress, 0)
 
-	for _, a := range addresses {
-		a.WatchOnly = true //观察地址
-		a.Symbol = strings.ToUpper(account.Symbol)
-		a.AccountID = account.AccountID
-		a.CreatedTime = createdAt.Unix()
-		err = tx.Save(a)
-		if err != nil {
-			return err
-		}
+	err = db.Update(func(tx DataStore) error {
 
-		key := wm.encodeSourceKey(appID, a.AccountID)
-		wm.AddAddressForBlockScan(a.Address, key)
+		for _, a := range addresses {
+			a.WatchOnly = true //观察地址
+			a.Symbol = strings.ToUpper(account.Symbol)
+			a.AccountID = account.AccountID
+			a.CreatedTime = createdAt.Unix()
+			err := tx.Save(a)
+			if err != nil {
+				return err
+			}
 
-		//记录要导入到核心钱包的地址
-		imported := openwallet.ImportAddress{
-			Address: *a,
-		}
+			key := wm.encodeSourceKey(appID, a.AccountID)
+			wm.AddAddressForBlockScan(a.Address, key)
 
-		err = tx.Save(&imported)
-		if err != nil {
-			return err
+			//记录要导入到核心钱包的地址
+			imported := openwallet.ImportAddress{
+				Address: *a,
+			}
+
+			err = tx.Save(&imported)
+			if err != nil {
+				return err
+			}
 		}
-	}
 
-	err = tx.Commit()
+		return nil
+	})
 	if err != nil {
 		return err
 	}
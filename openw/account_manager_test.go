@@ -0,0 +1,195 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testXPubAddressDecoder 公钥的hex作为地址
+type testXPubAddressDecoder struct {
+	openwallet.AddressDecoderV2Base
+}
+
+func (dec *testXPubAddressDecoder) PublicKeyToAddress(pub []byte, isTestnet bool) (string, error) {
+	return "addr_" + hex.EncodeToString(pub), nil
+}
+
+func (dec *testXPubAddressDecoder) RedeemScriptToAddress(pubs [][]byte, required uint64, isTestnet bool) (string, error) {
+	addr := fmt.Sprintf("multi_%d", required)
+	for _, pub := range pubs {
+		addr += "_" + hex.EncodeToString(pub)
+	}
+	return addr, nil
+}
+
+type testXPubAdapter struct {
+	openwallet.AssetsAdapterBase
+}
+
+func (a *testXPubAdapter) CurveType() uint32 {
+	return owcrypt.ECC_CURVE_SECP256K1
+}
+
+func (a *testXPubAdapter) GetAddressDecode() openwallet.AddressDecoder {
+	return &testXPubAddressDecoder{}
+}
+
+func TestWalletManager_CreateWatchOnlyAssetsAccount(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "xpub_app"
+	defer tm.CloseDB(appID)
+
+	RegAssets("XPBT", &testXPubAdapter{})
+
+	//冷钱包衍生账户扩展公钥
+	seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+	key, err := hdkeystore.NewHDKey(seed, "cold", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed, unexpected error: %v", err)
+	}
+	hdPath := hdkeystore.OpenwCoinTypePath + "/1'"
+	accountKey, err := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath failed, unexpected error: %v", err)
+	}
+	xpub, err := openwallet.EncodeXPub(accountKey.GetPublicKey().OWEncode(), false)
+	if err != nil {
+		t.Fatalf("EncodeXPub failed, unexpected error: %v", err)
+	}
+
+	//充值服务只有扩展公钥
+	account, addr, err := tm.CreateWatchOnlyAssetsAccount(appID, "W1", "deposit", "XPBT", xpub, hdPath)
+	if err != nil {
+		t.Fatalf("CreateWatchOnlyAssetsAccount failed, unexpected error: %v", err)
+	}
+	if account.IsTrust || account.Index != 1 || addr == nil {
+		t.Fatalf("CreateWatchOnlyAssetsAccount account = %+v, address = %v", account, addr)
+	}
+
+	wallet, err := tm.GetWalletInfo(appID, "W1")
+	if err != nil || !wallet.WatchOnly || wallet.IsTrust || len(wallet.KeyFile) > 0 {
+		t.Errorf("watch-only wallet = %+v, err: %v", wallet, err)
+	}
+
+	addrs, err := tm.CreateAddress(appID, "W1", account.AccountID, 2)
+	if err != nil || len(addrs) != 2 {
+		t.Fatalf("CreateAddress = %v, err: %v", addrs, err)
+	}
+
+	//收款地址与私钥衍生的一致
+	for _, a := range append(addrs, addr) {
+		childKey, err := key.DerivedKeyWithPath(fmt.Sprintf("%s/0/%d", hdPath, a.Index), owcrypt.ECC_CURVE_SECP256K1)
+		if err != nil {
+			t.Fatalf("DerivedKeyWithPath failed, unexpected error: %v", err)
+		}
+		if want := "addr_" + hex.EncodeToString(childKey.GetPublicKeyBytes()); a.Address != want {
+			t.Errorf("address[%d] = %s, want %s", a.Index, a.Address, want)
+		}
+	}
+
+	exported, err := tm.GetAssetsAccountXPub(appID, account.AccountID, false)
+	if err != nil || exported != xpub {
+		t.Errorf("GetAssetsAccountXPub = %s, err: %v", exported, err)
+	}
+
+	//衍生路径与扩展公钥不一致
+	_, _, err = tm.CreateWatchOnlyAssetsAccount(appID, "W1", "path", "XPBT", xpub, hdkeystore.OpenwCoinTypePath+"/2'")
+	if err == nil {
+		t.Errorf("CreateWatchOnlyAssetsAccount should fail with other hdPath")
+	}
+
+	//曲线类型不一致
+	edKey, _ := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_ED25519)
+	_, _, err = tm.CreateWatchOnlyAssetsAccount(appID, "W1", "ed", "XPBT", edKey.GetPublicKey().OWEncode(), hdPath)
+	if err == nil {
+		t.Errorf("CreateWatchOnlyAssetsAccount should fail with other curve")
+	}
+}
+
+func TestWalletManager_CreateMultiSigAssetsAccount(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "multisig_app"
+	defer tm.CloseDB(appID)
+
+	RegAssets("XPBT", &testXPubAdapter{})
+
+	w, _, err := tm.CreateWallet(appID, &openwallet.Wallet{Alias: "hot", IsTrust: true, Password: "12345678"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+
+	a1, _, err := tm.CreateAssetsAccount(appID, w.WalletID, "12345678", &openwallet.AssetsAccount{Alias: "a1", Symbol: "XPBT", IsTrust: true}, nil)
+	if err != nil {
+		t.Fatalf("CreateAssetsAccount failed, unexpected error: %v", err)
+	}
+
+	owners := make([]*openwallet.Wallet, 0)
+	for i := 0; i < 2; i++ {
+		seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+		key, _ := hdkeystore.NewHDKey(seed, fmt.Sprintf("o%d", i), hdkeystore.OpenwCoinTypePath)
+		rootPath := hdkeystore.OpenwCoinTypePath + "/1'"
+		childKey, err := key.DerivedKeyWithPath(rootPath, owcrypt.ECC_CURVE_SECP256K1)
+		if err != nil {
+			t.Fatalf("DerivedKeyWithPath failed, unexpected error: %v", err)
+		}
+		owners = append(owners, &openwallet.Wallet{WalletID: key.KeyID, RootPath: rootPath, RootPub: childKey.GetPublicKey().OWEncode()})
+	}
+
+	creator := &openwallet.Wallet{WalletID: w.WalletID, RootPath: a1.HDPath, RootPub: a1.PublicKey}
+	ms, addr, err := tm.CreateMultiSigAssetsAccount(appID, "ms", "XPBT", owners, 2, creator)
+	if err != nil {
+		t.Fatalf("CreateMultiSigAssetsAccount failed, unexpected error: %v", err)
+	}
+	if !ms.IsMultiSig || len(ms.OwnerKeys) != 3 || addr == nil || !strings.HasPrefix(addr.Address, "multi_2_") {
+		t.Fatalf("multi-signature account = %+v, address = %v", ms, addr)
+	}
+
+	//重复创建同一多重签名账户
+	if _, _, err = tm.CreateMultiSigAssetsAccount(appID, "ms", "XPBT", owners, 2, creator); err == nil {
+		t.Errorf("CreateMultiSigAssetsAccount should fail with existing account")
+	}
+
+	//多重签名账户不占用创建者钱包的账户索引
+	a2, _, err := tm.CreateAssetsAccount(appID, w.WalletID, "12345678", &openwallet.AssetsAccount{Alias: "a2", Symbol: "XPBT", IsTrust: true}, nil)
+	if err != nil {
+		t.Fatalf("CreateAssetsAccount failed, unexpected error: %v", err)
+	}
+	if a2.Index != a1.Index+1 {
+		t.Errorf("next trusted account index = %d, want %d", a2.Index, a1.Index+1)
+	}
+
+	//其他拥有者公钥的普通账户，账户ID和地址保持原有计算方式
+	a3, addr3, err := tm.CreateAssetsAccount(appID, w.WalletID, "12345678", &openwallet.AssetsAccount{Alias: "a3", Symbol: "XPBT", IsTrust: true}, []string{owners[0].RootPub})
+	if err != nil {
+		t.Fatalf("CreateAssetsAccount failed, unexpected error: %v", err)
+	}
+	if a3.AccountID != openwallet.GenAccountID(a3.PublicKey) || a3.OwnerKeys[0] != a3.PublicKey {
+		t.Errorf("multi-owner account ID = %s, owners = %v", a3.AccountID, a3.OwnerKeys)
+	}
+	if addr3 == nil || !strings.HasPrefix(addr3.Address, "addr_") {
+		t.Errorf("multi-owner account address = %v, want single key address", addr3)
+	}
+}
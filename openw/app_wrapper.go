@@ -16,7 +16,6 @@
 package openw
 
 import (
-	"github.com/asdine/storm"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
 
@@ -50,7 +49,7 @@ func NewAppWrapper(args ...interface{}) *AppWrapper {
 func (wrapper *AppWrapper) GetWalletInfo(walletID string) (*openwallet.Wallet, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -69,15 +68,15 @@ func (wrapper *AppWrapper) GetWalletInfo(walletID string) (*openwallet.Wallet, e
 func (wrapper *AppWrapper) GetWalletList(offset, limit int) ([]*openwallet.Wallet, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
 	defer wrapper.CloseDB()
 
 	var wallets []*openwallet.Wallet
-	err = db.All(&wallets, storm.Limit(limit), storm.Skip(offset))
-	if err != nil {
+	err = db.Find(&wallets, offset, limit)
+	if err != nil && err != ErrDataNotFound {
 		return nil, err
 	}
 
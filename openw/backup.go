@@ -0,0 +1,448 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	//AppBackupVersion 应用备份文件的版本
+	AppBackupVersion = 1
+	//AppBackupFileExt 应用备份文件的扩展名
+	AppBackupFileExt = ".owbk"
+
+	appBackupMagic        = "OWBK"
+	appBackupManifestName = "manifest.json"
+	appBackupDBDir        = "db"
+	appBackupKeyDir       = "key"
+	appBackupSaltLen      = 32
+	appBackupScryptN      = 1 << 15
+	appBackupScryptR      = 8
+	appBackupScryptP      = 1
+)
+
+//AppBackupFile 备份文件中的单个文件
+type AppBackupFile struct {
+	Name   string `json:"name"`   //归档中的路径，db/<appID>.db 或 key/<文件名>
+	Size   int64  `json:"size"`   //文件大小
+	SHA256 string `json:"sha256"` //文件内容的sha256
+}
+
+//AppBackupManifest 应用备份的清单
+type AppBackupManifest struct {
+	Version   int              `json:"version"`
+	AppID     string           `json:"appID"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Files     []*AppBackupFile `json:"files"`
+	Config    *Config          `json:"config"` //备份时的配置，仅作参考，恢复时不覆盖当前配置
+}
+
+//BackupApp 备份应用的数据库，托管钱包的密钥文件和配置，
+//使用password加密为一个带校验和的备份文件，保存在Config.BackupDir，返回备份文件路径。
+//备份文件格式：OWBK | 版本(1字节) | salt(32字节) | nonce | AES-256-GCM(gzip(tar)) | sha256(之前的全部内容)
+//只支持storm数据存储，sql数据存储请使用数据库自身的备份工具。
+func (wm *WalletManager) BackupApp(appID, password string) (string, error) {
+
+	if len(password) == 0 {
+		return "", fmt.Errorf("backup password is empty")
+	}
+
+	if wm.cfg.DataStoreType == SQLDataStoreType {
+		return "", fmt.Errorf("backup is not supported for sql data store")
+	}
+
+	db, err := wm.OpenDataStore(appID)
+	if err != nil {
+		return "", err
+	}
+
+	stormDS, ok := db.(*stormDataStore)
+	if !ok {
+		return "", fmt.Errorf("backup is not supported for the data store of app: %s", appID)
+	}
+
+	manifest := &AppBackupManifest{
+		Version:   AppBackupVersion,
+		AppID:     appID,
+		CreatedAt: time.Now(),
+		Config:    wm.cfg,
+	}
+
+	//在只读事务中复制数据库，得到一致的快照
+	var dbData bytes.Buffer
+	err = stormDS.db.Bolt.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&dbData)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("backup app db failed, unexpected error: %v", err)
+	}
+
+	files := map[string][]byte{
+		path.Join(appBackupDBDir, appID+".db"): dbData.Bytes(),
+	}
+
+	//托管钱包的密钥文件
+	var wallets []*openwallet.Wallet
+	if err = db.Find(&wallets, 0, 0); err != nil && err != ErrDataNotFound {
+		return "", err
+	}
+	for _, w := range wallets {
+		if len(w.KeyFile) == 0 {
+			continue
+		}
+		keyjson, err := ioutil.ReadFile(w.KeyFile)
+		if err != nil {
+			return "", fmt.Errorf("backup wallet: %s key file failed, unexpected error: %v", w.WalletID, err)
+		}
+		files[path.Join(appBackupKeyDir, filepath.Base(w.KeyFile))] = keyjson
+	}
+
+	archive, err := encodeAppBackup(manifest, files, password)
+	if err != nil {
+		return "", err
+	}
+
+	backupFile := filepath.Join(wm.cfg.BackupDir, fmt.Sprintf("%s-%s%s", appID, manifest.CreatedAt.Format("20060102150405"), AppBackupFileExt))
+	if err = writeBackupFile(backupFile, archive); err != nil {
+		return "", err
+	}
+
+	log.Infof("app: %s has been backup to: %s", appID, backupFile)
+
+	return backupFile, nil
+}
+
+//VerifyAppBackup 校验备份文件的校验和，解密并核对每个文件的sha256，返回备份清单
+func (wm *WalletManager) VerifyAppBackup(backupFile, password string) (*AppBackupManifest, error) {
+	manifest, _, err := readAppBackup(backupFile, password)
+	return manifest, err
+}
+
+//RestoreApp 校验备份文件后恢复应用的数据库和密钥文件。
+//本地的数据库或密钥文件的修改时间晚于备份时间时拒绝覆盖，force为true时强制覆盖。
+//恢复后钱包记录的密钥文件和数据库路径更新为当前配置的路径。
+func (wm *WalletManager) RestoreApp(appID, backupFile, password string, force bool) (*AppBackupManifest, error) {
+
+	if wm.cfg.DataStoreType == SQLDataStoreType {
+		return nil, fmt.Errorf("restore is not supported for sql data store")
+	}
+
+	manifest, files, err := readAppBackup(backupFile, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.AppID != appID {
+		return nil, fmt.Errorf("backup file is belong to app: %s, not %s", manifest.AppID, appID)
+	}
+
+	//目标路径
+	targets := make(map[string]string, len(files))
+	for name := range files {
+		dir, base := path.Split(name)
+		if base == "" || base == "." || base == ".." {
+			return nil, fmt.Errorf("backup file contains invalid file: %s", name)
+		}
+		switch path.Clean(dir) {
+		case appBackupDBDir:
+			if base != appID+".db" {
+				return nil, fmt.Errorf("backup file contains unknown db: %s", name)
+			}
+			targets[name] = wm.DBFile(appID)
+		case appBackupKeyDir:
+			targets[name] = filepath.Join(wm.cfg.KeyDir, base)
+		default:
+			return nil, fmt.Errorf("backup file contains unknown file: %s", name)
+		}
+	}
+
+	//检查是否会覆盖更新的数据
+	if !force {
+		for name, target := range targets {
+			fi, err := os.Stat(target)
+			if err != nil {
+				continue
+			}
+			current, err := ioutil.ReadFile(target)
+			if err == nil && bytes.Equal(current, files[name]) {
+				continue
+			}
+			if fi.ModTime().After(manifest.CreatedAt) {
+				return nil, fmt.Errorf("%s was modified after the backup created at %s, use force to overwrite it",
+					target, manifest.CreatedAt.Format(time.RFC3339))
+			}
+		}
+	}
+
+	//数据库文件被替换前先关闭
+	wm.CloseDB(appID)
+
+	for name, target := range targets {
+		if err = writeBackupFile(target, files[name]); err != nil {
+			return nil, fmt.Errorf("restore %s failed, unexpected error: %v", target, err)
+		}
+	}
+
+	//更新钱包的密钥文件路径
+	db, err := wm.OpenDataStore(appID)
+	if err != nil {
+		return nil, err
+	}
+	var wallets []*openwallet.Wallet
+	if err = db.Find(&wallets, 0, 0); err != nil && err != ErrDataNotFound {
+		return nil, err
+	}
+	for _, w := range wallets {
+		changed := false
+		if len(w.KeyFile) > 0 {
+			if keyFile := filepath.Join(wm.cfg.KeyDir, filepath.Base(w.KeyFile)); keyFile != w.KeyFile {
+				w.KeyFile = keyFile
+				changed = true
+			}
+		}
+		if w.DBFile != wm.DBFile(appID) {
+			w.DBFile = wm.DBFile(appID)
+			changed = true
+		}
+		if changed {
+			if err = db.Save(w); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	log.Infof("app: %s has been restored from: %s", appID, backupFile)
+
+	return manifest, nil
+}
+
+//encodeAppBackup 打包并加密备份文件
+func encodeAppBackup(manifest *AppBackupManifest, files map[string][]byte, password string) ([]byte, error) {
+
+	for name, data := range files {
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, &AppBackupFile{
+			Name:   name,
+			Size:   int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Name < manifest.Files[j].Name
+	})
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	tw := tar.NewWriter(gz)
+	writeEntry := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err = writeEntry(appBackupManifestName, manifestJSON); err != nil {
+		return nil, err
+	}
+	for _, f := range manifest.Files {
+		if err = writeEntry(f.Name, files[f.Name]); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, appBackupSaltLen)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAppBackupCipher(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(appBackupMagic), byte(AppBackupVersion))
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	//头部作为附加数据，防止版本和salt被篡改
+	archive := aead.Seal(header, nonce, plain.Bytes(), header)
+	sum := sha256.Sum256(archive)
+	return append(archive, sum[:]...), nil
+}
+
+//readAppBackup 校验并解密备份文件，返回清单和文件内容
+func readAppBackup(backupFile, password string) (*AppBackupManifest, map[string][]byte, error) {
+
+	archive, err := ioutil.ReadFile(backupFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headerLen := len(appBackupMagic) + 1 + appBackupSaltLen
+	if len(archive) < headerLen+sha256.Size || string(archive[:len(appBackupMagic)]) != appBackupMagic {
+		return nil, nil, fmt.Errorf("%s is not an app backup file", backupFile)
+	}
+
+	body, checksum := archive[:len(archive)-sha256.Size], archive[len(archive)-sha256.Size:]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], checksum) {
+		return nil, nil, fmt.Errorf("backup file checksum is mismatched, the file is corrupted")
+	}
+
+	if version := int(body[len(appBackupMagic)]); version > AppBackupVersion {
+		return nil, nil, fmt.Errorf("unsupported app backup version: %d", version)
+	}
+
+	salt := body[len(appBackupMagic)+1 : headerLen]
+	aead, err := newAppBackupCipher(password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < headerLen+aead.NonceSize() {
+		return nil, nil, fmt.Errorf("%s is not an app backup file", backupFile)
+	}
+	header := body[:headerLen+aead.NonceSize()]
+	nonce := header[headerLen:]
+
+	plain, err := aead.Open(nil, nonce, body[len(header):], header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not decrypt backup file with given password")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	var (
+		manifest *AppBackupManifest
+		files    = make(map[string][]byte)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == appBackupManifestName {
+			manifest = new(AppBackupManifest)
+			if err = json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		files[hdr.Name] = data
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("backup file manifest is missing")
+	}
+	if len(manifest.Files) != len(files) {
+		return nil, nil, fmt.Errorf("backup file contents is mismatched with manifest")
+	}
+	for _, f := range manifest.Files {
+		data, ok := files[f.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("backup file: %s is missing", f.Name)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, fmt.Errorf("backup file: %s checksum is mismatched", f.Name)
+		}
+	}
+
+	return manifest, files, nil
+}
+
+//newAppBackupCipher 使用scrypt从密码派生AES-256-GCM密钥
+func newAppBackupCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, appBackupScryptN, appBackupScryptR, appBackupScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//writeBackupFile 先写临时文件再改名，避免写入中断留下不完整的文件
+func writeBackupFile(file string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	f.Close()
+	return os.Rename(f.Name(), file)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestWalletManager_BackupApp(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "backup_app"
+	defer tm.CloseDB(appID)
+
+	w, _, err := tm.CreateWallet(appID, &openwallet.Wallet{Alias: "hot", IsTrust: true, Password: "12345678"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+
+	backupFile, err := tm.BackupApp(appID, "backup password")
+	if err != nil {
+		t.Fatalf("BackupApp failed, unexpected error: %v", err)
+	}
+
+	manifest, err := tm.VerifyAppBackup(backupFile, "backup password")
+	if err != nil {
+		t.Fatalf("VerifyAppBackup failed, unexpected error: %v", err)
+	}
+	if manifest.AppID != appID || len(manifest.Files) != 2 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if _, err = tm.VerifyAppBackup(backupFile, "wrong password"); err == nil {
+		t.Errorf("VerifyAppBackup should fail with wrong password")
+	}
+
+	//备份文件损坏
+	archive, _ := ioutil.ReadFile(backupFile)
+	broken := append([]byte{}, archive...)
+	broken[len(broken)/2] ^= 0x01
+	brokenFile := backupFile + ".broken"
+	ioutil.WriteFile(brokenFile, broken, 0600)
+	if _, err = tm.VerifyAppBackup(brokenFile, "backup password"); err == nil {
+		t.Errorf("VerifyAppBackup should fail with broken file")
+	}
+
+	//备份后创建了新钱包，不强制时拒绝覆盖
+	time.Sleep(10 * time.Millisecond)
+	w2, _, err := tm.CreateWallet(appID, &openwallet.Wallet{Alias: "cold", IsTrust: true, Password: "12345678"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+	if _, err = tm.RestoreApp(appID, backupFile, "backup password", false); err == nil {
+		t.Fatalf("RestoreApp should refuse to overwrite newer data")
+	}
+	if _, err = tm.RestoreApp("other_app", backupFile, "backup password", true); err == nil {
+		t.Errorf("RestoreApp should fail with other app")
+	}
+
+	//删除密钥文件后强制恢复
+	os.Remove(w.KeyFile)
+	if _, err = tm.RestoreApp(appID, backupFile, "backup password", true); err != nil {
+		t.Fatalf("RestoreApp failed, unexpected error: %v", err)
+	}
+
+	wallets, err := tm.GetWalletList(appID, 0, 0)
+	if err != nil {
+		t.Fatalf("GetWalletList failed, unexpected error: %v", err)
+	}
+	if len(wallets) != 1 || wallets[0].WalletID != w.WalletID {
+		t.Errorf("restored wallets = %v, want only %s", wallets, w.WalletID)
+	}
+	if _, err = tm.GetWalletInfo(appID, w2.WalletID); err == nil {
+		t.Errorf("wallet created after backup should not be restored")
+	}
+
+	key, err := wallets[0].HDKey("12345678")
+	if err != nil || key.KeyID != w.WalletID {
+		t.Errorf("restored key file is invalid, unexpected error: %v", err)
+	}
+}
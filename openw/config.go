@@ -15,7 +15,11 @@
 
 package openw
 
-import "path/filepath"
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
 
 var (
 	defaultDataDir = filepath.Join(".", "openw_data")
@@ -28,6 +32,17 @@ type Config struct {
 	SupportAssets   []string //支持的资产类型
 	EnableBlockScan bool
 	ConfigDir       string
+	DataStoreType   string //数据存储类型，storm：每个应用一个boltdb文件（默认），sql：共享的SQL数据库
+	SQLDriver       string //SQL数据库驱动名，需要使用者导入驱动，如：sqlite3，mysql，postgres
+	SQLDataSource   string //SQL数据库连接参数
+	//ConfirmThresholds 各主链交易单达到最终确认所需的确认数，key为主链symbol，没有配置的主链不跟踪确认数
+	ConfirmThresholds map[string]uint64
+	//TxRebroadcastPeriod 已广播未上链的交易单重新广播间隔，0不启用交易单跟踪任务
+	TxRebroadcastPeriod time.Duration
+	//TxDropTimeout 交易单广播后超过该时间还没上链，标记为丢弃，0不丢弃
+	TxDropTimeout time.Duration
+	//TxSidSubmitTimeout 业务订单号正在广播超过该时间，视为进程中断，按交易单ID核对广播结果
+	TxSidSubmitTimeout time.Duration
 }
 
 func NewConfig() *Config {
@@ -44,10 +59,27 @@ func NewConfig() *Config {
 	c.SupportAssets = []string{"BTC", "ETH", "QTUM", "NAS", "TRX"}
 	//开启区块扫描
 	c.EnableBlockScan = true
+	//数据存储类型
+	c.DataStoreType = StormDataStoreType
+	//交易确认数阈值
+	c.ConfirmThresholds = make(map[string]uint64)
+	//交易单跟踪，默认不启用
+	c.TxRebroadcastPeriod = 0
+	c.TxDropTimeout = 0
+	//业务订单号广播超时
+	c.TxSidSubmitTimeout = 10 * time.Minute
 
 	return &c
 }
 
+//SetConfirmThreshold 设置主链交易单达到最终确认所需的确认数，symbol不区分大小写，0为不跟踪
+func (c *Config) SetConfirmThreshold(symbol string, threshold uint64) {
+	if c.ConfirmThresholds == nil {
+		c.ConfirmThresholds = make(map[string]uint64)
+	}
+	c.ConfirmThresholds[strings.ToUpper(symbol)] = threshold
+}
+
 //loadConfig 加载配置文件
 //@param path 配置文件路径
 func loadConfig(path string) *Config {
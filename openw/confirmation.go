@@ -0,0 +1,182 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	//等待更新确认数的区块队列长度，队列满时丢弃，下一个区块会按最新高度重新计算
+	defaultConfirmQueueSize = 64
+)
+
+//ConfirmThreshold 主链交易单达到最终确认所需的确认数，0为不跟踪
+func (wm *WalletManager) ConfirmThreshold(symbol string) uint64 {
+	if wm.cfg.ConfirmThresholds == nil {
+		return 0
+	}
+	return wm.cfg.ConfirmThresholds[strings.ToUpper(symbol)]
+}
+
+//pushConfirmHeader 把新区块加入确认数更新队列，不阻塞区块扫描通知
+func (wm *WalletManager) pushConfirmHeader(header *openwallet.BlockHeader) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	if wm.confirmHeaders == nil || wm.ConfirmThreshold(header.Symbol) == 0 {
+		return
+	}
+	select {
+	case wm.confirmHeaders <- header:
+	default:
+		log.Warn("confirmation update queue is full, skip block:", header.Symbol, header.Height)
+	}
+}
+
+//confirmationUpdateRuntime 后台逐个处理新区块，更新交易单确认数，stop关闭后退出
+func (wm *WalletManager) confirmationUpdateRuntime(headers <-chan *openwallet.BlockHeader, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case header := <-headers:
+			err := wm.UpdateConfirmations(header)
+			if err != nil {
+				log.Error("update transaction confirmations failed, unexpected error:", err)
+			}
+		}
+	}
+}
+
+//confirmHeight 主链已更新确认数的区块高度，没有更新过返回0
+func (wm *WalletManager) confirmHeight(symbol string) uint64 {
+	wm.confirmMu.Lock()
+	defer wm.confirmMu.Unlock()
+	return wm.confirmHeights[strings.ToUpper(symbol)]
+}
+
+//setConfirmHeight 记录主链已更新确认数的区块高度，返回上一次的高度
+func (wm *WalletManager) setConfirmHeight(symbol string, height uint64) uint64 {
+	wm.confirmMu.Lock()
+	defer wm.confirmMu.Unlock()
+	if wm.confirmHeights == nil {
+		wm.confirmHeights = make(map[string]uint64)
+	}
+	symbol = strings.ToUpper(symbol)
+	last := wm.confirmHeights[symbol]
+	wm.confirmHeights[symbol] = height
+	return last
+}
+
+//confirmStartHeight 需要更新确认数的最低区块高度。
+//上一次更新的高度之前已达到阈值的交易单不需要再查询，第一次更新时查询全部未达到阈值的交易单
+func confirmStartHeight(last, height, threshold uint64) uint64 {
+	if last == 0 {
+		return 1
+	}
+	if height < last {
+		last = height
+	}
+	if last+1 <= threshold {
+		return 1
+	}
+	return last + 1 - threshold
+}
+
+//UpdateConfirmations 按新区块高度重新计算所有应用中该主链交易单的确认数，
+//确认数达到阈值的交易单通知观察者
+func (wm *WalletManager) UpdateConfirmations(header *openwallet.BlockHeader) error {
+
+	threshold := wm.ConfirmThreshold(header.Symbol)
+	if threshold == 0 {
+		return nil
+	}
+
+	last := wm.setConfirmHeight(header.Symbol, header.Height)
+	startHeight := confirmStartHeight(last, header.Height, threshold)
+
+	//加载已存在所有app
+	appIDs, err := wm.loadAllAppIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, appID := range appIDs {
+
+		wrapper, err := wm.NewWalletWrapper(appID, "")
+		if err != nil {
+			return err
+		}
+
+		txWrapper := NewTransactionWrapper(wrapper)
+		finalized, err := txWrapper.UpdateTransactionConfirmations(header.Symbol, startHeight, header.Height, threshold)
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range finalized {
+			wm.notifyTransactionFinalized(appID, wrapper, tx)
+		}
+	}
+
+	return nil
+}
+
+//notifyTransactionFinalized 通知观察者交易单达到最终确认，并更新交易单跟踪状态
+func (wm *WalletManager) notifyTransactionFinalized(appID string, wrapper *WalletWrapper, tx *openwallet.Transaction) {
+
+	log.Debug("TransactionFinalized:", appID, tx.TxID, tx.Confirm)
+	for o, _ := range wm.observers {
+		o.BlockTxFinalizedNotify(appID, tx)
+	}
+
+	err := wm.trackFinalizedTransaction(appID, wrapper, tx)
+	if err != nil {
+		log.Error("track finalized transaction failed, unexpected error:", err)
+	}
+}
+
+//finalizeSavedTransaction 新保存的主链交易单，按已更新确认数的区块高度计算确认数，
+//保存时已达到阈值的交易单不会再被UpdateConfirmations处理，在此通知观察者
+//@param prevConfirm 保存前记录的确认数，没有记录为0
+func (wm *WalletManager) finalizeSavedTransaction(appID string, wrapper *WalletWrapper, tx *openwallet.Transaction, prevConfirm int64) error {
+
+	threshold := wm.ConfirmThreshold(tx.Coin.Symbol)
+	if threshold == 0 || tx.BlockHeight == 0 || prevConfirm >= int64(threshold) {
+		return nil
+	}
+
+	if height := wm.confirmHeight(tx.Coin.Symbol); height >= tx.BlockHeight {
+		confirm := int64(height - tx.BlockHeight + 1)
+		if confirm > tx.Confirm {
+			txWrapper := NewTransactionWrapper(wrapper)
+			err := txWrapper.SaveTransactionConfirm(tx.WxID, confirm)
+			if err != nil {
+				return err
+			}
+			tx.Confirm = confirm
+		}
+	}
+
+	if tx.Confirm >= int64(threshold) {
+		wm.notifyTransactionFinalized(appID, wrapper, tx)
+	}
+
+	return nil
+}
@@ -19,9 +19,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"time"
 )
 
 // CreateSmartContractTransaction
@@ -196,16 +196,28 @@ func (wm *WalletManager) SignSmartContractTransaction(appID, walletID, accountID
 		return nil, openwallet.ConvertError(err)
 	}
 
-	//解锁钱包
-	err = wrapper.UnlockWallet(password, 5*time.Second)
+	//密码为空时使用已解锁钱包的密钥，否则临时解密密钥，签名后清零
+	err = wm.withWalletKey(appID, account.WalletID, password, wrapper, func() error {
+		key, err := wrapper.HDKey()
+		if err != nil {
+			return err
+		}
+		if signErr := signSmartContractRawTransaction(key, rawTx); signErr != nil {
+			return signErr
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, openwallet.ConvertError(err)
 	}
 
-	key, err := wrapper.HDKey()
-	if err != nil {
-		return nil, openwallet.ConvertError(err)
-	}
+	log.Debug("transaction has been signed successfully")
+
+	return rawTx, nil
+}
+
+//signSmartContractRawTransaction 使用钱包密钥签名合约交易单
+func signSmartContractRawTransaction(key *hdkeystore.HDKey, rawTx *openwallet.SmartContractRawTransaction) *openwallet.Error {
 
 	for accountID, keySignatures := range rawTx.Signatures {
 		//log.Infof("accountID: %s", accountID)
@@ -213,9 +225,12 @@ func (wm *WalletManager) SignSmartContractTransaction(appID, walletID, accountID
 			for _, keySignature := range keySignatures {
 
 				childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
+				if err != nil {
+					return openwallet.ConvertError(err)
+				}
 				keyBytes, err := childKey.GetPrivateKeyBytes()
 				if err != nil {
-					return nil, openwallet.ConvertError(err)
+					return openwallet.ConvertError(err)
 				}
 				//log.Debug("privateKey:", hex.EncodeToString(keyBytes))
 
@@ -235,7 +250,7 @@ func (wm *WalletManager) SignSmartContractTransaction(appID, walletID, accountID
 
 				signature, v, sigErr := owcrypt.Signature(keyBytes, nil, txHash, keySignature.EccType)
 				if sigErr != owcrypt.SUCCESS {
-					return nil, openwallet.Errorf(openwallet.ErrSystemException, "transaction hash sign failed")
+					return openwallet.Errorf(openwallet.ErrSystemException, "transaction hash sign failed")
 				}
 
 				if keySignature.RSV {
@@ -250,7 +265,33 @@ func (wm *WalletManager) SignSmartContractTransaction(appID, walletID, accountID
 		rawTx.Signatures[accountID] = keySignatures
 	}
 
-	log.Debug("transaction has been signed successfully")
+	return nil
+}
 
-	return rawTx, nil
+//GetSmartContractReceipts 获取应用的合约交易回执
+//cols除了回执字段外，支持ContractID，以及ReceiptQueryEvent，ReceiptQueryStartHeight，ReceiptQueryEndHeight
+func (wm *WalletManager) GetSmartContractReceipts(appID string, offset, limit int, cols ...interface{}) ([]*openwallet.SmartContractReceipt, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	receipts, err := wrapper.GetSmartContractReceipts(offset, limit, cols...)
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+//GetSmartContractReceiptByWxID 通过WxID获取合约交易回执
+func (wm *WalletManager) GetSmartContractReceiptByWxID(appID, wxID string) (*openwallet.SmartContractReceipt, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper.GetSmartContractReceiptByWxID(wxID)
 }
@@ -0,0 +1,217 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+// 多重签名交易单的签名收集流程：
+// 	1. 创建者构建交易单后，ExportPendingTransaction 导出待签交易单，发给其他拥有者
+// 	2. 拥有者 ImportPendingTransaction 导入后，CoSignTransaction 使用自己的钱包密码补充签名，再导出返回
+// 	3. 创建者 MergeTransactionSignatures 合并各方签名，已签拥有者达到 Required 后 IsCompleted = true，才可以 SubmitTransaction
+// 联署签名前由适配器实现的 openwallet.TransactionMessageVerifier 校验被签消息与RawHex一致，
+// 合并签名和广播前都以拥有者公钥校验签名。
+
+// ExportPendingTransaction 导出待签名的交易单
+func (wm *WalletManager) ExportPendingTransaction(rawTx *openwallet.RawTransaction) ([]byte, error) {
+
+	if rawTx == nil {
+		return nil, fmt.Errorf("raw transaction is nil")
+	}
+
+	if !rawTx.IsBuilt {
+		return nil, fmt.Errorf("raw transaction is not built")
+	}
+
+	if rawTx.IsSubmit {
+		return nil, fmt.Errorf("raw transaction has been submitted")
+	}
+
+	return json.Marshal(rawTx)
+}
+
+// ImportPendingTransaction 导入待签名的交易单
+func (wm *WalletManager) ImportPendingTransaction(data []byte) (*openwallet.RawTransaction, error) {
+
+	var rawTx openwallet.RawTransaction
+	err := json.Unmarshal(data, &rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("raw transaction is invalid, unexpected error: %v", err)
+	}
+
+	if rawTx.Account == nil {
+		return nil, fmt.Errorf("raw transaction account is empty")
+	}
+
+	if len(rawTx.Signatures) == 0 {
+		return nil, fmt.Errorf("raw transaction signatures is empty")
+	}
+
+	rawTx.CheckCompleted()
+
+	return &rawTx, nil
+}
+
+// CoSignTransaction 拥有者使用自己的钱包补充签名
+// 签名者需要在应用中已有该多重签名账户，以账户的PublicKey作为拥有者标识
+func (wm *WalletManager) CoSignTransaction(appID, walletID, password string, rawTx *openwallet.RawTransaction) (*openwallet.RawTransaction, error) {
+
+	if rawTx == nil || rawTx.Account == nil {
+		return nil, fmt.Errorf("raw transaction account is empty")
+	}
+
+	account, err := wm.GetAssetsAccountInfo(appID, walletID, rawTx.Account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(walletID) == 0 {
+		walletID = account.WalletID
+	}
+
+	owner := openwallet.SignatureOwnerKey(account)
+	keySignatures, ok := rawTx.Signatures[owner]
+	if !ok {
+		return nil, fmt.Errorf("wallet[%s] is not an owner of the transaction", walletID)
+	}
+
+	if rawTx.IsOwnerSigned(owner) {
+		log.Debugf("owner %s has signed the transaction", owner)
+		return rawTx, nil
+	}
+
+	wrapper, err := wm.NewWalletWrapper(appID, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	//不盲签，被签消息必须能由交易原生数据重新计算得到
+	err = wm.verifyTransactionMessages(wrapper, account, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := wrapper.HDKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, keySignature := range keySignatures {
+
+		if keySignature == nil || keySignature.Address == nil {
+			return nil, fmt.Errorf("key signature address is empty")
+		}
+
+		//多重签名地址的签名路径，以拥有者自己的账户路径衍生
+		path := keySignature.Address.HDPath
+		if account.IsMultiSig {
+			path = fmt.Sprintf("%s/%d/%d", account.HDPath, common.BoolToUInt(keySignature.Address.IsChange), keySignature.Address.Index)
+		}
+
+		err = signKeySignature(key, path, keySignature)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rawTx.CheckCompleted()
+
+	log.Debugf("owner %s has co-signed the transaction, completed: %v", owner, rawTx.IsCompleted)
+
+	return rawTx, nil
+}
+
+// MergeTransactionSignatures 合并多个来源的签名，以本地保存的账户拥有者公钥校验每个签名
+func (wm *WalletManager) MergeTransactionSignatures(appID string, rawTx *openwallet.RawTransaction, others ...*openwallet.RawTransaction) (*openwallet.RawTransaction, error) {
+
+	if rawTx == nil || rawTx.Account == nil {
+		return nil, fmt.Errorf("raw transaction account is empty")
+	}
+
+	account, err := wm.GetAssetsAccountInfo(appID, "", rawTx.Account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range others {
+		if other == nil {
+			continue
+		}
+
+		if other.RawHex != rawTx.RawHex {
+			return nil, fmt.Errorf("raw transaction to merge is not the same transaction")
+		}
+
+		err = rawTx.MergeSignatures(account, other.Signatures)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rawTx.CheckCompleted()
+
+	return rawTx, nil
+}
+
+// verifyTransactionMessages 由适配器从交易原生数据校验被签消息
+func (wm *WalletManager) verifyTransactionMessages(wrapper *WalletWrapper, account *openwallet.AssetsAccount, rawTx *openwallet.RawTransaction) error {
+
+	if len(rawTx.RawHex) == 0 {
+		return fmt.Errorf("raw transaction hex is empty")
+	}
+
+	assetsMgr, err := GetAssetsAdapter(account.Symbol)
+	if err != nil {
+		return err
+	}
+
+	verifier, ok := assetsMgr.GetTransactionDecoder().(openwallet.TransactionMessageVerifier)
+	if !ok {
+		return fmt.Errorf("[%s] transaction decoder can not verify transaction messages, refuse to co-sign", account.Symbol)
+	}
+
+	return verifier.VerifyTransactionMessages(wrapper, rawTx)
+}
+
+// signKeySignature 使用钱包密钥衍生路径的私钥签名被签消息
+func signKeySignature(key *hdkeystore.HDKey, path string, keySignature *openwallet.KeySignature) error {
+
+	msg, err := hex.DecodeString(keySignature.Message)
+	if err != nil {
+		return fmt.Errorf("key signature message is invalid, unexpected error: %v", err)
+	}
+
+	signature, v, err := signHashWithKey(key, path, keySignature.EccType, msg)
+	if err != nil {
+		return err
+	}
+
+	if keySignature.RSV {
+		signature = append(signature, v)
+	}
+
+	keySignature.Signature = hex.EncodeToString(signature)
+
+	return nil
+}
@@ -16,8 +16,11 @@
 package openw
 
 import (
-	"github.com/asdine/storm"
 	"fmt"
+	"reflect"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
 )
 
 type StormDB struct {
@@ -55,3 +58,150 @@ func (db *StormDB) Close() error {
 	return nil
 }
 
+//stormDataStore 基于storm的数据存储，node为数据库或者已开启的事务
+type stormDataStore struct {
+	db   *StormDB
+	node storm.Node
+	inTx bool
+}
+
+//NewStormDataStore 使用已打开的storm数据库创建数据存储
+func NewStormDataStore(db *StormDB) DataStore {
+	return &stormDataStore{db: db, node: db.DB}
+}
+
+//stormDBOf 数据存储使用的storm数据库，用于兼容旧的接口
+func stormDBOf(db DataStore) (*StormDB, error) {
+	s, ok := db.(*stormDataStore)
+	if !ok {
+		return nil, fmt.Errorf("data store is not storm db, use DataStore instead")
+	}
+	return s.db, nil
+}
+
+//stormError 统一没有找到数据的错误
+func stormError(err error) error {
+	if err == storm.ErrNotFound {
+		return ErrDataNotFound
+	}
+	return err
+}
+
+//stormMatchers 查询条件转为storm的匹配器
+func stormMatchers(conditions []*DataCondition) ([]q.Matcher, error) {
+	matchers := make([]q.Matcher, 0, len(conditions))
+	for _, c := range conditions {
+		switch c.Op {
+		case DataOpEq:
+			matchers = append(matchers, q.Eq(c.Field, c.Value))
+		case DataOpGte:
+			matchers = append(matchers, q.Gte(c.Field, c.Value))
+		case DataOpLte:
+			matchers = append(matchers, q.Lte(c.Field, c.Value))
+		case DataOpMatch:
+			matcher, ok := c.Value.(DataMatcher)
+			if !ok {
+				return nil, fmt.Errorf("field %s matcher is invalid", c.Field)
+			}
+			matchers = append(matchers, q.NewFieldMatcher(c.Field, matcher))
+		default:
+			return nil, fmt.Errorf("field %s operator is not supported", c.Field)
+		}
+	}
+	return matchers, nil
+}
+
+func (s *stormDataStore) Save(data interface{}) error {
+	return s.node.Save(data)
+}
+
+func (s *stormDataStore) Insert(data interface{}) error {
+
+	v, err := structValue(data)
+	if err != nil {
+		return err
+	}
+
+	//与SQL存储相同的主键解析
+	table, err := newSQLTable(v.Type())
+	if err != nil {
+		return err
+	}
+
+	//boltdb的写事务是独占的，在事务中检查主键后保存
+	return s.Update(func(tx DataStore) error {
+		node := tx.(*stormDataStore).node
+		exist := reflect.New(v.Type()).Interface()
+		err := node.One(table.key.name, v.FieldByIndex(table.key.index).Interface(), exist)
+		if err == nil {
+			return ErrDataExists
+		}
+		if err != storm.ErrNotFound {
+			return err
+		}
+		return node.Save(data)
+	})
+}
+
+func (s *stormDataStore) One(fieldName string, value interface{}, to interface{}) error {
+	return stormError(s.node.One(fieldName, value, to))
+}
+
+func (s *stormDataStore) Find(to interface{}, offset, limit int, conditions ...*DataCondition) error {
+
+	matchers, err := stormMatchers(conditions)
+	if err != nil {
+		return err
+	}
+
+	query := s.node.Select(q.And(matchers...))
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	return stormError(query.Skip(offset).Find(to))
+}
+
+func (s *stormDataStore) Delete(kind interface{}, conditions ...*DataCondition) error {
+
+	matchers, err := stormMatchers(conditions)
+	if err != nil {
+		return err
+	}
+
+	err = s.node.Select(q.And(matchers...)).Delete(kind)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+func (s *stormDataStore) Update(fn func(tx DataStore) error) error {
+
+	//已在事务中，直接执行
+	if s.inTx {
+		return fn(s)
+	}
+
+	tx, err := s.node.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(&stormDataStore{db: s.db, node: tx, inTx: true})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *stormDataStore) Close() error {
+	if s.inTx {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/common"
+)
+
+//数据存储类型
+const (
+	StormDataStoreType = "storm" //每个应用一个boltdb文件，默认
+	SQLDataStoreType   = "sql"   //多个应用共享一个SQL数据库，可供多个服务实例使用
+)
+
+var (
+	//ErrDataNotFound 没有找到数据
+	ErrDataNotFound = errors.New("data not found")
+	//ErrDataExists 主键已存在
+	ErrDataExists = errors.New("data already exists")
+)
+
+//DataOperator 查询条件的比较方式
+type DataOperator int
+
+const (
+	DataOpEq    DataOperator = iota //等于
+	DataOpGte                       //大于等于
+	DataOpLte                       //小于等于
+	DataOpMatch                     //Value实现DataMatcher，自定义匹配
+)
+
+//DataMatcher 自定义字段匹配器，用于列表等无法直接比较的字段
+type DataMatcher interface {
+	MatchField(v interface{}) (bool, error)
+}
+
+//DataCondition 查询条件
+type DataCondition struct {
+	Field string
+	Op    DataOperator
+	Value interface{}
+}
+
+//DataEq 字段等于
+func DataEq(field string, value interface{}) *DataCondition {
+	return &DataCondition{Field: field, Op: DataOpEq, Value: value}
+}
+
+//DataGte 字段大于等于
+func DataGte(field string, value interface{}) *DataCondition {
+	return &DataCondition{Field: field, Op: DataOpGte, Value: value}
+}
+
+//DataLte 字段小于等于
+func DataLte(field string, value interface{}) *DataCondition {
+	return &DataCondition{Field: field, Op: DataOpLte, Value: value}
+}
+
+//DataMatch 字段自定义匹配
+func DataMatch(field string, matcher DataMatcher) *DataCondition {
+	return &DataCondition{Field: field, Op: DataOpMatch, Value: matcher}
+}
+
+//DataStore 应用数据存储接口
+//openw的钱包，资产账户，地址，交易记录，出入账记录，区块头等数据都通过它持久化。
+//数据对象为结构体指针，以storm:"id"标记的字段为主键，查询字段为结构体的字段名（包括inline的匿名结构体字段）。
+type DataStore interface {
+
+	//Save 保存数据，主键已存在则覆盖
+	Save(data interface{}) error
+
+	//Insert 保存新数据，主键已存在返回ErrDataExists（SQL存储在事务中返回数据库的错误），多个服务实例并发插入时只有一个成功
+	Insert(data interface{}) error
+
+	//One 获取字段等于value的一条数据，没有返回ErrDataNotFound
+	One(fieldName string, value interface{}, to interface{}) error
+
+	//Find 查询满足全部条件的数据，to为结构体指针切片的指针，limit <= 0 不限制数量，没有返回ErrDataNotFound
+	Find(to interface{}, offset, limit int, conditions ...*DataCondition) error
+
+	//Delete 删除满足全部条件的数据，kind为结构体指针，没有匹配数据不返回错误
+	Delete(kind interface{}, conditions ...*DataCondition) error
+
+	//Update 在同一个事务中执行fn，fn返回错误则回滚
+	Update(fn func(tx DataStore) error) error
+
+	//Close 关闭存储
+	Close() error
+}
+
+//NewDataConditions 把成对的查询参数 (字段名, 值, ...) 解析为相等条件
+func NewDataConditions(cols ...interface{}) ([]*DataCondition, error) {
+
+	if len(cols)%2 != 0 {
+		return nil, fmt.Errorf("condition param is not pair")
+	}
+
+	conditions := make([]*DataCondition, 0, len(cols)/2)
+	for i := 0; i < len(cols); i = i + 2 {
+		field := common.NewString(cols[i])
+		conditions = append(conditions, DataEq(field.String(), cols[i+1]))
+	}
+
+	return conditions, nil
+}
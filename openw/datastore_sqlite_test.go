@@ -0,0 +1,79 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//sqlite驱动只在测试中使用，不加入模块依赖，运行前需要：go get github.com/mattn/go-sqlite3
+func init() {
+	testSQLDriver = "sqlite3"
+}
+
+func TestWalletManager_SQLDataStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openw_sql")
+	if err != nil {
+		t.Fatalf("TempDir failed, unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tc := NewConfig()
+	tc.KeyDir = filepath.Join(dir, "key")
+	tc.DBPath = filepath.Join(dir, "db")
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.DataStoreType = SQLDataStoreType
+	tc.SQLDriver = "sqlite3"
+	tc.SQLDataSource = filepath.Join(dir, "openw.sqlite") + "?_busy_timeout=3000"
+
+	tm := NewWalletManager(tc)
+	defer tm.sqlDB.Close()
+
+	appID := "sql_app"
+	_, _, err = tm.CreateWallet(appID, &openwallet.Wallet{WalletID: "W1", Alias: "alice"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+
+	wallets, err := tm.GetWalletList(appID, 0, 0)
+	if err != nil || len(wallets) != 1 || wallets[0].DBFile != "" {
+		t.Fatalf("GetWalletList = %v, err: %v", wallets, err)
+	}
+
+	//另一个实例共享同一个数据库
+	other := NewWalletManager(tc)
+	defer other.sqlDB.Close()
+
+	apps, err := other.loadAllAppIDs()
+	if err != nil || len(apps) != 1 || apps[0] != appID {
+		t.Fatalf("loadAllAppIDs = %v, err: %v", apps, err)
+	}
+
+	wallet, err := other.GetWalletInfo(appID, "W1")
+	if err != nil || wallet.Alias != "alice" {
+		t.Errorf("GetWalletInfo from other manager = %v, err: %v", wallet, err)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testSQLDriver SQL数据存储测试使用的驱动，为空时只测试storm，
+//以sqlite标签运行时为sqlite3：go test -tags sqlite ./openw
+var testSQLDriver string
+
+//testDataStores 使用临时目录打开storm和sqlite的数据存储
+func testDataStores(t *testing.T) (map[string]DataStore, func()) {
+	dir, err := ioutil.TempDir("", "openw_datastore")
+	if err != nil {
+		t.Fatalf("TempDir failed, unexpected error: %v", err)
+	}
+
+	stormDB, err := OpenStormDB(filepath.Join(dir, "app.db"))
+	if err != nil {
+		t.Fatalf("OpenStormDB failed, unexpected error: %v", err)
+	}
+
+	stores := map[string]DataStore{
+		StormDataStoreType: NewStormDataStore(stormDB),
+	}
+
+	if len(testSQLDriver) == 0 {
+		return stores, func() {
+			stormDB.Close()
+			os.RemoveAll(dir)
+		}
+	}
+
+	sqlDB, err := OpenSQLDataBase(testSQLDriver, filepath.Join(dir, "openw.sqlite")+"?_busy_timeout=3000")
+	if err != nil {
+		t.Fatalf("OpenSQLDataBase failed, unexpected error: %v", err)
+	}
+
+	sqlStore, err := sqlDB.AppDataStore("app")
+	if err != nil {
+		t.Fatalf("AppDataStore failed, unexpected error: %v", err)
+	}
+
+	stores[SQLDataStoreType] = sqlStore
+
+	return stores, func() {
+		stormDB.Close()
+		sqlDB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDataStore(t *testing.T) {
+	stores, clean := testDataStores(t)
+	defer clean()
+
+	for name, db := range stores {
+
+		wallet := &openwallet.Wallet{WalletID: "W1", Alias: "alice", AccountIndex: 1}
+		account := &openwallet.AssetsAccount{WalletID: "W1", AccountID: "A1", Symbol: "BTC", OwnerKeys: []string{"k1"}, AddressIndex: 1}
+
+		err := db.Update(func(tx DataStore) error {
+			if err := tx.Save(wallet); err != nil {
+				return err
+			}
+			if err := tx.Save(account); err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				addr := &openwallet.Address{Address: fmt.Sprintf("addr%d", i), AccountID: "A1", Index: uint64(i), IsChange: i == 2}
+				if err := tx.Save(addr); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("[%s] Update failed, unexpected error: %v", name, err)
+		}
+
+		var gotAccount openwallet.AssetsAccount
+		err = db.One("AccountID", "A1", &gotAccount)
+		if err != nil || gotAccount.Symbol != "BTC" || len(gotAccount.OwnerKeys) != 1 {
+			t.Errorf("[%s] One account = %+v, err: %v", name, gotAccount, err)
+		}
+
+		//主键相同则覆盖
+		wallet.Alias = "bob"
+		if err = db.Save(wallet); err != nil {
+			t.Fatalf("[%s] Save failed, unexpected error: %v", name, err)
+		}
+		var wallets []*openwallet.Wallet
+		err = db.Find(&wallets, 0, 0)
+		if err != nil || len(wallets) != 1 || wallets[0].Alias != "bob" {
+			t.Errorf("[%s] Find wallets = %d, err: %v", name, len(wallets), err)
+		}
+
+		var addrs []*openwallet.Address
+		err = db.Find(&addrs, 1, 1, DataEq("AccountID", "A1"))
+		if err != nil || len(addrs) != 1 || addrs[0].Address != "addr1" {
+			t.Errorf("[%s] Find page of addresses = %v, err: %v", name, addrs, err)
+		}
+
+		addrs = nil
+		err = db.Find(&addrs, 0, 0, DataEq("IsChange", true))
+		if err != nil || len(addrs) != 1 || addrs[0].Address != "addr2" {
+			t.Errorf("[%s] Find change addresses = %v, err: %v", name, addrs, err)
+		}
+
+		addrs = nil
+		err = db.Find(&addrs, 0, 0, DataEq("AccountID", "A2"))
+		if err != ErrDataNotFound {
+			t.Errorf("[%s] Find unknown account addresses err = %v, want ErrDataNotFound", name, err)
+		}
+
+		//出入账记录，inline字段可查询
+		for h := uint64(10); h < 13; h++ {
+			output := &openwallet.TxOutPut{}
+			output.Sid = fmt.Sprintf("sid%d", h)
+			output.AccountID = "A1"
+			output.BlockHeight = h
+			if err = db.Save(output); err != nil {
+				t.Fatalf("[%s] Save TxOutPut failed, unexpected error: %v", name, err)
+			}
+		}
+
+		var outputs []*openwallet.TxOutPut
+		err = db.Find(&outputs, 0, 0, DataGte("BlockHeight", uint64(11)), DataLte("BlockHeight", uint64(12)))
+		if err != nil || len(outputs) != 2 {
+			t.Errorf("[%s] Find outputs by height range = %d, err: %v", name, len(outputs), err)
+		}
+
+		err = db.Delete(&openwallet.TxOutPut{}, DataEq("BlockHeight", uint64(11)))
+		if err != nil {
+			t.Fatalf("[%s] Delete failed, unexpected error: %v", name, err)
+		}
+		err = db.Delete(&openwallet.TxOutPut{}, DataEq("BlockHeight", uint64(99)))
+		if err != nil {
+			t.Errorf("[%s] Delete nothing should not fail: %v", name, err)
+		}
+		outputs = nil
+		err = db.Find(&outputs, 0, 0, DataEq("AccountID", "A1"))
+		if err != nil || len(outputs) != 2 {
+			t.Errorf("[%s] outputs after delete = %d, err: %v", name, len(outputs), err)
+		}
+
+		//插入已存在的主键返回ErrDataExists，不覆盖
+		err = db.Insert(&openwallet.Wallet{WalletID: "W1", Alias: "carol"})
+		if err != ErrDataExists {
+			t.Errorf("[%s] Insert existing wallet err = %v, want ErrDataExists", name, err)
+		}
+		if err = db.Insert(&openwallet.Wallet{WalletID: "W3", Alias: "carol"}); err != nil {
+			t.Errorf("[%s] Insert failed, unexpected error: %v", name, err)
+		}
+		var w1 openwallet.Wallet
+		if err = db.One("WalletID", "W1", &w1); err != nil || w1.Alias != "bob" {
+			t.Errorf("[%s] wallet after insert = %+v, err: %v", name, w1, err)
+		}
+
+		//区块头
+		if err = db.Save(&openwallet.BlockHeader{Height: 100, Hash: "h100", Symbol: "BTC"}); err != nil {
+			t.Fatalf("[%s] Save BlockHeader failed, unexpected error: %v", name, err)
+		}
+		var header openwallet.BlockHeader
+		err = db.One("Hash", "h100", &header)
+		if err != nil || header.Height != 100 {
+			t.Errorf("[%s] One block header = %+v, err: %v", name, header, err)
+		}
+
+		//自定义匹配
+		receipt := &openwallet.SmartContractReceipt{TxID: "tx1", BlockHeight: 100, Events: []*openwallet.SmartContractEvent{{Event: "Transfer"}}}
+		if err = db.Save(openwallet.NewSmartContractReceiptRecord("C1", receipt)); err != nil {
+			t.Fatalf("[%s] Save receipt failed, unexpected error: %v", name, err)
+		}
+		var records []*openwallet.SmartContractReceiptRecord
+		err = db.Find(&records, 0, 0, DataEq("ContractID", "C1"), DataMatch("Events", &receiptEventMatcher{event: "Transfer"}))
+		if err != nil || len(records) != 1 {
+			t.Errorf("[%s] Find receipts by event = %d, err: %v", name, len(records), err)
+		}
+
+		//事务回滚
+		err = db.Update(func(tx DataStore) error {
+			if err := tx.Save(&openwallet.Wallet{WalletID: "W2"}); err != nil {
+				return err
+			}
+			return fmt.Errorf("rollback")
+		})
+		if err == nil {
+			t.Errorf("[%s] Update should return the error of fn", name)
+		}
+		var w2 openwallet.Wallet
+		if err = db.One("WalletID", "W2", &w2); err != ErrDataNotFound {
+			t.Errorf("[%s] rollback wallet err = %v, want ErrDataNotFound", name, err)
+		}
+	}
+}
+
+func TestSQLValue(t *testing.T) {
+
+	if v, err := sqlValue(uint64(math.MaxInt64)); err != nil || v != int64(math.MaxInt64) {
+		t.Errorf("sqlValue(MaxInt64) = %v, err: %v", v, err)
+	}
+
+	//超过BIGINT范围的无符号整数不能保存
+	if _, err := sqlValue(uint64(math.MaxUint64)); err == nil {
+		t.Errorf("sqlValue(MaxUint64) should fail")
+	}
+}
+
+func TestWalletManager_InitSQLDataBaseFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openw_sql")
+	if err != nil {
+		t.Fatalf("TempDir failed, unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tc := NewConfig()
+	tc.KeyDir = filepath.Join(dir, "key")
+	tc.DBPath = filepath.Join(dir, "db")
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.DataStoreType = SQLDataStoreType
+	tc.SQLDriver = "unknown_driver"
+
+	tm := NewWalletManager(tc)
+	if tm.InitError() == nil {
+		t.Fatalf("InitError should not be nil")
+	}
+
+	//数据操作返回初始化失败的原因
+	if _, err = tm.OpenDataStore("app"); err != tm.InitError() {
+		t.Errorf("OpenDataStore err = %v, want %v", err, tm.InitError())
+	}
+}
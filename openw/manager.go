@@ -45,17 +45,45 @@ type NotificationObject interface {
 
 	//BlockTxExtractDataNotify 区块提取结果通知
 	BlockTxExtractDataNotify(account *openwallet.AssetsAccount, data *openwallet.TxExtractData) error
+
+	//BlockSmartContractReceiptNotify 区块提取合约交易回执通知
+	BlockSmartContractReceiptNotify(appID string, receipt *openwallet.SmartContractReceipt) error
+
+	//BlockTxFinalizedNotify 交易单确认数达到阈值通知，每笔交易单只通知一次
+	BlockTxFinalizedNotify(appID string, tx *openwallet.Transaction) error
+
+	//TxBroadcastStateNotify 广播的交易单跟踪状态变化通知：submitted，seen，mined，confirmed，failed，dropped
+	TxBroadcastStateNotify(appID string, record *TxBroadcastRecord) error
+}
+
+//AppTxExtractDataNotificationObject 观察者可选实现，区块提取结果通知带上应用ID，实现后不再调用BlockTxExtractDataNotify
+type AppTxExtractDataNotificationObject interface {
+
+	//BlockAppTxExtractDataNotify 应用的区块提取结果通知
+	BlockAppTxExtractDataNotify(appID string, account *openwallet.AssetsAccount, data *openwallet.TxExtractData) error
 }
 
 //WalletManager OpenWallet钱包管理器
 type WalletManager struct {
-	appDB             map[string]*StormDB
+	appDB             map[string]DataStore
+	sqlDB             *SQLDataBase //数据存储类型为sql时，各应用共享的数据库
 	cfg               *Config
 	initialized       bool
+	initErr           error //初始化失败的原因
 	mu                sync.RWMutex
 	observers         map[NotificationObject]bool //观察者
 	importAddressTask *timer.TaskTimer
-	AddressInScanning map[string]string //加入扫描的地址
+	AddressInScanning map[string]string            //加入扫描的地址
+	confirmHeaders    chan *openwallet.BlockHeader //等待更新交易确认数的新区块
+	confirmStop       chan struct{}                //停止确认数更新任务
+	confirmMu         sync.Mutex
+	confirmHeights    map[string]uint64 //各主链已更新确认数的区块高度
+	txTrackerTask     *timer.TaskTimer  //交易单广播跟踪定时器
+	signerMu          sync.RWMutex
+	signers           map[string]openwallet.SignerProvider //已注册的签名提供者
+	unlockMu          sync.Mutex
+	unlocked          map[string]*unlockedWallet //已解锁的钱包
+	unlockMetrics     UnlockMetrics              //钱包解锁的统计
 }
 
 // NewWalletManager
@@ -82,8 +110,44 @@ func (wm *WalletManager) Init() {
 	file.MkdirAll(wm.cfg.KeyDir)
 
 	wm.observers = make(map[NotificationObject]bool)
-	wm.appDB = make(map[string]*StormDB)
+	wm.appDB = make(map[string]DataStore)
 	wm.AddressInScanning = make(map[string]string)
+	wm.signers = map[string]openwallet.SignerProvider{
+		LocalSignerName: NewLocalSignerProvider(),
+	}
+	wm.unlocked = make(map[string]*unlockedWallet)
+
+	//打开数据库失败，不启动后台任务和区块扫描，数据操作都返回该错误
+	wm.initErr = nil
+	if wm.cfg.DataStoreType == SQLDataStoreType {
+		sqlDB, err := OpenSQLDataBase(wm.cfg.SQLDriver, wm.cfg.SQLDataSource)
+		if err != nil {
+			wm.initErr = fmt.Errorf("open sql database failed, unexpected error: %v", err)
+			log.Error("openwallet Manager initialize failed:", wm.initErr)
+			wm.mu.Unlock()
+			return
+		}
+		wm.sqlDB = sqlDB
+	}
+
+	//确认数阈值的key统一为大写的主链symbol
+	thresholds := make(map[string]uint64)
+	for symbol, threshold := range wm.cfg.ConfirmThresholds {
+		thresholds[strings.ToUpper(symbol)] = threshold
+	}
+	wm.cfg.ConfirmThresholds = thresholds
+	wm.confirmHeights = make(map[string]uint64)
+
+	if len(wm.cfg.ConfirmThresholds) > 0 {
+		wm.confirmHeaders = make(chan *openwallet.BlockHeader, defaultConfirmQueueSize)
+		wm.confirmStop = make(chan struct{})
+		go wm.confirmationUpdateRuntime(wm.confirmHeaders, wm.confirmStop)
+	}
+
+	if wm.cfg.TxRebroadcastPeriod > 0 {
+		wm.txTrackerTask = timer.NewTask(wm.cfg.TxRebroadcastPeriod, wm.txTrackerRuntime)
+		wm.txTrackerTask.Start()
+	}
 
 	wm.initialized = true
 
@@ -130,18 +194,32 @@ func (wm *WalletManager) DBFile(appID string) string {
 }
 
 //OpenDB 打开应用数据库文件
+//Deprecated: 只支持storm数据存储，使用OpenDataStore
 func (wm *WalletManager) OpenDB(appID string) (*StormDB, error) {
 
+	db, err := wm.OpenDataStore(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	return stormDBOf(db)
+}
+
+//OpenDataStore 打开应用数据存储
+func (wm *WalletManager) OpenDataStore(appID string) (DataStore, error) {
+
 	var (
-		db  *StormDB
+		db  DataStore
 		err error
 		ok  bool
 	)
 
 	//数据库文件
+	wm.mu.RLock()
 	db, ok = wm.appDB[appID]
+	wm.mu.RUnlock()
 
-	if ok && db.Opened {
+	if ok {
 		return db, nil
 	}
 
@@ -151,19 +229,31 @@ func (wm *WalletManager) OpenDB(appID string) (*StormDB, error) {
 
 	//解锁进入后，再次确认是否已经存在
 	db, ok = wm.appDB[appID]
-	if ok && db.Opened {
+	if ok {
 		return db, nil
-
 	}
 
-	db, err = OpenStormDB(
-		wm.DBFile(appID),
-		storm.Batch(),
-		storm.BoltOptions(0600, &bolt.Options{Timeout: 3 * time.Second}),
-	)
-	log.Debug("open storm db appID:", appID)
-	if err != nil {
-		return nil, err
+	switch wm.cfg.DataStoreType {
+	case SQLDataStoreType:
+		if wm.sqlDB == nil {
+			return nil, wm.sqlNotOpenedError()
+		}
+		db, err = wm.sqlDB.AppDataStore(appID)
+		log.Debug("open sql data store appID:", appID)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		stormDB, err := OpenStormDB(
+			wm.DBFile(appID),
+			storm.Batch(),
+			storm.BoltOptions(0600, &bolt.Options{Timeout: 3 * time.Second}),
+		)
+		log.Debug("open storm db appID:", appID)
+		if err != nil {
+			return nil, err
+		}
+		db = NewStormDataStore(stormDB)
 	}
 
 	//return opendb, nil
@@ -172,15 +262,72 @@ func (wm *WalletManager) OpenDB(appID string) (*StormDB, error) {
 	return db, nil
 }
 
-//CloseDB 关闭应用数据库文件
+//CloseDB 关闭应用数据存储
 func (wm *WalletManager) CloseDB(appID string) error {
 
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
 	//数据库文件
 	db, ok := wm.appDB[appID]
 	if ok {
 		db.Close()
+		delete(wm.appDB, appID)
+	}
+
+	return nil
+}
+
+//InitError 初始化失败的原因，初始化成功返回nil
+func (wm *WalletManager) InitError() error {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.initErr
+}
+
+//sqlNotOpenedError SQL数据库没有打开的错误
+func (wm *WalletManager) sqlNotOpenedError() error {
+	if wm.initErr != nil {
+		return wm.initErr
+	}
+	return fmt.Errorf("sql database is not opened")
+}
+
+//Close 停止后台任务，关闭所有应用数据存储，再次调用Init可以重新启动
+func (wm *WalletManager) Close() error {
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if !wm.initialized {
+		return nil
+	}
+
+	//停止确认数更新
+	if wm.confirmStop != nil {
+		close(wm.confirmStop)
+		wm.confirmStop = nil
+		wm.confirmHeaders = nil
+	}
+
+	//停止交易单广播跟踪
+	if wm.txTrackerTask != nil {
+		wm.txTrackerTask.Stop()
+		wm.txTrackerTask = nil
+	}
+
+	for appID, db := range wm.appDB {
+		db.Close()
+		delete(wm.appDB, appID)
+	}
+
+	if wm.sqlDB != nil {
+		wm.sqlDB.Close()
+		wm.sqlDB = nil
 	}
 
+	wm.initialized = false
+
 	return nil
 }
 
@@ -192,6 +339,13 @@ func (wm *WalletManager) loadAllAppIDs() ([]string, error) {
 		dir  = wm.cfg.DBPath
 	)
 
+	if wm.cfg.DataStoreType == SQLDataStoreType {
+		if wm.sqlDB == nil {
+			return nil, wm.sqlNotOpenedError()
+		}
+		return wm.sqlDB.AppIDs()
+	}
+
 	//扫描key目录的所有钱包
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -296,14 +450,12 @@ func (wm *WalletManager) NewWalletWrapper(appID, walletID string) (*WalletWrappe
 	var walletWrapper *WalletWrapper
 
 	//打开数据库
-	db, err := wm.OpenDB(appID)
+	db, err := wm.OpenDataStore(appID)
 	if err != nil {
 		return nil, err
 	}
 
-	dbFile := WalletDBFile(wm.DBFile(appID))
-	wrapperAppID := WalletDBFile(appID)
-	wrapper := NewAppWrapper(wrapperAppID, dbFile, db)
+	wrapper := NewAppWrapper(AppID(appID), db)
 
 	if len(walletID) > 0 {
 
@@ -21,6 +21,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
@@ -73,6 +74,37 @@ func TestWalletManager_CreateWallet(t *testing.T) {
 
 }
 
+func TestWalletManager_CreateWalletWithMnemonic(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	defer tm.CloseDB("mnemonic_app")
+	defer tm.CloseDB("restore_app")
+
+	w := &openwallet.Wallet{Alias: "paper", Password: "12345678"}
+	nw, key, mnemonic, err := tm.CreateWalletWithMnemonic("mnemonic_app", w, hdkeystore.MnemonicJapanese, "passphrase")
+	if err != nil {
+		t.Fatalf("CreateWalletWithMnemonic failed, unexpected error: %v", err)
+	}
+	if nw.WalletID != key.KeyID || len(nw.KeyFile) == 0 {
+		t.Errorf("created wallet = %+v", nw)
+	}
+
+	//使用助记词在其他应用恢复相同的钱包
+	rw := &openwallet.Wallet{Alias: "restored", Password: "87654321"}
+	restored, _, err := tm.RestoreWalletWithMnemonic("restore_app", rw, mnemonic, hdkeystore.MnemonicJapanese, "passphrase")
+	if err != nil {
+		t.Fatalf("RestoreWalletWithMnemonic failed, unexpected error: %v", err)
+	}
+	if restored.WalletID != nw.WalletID {
+		t.Errorf("restored WalletID = %s, want %s", restored.WalletID, nw.WalletID)
+	}
+
+	_, _, err = tm.RestoreWalletWithMnemonic("restore_app", &openwallet.Wallet{Password: "87654321"}, mnemonic, hdkeystore.MnemonicEnglish, "passphrase")
+	if err == nil {
+		t.Errorf("restore with wrong language should fail")
+	}
+}
+
 func TestWalletManager_ConcurrentCreateWallet(t *testing.T) {
 
 	//w := &Wallet{Alias: "bitbank", IsTrust: true, Password: "12345678"}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//SignMessage 使用钱包地址的私钥签名消息，用于证明地址的所有权
+//私钥为地址HDPath路径，曲线为资产的CurveType，钱包选择了签名提供者时使用签名提供者
+func (wm *WalletManager) SignMessage(appID, walletID, address, password string, message []byte) (string, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, walletID)
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := wrapper.GetAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	if addr.WatchOnly || len(addr.HDPath) == 0 {
+		return "", fmt.Errorf("address: %s has no hdPath, can not sign message", address)
+	}
+
+	account, err := wrapper.GetAssetsAccountInfo(addr.AccountID)
+	if err != nil {
+		return "", err
+	}
+
+	if account.WalletID != walletID {
+		return "", fmt.Errorf("address: %s is not belong to wallet: %s", address, walletID)
+	}
+
+	if account.IsMultiSig {
+		return "", fmt.Errorf("multi-signature address can not sign message")
+	}
+
+	assetsMgr, err := GetAssetsAdapter(account.Symbol)
+	if err != nil {
+		return "", err
+	}
+
+	signer := openwallet.GetMessageSigner(assetsMgr)
+	curveType := assetsMgr.CurveType()
+
+	hash, err := signer.HashMessage(message)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := account.DerivePublicKey(addr.HDPath)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		signature []byte
+		v         byte
+	)
+	if wallet := wrapper.GetWallet(); wallet != nil && len(wallet.Signer) > 0 {
+		provider, err := wm.getSigner(wallet.Signer)
+		if err != nil {
+			return "", err
+		}
+		signature, v, err = provider.SignHash(wallet, addr.HDPath, curveType, hash, password)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		key, err := wrapper.HDKey(password)
+		if err != nil {
+			return "", err
+		}
+		signature, v, err = signHashWithKey(key, addr.HDPath, curveType, hash)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return signer.EncodeSignature(signature, v, publicKey)
+}
+
+//VerifyMessage 校验消息签名是否由地址的拥有者签名，地址不需要属于本地钱包
+func (wm *WalletManager) VerifyMessage(symbol, address string, message []byte, signature string) (bool, error) {
+
+	assetsMgr, err := GetAssetsAdapter(symbol)
+	if err != nil {
+		return false, err
+	}
+
+	return openwallet.GetMessageSigner(assetsMgr).VerifyMessage(address, message, signature)
+}
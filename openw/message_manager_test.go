@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+func TestWalletManager_SignMessage(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "msg_app"
+	defer tm.CloseDB(appID)
+
+	RegAssets("XPBT", &testXPubAdapter{})
+
+	//私钥由签名提供者保管，钱包只有账户扩展公钥
+	seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+	key, _ := hdkeystore.NewHDKey(seed, "hsm", hdkeystore.OpenwCoinTypePath)
+	hdPath := hdkeystore.OpenwCoinTypePath + "/0'"
+	accountKey, _ := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+	account, addr, err := tm.CreateWatchOnlyAssetsAccount(appID, key.KeyID, "proof", "XPBT", accountKey.GetPublicKey().OWEncode(), hdPath)
+	if err != nil {
+		t.Fatalf("CreateWatchOnlyAssetsAccount failed, unexpected error: %v", err)
+	}
+
+	software := NewSoftwareSignerProvider()
+	software.AddKey(key)
+	tm.RegisterSigner("soft", software)
+	if err = tm.SetWalletSigner(appID, key.KeyID, "soft"); err != nil {
+		t.Fatalf("SetWalletSigner failed, unexpected error: %v", err)
+	}
+
+	message := []byte("travel rule: beneficiary owns the address")
+	signature, err := tm.SignMessage(appID, key.KeyID, addr.Address, "", message)
+	if err != nil {
+		t.Fatalf("SignMessage failed, unexpected error: %v", err)
+	}
+
+	if ok, err := tm.VerifyMessage("XPBT", addr.Address, message, signature); !ok || err != nil {
+		t.Errorf("VerifyMessage = %v, err: %v", ok, err)
+	}
+	if ok, _ := tm.VerifyMessage("XPBT", addr.Address, []byte("other"), signature); ok {
+		t.Errorf("VerifyMessage should fail with other message")
+	}
+
+	if _, err = tm.SignMessage(appID, "other", addr.Address, "", message); err == nil {
+		t.Errorf("SignMessage should fail with other wallet")
+	}
+
+	if paths := software.SignedPaths(); len(paths) != 1 || paths[0] != addr.HDPath || account.HDPath != hdPath {
+		t.Errorf("SignedPaths = %v, want [%s]", paths, addr.HDPath)
+	}
+}
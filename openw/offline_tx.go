@@ -0,0 +1,154 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//ExportOfflineTransaction 导出构建完成的交易单为离线签名信封
+func (wm *WalletManager) ExportOfflineTransaction(appID, walletID, accountID string, rawTx *openwallet.RawTransaction) (string, error) {
+
+	if err := wm.checkOfflineAccount(appID, walletID, accountID, rawTx.Account); err != nil {
+		return "", err
+	}
+
+	env, err := openwallet.NewOfflineTxEnvelope(rawTx)
+	if err != nil {
+		return "", err
+	}
+
+	return env.Encode()
+}
+
+//ExportOfflineSmartContractTransaction 导出构建完成的智能合约交易单为离线签名信封
+func (wm *WalletManager) ExportOfflineSmartContractTransaction(appID, walletID, accountID string, rawTx *openwallet.SmartContractRawTransaction) (string, error) {
+
+	if err := wm.checkOfflineAccount(appID, walletID, accountID, rawTx.Account); err != nil {
+		return "", err
+	}
+
+	env, err := openwallet.NewOfflineContractTxEnvelope(rawTx)
+	if err != nil {
+		return "", err
+	}
+
+	return env.Encode()
+}
+
+//SignOfflineTransaction 离线主机签名信封，返回已签名的信封
+//钱包选择了签名提供者时使用签名提供者，否则使用password解密钱包密钥，password为空时使用已解锁的钱包密钥
+func (wm *WalletManager) SignOfflineTransaction(appID, password, envelope string) (string, error) {
+
+	env, err := openwallet.DecodeOfflineTxEnvelope(envelope)
+	if err != nil {
+		return "", err
+	}
+
+	wrapper, err := wm.NewWalletWrapper(appID, env.WalletID)
+	if err != nil {
+		return "", err
+	}
+
+	wallet := wrapper.GetWallet()
+	if wallet == nil {
+		return "", fmt.Errorf("wallet: %s is not found", env.WalletID)
+	}
+
+	if len(wallet.Signer) > 0 {
+		err = wm.signWithSigner(wallet, env.Signatures()[openwallet.SignatureOwnerKey(env.Account())], password)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		err = wm.withWalletKey(appID, env.WalletID, password, wrapper, func() error {
+			key, err := wrapper.HDKey()
+			if err != nil {
+				return err
+			}
+			return env.Sign(key)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Debug("offline transaction has been signed successfully")
+
+	return env.Encode()
+}
+
+//ImportOfflineTransaction 导入已签名的信封，校验后把签名合并到本地的交易单，之后可调用SubmitTransaction广播
+func (wm *WalletManager) ImportOfflineTransaction(appID, walletID, accountID string, rawTx *openwallet.RawTransaction, signed string) (*openwallet.RawTransaction, error) {
+
+	if err := wm.mergeOfflineEnvelope(appID, walletID, accountID, rawTx.Account, rawTx, signed); err != nil {
+		return nil, err
+	}
+
+	log.Debug("offline transaction signatures has been merged successfully")
+
+	return rawTx, nil
+}
+
+//ImportOfflineSmartContractTransaction 导入已签名的信封，校验后把签名合并到本地的智能合约交易单，之后可调用SubmitSmartContractTransaction广播
+func (wm *WalletManager) ImportOfflineSmartContractTransaction(appID, walletID, accountID string, rawTx *openwallet.SmartContractRawTransaction, signed string) (*openwallet.SmartContractRawTransaction, error) {
+
+	if err := wm.mergeOfflineEnvelope(appID, walletID, accountID, rawTx.Account, rawTx, signed); err != nil {
+		return nil, err
+	}
+
+	log.Debug("offline smart contract transaction signatures has been merged successfully")
+
+	return rawTx, nil
+}
+
+//mergeOfflineEnvelope 校验信封的完整性和签名，合并到本地交易单
+func (wm *WalletManager) mergeOfflineEnvelope(appID, walletID, accountID string, account *openwallet.AssetsAccount, rawTx interface{}, signed string) error {
+
+	if err := wm.checkOfflineAccount(appID, walletID, accountID, account); err != nil {
+		return err
+	}
+
+	env, err := openwallet.DecodeOfflineTxEnvelope(signed)
+	if err != nil {
+		return err
+	}
+
+	return env.MergeSignatures(rawTx)
+}
+
+//checkOfflineAccount 交易单的账户必须是应用中已保存的账户，扩展公钥一致
+func (wm *WalletManager) checkOfflineAccount(appID, walletID, accountID string, account *openwallet.AssetsAccount) error {
+
+	if account == nil {
+		return fmt.Errorf("transaction account is empty")
+	}
+
+	saved, err := wm.GetAssetsAccountInfo(appID, walletID, accountID)
+	if err != nil {
+		return err
+	}
+
+	if saved.AccountID != account.AccountID || saved.WalletID != account.WalletID ||
+		saved.PublicKey != account.PublicKey || saved.HDPath != account.HDPath {
+		return fmt.Errorf("transaction account is mismatched with account: %s", accountID)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestWalletManager_OfflineTransaction(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "offline_app"
+	defer tm.CloseDB(appID)
+
+	RegAssets("XPBT", &testXPubAdapter{})
+
+	w, _, err := tm.CreateWallet(appID, &openwallet.Wallet{Alias: "cold", IsTrust: true, Password: "12345678"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+	account, addr, err := tm.CreateAssetsAccount(appID, w.WalletID, "12345678", &openwallet.AssetsAccount{WalletID: w.WalletID, Alias: "a1", Symbol: "XPBT", IsTrust: true}, nil)
+	if err != nil {
+		t.Fatalf("CreateAssetsAccount failed, unexpected error: %v", err)
+	}
+
+	hash := owcrypt.Hash([]byte("offline transaction"), 0, owcrypt.HASH_ALG_SHA256)
+	newRawTx := func() *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			Coin:    openwallet.Coin{Symbol: "XPBT"},
+			Account: account,
+			RawHex:  "0100",
+			IsBuilt: true,
+			Signatures: map[string][]*openwallet.KeySignature{
+				openwallet.SignatureOwnerKey(account): {
+					{
+						EccType: owcrypt.ECC_CURVE_SECP256K1,
+						Address: addr,
+						Message: hex.EncodeToString(hash),
+					},
+				},
+			},
+		}
+	}
+
+	//在线主机导出，离线主机签名，在线主机导入
+	rawTx := newRawTx()
+	envelope, err := tm.ExportOfflineTransaction(appID, w.WalletID, account.AccountID, rawTx)
+	if err != nil {
+		t.Fatalf("ExportOfflineTransaction failed, unexpected error: %v", err)
+	}
+
+	if _, err = tm.SignOfflineTransaction(appID, "wrong-password", envelope); err == nil {
+		t.Errorf("SignOfflineTransaction should fail with wrong password")
+	}
+	signed, err := tm.SignOfflineTransaction(appID, "12345678", envelope)
+	if err != nil {
+		t.Fatalf("SignOfflineTransaction failed, unexpected error: %v", err)
+	}
+
+	//本地交易单与信封不一致
+	other := newRawTx()
+	other.RawHex = "0200"
+	if _, err = tm.ImportOfflineTransaction(appID, w.WalletID, account.AccountID, other, signed); err == nil {
+		t.Errorf("ImportOfflineTransaction should fail with other transaction")
+	}
+
+	//账户与应用保存的账户不一致
+	mismatched := newRawTx()
+	mismatched.Account = &openwallet.AssetsAccount{AccountID: account.AccountID, WalletID: account.WalletID, PublicKey: "owpub", HDPath: account.HDPath}
+	if _, err = tm.ImportOfflineTransaction(appID, w.WalletID, account.AccountID, mismatched, signed); err == nil {
+		t.Errorf("ImportOfflineTransaction should fail with mismatched account")
+	}
+
+	imported, err := tm.ImportOfflineTransaction(appID, w.WalletID, account.AccountID, rawTx, signed)
+	if err != nil {
+		t.Fatalf("ImportOfflineTransaction failed, unexpected error: %v", err)
+	}
+	ks := imported.Signatures[openwallet.SignatureOwnerKey(account)][0]
+	signature, _ := hex.DecodeString(ks.Signature)
+	pub, err := account.DerivePublicKey(addr.HDPath)
+	if err != nil {
+		t.Fatalf("DerivePublicKey failed, unexpected error: %v", err)
+	}
+	pub = owcrypt.PointDecompress(pub, owcrypt.ECC_CURVE_SECP256K1)[1:]
+	if owcrypt.Verify(pub, nil, hash, signature, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		t.Errorf("imported signature verify failed")
+	}
+
+	//未签名的信封不能导入
+	if _, err = tm.ImportOfflineTransaction(appID, w.WalletID, account.AccountID, newRawTx(), envelope); err == nil {
+		t.Errorf("ImportOfflineTransaction should fail with unsigned envelope")
+	}
+
+	//钱包已解锁，不需要密码
+	if _, err = tm.SignOfflineTransaction(appID, "", envelope); err == nil {
+		t.Errorf("SignOfflineTransaction should fail with locked wallet")
+	}
+	if err = tm.UnlockWallet(appID, w.WalletID, "12345678", time.Minute); err != nil {
+		t.Fatalf("UnlockWallet failed, unexpected error: %v", err)
+	}
+	defer tm.LockWallet(appID, w.WalletID)
+	if _, err = tm.SignOfflineTransaction(appID, "", envelope); err != nil {
+		t.Errorf("SignOfflineTransaction with unlocked wallet failed, unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,399 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+//内置的签名提供者名称
+const (
+	LocalSignerName = "local" //本地密钥文件
+)
+
+//PKCS#11的签名机制
+const (
+	CKM_ECDSA = 0x00001041
+	CKM_EDDSA = 0x00001057
+)
+
+//RegisterSigner 注册签名提供者，钱包的Signer为name时使用它签名
+func (wm *WalletManager) RegisterSigner(name string, provider openwallet.SignerProvider) {
+	wm.signerMu.Lock()
+	defer wm.signerMu.Unlock()
+	wm.signers[name] = provider
+}
+
+//getSigner 获取已注册的签名提供者
+func (wm *WalletManager) getSigner(name string) (openwallet.SignerProvider, error) {
+	wm.signerMu.RLock()
+	defer wm.signerMu.RUnlock()
+	provider, ok := wm.signers[name]
+	if !ok {
+		return nil, fmt.Errorf("signer provider: %s is not registered", name)
+	}
+	return provider, nil
+}
+
+//SetWalletSigner 设置钱包的签名提供者，name为空恢复为钱包密钥文件签名
+func (wm *WalletManager) SetWalletSigner(appID, walletID, name string) error {
+
+	if len(name) > 0 {
+		if _, err := wm.getSigner(name); err != nil {
+			return err
+		}
+	}
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return err
+	}
+
+	wallet, err := wrapper.GetWalletInfo(walletID)
+	if err != nil {
+		return err
+	}
+
+	wallet.Signer = name
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	return db.Save(wallet)
+}
+
+//signRawTransactionWithSigner 使用钱包的签名提供者签名交易单。
+//解析器支持签名提供者时由解析器通过WalletHashSigner签名，否则直接签名交易单的被签消息
+func (wm *WalletManager) signRawTransactionWithSigner(wrapper *WalletWrapper, txdecoder openwallet.TransactionDecoder, account *openwallet.AssetsAccount, rawTx *openwallet.RawTransaction, auth string) error {
+
+	wallet := wrapper.GetWallet()
+
+	if d, ok := txdecoder.(openwallet.SignerProviderDecoder); ok && d.SupportSignerProvider() {
+		provider, err := wm.getSigner(wallet.Signer)
+		if err != nil {
+			return err
+		}
+		return wrapper.withSigner(provider, auth, func() error {
+			return txdecoder.SignRawTransaction(wrapper, rawTx)
+		})
+	}
+
+	return wm.signWithSigner(wallet, rawTx.Signatures[openwallet.SignatureOwnerKey(account)], auth)
+}
+
+//signWithSigner 使用签名提供者签名被签消息
+func (wm *WalletManager) signWithSigner(wallet *openwallet.Wallet, keySignatures []*openwallet.KeySignature, auth string) error {
+
+	provider, err := wm.getSigner(wallet.Signer)
+	if err != nil {
+		return err
+	}
+
+	if len(keySignatures) == 0 {
+		return fmt.Errorf("transaction has no key signatures of wallet: %s", wallet.WalletID)
+	}
+
+	for _, keySignature := range keySignatures {
+
+		if keySignature.Address == nil {
+			return fmt.Errorf("key signature address is empty")
+		}
+
+		msg, err := hex.DecodeString(keySignature.Message)
+		if err != nil {
+			return fmt.Errorf("key signature message is invalid, unexpected error: %v", err)
+		}
+
+		signature, v, err := provider.SignHash(wallet, keySignature.Address.HDPath, keySignature.EccType, msg, auth)
+		if err != nil {
+			return err
+		}
+
+		if keySignature.RSV {
+			signature = append(signature, v)
+		}
+
+		keySignature.Signature = hex.EncodeToString(signature)
+	}
+
+	log.Debugf("transaction has been signed by signer provider: %s", wallet.Signer)
+
+	return nil
+}
+
+//signHashWithKey 使用HDKey衍生路径的私钥签名
+func signHashWithKey(key *hdkeystore.HDKey, hdPath string, eccType uint32, hash []byte) ([]byte, byte, error) {
+
+	childKey, err := key.DerivedKeyWithPath(hdPath, eccType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	signature, v, sigErr := owcrypt.Signature(keyBytes, nil, hash, eccType)
+	if sigErr != owcrypt.SUCCESS {
+		return nil, 0, fmt.Errorf("transaction hash sign failed")
+	}
+
+	return signature, v, nil
+}
+
+//LocalSignerProvider 本地密钥文件签名提供者，每次签名使用钱包密码解密密钥文件
+type LocalSignerProvider struct{}
+
+//NewLocalSignerProvider 创建本地密钥文件签名提供者
+func NewLocalSignerProvider() *LocalSignerProvider {
+	return &LocalSignerProvider{}
+}
+
+//SignHash 解密钱包密钥文件，衍生私钥签名
+func (p *LocalSignerProvider) SignHash(wallet *openwallet.Wallet, hdPath string, eccType uint32, hash []byte, auth string) ([]byte, byte, error) {
+
+	if len(wallet.KeyFile) == 0 {
+		return nil, 0, fmt.Errorf("wallet[%s] key file is not exist", wallet.WalletID)
+	}
+
+	if len(auth) == 0 {
+		return nil, 0, fmt.Errorf("password is empty")
+	}
+
+	keyjson, err := ioutil.ReadFile(wallet.KeyFile)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key, err := hdkeystore.DecryptHDKey(keyjson, auth)
+	if err != nil {
+		return nil, 0, err
+	}
+	//签名后清零解密的密钥
+	defer key.Zero()
+
+	return signHashWithKey(key, hdPath, eccType, hash)
+}
+
+//SoftwareSignerProvider 软件签名提供者，HDKey保存在内存中，用作外部签名器的测试替身
+type SoftwareSignerProvider struct {
+	mu     sync.Mutex
+	keys   map[string]*hdkeystore.HDKey
+	signed []string
+}
+
+//NewSoftwareSignerProvider 创建软件签名提供者
+func NewSoftwareSignerProvider() *SoftwareSignerProvider {
+	return &SoftwareSignerProvider{keys: make(map[string]*hdkeystore.HDKey)}
+}
+
+//AddKey 添加钱包的HDKey，钱包ID为KeyID
+func (p *SoftwareSignerProvider) AddKey(key *hdkeystore.HDKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[key.KeyID] = key
+}
+
+//SignedPaths 已签名的衍生路径
+func (p *SoftwareSignerProvider) SignedPaths() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.signed...)
+}
+
+//SignHash 使用内存中的HDKey签名，忽略auth
+func (p *SoftwareSignerProvider) SignHash(wallet *openwallet.Wallet, hdPath string, eccType uint32, hash []byte, auth string) ([]byte, byte, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[wallet.WalletID]
+	if !ok {
+		return nil, 0, fmt.Errorf("wallet[%s] key is not found", wallet.WalletID)
+	}
+
+	signature, v, err := signHashWithKey(key, hdPath, eccType, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p.signed = append(p.signed, hdPath)
+
+	return signature, v, nil
+}
+
+//PKCS11Session PKCS#11风格的HSM会话，由HSM驱动实现（如封装PKCS#11动态库的C_Login，C_FindObjects，C_Sign）
+type PKCS11Session interface {
+
+	//Login 使用PIN登录会话
+	Login(pin string) error
+
+	//Logout 登出会话
+	Logout() error
+
+	//FindKey 通过标签查找私钥对象
+	FindKey(label string) (uint, error)
+
+	//PublicKey 私钥对象对应的公钥，压缩或非压缩格式
+	PublicKey(handle uint) ([]byte, error)
+
+	//Sign 使用私钥对象和签名机制签名数据，ECDSA返回r||s
+	Sign(handle uint, mechanism uint, data []byte) ([]byte, error)
+}
+
+//PKCS11SignerProvider PKCS#11风格的HSM签名提供者，私钥在HSM中按钱包和衍生路径的标签保存，不离开HSM
+type PKCS11SignerProvider struct {
+	//KeyLabel 钱包衍生路径对应的私钥标签，默认为 walletID:hdPath
+	KeyLabel func(wallet *openwallet.Wallet, hdPath string) string
+
+	mu      sync.Mutex
+	session PKCS11Session
+	pin     string
+}
+
+//NewPKCS11SignerProvider 创建PKCS#11签名提供者，pin为空时使用签名请求的auth登录。
+//每次签名都重新登录，签名后登出，不同钱包的签名请求不会共用登录状态
+func NewPKCS11SignerProvider(session PKCS11Session, pin string) *PKCS11SignerProvider {
+	return &PKCS11SignerProvider{
+		KeyLabel: func(wallet *openwallet.Wallet, hdPath string) string {
+			return wallet.WalletID + ":" + hdPath
+		},
+		session: session,
+		pin:     pin,
+	}
+}
+
+//SignHash 在HSM中签名，secp256k1签名规范为low-S，并计算恢复值v
+func (p *PKCS11SignerProvider) SignHash(wallet *openwallet.Wallet, hdPath string, eccType uint32, hash []byte, auth string) ([]byte, byte, error) {
+
+	var mechanism uint
+	switch eccType {
+	case owcrypt.ECC_CURVE_SECP256K1, owcrypt.ECC_CURVE_SECP256R1:
+		mechanism = CKM_ECDSA
+	case owcrypt.ECC_CURVE_ED25519:
+		mechanism = CKM_EDDSA
+	default:
+		return nil, 0, fmt.Errorf("PKCS#11 signer is not support curve type: %x", eccType)
+	}
+
+	//PKCS#11会话不能并发使用
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin := p.pin
+	if len(pin) == 0 {
+		pin = auth
+	}
+	if len(pin) == 0 {
+		return nil, 0, fmt.Errorf("PKCS#11 pin is empty")
+	}
+	if err := p.session.Login(pin); err != nil {
+		return nil, 0, fmt.Errorf("PKCS#11 login failed, unexpected error: %v", err)
+	}
+	defer p.session.Logout()
+
+	handle, err := p.session.FindKey(p.KeyLabel(wallet, hdPath))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	signature, err := p.session.Sign(handle, mechanism, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if mechanism != CKM_ECDSA {
+		return signature, 0, nil
+	}
+
+	if len(signature) != 64 {
+		return nil, 0, fmt.Errorf("PKCS#11 ECDSA signature length is invalid: %d", len(signature))
+	}
+
+	signature = normalizeLowS(signature, eccType)
+
+	if eccType != owcrypt.ECC_CURVE_SECP256K1 {
+		return signature, 0, nil
+	}
+
+	pub, err := p.session.PublicKey(handle)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	v, err := recoverSecp256k1V(signature, hash, pub)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return signature, v, nil
+}
+
+//normalizeLowS s大于曲线阶的一半时，替换为n-s
+func normalizeLowS(signature []byte, eccType uint32) []byte {
+	n := new(big.Int).SetBytes(owcrypt.GetCurveOrder(eccType))
+	s := new(big.Int).SetBytes(signature[32:])
+	if s.Cmp(new(big.Int).Rsh(n, 1)) <= 0 {
+		return signature
+	}
+	s.Sub(n, s)
+	normalized := make([]byte, 64)
+	copy(normalized, signature[:32])
+	sb := s.Bytes()
+	copy(normalized[64-len(sb):], sb)
+	return normalized
+}
+
+//recoverSecp256k1V 尝试恢复公钥，与私钥对象的公钥一致的为签名的恢复值
+func recoverSecp256k1V(signature, hash, pub []byte) (byte, error) {
+
+	pubKey, err := btcec.ParsePubKey(pub)
+	if err != nil {
+		return 0, fmt.Errorf("PKCS#11 public key is invalid, unexpected error: %v", err)
+	}
+	compressed := pubKey.SerializeCompressed()
+
+	for v := byte(0); v < 2; v++ {
+		//紧凑签名头：27 + v + 4（压缩公钥）
+		compact := append([]byte{27 + 4 + v}, signature...)
+		recovered, _, err := ecdsa.RecoverCompact(compact, hash)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(recovered.SerializeCompressed(), compressed) {
+			return v, nil
+		}
+	}
+
+	return 0, fmt.Errorf("PKCS#11 signature does not match the public key")
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testPKCS11Session 私钥保存在内存中的PKCS#11会话，签名总是返回high-S
+type testPKCS11Session struct {
+	pin      string
+	keys     map[string][]byte
+	loggedIn bool
+}
+
+func (s *testPKCS11Session) Login(pin string) error {
+	if pin != s.pin {
+		return fmt.Errorf("CKR_PIN_INCORRECT")
+	}
+	s.loggedIn = true
+	return nil
+}
+
+func (s *testPKCS11Session) Logout() error {
+	s.loggedIn = false
+	return nil
+}
+
+func (s *testPKCS11Session) FindKey(label string) (uint, error) {
+	i := uint(0)
+	for l := range s.keys {
+		i++
+		if l == label {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("key: %s not found", label)
+}
+
+func (s *testPKCS11Session) privateKey(handle uint) []byte {
+	i := uint(0)
+	for _, k := range s.keys {
+		i++
+		if i == handle {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *testPKCS11Session) PublicKey(handle uint) ([]byte, error) {
+	pub, _ := owcrypt.GenPubkey(s.privateKey(handle), owcrypt.ECC_CURVE_SECP256K1)
+	return owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1), nil
+}
+
+func (s *testPKCS11Session) Sign(handle uint, mechanism uint, data []byte) ([]byte, error) {
+	if !s.loggedIn {
+		return nil, fmt.Errorf("CKR_USER_NOT_LOGGED_IN")
+	}
+	signature, _, ret := owcrypt.Signature(s.privateKey(handle), nil, data, owcrypt.ECC_CURVE_SECP256K1)
+	if ret != owcrypt.SUCCESS {
+		return nil, fmt.Errorf("CKR_FUNCTION_FAILED")
+	}
+	n := new(big.Int).SetBytes(owcrypt.GetCurveOrder(owcrypt.ECC_CURVE_SECP256K1))
+	sv := new(big.Int).SetBytes(signature[32:])
+	if sv.Cmp(new(big.Int).Rsh(n, 1)) <= 0 {
+		sv.Sub(n, sv)
+		sv.FillBytes(signature[32:])
+	}
+	return signature, nil
+}
+
+func TestWalletManager_SignTransactionWithSigner(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+
+	seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+	key, err := hdkeystore.NewHDKey(seed, "hsm", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed, unexpected error: %v", err)
+	}
+
+	//钱包没有密钥文件，签名不需要密码
+	wallet := &openwallet.Wallet{WalletID: key.KeyID, Signer: "soft"}
+	software := NewSoftwareSignerProvider()
+	software.AddKey(key)
+	tm.RegisterSigner("soft", software)
+
+	hdPath := hdkeystore.OpenwCoinTypePath + "/0'/0/3"
+	hash := owcrypt.Hash([]byte("signer provider"), 0, owcrypt.HASH_ALG_SHA256)
+	rawTx := &openwallet.RawTransaction{
+		Signatures: map[string][]*openwallet.KeySignature{
+			"A1": {
+				{
+					EccType: owcrypt.ECC_CURVE_SECP256K1,
+					Address: &openwallet.Address{HDPath: hdPath},
+					Message: hex.EncodeToString(hash),
+					RSV:     true,
+				},
+			},
+		},
+	}
+
+	if err = tm.signWithSigner(wallet, rawTx.Signatures["A1"], ""); err != nil {
+		t.Fatalf("signWithSigner failed, unexpected error: %v", err)
+	}
+
+	childKey, _ := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+	pub := owcrypt.PointDecompress(childKey.GetPublicKey().GetPublicKeyBytes(), owcrypt.ECC_CURVE_SECP256K1)[1:]
+	signature, _ := hex.DecodeString(rawTx.Signatures["A1"][0].Signature)
+	if len(signature) != 65 {
+		t.Fatalf("signature length = %d, want 65", len(signature))
+	}
+	if owcrypt.Verify(pub, nil, hash, signature[:64], owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		t.Errorf("signature verify failed")
+	}
+	if paths := software.SignedPaths(); len(paths) != 1 || paths[0] != hdPath {
+		t.Errorf("SignedPaths = %v, want [%s]", paths, hdPath)
+	}
+
+	//未注册的签名提供者
+	wallet.Signer = "unknown"
+	if err = tm.signWithSigner(wallet, rawTx.Signatures["A1"], ""); err == nil {
+		t.Errorf("signWithSigner should fail with unregistered signer")
+	}
+
+	//PKCS#11签名规范为low-S，恢复值与本地签名的计算一致
+	if v, err := recoverSecp256k1V(signature[:64], hash, childKey.GetPublicKey().GetPublicKeyBytes()); err != nil || v != signature[64] {
+		t.Fatalf("recoverSecp256k1V = %d, %v, want %d", v, err, signature[64])
+	}
+	keyBytes, _ := childKey.GetPrivateKeyBytes()
+	session := &testPKCS11Session{pin: "1234", keys: map[string][]byte{key.KeyID + ":" + hdPath: keyBytes}}
+	hsm := NewPKCS11SignerProvider(session, "")
+	if _, _, err = hsm.SignHash(wallet, hdPath, owcrypt.ECC_CURVE_SECP256K1, hash, "0000"); err == nil {
+		t.Fatalf("PKCS11SignerProvider should fail with wrong pin")
+	}
+	hsmSig, v, err := hsm.SignHash(wallet, hdPath, owcrypt.ECC_CURVE_SECP256K1, hash, "1234")
+	if err != nil {
+		t.Fatalf("PKCS11SignerProvider.SignHash failed, unexpected error: %v", err)
+	}
+	if owcrypt.Verify(pub, nil, hash, hsmSig, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		t.Errorf("PKCS11SignerProvider signature verify failed")
+	}
+	if new(big.Int).SetBytes(hsmSig[32:]).Cmp(new(big.Int).Rsh(new(big.Int).SetBytes(owcrypt.GetCurveOrder(owcrypt.ECC_CURVE_SECP256K1)), 1)) > 0 {
+		t.Errorf("PKCS11SignerProvider signature is not low-S: %x", hsmSig)
+	}
+	if recovered, err := recoverSecp256k1V(hsmSig, hash, childKey.GetPublicKey().GetPublicKeyBytes()); err != nil || recovered != v {
+		t.Errorf("PKCS11SignerProvider v = %d, want %d", v, recovered)
+	}
+
+	//每次签名都校验PIN，登录成功后也不能用错误的PIN签名
+	if _, _, err = hsm.SignHash(wallet, hdPath, owcrypt.ECC_CURVE_SECP256K1, hash, "0000"); err == nil {
+		t.Errorf("PKCS11SignerProvider should fail with wrong pin after logged in")
+	}
+	if session.loggedIn {
+		t.Errorf("PKCS11SignerProvider should logout after signing")
+	}
+}
+
+//testSignerDecoder 支持签名提供者的交易单解析器，签名后在签名结果后追加标记
+type testSignerDecoder struct {
+	openwallet.TransactionDecoderBase
+	support bool
+}
+
+func (decoder *testSignerDecoder) SupportSignerProvider() bool {
+	return decoder.support
+}
+
+func (decoder *testSignerDecoder) SignRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	signer, ok := wrapper.(openwallet.WalletHashSigner)
+	if !ok {
+		return fmt.Errorf("wallet is not support hash signer")
+	}
+	if _, err := wrapper.HDKey(); err == nil {
+		return fmt.Errorf("HDKey should not be available")
+	}
+	for _, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			hash, _ := hex.DecodeString(keySignature.Message)
+			signature, _, err := signer.SignHash(keySignature.Address.HDPath, keySignature.EccType, hash)
+			if err != nil {
+				return err
+			}
+			keySignature.Signature = hex.EncodeToString(signature) + "ff"
+		}
+	}
+	return nil
+}
+
+func TestWalletManager_SignRawTransactionWithSigner(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+
+	seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+	key, err := hdkeystore.NewHDKey(seed, "hsm", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed, unexpected error: %v", err)
+	}
+	software := NewSoftwareSignerProvider()
+	software.AddKey(key)
+	tm.RegisterSigner("soft", software)
+
+	wallet := &openwallet.Wallet{WalletID: key.KeyID, Signer: "soft"}
+	account := &openwallet.AssetsAccount{WalletID: key.KeyID, AccountID: "A1"}
+	hash := owcrypt.Hash([]byte("signer decoder"), 0, owcrypt.HASH_ALG_SHA256)
+	newRawTx := func() *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			Signatures: map[string][]*openwallet.KeySignature{
+				openwallet.SignatureOwnerKey(account): {
+					{
+						EccType: owcrypt.ECC_CURVE_SECP256K1,
+						Address: &openwallet.Address{HDPath: hdkeystore.OpenwCoinTypePath + "/0'/0/1"},
+						Message: hex.EncodeToString(hash),
+					},
+				},
+			},
+		}
+	}
+
+	//解析器支持签名提供者，由解析器签名并处理签名结果
+	wrapper := NewWalletWrapper(wallet)
+	rawTx := newRawTx()
+	err = tm.signRawTransactionWithSigner(wrapper, &testSignerDecoder{support: true}, account, rawTx, "")
+	if err != nil {
+		t.Fatalf("signRawTransactionWithSigner failed, unexpected error: %v", err)
+	}
+	signature := rawTx.Signatures[openwallet.SignatureOwnerKey(account)][0].Signature
+	if len(signature) != 130 || signature[128:] != "ff" {
+		t.Errorf("decoder signature = %s", signature)
+	}
+	if _, err = wrapper.HDKey(); err == nil || len(software.SignedPaths()) != 1 {
+		t.Errorf("signer should be released after signing")
+	}
+
+	//解析器不支持签名提供者，直接签名被签消息
+	rawTx = newRawTx()
+	err = tm.signRawTransactionWithSigner(wrapper, &testSignerDecoder{}, account, rawTx, "")
+	if err != nil {
+		t.Fatalf("signRawTransactionWithSigner failed, unexpected error: %v", err)
+	}
+	if signature := rawTx.Signatures[openwallet.SignatureOwnerKey(account)][0].Signature; len(signature) != 128 {
+		t.Errorf("signature = %s", signature)
+	}
+}
@@ -0,0 +1,759 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+// SQL数据存储的表结构：
+// 	每种数据对象一张表，表名为 ow_ + 小写的结构体名，如：ow_wallet，ow_assetsaccount，ow_address，ow_txinput
+// 	ow_app 应用ID，ow_data 数据对象的json，其余列为结构体的基本类型字段，列名与字段名一致，用于查询
+// 	主键为 (ow_app, storm:"id"标记的字段)
+// 	ow_apps 登记已创建的应用
+
+const (
+	sqlAppColumn   = "ow_app"
+	sqlDataColumn  = "ow_data"
+	sqlTablePrefix = "ow_"
+	sqlAppsTable   = "ow_apps"
+)
+
+//sqlDataKinds openw持久化的数据对象
+var sqlDataKinds = []interface{}{
+	&openwallet.Wallet{},
+	&openwallet.AssetsAccount{},
+	&openwallet.Address{},
+	&openwallet.ImportAddress{},
+	&openwallet.Transaction{},
+	&openwallet.TxInput{},
+	&openwallet.TxOutPut{},
+	&openwallet.BlockHeader{},
+	&openwallet.SmartContractReceiptRecord{},
+	&openwallet.TransactionSidRecord{},
+	&TxBroadcastRecord{},
+}
+
+//sqlDialect 不同数据库的语法差异
+type sqlDialect struct {
+	quoteChar    string
+	numbered     bool //占位符是否为$1，$2...
+	ignoreIndexE bool //创建索引不支持IF NOT EXISTS，忽略重复创建的错误
+}
+
+func newSQLDialect(driver string) sqlDialect {
+	switch driver {
+	case "mysql":
+		return sqlDialect{quoteChar: "`", ignoreIndexE: true}
+	case "postgres", "pgx":
+		return sqlDialect{quoteChar: `"`, numbered: true}
+	default:
+		return sqlDialect{quoteChar: `"`}
+	}
+}
+
+func (d sqlDialect) quote(name string) string {
+	return d.quoteChar + name + d.quoteChar
+}
+
+func (d sqlDialect) placeholder(n int) string {
+	if d.numbered {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+//sqlColumn 结构体字段对应的列
+type sqlColumn struct {
+	name    string
+	index   []int //reflect字段路径
+	kind    reflect.Kind
+	indexed bool //storm:"index"
+}
+
+//sqlTable 数据对象对应的表
+type sqlTable struct {
+	name    string
+	key     *sqlColumn
+	columns []*sqlColumn
+	byName  map[string]*sqlColumn
+}
+
+//SQLDataBase SQL数据库，多个应用共享，按应用划分数据
+type SQLDataBase struct {
+	db      *sql.DB
+	dialect sqlDialect
+	mu      sync.Mutex
+	tables  map[reflect.Type]*sqlTable
+}
+
+//OpenSQLDataBase 打开SQL数据库，driver需要由使用者导入，如：sqlite3，mysql，postgres
+func OpenSQLDataBase(driver, source string) (*SQLDataBase, error) {
+
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, fmt.Errorf("can not open sql database: '%s', unexpected error: %v", driver, err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can not connect sql database: '%s', unexpected error: %v", driver, err)
+	}
+
+	base := &SQLDataBase{
+		db:      db,
+		dialect: newSQLDialect(driver),
+		tables:  make(map[reflect.Type]*sqlTable),
+	}
+
+	_, err = db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s VARCHAR(255) NOT NULL PRIMARY KEY, %s BIGINT NOT NULL)",
+		sqlAppsTable, sqlAppColumn, base.dialect.quote("CreatedTime")))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	//预先创建openw的数据表，避免在事务中建表
+	for _, kind := range sqlDataKinds {
+		_, err = base.table(reflect.TypeOf(kind).Elem(), db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return base, nil
+}
+
+//Close 关闭数据库
+func (base *SQLDataBase) Close() error {
+	return base.db.Close()
+}
+
+//AppIDs 已登记的全部应用ID
+func (base *SQLDataBase) AppIDs() ([]string, error) {
+
+	rows, err := base.db.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", sqlAppColumn, sqlAppsTable, sqlAppColumn))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apps := make([]string, 0)
+	for rows.Next() {
+		var appID string
+		if err = rows.Scan(&appID); err != nil {
+			return nil, err
+		}
+		apps = append(apps, appID)
+	}
+
+	return apps, rows.Err()
+}
+
+//AppDataStore 应用的数据存储，应用未登记则先登记
+func (base *SQLDataBase) AppDataStore(appID string) (DataStore, error) {
+
+	if len(appID) == 0 {
+		return nil, fmt.Errorf("appID is empty")
+	}
+
+	d := base.dialect
+	var count int
+	err := base.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = %s", sqlAppsTable, sqlAppColumn, d.placeholder(1)), appID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		_, err = base.db.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (%s, %s)", sqlAppsTable, sqlAppColumn, d.quote("CreatedTime"), d.placeholder(1), d.placeholder(2)),
+			appID, time.Now().Unix())
+		if err != nil {
+			//其他实例同时登记
+			if e := base.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = %s", sqlAppsTable, sqlAppColumn, d.placeholder(1)), appID).Scan(&count); e != nil || count == 0 {
+				return nil, err
+			}
+		}
+	}
+
+	return &sqlDataStore{base: base, appID: appID, exec: base.db}, nil
+}
+
+//table 数据对象类型对应的表，首次使用时通过exec创建
+func (base *SQLDataBase) table(t reflect.Type, exec sqlExecutor) (*sqlTable, error) {
+
+	base.mu.Lock()
+	defer base.mu.Unlock()
+
+	if table, ok := base.tables[t]; ok {
+		return table, nil
+	}
+
+	table, err := newSQLTable(t)
+	if err != nil {
+		return nil, err
+	}
+
+	d := base.dialect
+	defs := []string{
+		fmt.Sprintf("%s VARCHAR(255) NOT NULL", sqlAppColumn),
+		fmt.Sprintf("%s TEXT NOT NULL", sqlDataColumn),
+	}
+	for _, c := range table.columns {
+		defs = append(defs, fmt.Sprintf("%s %s", d.quote(c.name), sqlColumnType(c, c == table.key)))
+	}
+	defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s, %s)", sqlAppColumn, d.quote(table.key.name)))
+
+	_, err = exec.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table.name, strings.Join(defs, ", ")))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range table.columns {
+		if !c.indexed {
+			continue
+		}
+		indexName := fmt.Sprintf("%s_%s", table.name, strings.ToLower(c.name))
+		if d.ignoreIndexE {
+			exec.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s, %s)", indexName, table.name, sqlAppColumn, d.quote(c.name)))
+			continue
+		}
+		_, err = exec.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s, %s)", indexName, table.name, sqlAppColumn, d.quote(c.name)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	base.tables[t] = table
+
+	return table, nil
+}
+
+//newSQLTable 解析结构体的字段，inline的匿名结构体字段展开
+func newSQLTable(t reflect.Type) (*sqlTable, error) {
+
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("data must be a struct, not %s", t.Kind())
+	}
+
+	table := &sqlTable{
+		name:   sqlTablePrefix + strings.ToLower(t.Name()),
+		byName: make(map[string]*sqlColumn),
+	}
+
+	var parse func(t reflect.Type, index []int)
+	parse = func(t reflect.Type, index []int) {
+		anonymous := make([]reflect.StructField, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				anonymous = append(anonymous, f)
+				continue
+			}
+			if f.PkgPath != "" || !isSQLScalar(f.Type.Kind()) {
+				continue
+			}
+			//外层字段优先
+			if _, exist := table.byName[f.Name]; exist {
+				continue
+			}
+			tags := strings.Split(f.Tag.Get("storm"), ",")
+			c := &sqlColumn{
+				name:    f.Name,
+				index:   append(append([]int{}, index...), i),
+				kind:    f.Type.Kind(),
+				indexed: hasStormTag(tags, "index") || hasStormTag(tags, "unique"),
+			}
+			table.columns = append(table.columns, c)
+			table.byName[c.name] = c
+			if table.key == nil && hasStormTag(tags, "id") {
+				table.key = c
+			}
+		}
+		for _, f := range anonymous {
+			parse(f.Type, append(append([]int{}, index...), f.Index...))
+		}
+	}
+	parse(t, nil)
+
+	//与storm一致，没有标记主键时使用ID字段
+	if table.key == nil {
+		table.key = table.byName["ID"]
+	}
+	if table.key == nil {
+		return nil, fmt.Errorf("%s has no id field", t.Name())
+	}
+
+	return table, nil
+}
+
+func hasStormTag(tags []string, name string) bool {
+	for _, tag := range tags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isSQLScalar(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func sqlColumnType(c *sqlColumn, primary bool) string {
+	switch c.kind {
+	case reflect.String:
+		if primary || c.indexed {
+			return "VARCHAR(255)"
+		}
+		return "TEXT"
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE PRECISION"
+	default:
+		//整数，布尔值（0，1）
+		return "BIGINT"
+	}
+}
+
+//sqlValue 转换为数据库驱动支持的值，无符号整数超过BIGINT的范围返回错误
+func sqlValue(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d is out of range of sql BIGINT", rv.Uint())
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+	return v, nil
+}
+
+//sqlExecutor 数据库或者事务
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//sqlDataStore 应用的SQL数据存储
+type sqlDataStore struct {
+	base  *SQLDataBase
+	appID string
+	exec  sqlExecutor
+	tx    *sql.Tx
+}
+
+//structValue 数据对象必须为结构体指针
+func structValue(data interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("data must be a pointer to a struct")
+	}
+	return v.Elem(), nil
+}
+
+//sqlRow 数据对象的表、主键值和插入的列
+type sqlRow struct {
+	table   *sqlTable
+	key     interface{}
+	names   []string
+	holders []string
+	args    []interface{}
+}
+
+func (s *sqlDataStore) row(data interface{}) (*sqlRow, error) {
+
+	v, err := structValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := s.base.table(v.Type(), s.exec)
+	if err != nil {
+		return nil, err
+	}
+
+	key := v.FieldByIndex(table.key.index)
+	if key.IsZero() {
+		return nil, fmt.Errorf("%s id field must not be zero", v.Type().Name())
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	keyValue, err := sqlValue(key.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	d := s.base.dialect
+	row := &sqlRow{
+		table: table,
+		key:   keyValue,
+		names: []string{sqlAppColumn, sqlDataColumn},
+		args:  []interface{}{s.appID, string(raw)},
+	}
+	for _, c := range table.columns {
+		value, err := sqlValue(v.FieldByIndex(c.index).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("%s field %s: %v", v.Type().Name(), c.name, err)
+		}
+		row.names = append(row.names, d.quote(c.name))
+		row.args = append(row.args, value)
+	}
+	row.holders = make([]string, len(row.args))
+	for i := range row.holders {
+		row.holders[i] = d.placeholder(i + 1)
+	}
+
+	return row, nil
+}
+
+func (row *sqlRow) insert(exec sqlExecutor) error {
+	_, err := exec.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		row.table.name, strings.Join(row.names, ", "), strings.Join(row.holders, ", ")), row.args...)
+	return err
+}
+
+func (s *sqlDataStore) Save(data interface{}) error {
+
+	row, err := s.row(data)
+	if err != nil {
+		return err
+	}
+
+	d := s.base.dialect
+	return s.Update(func(tx DataStore) error {
+		exec := tx.(*sqlDataStore).exec
+		_, err := exec.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s",
+			row.table.name, sqlAppColumn, d.placeholder(1), d.quote(row.table.key.name), d.placeholder(2)),
+			s.appID, row.key)
+		if err != nil {
+			return err
+		}
+		return row.insert(exec)
+	})
+}
+
+//Insert 直接插入，由主键约束保证并发插入时只有一个成功
+func (s *sqlDataStore) Insert(data interface{}) error {
+
+	row, err := s.row(data)
+	if err != nil {
+		return err
+	}
+
+	err = row.insert(s.exec)
+	if err == nil {
+		return nil
+	}
+
+	//在事务中插入失败后，部分数据库（如postgres）的事务不能再查询，返回原始错误由调用者回滚
+	if s.tx != nil {
+		return err
+	}
+
+	d := s.base.dialect
+	var count int
+	e := s.exec.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = %s AND %s = %s",
+		row.table.name, sqlAppColumn, d.placeholder(1), d.quote(row.table.key.name), d.placeholder(2)),
+		s.appID, row.key).Scan(&count)
+	if e == nil && count > 0 {
+		return ErrDataExists
+	}
+
+	return err
+}
+
+func (s *sqlDataStore) One(fieldName string, value interface{}, to interface{}) error {
+
+	v, err := structValue(to)
+	if err != nil {
+		return err
+	}
+
+	table, err := s.base.table(v.Type(), s.exec)
+	if err != nil {
+		return err
+	}
+
+	c, ok := table.byName[fieldName]
+	if !ok {
+		return fmt.Errorf("%s can not be queried by field: %s", v.Type().Name(), fieldName)
+	}
+
+	arg, err := sqlValue(value)
+	if err != nil {
+		return err
+	}
+
+	d := s.base.dialect
+	var raw string
+	err = s.exec.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s AND %s = %s ORDER BY %s LIMIT 1",
+		sqlDataColumn, table.name, sqlAppColumn, d.placeholder(1), d.quote(c.name), d.placeholder(2), d.quote(table.key.name)),
+		s.appID, arg).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return ErrDataNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(raw), to)
+}
+
+//query 查询满足条件的数据，DataOpMatch的条件读取后再过滤，没有匹配返回空列表
+func (s *sqlDataStore) query(t reflect.Type, offset, limit int, conditions []*DataCondition) ([]reflect.Value, *sqlTable, error) {
+
+	table, err := s.base.table(t, s.exec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := s.base.dialect
+	where := []string{fmt.Sprintf("%s = %s", sqlAppColumn, d.placeholder(1))}
+	args := []interface{}{s.appID}
+	filters := make([]*DataCondition, 0)
+
+	for _, cond := range conditions {
+		if cond.Op == DataOpMatch {
+			if _, ok := cond.Value.(DataMatcher); !ok {
+				return nil, nil, fmt.Errorf("field %s matcher is invalid", cond.Field)
+			}
+			if _, ok := t.FieldByName(cond.Field); !ok {
+				return nil, nil, fmt.Errorf("%s has no field: %s", t.Name(), cond.Field)
+			}
+			filters = append(filters, cond)
+			continue
+		}
+
+		c, ok := table.byName[cond.Field]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s can not be queried by field: %s", t.Name(), cond.Field)
+		}
+
+		var op string
+		switch cond.Op {
+		case DataOpEq:
+			op = "="
+		case DataOpGte:
+			op = ">="
+		case DataOpLte:
+			op = "<="
+		default:
+			return nil, nil, fmt.Errorf("field %s operator is not supported", cond.Field)
+		}
+
+		arg, err := sqlValue(cond.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf("%s %s %s", d.quote(c.name), op, d.placeholder(len(args))))
+	}
+
+	stmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s",
+		sqlDataColumn, table.name, strings.Join(where, " AND "), d.quote(table.key.name))
+
+	//需要过滤，或者只有偏移量时，读取后再分页
+	paged := limit > 0 && len(filters) == 0
+	if paged {
+		stmt += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+
+	rows, err := s.exec.Query(stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	values := make([]reflect.Value, 0)
+	skipped := 0
+
+	for rows.Next() {
+		var raw string
+		if err = rows.Scan(&raw); err != nil {
+			return nil, nil, err
+		}
+
+		obj := reflect.New(t)
+		if err = json.Unmarshal([]byte(raw), obj.Interface()); err != nil {
+			return nil, nil, err
+		}
+
+		match := true
+		for _, f := range filters {
+			match, err = f.Value.(DataMatcher).MatchField(obj.Elem().FieldByName(f.Field).Interface())
+			if err != nil {
+				return nil, nil, err
+			}
+			if !match {
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		if !paged {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if limit > 0 && len(values) >= limit {
+				break
+			}
+		}
+
+		values = append(values, obj)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return values, table, nil
+}
+
+func (s *sqlDataStore) Find(to interface{}, offset, limit int, conditions ...*DataCondition) error {
+
+	ref := reflect.ValueOf(to)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("find result must be a pointer to a slice")
+	}
+
+	slice := ref.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	structType := elemType
+	if isPtr {
+		structType = elemType.Elem()
+	}
+
+	values, _, err := s.query(structType, offset, limit, conditions)
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		return ErrDataNotFound
+	}
+
+	result := reflect.MakeSlice(slice.Type(), 0, len(values))
+	for _, obj := range values {
+		if isPtr {
+			result = reflect.Append(result, obj)
+		} else {
+			result = reflect.Append(result, obj.Elem())
+		}
+	}
+	slice.Set(result)
+
+	return nil
+}
+
+func (s *sqlDataStore) Delete(kind interface{}, conditions ...*DataCondition) error {
+
+	v, err := structValue(kind)
+	if err != nil {
+		return err
+	}
+
+	values, table, err := s.query(v.Type(), 0, 0, conditions)
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	d := s.base.dialect
+	return s.Update(func(tx DataStore) error {
+		exec := tx.(*sqlDataStore).exec
+		for _, obj := range values {
+			key, err := sqlValue(obj.Elem().FieldByIndex(table.key.index).Interface())
+			if err != nil {
+				return err
+			}
+			_, err = exec.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s",
+				table.name, sqlAppColumn, d.placeholder(1), d.quote(table.key.name), d.placeholder(2)),
+				s.appID, key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlDataStore) Update(fn func(tx DataStore) error) error {
+
+	//已在事务中，直接执行
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.base.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(&sqlDataStore{base: s.base, appID: s.appID, exec: tx, tx: tx})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//Close 数据库由多个应用共享，通过SQLDataBase.Close关闭
+func (s *sqlDataStore) Close() error {
+	return nil
+}
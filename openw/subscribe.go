@@ -18,6 +18,7 @@ package openw
 import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
 )
 
 //blockScanNotify 区块扫描结果通知
@@ -31,7 +32,7 @@ func (wm *WalletManager) BlockScanNotify(header *openwallet.BlockHeader) error {
 			return err
 		}
 
-		//分叉的区块，删除提出记录
+		//分叉的区块，删除提出记录，扫描器对每个孤立区块通知一次，逐个高度回滚
 		for _, appID := range appIDs {
 
 			wrapper, err := wm.NewWalletWrapper(appID, "")
@@ -45,8 +46,14 @@ func (wm *WalletManager) BlockScanNotify(header *openwallet.BlockHeader) error {
 				return err
 			}
 
+			err = wm.trackForkBlock(appID, wrapper, header.Height)
+			if err != nil {
+				log.Error("track fork block failed, unexpected error:", err)
+			}
 		}
-		return nil
+	} else {
+		//新区块，后台更新交易单的确认数
+		wm.pushConfirmHeader(header)
 	}
 
 	//推送数据
@@ -66,7 +73,7 @@ func (wm *WalletManager) BlockExtractDataNotify(sourceKey string, data *openwall
 	//保存提取出来的数据
 	appID, accountID := wm.decodeSourceKey(sourceKey)
 
-	log.Debug("NewBlockExtractData:", appID, accountID)
+	log.Debug("NewBlockExtractData:", appID, accountID, "pending:", data.Pending)
 
 	wrapper, err := wm.NewWalletWrapper(appID, "")
 	if err != nil {
@@ -74,11 +81,38 @@ func (wm *WalletManager) BlockExtractDataNotify(sourceKey string, data *openwall
 	}
 
 	txWrapper := NewTransactionWrapper(wrapper)
+
+	//保存前的确认数，用于判断是否已经通知过最终确认
+	var prevConfirm int64
+	if !data.Pending && data.Transaction != nil {
+		prevConfirm, err = txWrapper.GetTransactionConfirm(data.Transaction.WxID)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = txWrapper.SaveBlockExtractData(accountID, data)
 	if err != nil {
+		if err == ErrPendingTxConfirmed {
+			//交易单已上链，不再推送未确认的数据
+			return nil
+		}
 		return err
 	}
 
+	err = wm.trackExtractData(appID, wrapper, data)
+	if err != nil {
+		log.Error("track extract data failed, unexpected error:", err)
+	}
+
+	//保存时已达到确认阈值的交易单通知最终确认
+	if !data.Pending && data.Transaction != nil {
+		err = wm.finalizeSavedTransaction(appID, wrapper, data.Transaction, prevConfirm)
+		if err != nil {
+			log.Error("finalize saved transaction failed, unexpected error:", err)
+		}
+	}
+
 	//更新账户余额
 	//err = wm.RefreshAssetsAccountBalance(appID, accountID)
 	//if err != nil {
@@ -91,6 +125,10 @@ func (wm *WalletManager) BlockExtractDataNotify(sourceKey string, data *openwall
 	}
 
 	for o, _ := range wm.observers {
+		if ao, ok := o.(AppTxExtractDataNotificationObject); ok {
+			ao.BlockAppTxExtractDataNotify(appID, account, data)
+			continue
+		}
 		o.BlockTxExtractDataNotify(account, data)
 	}
 
@@ -102,9 +140,106 @@ func (wm *WalletManager) BlockExtractDataNotify(sourceKey string, data *openwall
 //@param data: 合约交易回执
 //@required
 func (wm *WalletManager) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+
+	if data == nil {
+		return nil
+	}
+
+	var (
+		appIDs     []string
+		contractID string
+	)
+
+	//sourceKey为appID:contractID时，只保存到该应用，否则为contractID，保存到回执地址所属的应用
+	appID, key := wm.decodeSourceKey(sourceKey)
+	if len(appID) > 0 {
+		appIDs = []string{appID}
+		contractID = key
+	} else {
+		appIDs = wm.receiptOwnerAppIDs(data)
+		contractID = sourceKey
+		if len(appIDs) == 0 {
+			log.Warning("smart contract receipt:", data.TxID, "of contract:", contractID, "has no owning app, skip saving")
+			return nil
+		}
+	}
+
+	log.Debug("NewSmartContractReceipt:", contractID, data.TxID, "apps:", appIDs)
+
+	for _, appID := range appIDs {
+
+		wrapper, err := wm.NewWalletWrapper(appID, "")
+		if err != nil {
+			return err
+		}
+
+		txWrapper := NewTransactionWrapper(wrapper)
+		err = txWrapper.SaveSmartContractReceipt(contractID, data)
+		if err != nil {
+			return err
+		}
+
+		for o, _ := range wm.observers {
+			err = o.BlockSmartContractReceiptNotify(appID, data)
+			if err != nil {
+				log.Error("observer notify smart contract receipt failed, unexpected error:", err)
+			}
+		}
+	}
+
 	return nil
 }
 
+//receiptOwnerAppIDs 根据订阅扫描的地址，查找合约回执的调用者、调用地址、合约地址或事件参数中的地址所属的应用
+func (wm *WalletManager) receiptOwnerAppIDs(data *openwallet.SmartContractReceipt) []string {
+
+	addresses := []string{data.From, data.To, data.Coin.Contract.Address}
+	//代币转账的收款地址只在事件参数中
+	for _, event := range data.Events {
+		if event == nil {
+			continue
+		}
+		addresses = append(addresses, receiptEventAddresses(gjson.Parse(event.Value))...)
+	}
+
+	appIDs := make([]string, 0)
+	exist := make(map[string]bool)
+	for _, address := range addresses {
+		if len(address) == 0 {
+			continue
+		}
+		sourceKey, ok := wm.GetSourceKeyByAddressForBlockScan(address)
+		if !ok {
+			continue
+		}
+		appID, _ := wm.decodeSourceKey(sourceKey)
+		if len(appID) == 0 || exist[appID] {
+			continue
+		}
+		exist[appID] = true
+		appIDs = append(appIDs, appID)
+	}
+
+	return appIDs
+}
+
+//receiptEventAddresses 事件参数json中所有的字符串值，作为可能的地址
+func receiptEventAddresses(value gjson.Result) []string {
+
+	addresses := make([]string, 0)
+	switch {
+	case value.IsObject(), value.IsArray():
+		value.ForEach(func(_, v gjson.Result) bool {
+			addresses = append(addresses, receiptEventAddresses(v)...)
+			return true
+		})
+	case value.Type == gjson.String:
+		addresses = append(addresses, value.String())
+	}
+
+	return addresses
+}
+
 //DeleteRechargesByHeight 删除某区块高度的充值记录
 func (wm *WalletManager) DeleteRechargesByHeight(height uint64) error {
 
@@ -0,0 +1,406 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testInitTempWalletManager 使用临时目录初始化钱包管理器，返回清理临时目录的方法
+func testInitTempWalletManager(t *testing.T) (*WalletManager, func()) {
+	dir, err := ioutil.TempDir("", "openw_test")
+	if err != nil {
+		t.Fatalf("TempDir failed, unexpected error: %v", err)
+	}
+
+	tc := NewConfig()
+	tc.KeyDir = filepath.Join(dir, "key")
+	tc.DBPath = filepath.Join(dir, "db")
+	tc.BackupDir = filepath.Join(dir, "backup")
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+
+	return NewWalletManager(tc), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+type testReceiptObserver struct {
+	receipts  map[string]*openwallet.SmartContractReceipt
+	finalized []*openwallet.Transaction
+	extracts  []*openwallet.TxExtractData
+	states    []string
+}
+
+func (o *testReceiptObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return nil
+}
+
+func (o *testReceiptObserver) BlockTxExtractDataNotify(account *openwallet.AssetsAccount, data *openwallet.TxExtractData) error {
+	o.extracts = append(o.extracts, data)
+	return nil
+}
+
+func (o *testReceiptObserver) BlockSmartContractReceiptNotify(appID string, receipt *openwallet.SmartContractReceipt) error {
+	o.receipts[appID] = receipt
+	return nil
+}
+
+func (o *testReceiptObserver) BlockTxFinalizedNotify(appID string, tx *openwallet.Transaction) error {
+	o.finalized = append(o.finalized, tx)
+	return nil
+}
+
+func (o *testReceiptObserver) TxBroadcastStateNotify(appID string, record *TxBroadcastRecord) error {
+	o.states = append(o.states, record.TxID+":"+record.State)
+	return nil
+}
+
+func TestWalletManager_BlockExtractSmartContractDataNotify(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "receipt_app"
+	defer tm.CloseDB(appID)
+
+	_, err := tm.OpenDataStore(appID)
+	if err != nil {
+		t.Fatalf("OpenDB failed, unexpected error: %v", err)
+	}
+
+	observer := &testReceiptObserver{receipts: make(map[string]*openwallet.SmartContractReceipt)}
+	tm.AddObserver(observer)
+
+	contractID := openwallet.GenContractID("ETH", "0x4092678e4E78230F46A1534C0fbc8fA39780892B")
+	receipt := &openwallet.SmartContractReceipt{
+		Coin:        openwallet.Coin{Symbol: "ETH", IsContract: true, ContractID: contractID},
+		TxID:        "0x9a3c4f",
+		From:        "0xaaaa",
+		To:          "0x4092678e4E78230F46A1534C0fbc8fA39780892B",
+		BlockHeight: 100,
+		Events: []*openwallet.SmartContractEvent{
+			{Event: "Transfer", Value: `{"value":"1"}`},
+		},
+	}
+
+	//回执地址不属于任何应用，不保存
+	err = tm.BlockExtractSmartContractDataNotify(contractID, receipt)
+	if err != nil {
+		t.Fatalf("BlockExtractSmartContractDataNotify failed, unexpected error: %v", err)
+	}
+	if observer.receipts[appID] != nil {
+		t.Fatalf("receipt of unknown address should not be notified to app: %s", appID)
+	}
+
+	//调用者地址属于应用，只保存到该应用
+	tm.AddAddressForBlockScan("0xaaaa", tm.encodeSourceKey(appID, "A1"))
+	err = tm.BlockExtractSmartContractDataNotify(contractID, receipt)
+	if err != nil {
+		t.Fatalf("BlockExtractSmartContractDataNotify failed, unexpected error: %v", err)
+	}
+
+	if observer.receipts[appID] == nil {
+		t.Errorf("observer did not receive receipt of app: %s", appID)
+	}
+
+	wrapper, err := tm.NewWalletWrapper(appID, "")
+	if err != nil {
+		t.Fatalf("NewWalletWrapper failed, unexpected error: %v", err)
+	}
+
+	records, err := wrapper.GetSmartContractReceiptRecords(0, -1, "ContractID", contractID)
+	if err != nil {
+		t.Fatalf("GetSmartContractReceiptRecords failed, unexpected error: %v", err)
+	}
+	//保存记录不修改通知的回执
+	if len(receipt.WxID) != 0 {
+		t.Errorf("notified receipt should not be modified, wxid: %s", receipt.WxID)
+	}
+	receipt.GenWxID()
+	if len(records) != 1 || records[0].WxID != receipt.WxID {
+		t.Fatalf("saved receipts = %d, want 1", len(records))
+	}
+
+	other := &openwallet.SmartContractReceipt{
+		Coin:        openwallet.Coin{Symbol: "ETH", IsContract: true, ContractID: contractID},
+		TxID:        "0x7b1d2e",
+		From:        "0xbbbb",
+		To:          "0x4092678e4E78230F46A1534C0fbc8fA39780892B",
+		BlockHeight: 105,
+		Events: []*openwallet.SmartContractEvent{
+			{Event: "Approval", Value: `{"value":"2"}`},
+		},
+	}
+	err = tm.BlockExtractSmartContractDataNotify(tm.encodeSourceKey(appID, contractID), other)
+	if err != nil {
+		t.Fatalf("BlockExtractSmartContractDataNotify failed, unexpected error: %v", err)
+	}
+
+	queries := []struct {
+		cols []interface{}
+		want int
+	}{
+		{[]interface{}{"ContractID", contractID}, 2},
+		{[]interface{}{ReceiptQueryEvent, "Transfer"}, 1},
+		{[]interface{}{"From", "0xbbbb"}, 1},
+		{[]interface{}{ReceiptQueryStartHeight, uint64(101), ReceiptQueryEndHeight, uint64(200)}, 1},
+		{[]interface{}{ReceiptQueryEvent, "Approval", "From", "0xaaaa"}, 0},
+	}
+	for i, query := range queries {
+		list, err := tm.GetSmartContractReceipts(appID, 0, -1, query.cols...)
+		if err != nil {
+			t.Fatalf("GetSmartContractReceipts[%d] failed, unexpected error: %v", i, err)
+		}
+		if len(list) != query.want {
+			t.Errorf("GetSmartContractReceipts[%d] = %d receipts, want %d", i, len(list), query.want)
+		}
+	}
+
+	other.GenWxID()
+	found, err := tm.GetSmartContractReceiptByWxID(appID, other.WxID)
+	if err != nil {
+		t.Fatalf("GetSmartContractReceiptByWxID failed, unexpected error: %v", err)
+	}
+	if found.TxID != other.TxID || len(found.Events) != 1 {
+		t.Errorf("GetSmartContractReceiptByWxID got txid %s, want %s", found.TxID, other.TxID)
+	}
+
+	//分叉区块，删除该高度的回执
+	err = tm.BlockScanNotify(&openwallet.BlockHeader{Height: 100, Fork: true})
+	if err != nil {
+		t.Fatalf("BlockScanNotify failed, unexpected error: %v", err)
+	}
+
+	records, err = wrapper.GetSmartContractReceiptRecords(0, -1, "BlockHeight", uint64(100))
+	if err != nil {
+		t.Fatalf("GetSmartContractReceiptRecords failed, unexpected error: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("receipts of fork block are not deleted, count: %d", len(records))
+	}
+
+	//代币充值的收款地址只在事件参数中
+	deposit := &openwallet.SmartContractReceipt{
+		Coin:        openwallet.Coin{Symbol: "ETH", IsContract: true, ContractID: contractID},
+		TxID:        "0x5e8f01",
+		From:        "0xcccc",
+		To:          "0x4092678e4E78230F46A1534C0fbc8fA39780892B",
+		BlockHeight: 110,
+		Events: []*openwallet.SmartContractEvent{
+			{Event: "Transfer", Value: `{"from":"0xcccc","to":"0xdddd","value":"5"}`},
+		},
+	}
+	tm.AddAddressForBlockScan("0xdddd", tm.encodeSourceKey(appID, "A1"))
+	err = tm.BlockExtractSmartContractDataNotify(contractID, deposit)
+	if err != nil {
+		t.Fatalf("BlockExtractSmartContractDataNotify failed, unexpected error: %v", err)
+	}
+	list, err := tm.GetSmartContractReceipts(appID, 0, -1, "TxID", deposit.TxID)
+	if err != nil || len(list) != 1 {
+		t.Errorf("deposit receipts = %d, err: %v", len(list), err)
+	}
+}
+
+func TestWalletManager_UpdateConfirmations(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "confirm_app"
+	defer tm.CloseDB(appID)
+
+	tm.cfg.SetConfirmThreshold("btc", 3)
+
+	db, err := tm.OpenDataStore(appID)
+	if err != nil {
+		t.Fatalf("OpenDB failed, unexpected error: %v", err)
+	}
+
+	txs := []*openwallet.Transaction{
+		{WxID: "w1", TxID: "tx1", Coin: openwallet.Coin{Symbol: "BTC"}, BlockHeight: 100},
+		{WxID: "w2", TxID: "tx2", Coin: openwallet.Coin{Symbol: "BTC"}, BlockHeight: 101},
+		{WxID: "w3", TxID: "tx3", Coin: openwallet.Coin{Symbol: "ETH"}, BlockHeight: 100},
+	}
+	for _, tx := range txs {
+		if err = db.Save(tx); err != nil {
+			t.Fatalf("Save failed, unexpected error: %v", err)
+		}
+	}
+
+	observer := &testReceiptObserver{receipts: make(map[string]*openwallet.SmartContractReceipt)}
+	tm.AddObserver(observer)
+
+	wrapper, err := tm.NewWalletWrapper(appID, "")
+	if err != nil {
+		t.Fatalf("NewWalletWrapper failed, unexpected error: %v", err)
+	}
+
+	steps := []struct {
+		height    uint64
+		finalized []string
+	}{
+		{101, nil},
+		{102, []string{"tx1"}},
+		{103, []string{"tx2"}},
+		{104, nil},
+	}
+	for _, step := range steps {
+		observer.finalized = nil
+		err = tm.UpdateConfirmations(&openwallet.BlockHeader{Symbol: "BTC", Height: step.height})
+		if err != nil {
+			t.Fatalf("UpdateConfirmations failed, unexpected error: %v", err)
+		}
+		if len(observer.finalized) != len(step.finalized) {
+			t.Fatalf("height %d finalized %d transactions, want %d", step.height, len(observer.finalized), len(step.finalized))
+		}
+		for i, txid := range step.finalized {
+			if observer.finalized[i].TxID != txid {
+				t.Errorf("height %d finalized %s, want %s", step.height, observer.finalized[i].TxID, txid)
+			}
+		}
+	}
+
+	list, err := wrapper.GetTransactions(0, -1)
+	if err != nil {
+		t.Fatalf("GetTransactions failed, unexpected error: %v", err)
+	}
+	want := map[string]int64{"tx1": 3, "tx2": 3, "tx3": 0}
+	for _, tx := range list {
+		if tx.Confirm != want[tx.TxID] {
+			t.Errorf("transaction %s confirm = %d, want %d", tx.TxID, tx.Confirm, want[tx.TxID])
+		}
+	}
+
+	//保存时已达到阈值的交易单，保存后通知一次
+	db.Save(&openwallet.AssetsAccount{WalletID: "W1", AccountID: "A1", Symbol: "BTC"})
+	db.Save(&openwallet.Address{Address: "addr1", AccountID: "A1"})
+	data := openwallet.NewBlockExtractData()
+	data.Transaction = &openwallet.Transaction{TxID: "tx4", Coin: openwallet.Coin{Symbol: "BTC"}, BlockHeight: 100, Decimal: 8}
+	data.Transaction.WxID = openwallet.GenTransactionWxID(data.Transaction)
+	for i := 0; i < 2; i++ {
+		observer.finalized = nil
+		err = tm.BlockExtractDataNotify(tm.encodeSourceKey(appID, "A1"), data)
+		if err != nil {
+			t.Fatalf("BlockExtractDataNotify failed, unexpected error: %v", err)
+		}
+		if i == 0 && (len(observer.finalized) != 1 || observer.finalized[0].Confirm != 5) {
+			t.Errorf("saved transaction finalized = %v", observer.finalized)
+		}
+		if i == 1 && len(observer.finalized) != 0 {
+			t.Errorf("saved transaction finalized again")
+		}
+	}
+}
+
+func TestWalletManager_ConfirmationRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openw_test")
+	if err != nil {
+		t.Fatalf("TempDir failed, unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tc := NewConfig()
+	tc.KeyDir = filepath.Join(dir, "key")
+	tc.DBPath = filepath.Join(dir, "db")
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.ConfirmThresholds["eth"] = 12
+	tm := NewWalletManager(tc)
+
+	if threshold := tm.ConfirmThreshold("ETH"); threshold != 12 {
+		t.Errorf("ConfirmThreshold = %d, want 12", threshold)
+	}
+
+	stop := tm.confirmStop
+	if err = tm.Close(); err != nil {
+		t.Fatalf("Close failed, unexpected error: %v", err)
+	}
+	select {
+	case <-stop:
+	default:
+		t.Errorf("confirmation runtime is not stopped")
+	}
+	//停止后不再加入队列
+	tm.pushConfirmHeader(&openwallet.BlockHeader{Symbol: "ETH", Height: 1})
+}
+
+func TestWalletManager_BlockExtractPendingDataNotify(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "pending_app"
+	defer tm.CloseDB(appID)
+
+	db, err := tm.OpenDataStore(appID)
+	if err != nil {
+		t.Fatalf("OpenDB failed, unexpected error: %v", err)
+	}
+	db.Save(&openwallet.AssetsAccount{WalletID: "W1", AccountID: "A1", Symbol: "BTC"})
+	db.Save(&openwallet.Address{Address: "addr1", AccountID: "A1"})
+
+	observer := &testReceiptObserver{receipts: make(map[string]*openwallet.SmartContractReceipt)}
+	tm.AddObserver(observer)
+
+	newData := func(height uint64, pending bool) *openwallet.TxExtractData {
+		data := openwallet.NewBlockExtractData()
+		data.Pending = pending
+		data.Transaction = &openwallet.Transaction{TxID: "tx1", Coin: openwallet.Coin{Symbol: "BTC"}, BlockHeight: height, Decimal: 8}
+		data.Transaction.WxID = openwallet.GenTransactionWxID(data.Transaction)
+		output := &openwallet.TxOutPut{}
+		output.Sid = openwallet.GenTxOutPutSID("tx1", "BTC", "", 0)
+		output.TxID = "tx1"
+		output.Address = "addr1"
+		output.Amount = "1"
+		output.BlockHeight = height
+		data.TxOutputs = append(data.TxOutputs, output)
+		return data
+	}
+
+	sourceKey := tm.encodeSourceKey(appID, "A1")
+	wrapper, err := tm.NewWalletWrapper(appID, "")
+	if err != nil {
+		t.Fatalf("NewWalletWrapper failed, unexpected error: %v", err)
+	}
+
+	steps := []struct {
+		data     *openwallet.TxExtractData
+		notified int
+		height   uint64
+	}{
+		{newData(0, true), 1, 0},      //交易池中的未确认记录
+		{newData(100, false), 2, 100}, //上链后覆盖
+		{newData(0, true), 2, 100},    //已上链，忽略过时的未确认记录
+	}
+	for i, step := range steps {
+		err = tm.BlockExtractDataNotify(sourceKey, step.data)
+		if err != nil {
+			t.Fatalf("BlockExtractDataNotify[%d] failed, unexpected error: %v", i, err)
+		}
+		if len(observer.extracts) != step.notified {
+			t.Errorf("BlockExtractDataNotify[%d] notified %d, want %d", i, len(observer.extracts), step.notified)
+		}
+
+		txs, err := wrapper.GetTransactions(0, -1)
+		if err != nil || len(txs) != 1 || txs[0].BlockHeight != step.height {
+			t.Fatalf("BlockExtractDataNotify[%d] transactions = %v, err: %v", i, txs, err)
+		}
+		outputs, err := wrapper.GetTxOutputs(0, -1)
+		if err != nil || len(outputs) != 1 || outputs[0].BlockHeight != step.height {
+			t.Fatalf("BlockExtractDataNotify[%d] outputs = %v, err: %v", i, outputs, err)
+		}
+	}
+}
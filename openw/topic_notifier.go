@@ -0,0 +1,104 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//钱包管理器通知推送的主题，除了区块扫描，其他主题包含应用数据，按应用推送到AppTopic(topic, appID)
+const (
+	TopicBlockScan            = "openw.blockScan"            //新区块扫描完成，数据：*openwallet.BlockHeader
+	TopicTxExtractData        = "openw.txExtractData"        //区块提取结果，数据：TxExtractDataMessage
+	TopicSmartContractReceipt = "openw.smartContractReceipt" //合约交易回执，数据：SmartContractReceiptMessage
+	TopicTxFinalized          = "openw.txFinalized"          //交易单确认数达到阈值，数据：TxFinalizedMessage
+	TopicTxBroadcastState     = "openw.txBroadcastState"     //广播交易单跟踪状态变化，数据：TxBroadcastStateMessage
+)
+
+//AppTopic 应用的主题，例如：openw.txFinalized.<appID>。
+//服务端需要在订阅方法的prepare中校验订阅节点是否有权限访问主题中的appID，否则任何节点都可以订阅其他应用的数据
+func AppTopic(topic, appID string) string {
+	return topic + "." + appID
+}
+
+//TopicPublishFunc 推送主题消息，例如owtp节点的Publish方法
+type TopicPublishFunc func(topic string, data interface{}) error
+
+//TxExtractDataMessage 区块提取结果的推送消息
+type TxExtractDataMessage struct {
+	AppID   string                    `json:"appID"`
+	Account *openwallet.AssetsAccount `json:"account"`
+	Data    *openwallet.TxExtractData `json:"data"`
+}
+
+//SmartContractReceiptMessage 合约交易回执的推送消息
+type SmartContractReceiptMessage struct {
+	AppID   string                           `json:"appID"`
+	Receipt *openwallet.SmartContractReceipt `json:"receipt"`
+}
+
+//TxFinalizedMessage 交易单确认的推送消息
+type TxFinalizedMessage struct {
+	AppID string                  `json:"appID"`
+	Tx    *openwallet.Transaction `json:"tx"`
+}
+
+//TxBroadcastStateMessage 广播交易单跟踪状态的推送消息
+type TxBroadcastStateMessage struct {
+	AppID  string             `json:"appID"`
+	Record *TxBroadcastRecord `json:"record"`
+}
+
+//TopicNotifier 把钱包管理器的通知推送到主题，通过AddObserver添加，远程应用订阅主题即可接收通知。
+//应用数据只推送到该应用的主题，见AppTopic
+type TopicNotifier struct {
+	publish TopicPublishFunc
+}
+
+//NewTopicNotifier 创建主题通知推送者
+func NewTopicNotifier(publish TopicPublishFunc) *TopicNotifier {
+	return &TopicNotifier{publish: publish}
+}
+
+//BlockScanNotify 新区块扫描完成通知
+func (n *TopicNotifier) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return n.publish(TopicBlockScan, header)
+}
+
+//BlockTxExtractDataNotify 区块提取结果通知，没有应用ID，不推送，由BlockAppTxExtractDataNotify推送
+func (n *TopicNotifier) BlockTxExtractDataNotify(account *openwallet.AssetsAccount, data *openwallet.TxExtractData) error {
+	return nil
+}
+
+//BlockAppTxExtractDataNotify 应用的区块提取结果通知
+func (n *TopicNotifier) BlockAppTxExtractDataNotify(appID string, account *openwallet.AssetsAccount, data *openwallet.TxExtractData) error {
+	return n.publish(AppTopic(TopicTxExtractData, appID), &TxExtractDataMessage{AppID: appID, Account: account, Data: data})
+}
+
+//BlockSmartContractReceiptNotify 区块提取合约交易回执通知
+func (n *TopicNotifier) BlockSmartContractReceiptNotify(appID string, receipt *openwallet.SmartContractReceipt) error {
+	return n.publish(AppTopic(TopicSmartContractReceipt, appID), &SmartContractReceiptMessage{AppID: appID, Receipt: receipt})
+}
+
+//BlockTxFinalizedNotify 交易单确认数达到阈值通知
+func (n *TopicNotifier) BlockTxFinalizedNotify(appID string, tx *openwallet.Transaction) error {
+	return n.publish(AppTopic(TopicTxFinalized, appID), &TxFinalizedMessage{AppID: appID, Tx: tx})
+}
+
+//TxBroadcastStateNotify 广播的交易单跟踪状态变化通知
+func (n *TopicNotifier) TxBroadcastStateNotify(appID string, record *TxBroadcastRecord) error {
+	return n.publish(AppTopic(TopicTxBroadcastState, appID), &TxBroadcastStateMessage{AppID: appID, Record: record})
+}
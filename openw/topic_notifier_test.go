@@ -0,0 +1,64 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestTopicNotifier(t *testing.T) {
+
+	var (
+		topics   []string
+		messages []interface{}
+	)
+
+	var notifier NotificationObject = NewTopicNotifier(func(topic string, data interface{}) error {
+		topics = append(topics, topic)
+		messages = append(messages, data)
+		return nil
+	})
+
+	header := &openwallet.BlockHeader{Height: 100, Symbol: "BTC"}
+	tx := &openwallet.Transaction{TxID: "tx1"}
+	notifier.BlockScanNotify(header)
+	notifier.BlockTxExtractDataNotify(&openwallet.AssetsAccount{AccountID: "a1"}, &openwallet.TxExtractData{})
+	notifier.(AppTxExtractDataNotificationObject).BlockAppTxExtractDataNotify("app", &openwallet.AssetsAccount{AccountID: "a1"}, &openwallet.TxExtractData{})
+	notifier.BlockSmartContractReceiptNotify("app", &openwallet.SmartContractReceipt{TxID: "tx1"})
+	notifier.BlockTxFinalizedNotify("app", tx)
+	notifier.TxBroadcastStateNotify("app", &TxBroadcastRecord{TxID: "tx1", State: "seen"})
+
+	//应用数据只推送到应用的主题
+	want := []string{
+		TopicBlockScan,
+		"openw.txExtractData.app",
+		"openw.smartContractReceipt.app",
+		"openw.txFinalized.app",
+		"openw.txBroadcastState.app",
+	}
+	if !reflect.DeepEqual(topics, want) {
+		t.Fatalf("topics = %v, want %v", topics, want)
+	}
+	if messages[0] != header {
+		t.Errorf("block scan message = %+v", messages[0])
+	}
+	if m, ok := messages[3].(*TxFinalizedMessage); !ok || m.AppID != "app" || m.Tx != tx {
+		t.Errorf("tx finalized message = %+v", messages[3])
+	}
+}
@@ -135,6 +135,16 @@ func (wm *WalletManager) CreateTransaction(appID, walletID, accountID, amount, a
 
 // CreateBatchTransaction
 func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeRate, memo string, to map[string]string, contract *openwallet.SmartContract, extParam map[string]interface{},) (*openwallet.RawTransaction, error) {
+	return wm.CreateBatchTransactionWithSid(appID, walletID, accountID, "", feeRate, memo, to, contract, extParam)
+}
+
+// CreateTransactionWithSid 以业务订单号创建交易单，业务订单号已被使用返回ErrTransactionSidUsed
+func (wm *WalletManager) CreateTransactionWithSid(appID, walletID, accountID, sid, amount, address, feeRate, memo string, contract *openwallet.SmartContract, extParam map[string]interface{}) (*openwallet.RawTransaction, error) {
+	return wm.CreateBatchTransactionWithSid(appID, walletID, accountID, sid, feeRate, memo, map[string]string{address: amount}, contract, extParam)
+}
+
+// CreateBatchTransactionWithSid 以业务订单号创建批量交易单，业务订单号已被使用返回ErrTransactionSidUsed
+func (wm *WalletManager) CreateBatchTransactionWithSid(appID, walletID, accountID, sid, feeRate, memo string, to map[string]string, contract *openwallet.SmartContract, extParam map[string]interface{}) (*openwallet.RawTransaction, error) {
 
 	var (
 		coin openwallet.Coin
@@ -150,6 +160,13 @@ func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeR
 		return nil, err
 	}
 
+	//业务订单号只能使用一次
+	if len(sid) > 0 {
+		if err = wm.checkTransactionSid(wrapper, sid); err != nil {
+			return nil, err
+		}
+	}
+
 	assetsMgr, err := GetAssetsAdapter(account.Symbol)
 	if err != nil {
 		return nil, err
@@ -170,12 +187,19 @@ func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeR
 		}
 	}
 
+	//多重签名账户需要收集必要签名数
+	required := account.Required
+	if required == 0 {
+		required = 1
+	}
+
 	rawTx := openwallet.RawTransaction{
 		Coin:     coin,
 		Account:  account,
 		FeeRate:  feeRate,
 		To:       to,
-		Required: 1,
+		Required: required,
+		Sid:      sid,
 	}
 
 	if extParam != nil {
@@ -225,13 +249,19 @@ func (wm *WalletManager) SignTransaction(appID, walletID, accountID, password st
 		return nil, fmt.Errorf("[%s] is not support transaction. ", account.Symbol)
 	}
 
-	//解锁钱包
-	err = wrapper.UnlockWallet(password, 5*time.Second)
-	if err != nil {
-		return nil, err
+	//钱包选择了签名提供者，不在进程内解密种子
+	if wallet := wrapper.GetWallet(); wallet != nil && len(wallet.Signer) > 0 {
+		err = wm.signRawTransactionWithSigner(wrapper, txdecoder, account, rawTx, password)
+		if err != nil {
+			return nil, err
+		}
+		return rawTx, nil
 	}
 
-	err = txdecoder.SignRawTransaction(wrapper, rawTx)
+	//密码为空时使用已解锁钱包的密钥，否则临时解密密钥，签名后清零
+	err = wm.withWalletKey(appID, account.WalletID, password, wrapper, func() error {
+		return txdecoder.SignRawTransaction(wrapper, rawTx)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -297,15 +327,58 @@ func (wm *WalletManager) SubmitTransaction(appID, walletID, accountID string, ra
 		return nil, fmt.Errorf("[%s] is not support transaction. ", account.Symbol)
 	}
 
+	//多重签名交易单，校验已签名拥有者的签名，未达到必要签名数，不允许广播
+	if rawTx.Required > 1 {
+		if err = rawTx.VerifySignatures(account); err != nil {
+			return nil, err
+		}
+	}
+	if rawTx.Required > 1 && !rawTx.IsCompleted {
+		return nil, fmt.Errorf("transaction signatures are not completed, signed: %d, required: %d", len(rawTx.SignedOwners()), rawTx.Required)
+	}
+
+	txWrapper := NewTransactionWrapper(wrapper)
+
+	//业务订单号只能广播一次，重复提交返回已广播的交易单
+	if len(rawTx.Sid) > 0 {
+		record, err := txWrapper.ReserveTransactionSid(rawTx.Sid, accountID, rawTx.TxID)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			return wm.reconcileTransactionSid(txWrapper, accountID, record)
+		}
+	}
+
 	tx, err := txdecoder.SubmitRawTransaction(wrapper, rawTx)
 	if err != nil {
+		if len(rawTx.Sid) > 0 {
+			//广播出错时节点可能已接收交易单（如请求超时），保留登记，不允许同一业务订单号再次广播
+			if e := txWrapper.MarkTransactionSidUnknown(rawTx.Sid, rawTx.TxID); e != nil {
+				log.Error("mark transaction sid unknown failed, unexpected error:", e)
+			}
+		}
 		return nil, err
 	}
 
 	log.Debug("transaction has been submitted successfully")
 
+	if len(rawTx.Sid) > 0 {
+		err = txWrapper.CompleteTransactionSid(rawTx.Sid, tx)
+		if err != nil {
+			//交易单已广播，返回交易单和错误，登记超时后按交易单ID核对
+			return tx, fmt.Errorf("transaction: %s has been submitted, but save sid: %s failed, unexpected error: %v", tx.TxID, rawTx.Sid, err)
+		}
+	}
+
+	//跟踪交易单直到上链
+	err = wm.trackSubmittedTransaction(appID, wrapper, rawTx, tx)
+	if err != nil {
+		log.Error("track submitted transaction failed, unexpected error:", err)
+	}
+
 	log.Info("Save new transaction data successfully")
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return tx, nil
 	}
@@ -321,6 +394,101 @@ func (wm *WalletManager) SubmitTransaction(appID, walletID, accountID string, ra
 	//return perfectTx, nil
 }
 
+//reconcileTransactionSid 业务订单号已登记，返回已广播的交易单。
+//正在广播超时（进程中断）或广播结果未知时，按登记的交易单ID查找已上链的交易记录，找到则补充完成登记。
+func (wm *WalletManager) reconcileTransactionSid(txWrapper *TransactionWrapper, accountID string, record *openwallet.TransactionSidRecord) (*openwallet.Transaction, error) {
+
+	if record.AccountID != accountID {
+		return nil, fmt.Errorf("%w: sid: %s has been used by account: %s", ErrTransactionSidUsed, record.Sid, record.AccountID)
+	}
+
+	if record.Submitted {
+		return wm.getTransactionBySidRecord(txWrapper.WalletWrapper, accountID, record)
+	}
+
+	if record.State == openwallet.TxSidStateSubmitting {
+		if time.Since(time.Unix(record.CreateAt, 0)) < wm.cfg.TxSidSubmitTimeout {
+			return nil, fmt.Errorf("transaction of sid: %s is submitting", record.Sid)
+		}
+		//广播过程中进程中断
+		log.Warning("transaction of sid:", record.Sid, "is submitting timeout, mark the broadcast result unknown")
+		if err := txWrapper.MarkTransactionSidUnknown(record.Sid, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(record.TxID) > 0 {
+		txs, err := txWrapper.GetTransactions(0, 1, "TxID", record.TxID)
+		if err == nil && len(txs) > 0 {
+			if err = txWrapper.CompleteTransactionSid(record.Sid, txs[0]); err != nil {
+				return nil, err
+			}
+			log.Warning("transaction of sid:", record.Sid, "has been found on chain, txid:", record.TxID)
+			return txs[0], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: sid: %s, txid: %s, check the transaction on chain and call ResolveTransactionSid", ErrTransactionSidUnknown, record.Sid, record.TxID)
+}
+
+//ResolveTransactionSid 核对广播结果未知的业务订单号。
+//tx不为空表示交易单已广播，登记为已完成；tx为空表示确认没有广播，删除登记，允许使用该业务订单号重新提交
+func (wm *WalletManager) ResolveTransactionSid(appID, sid string, tx *openwallet.Transaction) error {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return err
+	}
+
+	txWrapper := NewTransactionWrapper(wrapper)
+
+	if tx != nil {
+		return txWrapper.CompleteTransactionSid(sid, tx)
+	}
+
+	return txWrapper.ReleaseTransactionSid(sid)
+}
+
+//checkTransactionSid 创建交易单前检查业务订单号没有被使用
+func (wm *WalletManager) checkTransactionSid(wrapper *WalletWrapper, sid string) error {
+
+	record, err := wrapper.GetTransactionSidRecord(sid)
+	if err == ErrDataNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: sid: %s, account: %s, state: %s, txid: %s", ErrTransactionSidUsed, record.Sid, record.AccountID, record.State, record.TxID)
+}
+
+//getTransactionBySidRecord 已登记的业务订单号对应的交易单
+func (wm *WalletManager) getTransactionBySidRecord(wrapper *WalletWrapper, accountID string, record *openwallet.TransactionSidRecord) (*openwallet.Transaction, error) {
+
+	if record.AccountID != accountID {
+		return nil, fmt.Errorf("sid: %s has been used by account: %s", record.Sid, record.AccountID)
+	}
+
+	if !record.Submitted {
+		return nil, fmt.Errorf("transaction of sid: %s is submitting", record.Sid)
+	}
+
+	log.Warning("transaction of sid:", record.Sid, "has been submitted, txid:", record.TxID)
+
+	txs, err := wrapper.GetTransactions(0, 1, "WxID", record.WxID)
+	if err == nil && len(txs) > 0 {
+		return txs[0], nil
+	}
+
+	//交易记录已被删除（如分叉回滚），返回登记的交易单ID
+	return &openwallet.Transaction{
+		WxID:      record.WxID,
+		TxID:      record.TxID,
+		AccountID: record.AccountID,
+	}, nil
+}
+
 //GetAssetsAccountBalance 获取账户余额
 func (wm *WalletManager) GetAssetsAccountBalance(appID, walletID, accountID string) (*openwallet.Balance, error) {
 
@@ -504,6 +672,33 @@ func (wm *WalletManager) GetTransactionByWxID(appID, wxID string) (*openwallet.T
 	return trx[0], nil
 }
 
+//GetTransactionSidRecord 通过业务订单号获取交易单关联记录
+func (wm *WalletManager) GetTransactionSidRecord(appID, sid string) (*openwallet.TransactionSidRecord, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper.GetTransactionSidRecord(sid)
+}
+
+//GetTransactionBySid 通过业务订单号获取已广播的交易单
+func (wm *WalletManager) GetTransactionBySid(appID, sid string) (*openwallet.Transaction, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := wrapper.GetTransactionSidRecord(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.getTransactionBySidRecord(wrapper, record.AccountID, record)
+}
+
 //GetTxUnspent
 func (wm *WalletManager) GetTxUnspent(appID string, offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
 
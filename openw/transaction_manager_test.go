@@ -16,6 +16,8 @@
 package openw
 
 import (
+	"errors"
+	"fmt"
 	"github.com/astaxie/beego/config"
 	"path/filepath"
 	"testing"
@@ -401,3 +403,120 @@ func TestGetAddressBalance(t *testing.T) {
 		log.Infof("ConfirmBalance[%s] = %s", b.Address, b.ConfirmBalance)
 	}
 }
+
+//testSubmitDecoder 记录广播次数的交易单解析器
+type testSubmitDecoder struct {
+	openwallet.TransactionDecoderBase
+	submitted int
+	fail      bool
+}
+
+func (decoder *testSubmitDecoder) SubmitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
+	if decoder.fail {
+		return nil, fmt.Errorf("broadcast failed")
+	}
+	decoder.submitted++
+	tx := &openwallet.Transaction{
+		TxID:      fmt.Sprintf("tx%d", decoder.submitted),
+		AccountID: rawTx.Account.AccountID,
+		Coin:      rawTx.Coin,
+	}
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+	return tx, nil
+}
+
+type testSubmitAdapter struct {
+	openwallet.AssetsAdapterBase
+	decoder *testSubmitDecoder
+}
+
+func (a *testSubmitAdapter) GetTransactionDecoder() openwallet.TransactionDecoder {
+	return a.decoder
+}
+
+func TestWalletManager_SubmitTransactionWithSid(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "sid_app"
+	defer tm.CloseDB(appID)
+
+	decoder := &testSubmitDecoder{}
+	RegAssets("SIDT", &testSubmitAdapter{decoder: decoder})
+
+	db, err := tm.OpenDataStore(appID)
+	if err != nil {
+		t.Fatalf("OpenDB failed, unexpected error: %v", err)
+	}
+	account := &openwallet.AssetsAccount{WalletID: "W1", AccountID: "A1", Symbol: "SIDT"}
+	db.Save(account)
+	db.Save(&openwallet.AssetsAccount{WalletID: "W1", AccountID: "A2", Symbol: "SIDT"})
+
+	newRawTx := func(sid string) *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{Sid: sid, Account: account, Coin: openwallet.Coin{Symbol: "SIDT"}}
+	}
+
+	//广播出错，结果未知，核对前不能重新提交
+	decoder.fail = true
+	_, err = tm.SubmitTransaction(appID, "W1", "A1", newRawTx("order1"))
+	if err == nil {
+		t.Fatalf("SubmitTransaction should fail")
+	}
+	decoder.fail = false
+
+	_, err = tm.SubmitTransaction(appID, "W1", "A1", newRawTx("order1"))
+	if !errors.Is(err, ErrTransactionSidUnknown) || decoder.submitted != 0 {
+		t.Fatalf("SubmitTransaction with unknown sid broadcast %d times, err: %v", decoder.submitted, err)
+	}
+
+	//确认没有广播后可以重新提交
+	if err = tm.ResolveTransactionSid(appID, "order1", nil); err != nil {
+		t.Fatalf("ResolveTransactionSid failed, unexpected error: %v", err)
+	}
+
+	tx1, err := tm.SubmitTransaction(appID, "W1", "A1", newRawTx("order1"))
+	if err != nil {
+		t.Fatalf("SubmitTransaction failed, unexpected error: %v", err)
+	}
+
+	//重复提交返回已广播的交易单
+	tx2, err := tm.SubmitTransaction(appID, "W1", "A1", newRawTx("order1"))
+	if err != nil {
+		t.Fatalf("SubmitTransaction again failed, unexpected error: %v", err)
+	}
+	if decoder.submitted != 1 || tx2.TxID != tx1.TxID {
+		t.Errorf("retried submit broadcast %d times, txid: %s, want 1 time, txid: %s", decoder.submitted, tx2.TxID, tx1.TxID)
+	}
+
+	//其他账户不能使用同一个业务订单号
+	_, err = tm.SubmitTransaction(appID, "W1", "A2", newRawTx("order1"))
+	if err == nil {
+		t.Errorf("SubmitTransaction by other account should fail")
+	}
+
+	_, err = tm.SubmitTransaction(appID, "W1", "A1", newRawTx("order2"))
+	if err != nil || decoder.submitted != 2 {
+		t.Fatalf("SubmitTransaction of new sid broadcast %d times, err: %v", decoder.submitted, err)
+	}
+
+	record, err := tm.GetTransactionSidRecord(appID, "order1")
+	if err != nil || !record.Submitted || record.TxID != tx1.TxID {
+		t.Errorf("GetTransactionSidRecord = %+v, err: %v", record, err)
+	}
+
+	found, err := tm.GetTransactionBySid(appID, "order2")
+	if err != nil || found.TxID != "tx2" {
+		t.Errorf("GetTransactionBySid = %+v, err: %v", found, err)
+	}
+
+	//已使用的业务订单号不能再创建交易单
+	wrapper, err := tm.NewWalletWrapper(appID, "")
+	if err != nil {
+		t.Fatalf("NewWalletWrapper failed, unexpected error: %v", err)
+	}
+	if err = tm.checkTransactionSid(wrapper, "order2"); !errors.Is(err, ErrTransactionSidUsed) {
+		t.Errorf("checkTransactionSid of used sid, err: %v", err)
+	}
+	if err = tm.checkTransactionSid(wrapper, "order3"); err != nil {
+		t.Errorf("checkTransactionSid of new sid, unexpected error: %v", err)
+	}
+}
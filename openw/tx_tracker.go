@@ -0,0 +1,349 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//交易单广播的跟踪状态
+const (
+	TxStateSubmitted = "submitted" //已广播，等待扫描器发现
+	TxStateSeen      = "seen"      //扫描器已在交易池中发现
+	TxStateMined     = "mined"     //已上链，等待确认数达到阈值，分叉时恢复为已广播
+	TxStateConfirmed = "confirmed" //确认数达到阈值，最终确认
+	TxStateFailed    = "failed"    //已上链，但链上执行失败
+	TxStateDropped   = "dropped"   //超过丢弃时间没有被发现，不再重新广播
+)
+
+//TxBroadcastRecord 交易单广播跟踪记录，从广播开始跟踪到上链确认
+type TxBroadcastRecord struct {
+	WxID              string                     `json:"wxid" storm:"id"`
+	TxID              string                     `json:"txid"`
+	AccountID         string                     `json:"accountID" storm:"index"`
+	Symbol            string                     `json:"symbol"`
+	State             string                     `json:"state" storm:"index"`
+	RawTx             *openwallet.RawTransaction `json:"rawTx"`             //已签名的原始交易单，用于重新广播
+	Broadcasts        int64                      `json:"broadcasts"`        //广播次数
+	SubmitTime        int64                      `json:"submitTime"`        //首次广播时间
+	LastBroadcastTime int64                      `json:"lastBroadcastTime"` //最后广播时间
+	BlockHeight       uint64                     `json:"blockHeight"`       //被发现的区块高度，交易池中为0
+	UpdateTime        int64                      `json:"updateTime"`        //状态更新时间
+	Reason            string                     `json:"reason"`            //失败或丢弃原因
+}
+
+//isFinal 是否为扫描通知不再更新的状态，只有区块分叉时恢复为已广播
+func (record *TxBroadcastRecord) isFinal() bool {
+	return record.State == TxStateConfirmed || record.State == TxStateFailed
+}
+
+//GetTxBroadcastRecords 获取交易单广播跟踪记录
+func (wrapper *WalletWrapper) GetTxBroadcastRecords(offset, limit int, cols ...interface{}) ([]*TxBroadcastRecord, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	conditions, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*TxBroadcastRecord
+	err = db.Find(&records, offset, limit, conditions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+//SaveTxBroadcastRecord 保存交易单广播跟踪记录
+func (wrapper *TransactionWrapper) SaveTxBroadcastRecord(record *TxBroadcastRecord) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	return db.Save(record)
+}
+
+//getTxBroadcastRecord 获取交易单的广播跟踪记录，没有返回nil
+func (wrapper *TransactionWrapper) getTxBroadcastRecord(wxID string) (*TxBroadcastRecord, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var record TxBroadcastRecord
+	err = db.One("WxID", wxID, &record)
+	if err != nil {
+		if err == ErrDataNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+//GetTxBroadcastRecords 获取应用的交易单广播跟踪记录
+func (wm *WalletManager) GetTxBroadcastRecords(appID string, offset, limit int, cols ...interface{}) ([]*TxBroadcastRecord, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper.GetTxBroadcastRecords(offset, limit, cols...)
+}
+
+//setTxBroadcastState 更新跟踪状态并通知观察者
+func (wm *WalletManager) setTxBroadcastState(appID string, txWrapper *TransactionWrapper, record *TxBroadcastRecord, state, reason string) error {
+
+	record.State = state
+	record.Reason = reason
+	record.UpdateTime = time.Now().Unix()
+
+	err := txWrapper.SaveTxBroadcastRecord(record)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("TxBroadcastState:", appID, record.TxID, state)
+
+	for o, _ := range wm.observers {
+		o.TxBroadcastStateNotify(appID, record)
+	}
+
+	return nil
+}
+
+//trackSubmittedTransaction 开始跟踪新广播的交易单
+func (wm *WalletManager) trackSubmittedTransaction(appID string, wrapper *WalletWrapper, rawTx *openwallet.RawTransaction, tx *openwallet.Transaction) error {
+
+	if len(tx.WxID) == 0 {
+		tx.WxID = openwallet.GenTransactionWxID(tx)
+	}
+
+	now := time.Now().Unix()
+	record := &TxBroadcastRecord{
+		WxID:              tx.WxID,
+		TxID:              tx.TxID,
+		AccountID:         tx.AccountID,
+		Symbol:            rawTx.Coin.Symbol,
+		RawTx:             rawTx,
+		Broadcasts:        1,
+		SubmitTime:        now,
+		LastBroadcastTime: now,
+	}
+
+	return wm.setTxBroadcastState(appID, NewTransactionWrapper(wrapper), record, TxStateSubmitted, "")
+}
+
+//trackExtractData 扫描器提取到交易单，更新跟踪状态
+func (wm *WalletManager) trackExtractData(appID string, wrapper *WalletWrapper, data *openwallet.TxExtractData) error {
+
+	if data.Transaction == nil {
+		return nil
+	}
+
+	txWrapper := NewTransactionWrapper(wrapper)
+	record, err := txWrapper.getTxBroadcastRecord(data.Transaction.WxID)
+	if err != nil || record == nil || record.isFinal() {
+		return err
+	}
+
+	trx := data.Transaction
+
+	//交易池中发现，已上链的不再回退
+	if data.Pending || trx.BlockHeight == 0 {
+		if record.State != TxStateSubmitted {
+			return nil
+		}
+		return wm.setTxBroadcastState(appID, txWrapper, record, TxStateSeen, "")
+	}
+
+	record.BlockHeight = trx.BlockHeight
+
+	if record.State == TxStateSubmitted {
+		err = wm.setTxBroadcastState(appID, txWrapper, record, TxStateSeen, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if trx.Status == openwallet.TxStatusFail {
+		return wm.setTxBroadcastState(appID, txWrapper, record, TxStateFailed, trx.Reason)
+	}
+
+	//上链后等待确认数达到阈值，没有配置阈值的主链保持为已上链，分叉时可以恢复
+	return wm.setTxBroadcastState(appID, txWrapper, record, TxStateMined, "")
+}
+
+//trackFinalizedTransaction 交易单确认数达到阈值，跟踪状态更新为已确认
+func (wm *WalletManager) trackFinalizedTransaction(appID string, wrapper *WalletWrapper, tx *openwallet.Transaction) error {
+
+	txWrapper := NewTransactionWrapper(wrapper)
+	record, err := txWrapper.getTxBroadcastRecord(tx.WxID)
+	if err != nil || record == nil || record.isFinal() {
+		return err
+	}
+
+	record.BlockHeight = tx.BlockHeight
+	return wm.setTxBroadcastState(appID, txWrapper, record, TxStateConfirmed, "")
+}
+
+//trackForkBlock 分叉高度及以上区块中发现的交易单（包括已确认和执行失败的），恢复为已广播，继续重新广播
+func (wm *WalletManager) trackForkBlock(appID string, wrapper *WalletWrapper, height uint64) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return err
+	}
+
+	var records []*TxBroadcastRecord
+	err = db.Find(&records, 0, -1, DataGte("BlockHeight", height))
+	wrapper.CloseDB()
+	if err != nil {
+		if err == ErrDataNotFound {
+			return nil
+		}
+		return err
+	}
+
+	txWrapper := NewTransactionWrapper(wrapper)
+	for _, record := range records {
+		if record.State == TxStateSubmitted || record.State == TxStateDropped {
+			continue
+		}
+		record.BlockHeight = 0
+		record.LastBroadcastTime = 0
+		err = wm.setTxBroadcastState(appID, txWrapper, record, TxStateSubmitted, fmt.Sprintf("block %d has been forked", height))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//txTrackerRuntime 定时检查已广播未被发现的交易单
+func (wm *WalletManager) txTrackerRuntime() {
+
+	//加载已存在所有app
+	appIDs, err := wm.loadAllAppIDs()
+	if err != nil {
+		log.Error("transaction tracker load apps failed, unexpected error:", err)
+		return
+	}
+
+	for _, appID := range appIDs {
+		err = wm.CheckSubmittedTransactions(appID, time.Now())
+		if err != nil {
+			log.Error("transaction tracker check app:", appID, "failed, unexpected error:", err)
+		}
+	}
+}
+
+//CheckSubmittedTransactions 检查应用已广播但未上链的交易单（包括只在交易池中发现的），
+//超过TxDropTimeout标记为丢弃，否则超过TxRebroadcastPeriod重新广播
+func (wm *WalletManager) CheckSubmittedTransactions(appID string, now time.Time) error {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return err
+	}
+
+	records := make([]*TxBroadcastRecord, 0)
+	for _, state := range []string{TxStateSubmitted, TxStateSeen} {
+		list, err := wrapper.GetTxBroadcastRecords(0, -1, "State", state, "BlockHeight", uint64(0))
+		if err != nil && err != ErrDataNotFound {
+			return err
+		}
+		records = append(records, list...)
+	}
+
+	txWrapper := NewTransactionWrapper(wrapper)
+
+	for _, record := range records {
+
+		if wm.cfg.TxDropTimeout > 0 && now.Sub(time.Unix(record.SubmitTime, 0)) >= wm.cfg.TxDropTimeout {
+			reason := fmt.Sprintf("transaction is not found after %v", wm.cfg.TxDropTimeout)
+			err = wm.setTxBroadcastState(appID, txWrapper, record, TxStateDropped, reason)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if wm.cfg.TxRebroadcastPeriod <= 0 || record.RawTx == nil {
+			continue
+		}
+
+		if now.Sub(time.Unix(record.LastBroadcastTime, 0)) < wm.cfg.TxRebroadcastPeriod {
+			continue
+		}
+
+		err = wm.rebroadcastTransaction(wrapper, record)
+		if err != nil {
+			//节点可能已经有该交易单，只记录错误，继续等待发现或丢弃
+			log.Warning("rebroadcast transaction:", record.TxID, "failed, unexpected error:", err)
+		}
+
+		record.Broadcasts++
+		record.LastBroadcastTime = now.Unix()
+		err = txWrapper.SaveTxBroadcastRecord(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//rebroadcastTransaction 重新广播保存的原始交易单
+func (wm *WalletManager) rebroadcastTransaction(wrapper *WalletWrapper, record *TxBroadcastRecord) error {
+
+	assetsMgr, err := GetAssetsAdapter(record.Symbol)
+	if err != nil {
+		return err
+	}
+
+	txdecoder := assetsMgr.GetTransactionDecoder()
+	if txdecoder == nil {
+		return fmt.Errorf("[%s] is not support transaction. ", record.Symbol)
+	}
+
+	log.Info("rebroadcast transaction:", record.TxID)
+
+	_, err = txdecoder.SubmitRawTransaction(wrapper, record.RawTx)
+	return err
+}
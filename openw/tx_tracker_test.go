@@ -0,0 +1,128 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestWalletManager_TrackSubmittedTransaction(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	defer tm.Close()
+	appID := "tracker_app"
+	tm.cfg.TxRebroadcastPeriod = time.Minute
+	tm.cfg.TxDropTimeout = 6 * time.Hour
+
+	decoder := &testSubmitDecoder{}
+	RegAssets("TRKT", &testSubmitAdapter{decoder: decoder})
+
+	db, err := tm.OpenDataStore(appID)
+	if err != nil {
+		t.Fatalf("OpenDB failed, unexpected error: %v", err)
+	}
+	account := &openwallet.AssetsAccount{WalletID: "W1", AccountID: "A1", Symbol: "TRKT"}
+	db.Save(account)
+	db.Save(&openwallet.Address{Address: "addr1", AccountID: "A1"})
+
+	observer := &testReceiptObserver{receipts: make(map[string]*openwallet.SmartContractReceipt)}
+	tm.AddObserver(observer)
+
+	rawTx := &openwallet.RawTransaction{Account: account, Coin: openwallet.Coin{Symbol: "TRKT"}}
+	tx1, err := tm.SubmitTransaction(appID, "W1", "A1", rawTx)
+	if err != nil {
+		t.Fatalf("SubmitTransaction failed, unexpected error: %v", err)
+	}
+
+	//未到重新广播的时间
+	now := time.Now()
+	if err = tm.CheckSubmittedTransactions(appID, now); err != nil {
+		t.Fatalf("CheckSubmittedTransactions failed, unexpected error: %v", err)
+	}
+	if decoder.submitted != 1 {
+		t.Errorf("broadcast %d times, want 1", decoder.submitted)
+	}
+
+	if err = tm.CheckSubmittedTransactions(appID, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("CheckSubmittedTransactions failed, unexpected error: %v", err)
+	}
+	records, err := tm.GetTxBroadcastRecords(appID, 0, -1, "WxID", tx1.WxID)
+	if err != nil || len(records) != 1 || records[0].Broadcasts != 2 || decoder.submitted != 2 {
+		t.Fatalf("rebroadcast records = %v, broadcast %d times, err: %v", records, decoder.submitted, err)
+	}
+
+	//交易池中发现，然后上链
+	newData := func(height uint64, pending bool) *openwallet.TxExtractData {
+		data := openwallet.NewBlockExtractData()
+		data.Pending = pending
+		data.Transaction = &openwallet.Transaction{TxID: tx1.TxID, Coin: tx1.Coin, BlockHeight: height, Status: openwallet.TxStatusSuccess}
+		data.Transaction.WxID = openwallet.GenTransactionWxID(data.Transaction)
+		return data
+	}
+	sourceKey := tm.encodeSourceKey(appID, "A1")
+	for _, data := range []*openwallet.TxExtractData{newData(0, true), newData(100, false)} {
+		if err = tm.BlockExtractDataNotify(sourceKey, data); err != nil {
+			t.Fatalf("BlockExtractDataNotify failed, unexpected error: %v", err)
+		}
+	}
+
+	//一直没有上链的交易单被丢弃
+	tx2, err := tm.SubmitTransaction(appID, "W1", "A1", &openwallet.RawTransaction{Account: account, Coin: openwallet.Coin{Symbol: "TRKT"}})
+	if err != nil {
+		t.Fatalf("SubmitTransaction failed, unexpected error: %v", err)
+	}
+	if err = tm.CheckSubmittedTransactions(appID, now.Add(tm.cfg.TxDropTimeout+time.Minute)); err != nil {
+		t.Fatalf("CheckSubmittedTransactions failed, unexpected error: %v", err)
+	}
+
+	//没有配置确认数阈值，上链后保持为已上链
+	records, err = tm.GetTxBroadcastRecords(appID, 0, -1, "State", TxStateMined)
+	if err != nil || len(records) != 1 || records[0].BlockHeight != 100 {
+		t.Errorf("mined records = %v, err: %v", records, err)
+	}
+
+	//低于上链高度的分叉区块不影响，上链高度分叉后恢复为已广播
+	wrapper, err := tm.NewWalletWrapper(appID, "")
+	if err != nil {
+		t.Fatalf("NewWalletWrapper failed, unexpected error: %v", err)
+	}
+	for _, height := range []uint64{101, 99} {
+		if err = tm.trackForkBlock(appID, wrapper, height); err != nil {
+			t.Fatalf("trackForkBlock failed, unexpected error: %v", err)
+		}
+	}
+
+	want := []string{
+		tx1.TxID + ":" + TxStateSubmitted,
+		tx1.TxID + ":" + TxStateSeen,
+		tx1.TxID + ":" + TxStateMined,
+		tx2.TxID + ":" + TxStateSubmitted,
+		tx2.TxID + ":" + TxStateDropped,
+		tx1.TxID + ":" + TxStateSubmitted,
+	}
+	if !reflect.DeepEqual(observer.states, want) {
+		t.Errorf("observer states = %v, want %v", observer.states, want)
+	}
+
+	records, err = tm.GetTxBroadcastRecords(appID, 0, -1, "WxID", tx1.WxID)
+	if err != nil || len(records) != 1 || records[0].State != TxStateSubmitted || records[0].BlockHeight != 0 {
+		t.Errorf("forked records = %v, err: %v", records, err)
+	}
+}
@@ -16,11 +16,22 @@
 package openw
 
 import (
+	"errors"
 	"fmt"
-	"github.com/asdine/storm/q"
 	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrPendingTxConfirmed 未确认的交易单已经上链保存，不需要再保存
+	ErrPendingTxConfirmed = errors.New("pending transaction has been confirmed")
+	//ErrTransactionSidUsed 业务订单号已登记，不能再创建或广播新的交易单
+	ErrTransactionSidUsed = errors.New("transaction sid has been used")
+	//ErrTransactionSidUnknown 业务订单号的交易单广播结果未知，需要核对后调用ResolveTransactionSid
+	ErrTransactionSidUnknown = errors.New("transaction sid broadcast result is unknown")
 )
 
 // TransactionWrapper 交易包装器，扩展钱包交易单相关功能
@@ -48,7 +59,7 @@ func NewTransactionWrapper(args ...interface{}) *TransactionWrapper {
 func (wrapper *WalletWrapper) GetTxInputs(offset, limit int, cols ...interface{}) ([]*openwallet.TxInput, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -56,31 +67,12 @@ func (wrapper *WalletWrapper) GetTxInputs(offset, limit int, cols ...interface{}
 
 	var txs []*openwallet.TxInput
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+	query, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&txs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&txs)
-
-	}
+	err = db.Find(&txs, offset, limit, query...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find txInputs")
@@ -93,7 +85,7 @@ func (wrapper *WalletWrapper) GetTxInputs(offset, limit int, cols ...interface{}
 func (wrapper *WalletWrapper) GetTxOutputs(offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -101,31 +93,12 @@ func (wrapper *WalletWrapper) GetTxOutputs(offset, limit int, cols ...interface{
 
 	var txs []*openwallet.TxOutPut
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+	query, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&txs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&txs)
-
-	}
+	err = db.Find(&txs, offset, limit, query...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find txoutputs")
@@ -138,7 +111,7 @@ func (wrapper *WalletWrapper) GetTxOutputs(offset, limit int, cols ...interface{
 func (wrapper *WalletWrapper) GetTransactions(offset, limit int, cols ...interface{}) ([]*openwallet.Transaction, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -146,31 +119,12 @@ func (wrapper *WalletWrapper) GetTransactions(offset, limit int, cols ...interfa
 
 	var txs []*openwallet.Transaction
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+	query, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&txs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&txs)
-
-	}
+	err = db.Find(&txs, offset, limit, query...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find transactions")
@@ -180,6 +134,8 @@ func (wrapper *WalletWrapper) GetTransactions(offset, limit int, cols ...interfa
 }
 
 //SaveBlockExtractData 保存区块提取数据
+//未确认的提取数据（data.Pending）与上链后的数据有相同的记录ID，上链后的数据会覆盖未确认的记录，
+//已上链的交易单不会被未确认的数据覆盖，返回ErrPendingTxConfirmed
 func (wrapper *TransactionWrapper) SaveBlockExtractData(accountID string, data *openwallet.TxExtractData) error {
 
 	var (
@@ -188,128 +144,471 @@ func (wrapper *TransactionWrapper) SaveBlockExtractData(accountID string, data *
 	)
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
 	defer wrapper.CloseDB()
 
-	tx, err := db.Begin(true)
+	err = db.Update(func(tx DataStore) error {
+
+		if data.Pending {
+			var exist openwallet.Transaction
+			err := tx.One("WxID", data.Transaction.WxID, &exist)
+			if err == nil && exist.BlockHeight > 0 {
+				return ErrPendingTxConfirmed
+			}
+		}
+
+		//保存出账的记录
+		for _, input := range data.TxInputs {
+			var a openwallet.Address
+			err := tx.One("Address", input.Address, &a)
+			if err != nil {
+				continue
+			}
+			input.AccountID = a.AccountID
+			err = tx.Save(input)
+			if err != nil {
+				return fmt.Errorf("wallet save TxInputs failed, unexpected error: %v", err)
+			}
+
+			//统计该交易单下的各个资产账户的支出总数
+			if a.AccountID == accountID {
+				amount, _ := decimal.NewFromString(input.Amount)
+				accountSpent = accountSpent.Add(amount)
+			}
+		}
+
+		//保存入账的记录
+		for _, output := range data.TxOutputs {
+			var a openwallet.Address
+			err := tx.One("Address", output.Address, &a)
+			if err != nil {
+				continue
+			}
+			output.AccountID = a.AccountID
+			err = tx.Save(output)
+			if err != nil {
+				return fmt.Errorf("wallet save TxOutputs failed, unexpected error: %v", err)
+			}
+
+			//统计该交易单下的各个资产账户的收入总数
+			if a.AccountID == accountID {
+				amount, _ := decimal.NewFromString(output.Amount)
+				accountReceived = accountReceived.Add(amount)
+			}
+		}
+
+		//计算该交易单下的各个资产账户实际总收支，记录为账单数据
+		trx := data.Transaction
+		trx.AccountID = accountID
+		trx.Amount = accountReceived.Sub(accountSpent).StringFixed(trx.Decimal)
+
+		//保存账户相关的记录
+		err := tx.Save(trx)
+		if err != nil {
+			return fmt.Errorf("wallet save Transactions failed, unexpected error: %v", err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if err == ErrPendingTxConfirmed {
+			return err
+		}
+		return fmt.Errorf("wallet save TxExtractData failed, unexpected error: %v", err)
+	}
+
+	return nil
+}
+
+//DeleteBlockDataByHeight 删除钱包中指定区块高度相关的交易记录
+func (wrapper *TransactionWrapper) DeleteBlockDataByHeight(height uint64) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
+	defer wrapper.CloseDB()
 
-	defer tx.Rollback()
+	//该高度相关的交易记录，出入账记录，合约回执
+	kinds := []interface{}{
+		&openwallet.Transaction{},
+		&openwallet.TxInput{},
+		&openwallet.TxOutPut{},
+		&openwallet.SmartContractReceiptRecord{},
+	}
 
-	//保存出账的记录
-	for _, input := range data.TxInputs {
-		a, err := wrapper.GetAddress(input.Address)
-		if err != nil {
-			continue
+	return db.Update(func(tx DataStore) error {
+		for _, kind := range kinds {
+			err := tx.Delete(kind, DataEq("BlockHeight", height))
+			if err != nil {
+				return err
+			}
 		}
-		input.AccountID = a.AccountID
-		err = tx.Save(input)
+		return nil
+	})
+}
+
+//UpdateTransactionConfirmations 按最新区块高度更新主链symbol区块高度在[startHeight, height]，未达到确认阈值的交易单确认数
+//@return 本次更新后确认数达到阈值的交易单
+func (wrapper *TransactionWrapper) UpdateTransactionConfirmations(symbol string, startHeight, height, threshold uint64) ([]*openwallet.Transaction, error) {
+
+	if threshold == 0 {
+		return nil, nil
+	}
+
+	if startHeight == 0 {
+		startHeight = 1
+	}
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	finalized := make([]*openwallet.Transaction, 0)
+
+	err = db.Update(func(tx DataStore) error {
+
+		var txs []*openwallet.Transaction
+		err := tx.Find(&txs, 0, 0,
+			DataGte("BlockHeight", startHeight),
+			DataLte("BlockHeight", height),
+			DataLte("Confirm", int64(threshold)-1),
+		)
 		if err != nil {
-			return fmt.Errorf("wallet save TxInputs failed, unexpected error: %v", err)
+			if err == ErrDataNotFound {
+				return nil
+			}
+			return err
 		}
 
-		//统计该交易单下的各个资产账户的支出总数
-		if a.AccountID == accountID {
-			amount, _ := decimal.NewFromString(input.Amount)
-			accountSpent = accountSpent.Add(amount)
+		for _, trx := range txs {
+			//Coin不是inline字段，只能在查询结果中过滤
+			if !strings.EqualFold(trx.Coin.Symbol, symbol) {
+				continue
+			}
+
+			confirm := int64(height - trx.BlockHeight + 1)
+			if confirm <= trx.Confirm {
+				continue
+			}
+			trx.Confirm = confirm
+			err = tx.Save(trx)
+			if err != nil {
+				return fmt.Errorf("wallet save Transaction failed, unexpected error: %v", err)
+			}
+
+			if uint64(confirm) >= threshold {
+				finalized = append(finalized, trx)
+			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	//保存入账的记录
-	for _, output := range data.TxOutputs {
-		a, err := wrapper.GetAddress(output.Address)
-		if err != nil {
-			continue
-		}
-		output.AccountID = a.AccountID
-		err = tx.Save(output)
+	return finalized, nil
+}
+
+//SaveTransactionConfirm 更新交易单的确认数
+func (wrapper *TransactionWrapper) SaveTransactionConfirm(wxID string, confirm int64) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	return db.Update(func(tx DataStore) error {
+		var trx openwallet.Transaction
+		err := tx.One("WxID", wxID, &trx)
 		if err != nil {
-			return fmt.Errorf("wallet save TxOutputs failed, unexpected error: %v", err)
+			return err
 		}
+		trx.Confirm = confirm
+		return tx.Save(&trx)
+	})
+}
 
-		//统计该交易单下的各个资产账户的收入总数
-		if a.AccountID == accountID {
-			amount, _ := decimal.NewFromString(output.Amount)
-			accountReceived = accountReceived.Add(amount)
+//GetTransactionConfirm 交易单记录的确认数，没有记录返回0
+func (wrapper *TransactionWrapper) GetTransactionConfirm(wxID string) (int64, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return 0, err
+	}
+	defer wrapper.CloseDB()
+
+	var trx openwallet.Transaction
+	err = db.One("WxID", wxID, &trx)
+	if err != nil {
+		if err == ErrDataNotFound {
+			return 0, nil
 		}
+		return 0, err
 	}
+	return trx.Confirm, nil
+}
 
-	//计算该交易单下的各个资产账户实际总收支，记录为账单数据
-	trx := data.Transaction
-	trx.AccountID = accountID
-	trx.Amount = accountReceived.Sub(accountSpent).StringFixed(trx.Decimal)
+//GetTransactionSidRecord 获取业务订单号的交易单关联记录
+func (wrapper *WalletWrapper) GetTransactionSidRecord(sid string) (*openwallet.TransactionSidRecord, error) {
 
-	//保存账户相关的记录
-	err = tx.Save(trx)
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
-		return fmt.Errorf("wallet save Transactions failed, unexpected error: %v", err)
+		return nil, err
 	}
+	defer wrapper.CloseDB()
 
-	err = tx.Commit()
+	var record openwallet.TransactionSidRecord
+	err = db.One("Sid", sid, &record)
 	if err != nil {
-		return fmt.Errorf("wallet save TxExtractData failed, unexpected error: %v", err)
+		return nil, err
 	}
 
-	return nil
+	return &record, nil
 }
 
-//DeleteBlockDataByHeight 删除钱包中指定区块高度相关的交易记录
-func (wrapper *TransactionWrapper) DeleteBlockDataByHeight(height uint64) error {
+//ReserveTransactionSid 登记业务订单号准备广播交易单
+//业务订单号已经登记过，返回已有的记录，否则登记为正在提交，返回nil。
+//以主键插入登记，多个服务实例共享SQL数据库并发提交时只有一个能登记成功
+func (wrapper *TransactionWrapper) ReserveTransactionSid(sid, accountID, txID string) (*openwallet.TransactionSidRecord, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer wrapper.CloseDB()
 
-	tx, err := db.Begin(true)
+	now := time.Now().Unix()
+	err = db.Insert(&openwallet.TransactionSidRecord{
+		Sid:       sid,
+		AccountID: accountID,
+		TxID:      txID,
+		State:     openwallet.TxSidStateSubmitting,
+		CreateAt:  now,
+		UpdateAt:  now,
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if err != ErrDataExists {
+		return nil, err
+	}
+
+	var record openwallet.TransactionSidRecord
+	err = db.One("Sid", sid, &record)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer tx.Rollback()
+	return &record, nil
+}
+
+//CompleteTransactionSid 交易单广播成功，记录业务订单号对应的交易单
+func (wrapper *TransactionWrapper) CompleteTransactionSid(sid string, trx *openwallet.Transaction) error {
+
+	return wrapper.updateTransactionSid(sid, func(record *openwallet.TransactionSidRecord) {
+		record.TxID = trx.TxID
+		record.WxID = trx.WxID
+		record.Submitted = true
+		record.State = openwallet.TxSidStateSubmitted
+	})
+}
 
-	trxs, err := wrapper.GetTransactions(0, -1, "BlockHeight", height)
+//MarkTransactionSidUnknown 交易单广播出错，节点可能已经接收，保留登记并标记为结果未知
+func (wrapper *TransactionWrapper) MarkTransactionSidUnknown(sid, txID string) error {
+
+	return wrapper.updateTransactionSid(sid, func(record *openwallet.TransactionSidRecord) {
+		if len(txID) > 0 {
+			record.TxID = txID
+		}
+		record.State = openwallet.TxSidStateUnknown
+	})
+}
+
+//updateTransactionSid 在同一个事务中更新已登记的业务订单号
+func (wrapper *TransactionWrapper) updateTransactionSid(sid string, update func(record *openwallet.TransactionSidRecord)) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
+	defer wrapper.CloseDB()
 
-	for _, obj := range trxs {
-		err = db.DeleteStruct(&obj)
+	return db.Update(func(tx DataStore) error {
+		var record openwallet.TransactionSidRecord
+		err := tx.One("Sid", sid, &record)
 		if err != nil {
 			return err
 		}
-	}
+		update(&record)
+		record.UpdateAt = time.Now().Unix()
+		return tx.Save(&record)
+	})
+}
+
+//ReleaseTransactionSid 确认交易单没有广播，删除未完成的业务订单号，允许重新提交
+func (wrapper *TransactionWrapper) ReleaseTransactionSid(sid string) error {
 
-	inputs, err := wrapper.GetTxInputs(0, -1, "BlockHeight", height)
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
+	defer wrapper.CloseDB()
 
-	for _, obj := range inputs {
-		err = db.DeleteStruct(&obj)
-		if err != nil {
-			return err
+	return db.Delete(&openwallet.TransactionSidRecord{}, DataEq("Sid", sid), DataEq("Submitted", false))
+}
+
+//合约交易回执的扩展查询条件，与字段条件一样成对传入cols
+const (
+	ReceiptQueryEvent       = "Event"       //回执包含指定名称的事件
+	ReceiptQueryStartHeight = "StartHeight" //区块高度大于等于
+	ReceiptQueryEndHeight   = "EndHeight"   //区块高度小于等于
+)
+
+//receiptEventMatcher 匹配回执的事件列表中是否存在指定事件
+type receiptEventMatcher struct {
+	event string
+}
+
+func (m *receiptEventMatcher) MatchField(v interface{}) (bool, error) {
+	events, ok := v.([]*openwallet.SmartContractEvent)
+	if !ok {
+		return false, nil
+	}
+	for _, e := range events {
+		if e != nil && e.Event == m.event {
+			return true, nil
 		}
 	}
+	return false, nil
+}
+
+//newSmartContractReceiptQuery 解析回执查询条件
+func newSmartContractReceiptQuery(cols ...interface{}) ([]*DataCondition, error) {
+
+	query := make([]*DataCondition, 0)
 
-	outputs, err := wrapper.GetTxOutputs(0, -1, "BlockHeight", height)
+	if len(cols)%2 != 0 {
+		return nil, fmt.Errorf("condition param is not pair")
+	}
+
+	for i := 0; i < len(cols); i = i + 2 {
+		field := common.NewString(cols[i])
+		val := cols[i+1]
+		switch field.String() {
+		case ReceiptQueryEvent:
+			event := common.NewString(val)
+			query = append(query, DataMatch("Events", &receiptEventMatcher{event: event.String()}))
+		case ReceiptQueryStartHeight:
+			query = append(query, DataGte("BlockHeight", val))
+		case ReceiptQueryEndHeight:
+			query = append(query, DataLte("BlockHeight", val))
+		default:
+			query = append(query, DataEq(field.String(), val))
+		}
+	}
+
+	return query, nil
+}
+
+//GetSmartContractReceiptRecords 获取钱包的合约交易回执记录
+func (wrapper *WalletWrapper) GetSmartContractReceiptRecords(offset, limit int, cols ...interface{}) ([]*openwallet.SmartContractReceiptRecord, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var records []*openwallet.SmartContractReceiptRecord
+
+	query, err := newSmartContractReceiptQuery(cols...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Find(&records, offset, limit, query...)
+	if err != nil && err != ErrDataNotFound {
+		return nil, fmt.Errorf("can not find smart contract receipts")
+	}
+
+	return records, nil
+}
+
+//GetSmartContractReceipts 获取钱包的合约交易回执
+//cols除了回执字段外，支持ContractID，以及ReceiptQueryEvent，ReceiptQueryStartHeight，ReceiptQueryEndHeight
+func (wrapper *WalletWrapper) GetSmartContractReceipts(offset, limit int, cols ...interface{}) ([]*openwallet.SmartContractReceipt, error) {
+
+	records, err := wrapper.GetSmartContractReceiptRecords(offset, limit, cols...)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := make([]*openwallet.SmartContractReceipt, 0, len(records))
+	for _, r := range records {
+		receipt := r.SmartContractReceipt
+		receipts = append(receipts, &receipt)
+	}
+
+	return receipts, nil
+}
+
+//GetSmartContractReceiptByWxID 通过WxID获取合约交易回执
+func (wrapper *WalletWrapper) GetSmartContractReceiptByWxID(wxID string) (*openwallet.SmartContractReceipt, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var record openwallet.SmartContractReceiptRecord
+	err = db.One("WxID", wxID, &record)
+	if err != nil {
+		return nil, fmt.Errorf("can not find smart contract receipt: %s", wxID)
+	}
+
+	return &record.SmartContractReceipt, nil
+}
+
+//SaveSmartContractReceipt 保存合约交易回执
+func (wrapper *TransactionWrapper) SaveSmartContractReceipt(contractID string, receipt *openwallet.SmartContractReceipt) error {
+
+	if receipt == nil {
+		return fmt.Errorf("smart contract receipt is nil")
+	}
+
+	//打开数据库
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
+	defer wrapper.CloseDB()
 
-	for _, obj := range outputs {
-		err = db.DeleteStruct(&obj)
-		if err != nil {
-			return err
-		}
+	record := openwallet.NewSmartContractReceiptRecord(contractID, receipt)
+
+	err = db.Save(record)
+	if err != nil {
+		return fmt.Errorf("wallet save SmartContractReceipt failed, unexpected error: %v", err)
 	}
 
-	return tx.Commit()
+	return nil
 }
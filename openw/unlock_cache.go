@@ -0,0 +1,237 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+//unlockedWallet 已解锁的钱包，到期或主动锁定时清零密钥
+type unlockedWallet struct {
+	mu       sync.RWMutex //使用密钥时持有读锁，清零时持有写锁
+	key      *hdkeystore.HDKey
+	appID    string
+	walletID string
+	unlockAt time.Time
+	expireAt time.Time
+	timer    *time.Timer
+}
+
+//zero 等待正在使用密钥的签名完成后清零
+func (w *unlockedWallet) zero() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.key != nil {
+		w.key.Zero()
+		w.key = nil
+	}
+}
+
+//UnlockedWalletInfo 已解锁钱包的信息
+type UnlockedWalletInfo struct {
+	AppID    string    `json:"appID"`
+	WalletID string    `json:"walletID"`
+	UnlockAt time.Time `json:"unlockAt"` //解锁时间
+	ExpireAt time.Time `json:"expireAt"` //到期自动锁定时间
+}
+
+//UnlockMetrics 钱包解锁的统计
+type UnlockMetrics struct {
+	Unlocked int    `json:"unlocked"` //当前已解锁的钱包数
+	Unlocks  uint64 `json:"unlocks"`  //累计解锁次数
+	Expired  uint64 `json:"expired"`  //累计到期自动锁定次数
+	Locked   uint64 `json:"locked"`   //累计主动锁定次数
+}
+
+func unlockedWalletKey(appID, walletID string) string {
+	return appID + "_" + walletID
+}
+
+//UnlockWallet 解锁钱包，解密的密钥在duration内保存在内存中，SignTransaction不需要密码即可签名
+//重复解锁会替换原来的密钥，并重新计算到期时间
+func (wm *WalletManager) UnlockWallet(appID, walletID, password string, duration time.Duration) error {
+
+	if duration <= 0 {
+		return fmt.Errorf("unlock duration must be greater than 0")
+	}
+
+	wrapper, err := wm.NewWalletWrapper(appID, walletID)
+	if err != nil {
+		return err
+	}
+
+	key, err := wrapper.HDKey(password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	entry := &unlockedWallet{
+		key:      key,
+		appID:    appID,
+		walletID: walletID,
+		unlockAt: now,
+		expireAt: now.Add(duration),
+	}
+
+	id := unlockedWalletKey(appID, walletID)
+
+	wm.unlockMu.Lock()
+	old := wm.unlocked[id]
+	wm.unlocked[id] = entry
+	wm.unlockMetrics.Unlocks++
+	entry.timer = time.AfterFunc(duration, func() {
+		wm.removeUnlockedWallet(id, entry, true)
+	})
+	wm.unlockMu.Unlock()
+
+	if old != nil {
+		old.timer.Stop()
+		old.zero()
+	}
+
+	log.Debugf("wallet: %s has been unlocked until %s", walletID, entry.expireAt.Format(time.RFC3339))
+
+	return nil
+}
+
+//LockWallet 锁定钱包，清零内存中的密钥，钱包未解锁时不做处理
+func (wm *WalletManager) LockWallet(appID, walletID string) error {
+
+	id := unlockedWalletKey(appID, walletID)
+
+	wm.unlockMu.Lock()
+	entry := wm.unlocked[id]
+	wm.unlockMu.Unlock()
+
+	if entry == nil {
+		return nil
+	}
+
+	entry.timer.Stop()
+	wm.removeUnlockedWallet(id, entry, false)
+
+	return nil
+}
+
+//LockAllWallets 锁定全部已解锁的钱包
+func (wm *WalletManager) LockAllWallets() {
+
+	wm.unlockMu.Lock()
+	entries := make(map[string]*unlockedWallet, len(wm.unlocked))
+	for id, entry := range wm.unlocked {
+		entries[id] = entry
+	}
+	wm.unlockMu.Unlock()
+
+	for id, entry := range entries {
+		entry.timer.Stop()
+		wm.removeUnlockedWallet(id, entry, false)
+	}
+}
+
+//removeUnlockedWallet 移除并清零已解锁的钱包，entry已被替换时只清零
+func (wm *WalletManager) removeUnlockedWallet(id string, entry *unlockedWallet, expired bool) {
+
+	wm.unlockMu.Lock()
+	if wm.unlocked[id] == entry {
+		delete(wm.unlocked, id)
+		if expired {
+			wm.unlockMetrics.Expired++
+		} else {
+			wm.unlockMetrics.Locked++
+		}
+	}
+	wm.unlockMu.Unlock()
+
+	entry.zero()
+
+	log.Debugf("wallet: %s has been locked", entry.walletID)
+}
+
+//withUnlockedKey 使用已解锁钱包的密钥，使用期间密钥不会被清零
+func (wm *WalletManager) withUnlockedKey(appID, walletID string, fn func(key *hdkeystore.HDKey) error) error {
+
+	wm.unlockMu.Lock()
+	entry := wm.unlocked[unlockedWalletKey(appID, walletID)]
+	wm.unlockMu.Unlock()
+
+	if entry == nil {
+		return fmt.Errorf("the wallet is locked. ")
+	}
+
+	entry.mu.RLock()
+	defer entry.mu.RUnlock()
+
+	//获取后已被清零
+	if entry.key == nil {
+		return fmt.Errorf("the wallet is locked. ")
+	}
+
+	return fn(entry.key)
+}
+
+//withWalletKey 使用钱包密钥签名，密码为空时使用已解锁钱包的密钥，否则临时解密密钥，fn执行后清零
+func (wm *WalletManager) withWalletKey(appID, walletID, password string, wrapper *WalletWrapper, fn func() error) error {
+
+	if len(password) == 0 {
+		return wm.withUnlockedKey(appID, walletID, func(key *hdkeystore.HDKey) error {
+			return wrapper.withKey(key, fn)
+		})
+	}
+
+	key, err := wrapper.HDKey(password)
+	if err != nil {
+		return err
+	}
+	defer key.Zero()
+
+	return wrapper.withKey(key, fn)
+}
+
+//GetUnlockedWallets 当前已解锁的钱包
+func (wm *WalletManager) GetUnlockedWallets() []*UnlockedWalletInfo {
+
+	wm.unlockMu.Lock()
+	defer wm.unlockMu.Unlock()
+
+	list := make([]*UnlockedWalletInfo, 0, len(wm.unlocked))
+	for _, entry := range wm.unlocked {
+		list = append(list, &UnlockedWalletInfo{
+			AppID:    entry.appID,
+			WalletID: entry.walletID,
+			UnlockAt: entry.unlockAt,
+			ExpireAt: entry.expireAt,
+		})
+	}
+	return list
+}
+
+//GetUnlockMetrics 钱包解锁的统计
+func (wm *WalletManager) GetUnlockMetrics() UnlockMetrics {
+
+	wm.unlockMu.Lock()
+	defer wm.unlockMu.Unlock()
+
+	metrics := wm.unlockMetrics
+	metrics.Unlocked = len(wm.unlocked)
+	return metrics
+}
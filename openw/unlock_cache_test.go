@@ -0,0 +1,131 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestWalletManager_UnlockWallet(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "unlock_app"
+	defer tm.CloseDB(appID)
+
+	w, _, err := tm.CreateWallet(appID, &openwallet.Wallet{Alias: "hot", IsTrust: true, Password: "12345678"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+
+	if err = tm.UnlockWallet(appID, w.WalletID, "wrong password", time.Minute); err == nil {
+		t.Fatalf("UnlockWallet should fail with wrong password")
+	}
+
+	//到期自动锁定，密钥被清零
+	if err = tm.UnlockWallet(appID, w.WalletID, "12345678", 100*time.Millisecond); err != nil {
+		t.Fatalf("UnlockWallet failed, unexpected error: %v", err)
+	}
+	var cached *hdkeystore.HDKey
+	err = tm.withUnlockedKey(appID, w.WalletID, func(key *hdkeystore.HDKey) error {
+		cached = key
+		_, err := key.DerivedKeyWithPath(hdkeystore.OpenwCoinTypePath, owcrypt.ECC_CURVE_SECP256K1)
+		return err
+	})
+	if err != nil || cached.KeyID != w.WalletID {
+		t.Fatalf("withUnlockedKey failed, unexpected error: %v", err)
+	}
+	if list := tm.GetUnlockedWallets(); len(list) != 1 || list[0].WalletID != w.WalletID {
+		t.Errorf("GetUnlockedWallets = %v", list)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if len(cached.Seed()) != 0 {
+		t.Errorf("expired wallet key is not zeroed")
+	}
+	if err = tm.withUnlockedKey(appID, w.WalletID, func(key *hdkeystore.HDKey) error { return nil }); err == nil {
+		t.Errorf("withUnlockedKey should fail after expired")
+	}
+	if _, err = tm.SignTransaction(appID, w.WalletID, "", "", &openwallet.RawTransaction{}); err == nil {
+		t.Errorf("SignTransaction should fail without password after expired")
+	}
+
+	//主动锁定
+	if err = tm.UnlockWallet(appID, w.WalletID, "12345678", time.Hour); err != nil {
+		t.Fatalf("UnlockWallet failed, unexpected error: %v", err)
+	}
+	tm.withUnlockedKey(appID, w.WalletID, func(key *hdkeystore.HDKey) error {
+		cached = key
+		return nil
+	})
+	if err = tm.LockWallet(appID, w.WalletID); err != nil {
+		t.Fatalf("LockWallet failed, unexpected error: %v", err)
+	}
+	if len(cached.Seed()) != 0 {
+		t.Errorf("locked wallet key is not zeroed")
+	}
+
+	metrics := tm.GetUnlockMetrics()
+	if metrics.Unlocked != 0 || metrics.Unlocks != 2 || metrics.Expired != 1 || metrics.Locked != 1 {
+		t.Errorf("GetUnlockMetrics = %+v", metrics)
+	}
+}
+
+func TestWalletWrapper_UnlockWallet(t *testing.T) {
+	tm, clean := testInitTempWalletManager(t)
+	defer clean()
+	appID := "unlock_app"
+	defer tm.CloseDB(appID)
+
+	w, _, err := tm.CreateWallet(appID, &openwallet.Wallet{Alias: "hot", IsTrust: true, Password: "12345678"})
+	if err != nil {
+		t.Fatalf("CreateWallet failed, unexpected error: %v", err)
+	}
+	wrapper, err := tm.NewWalletWrapper(appID, w.WalletID)
+	if err != nil {
+		t.Fatalf("NewWalletWrapper failed, unexpected error: %v", err)
+	}
+
+	//到期清零密钥
+	if err = wrapper.UnlockWallet("12345678", 100*time.Millisecond); err != nil {
+		t.Fatalf("UnlockWallet failed, unexpected error: %v", err)
+	}
+	key, err := wrapper.HDKey()
+	if err != nil {
+		t.Fatalf("HDKey failed, unexpected error: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if len(key.Seed()) != 0 {
+		t.Errorf("expired wrapper key is not zeroed")
+	}
+	if _, err = wrapper.HDKey(); err == nil {
+		t.Errorf("HDKey should fail after expired")
+	}
+
+	//主动锁定
+	if err = wrapper.UnlockWallet("12345678", time.Hour); err != nil {
+		t.Fatalf("UnlockWallet failed, unexpected error: %v", err)
+	}
+	key, _ = wrapper.HDKey()
+	wrapper.LockWallet()
+	if len(key.Seed()) != 0 {
+		t.Errorf("locked wrapper key is not zeroed")
+	}
+}
@@ -24,12 +24,46 @@ import (
 
 // CreateWallet 创建钱包
 func (wm *WalletManager) CreateWallet(appID string, wallet *openwallet.Wallet) (*openwallet.Wallet, *hdkeystore.HDKey, error) {
+	return wm.createWallet(appID, wallet, func() (*hdkeystore.HDKey, string, error) {
+		return hdkeystore.StoreHDKey(wm.cfg.KeyDir, wallet.Alias, wallet.Password, hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	})
+}
+
+// CreateWalletWithMnemonic 创建托管密钥的钱包，并返回BIP39助记词，用于纸质备份
+// @param language 助记词语言，为空使用英文
+// @param passphrase 可选的助记词密码短语，恢复钱包时需要相同的密码短语
+func (wm *WalletManager) CreateWalletWithMnemonic(appID string, wallet *openwallet.Wallet, language, passphrase string) (*openwallet.Wallet, *hdkeystore.HDKey, string, error) {
+
+	mnemonic, err := hdkeystore.NewMnemonic(hdkeystore.DefaultMnemonicBitSize, language)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	wallet.IsTrust = true
+	w, key, err := wm.RestoreWalletWithMnemonic(appID, wallet, mnemonic, language, passphrase)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return w, key, mnemonic, nil
+}
+
+// RestoreWalletWithMnemonic 通过BIP39助记词恢复托管密钥的钱包，相同的助记词和密码短语恢复相同的钱包ID
+func (wm *WalletManager) RestoreWalletWithMnemonic(appID string, wallet *openwallet.Wallet, mnemonic, language, passphrase string) (*openwallet.Wallet, *hdkeystore.HDKey, error) {
+	wallet.IsTrust = true
+	return wm.createWallet(appID, wallet, func() (*hdkeystore.HDKey, string, error) {
+		return hdkeystore.StoreHDKeyWithMnemonic(wm.cfg.KeyDir, wallet.Alias, wallet.Password, mnemonic, passphrase, language, hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	})
+}
+
+// createWallet 创建钱包，托管密钥的钱包通过storeKey生成keystore
+func (wm *WalletManager) createWallet(appID string, wallet *openwallet.Wallet, storeKey func() (*hdkeystore.HDKey, string, error)) (*openwallet.Wallet, *hdkeystore.HDKey, error) {
 
 	var (
 		key *hdkeystore.HDKey
 	)
 	//打开数据库
-	db, err := wm.OpenDB(appID)
+	db, err := wm.OpenDataStore(appID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -43,7 +77,7 @@ func (wm *WalletManager) CreateWallet(appID string, wallet *openwallet.Wallet) (
 		}
 
 		//生成keystore
-		_key, filePath, err := hdkeystore.StoreHDKey(wm.cfg.KeyDir, wallet.Alias, wallet.Password, hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+		_key, filePath, err := storeKey()
 		if err != nil {
 			return nil, nil, err
 		}
@@ -58,8 +92,10 @@ func (wm *WalletManager) CreateWallet(appID string, wallet *openwallet.Wallet) (
 		return nil, nil, fmt.Errorf("walletID is empty")
 	}
 
-	//数据路径
-	wallet.DBFile = db.FileName
+	//数据路径，SQL数据存储没有数据库文件
+	if wm.cfg.DataStoreType != SQLDataStoreType {
+		wallet.DBFile = wm.DBFile(appID)
+	}
 
 	//保存钱包到本地应用数据库
 	err = db.Save(wallet)
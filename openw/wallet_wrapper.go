@@ -23,9 +23,9 @@ import (
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"io/ioutil"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/asdine/storm/q"
 	"github.com/blocktree/go-owcdrivers/owkeychain"
 	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/hdkeystore"
@@ -40,9 +40,14 @@ type WalletKeyFile string
 // WalletWrapper 钱包包装器，扩展钱包功能
 type WalletWrapper struct {
 	*AppWrapper
-	wallet  *openwallet.Wallet //需要包装的钱包
-	keyFile string             //钱包密钥文件路径
-	key     *hdkeystore.HDKey
+	wallet   *openwallet.Wallet        //需要包装的钱包
+	keyFile  string                    //钱包密钥文件路径
+	keyMu    sync.Mutex                //保护解锁的密钥和临时的签名密钥、签名提供者
+	key      *hdkeystore.HDKey         //UnlockWallet解锁的密钥
+	keyTimer *time.Timer               //解锁到期清零密钥的定时器
+	signKey  *hdkeystore.HDKey         //withKey临时使用的密钥，优先于key
+	signer   openwallet.SignerProvider //withSigner临时使用的签名提供者
+	signAuth string                    //签名提供者的解锁凭证
 }
 
 func NewWalletWrapper(args ...interface{}) *WalletWrapper {
@@ -77,7 +82,7 @@ func (wrapper *WalletWrapper) GetWallet() *openwallet.Wallet {
 func (wrapper *WalletWrapper) GetWalletByID(walletID string) (*openwallet.Wallet, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +101,7 @@ func (wrapper *WalletWrapper) GetWalletByID(walletID string) (*openwallet.Wallet
 func (wrapper *WalletWrapper) GetAssetsAccountInfo(accountID string) (*openwallet.AssetsAccount, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +120,7 @@ func (wrapper *WalletWrapper) GetAssetsAccountInfo(accountID string) (*openwalle
 func (wrapper *WalletWrapper) GetAssetsAccountList(offset, limit int, cols ...interface{}) ([]*openwallet.AssetsAccount, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -123,35 +128,16 @@ func (wrapper *WalletWrapper) GetAssetsAccountList(offset, limit int, cols ...in
 
 	var accounts []*openwallet.AssetsAccount
 
-	query := make([]q.Matcher, 0)
-
-	if wrapper.wallet != nil {
-		query = append(query, q.Eq("WalletID", wrapper.wallet.WalletID))
-	}
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
+	query, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
 	}
 
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+	if wrapper.wallet != nil {
+		query = append([]*DataCondition{DataEq("WalletID", wrapper.wallet.WalletID)}, query...)
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&accounts)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&accounts)
-
-	}
+	err = db.Find(&accounts, offset, limit, query...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find accounts")
@@ -162,7 +148,7 @@ func (wrapper *WalletWrapper) GetAssetsAccountList(offset, limit int, cols ...in
 
 //GetAssetsAccountByAddress 通过地址获取资产账户对象
 func (wrapper *WalletWrapper) GetAssetsAccountByAddress(address string) (*openwallet.AssetsAccount, error) {
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -186,7 +172,7 @@ func (wrapper *WalletWrapper) GetAssetsAccountByAddress(address string) (*openwa
 
 //GetAddress 通过地址字符串获取地址对象
 func (wrapper *WalletWrapper) GetAddress(address string) (*openwallet.Address, error) {
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +191,7 @@ func (wrapper *WalletWrapper) GetAddress(address string) (*openwallet.Address, e
 // GetAddresses 获取资产账户地址列表
 func (wrapper *WalletWrapper) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -213,31 +199,12 @@ func (wrapper *WalletWrapper) GetAddressList(offset, limit int, cols ...interfac
 
 	var addrs []*openwallet.Address
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+	query, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&addrs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&addrs)
-
-	}
+	err = db.Find(&addrs, offset, limit, query...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find addresses")
@@ -249,7 +216,7 @@ func (wrapper *WalletWrapper) GetAddressList(offset, limit int, cols ...interfac
 // GetImportAddressList 获取待导入
 func (wrapper *WalletWrapper) GetImportAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.ImportAddress, error) {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
@@ -257,31 +224,12 @@ func (wrapper *WalletWrapper) GetImportAddressList(offset, limit int, cols ...in
 
 	var addrs []*openwallet.ImportAddress
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+	query, err := NewDataConditions(cols...)
+	if err != nil {
+		return nil, err
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&addrs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&addrs)
-
-	}
+	err = db.Find(&addrs, offset, limit, query...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find addresses")
@@ -315,105 +263,102 @@ func (wrapper *WalletWrapper) CreateAddress(accountID string, count uint64, deco
 	}
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
 	defer wrapper.CloseDB()
 
-	tx, err := db.Begin(true)
-	if err != nil {
-		return nil, err
-	}
+	changeIndex := uint32(common.BoolToUInt(isChange))
 
-	defer tx.Rollback()
+	err = db.Update(func(tx DataStore) error {
 
-	changeIndex := uint32(common.BoolToUInt(isChange))
+		for i := uint64(0); i < count; i++ {
 
-	for i := uint64(0); i < count; i++ {
+			address = ""
 
-		address = ""
+			publicKey = ""
 
-		publicKey = ""
+			newKeys = [][]byte{}
 
-		newKeys = [][]byte{}
+			newIndex := account.AddressIndex + 1
 
-		newIndex := account.AddressIndex + 1
+			derivedPath := fmt.Sprintf("%s/%d/%d", account.HDPath, changeIndex, newIndex)
+			//log.Debug("account.OwnerKeys:", len(account.OwnerKeys))
+			//通过多个拥有者公钥生成地址
+			for _, pub := range account.OwnerKeys {
 
-		derivedPath := fmt.Sprintf("%s/%d/%d", account.HDPath, changeIndex, newIndex)
-		//log.Debug("account.OwnerKeys:", len(account.OwnerKeys))
-		//通过多个拥有者公钥生成地址
-		for _, pub := range account.OwnerKeys {
+				if len(pub) == 0 {
+					continue
+				}
 
-			if len(pub) == 0 {
-				continue
+				pubkey, err := owkeychain.OWDecode(pub)
+				if err != nil {
+					return err
+				}
+
+				start, err := pubkey.GenPublicChild(changeIndex)
+				newKey, err := start.GenPublicChild(uint32(newIndex))
+				newKeys = append(newKeys, newKey.GetPublicKeyBytes())
+
+			}
+			//log.Debug("newKeys:", newKeys)
+			if len(newKeys) > 1 {
+				address, err = decoder.RedeemScriptToAddress(newKeys, account.Required, isTestNet)
+				if err != nil {
+					return err
+				}
+				publicKey = ""
+			} else {
+				address, err = decoder.PublicKeyToAddress(newKeys[0], isTestNet)
+				if err != nil {
+					return err
+				}
+				publicKey = hex.EncodeToString(newKeys[0])
 			}
 
-			pubkey, err := owkeychain.OWDecode(pub)
-			if err != nil {
-				return nil, err
+			addr := &openwallet.Address{
+				Address:     address,
+				AccountID:   accountID,
+				HDPath:      derivedPath,
+				CreatedTime: time.Now().Unix(),
+				Symbol:      strings.ToLower(account.Symbol),
+				Index:       uint64(newIndex),
+				WatchOnly:   false,
+				IsChange:    isChange,
+				PublicKey:   publicKey,
 			}
 
-			start, err := pubkey.GenPublicChild(changeIndex)
-			newKey, err := start.GenPublicChild(uint32(newIndex))
-			newKeys = append(newKeys, newKey.GetPublicKeyBytes())
+			account.AddressIndex = newIndex
 
-		}
-		//log.Debug("newKeys:", newKeys)
-		if len(newKeys) > 1 {
-			address, err = decoder.RedeemScriptToAddress(newKeys, account.Required, isTestNet)
+			err = tx.Save(account)
 			if err != nil {
-				return nil, err
+
+				return err
 			}
-			publicKey = ""
-		} else {
-			address, err = decoder.PublicKeyToAddress(newKeys[0], isTestNet)
+
+			err = tx.Save(addr)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			publicKey = hex.EncodeToString(newKeys[0])
-		}
-
-		addr := &openwallet.Address{
-			Address:     address,
-			AccountID:   accountID,
-			HDPath:      derivedPath,
-			CreatedTime: time.Now().Unix(),
-			Symbol:      strings.ToLower(account.Symbol),
-			Index:       uint64(newIndex),
-			WatchOnly:   false,
-			IsChange:    isChange,
-			PublicKey:   publicKey,
-		}
 
-		account.AddressIndex = newIndex
+			////记录要导入到核心钱包的地址
+			//imported := ImportAddress{
+			//	Address: *addr,
+			//}
+			//
+			//err = tx.Save(&imported)
+			//if err != nil {
+			//	return err
+			//}
 
-		err = tx.Save(account)
-		if err != nil {
+			addrs = append(addrs, addr)
 
-			return nil, err
-		}
-
-		err = tx.Save(addr)
-		if err != nil {
-			return nil, err
 		}
 
-		////记录要导入到核心钱包的地址
-		//imported := ImportAddress{
-		//	Address: *addr,
-		//}
-		//
-		//err = tx.Save(&imported)
-		//if err != nil {
-		//	return nil, err
-		//}
+		return nil
+	})
 
-		addrs = append(addrs, addr)
-
-	}
-
-	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
@@ -425,44 +370,40 @@ func (wrapper *WalletWrapper) CreateAddress(accountID string, count uint64, deco
 func (wrapper *WalletWrapper) ImportWatchOnlyAddress(address ...*openwallet.Address) error {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
 	defer wrapper.CloseDB()
 
-	tx, err := db.Begin(true)
-	if err != nil {
-		return err
-	}
+	return db.Update(func(tx DataStore) error {
 
-	defer tx.Rollback()
+		for _, a := range address {
 
-	for _, a := range address {
-
-		var searchAddress openwallet.Address
-		err = tx.One("Address", a.Address, &searchAddress)
-		if &searchAddress != nil {
-			log.Info(a.Address, "is existed, skip import wallet.")
-			continue
-		}
+			var searchAddress openwallet.Address
+			err = tx.One("Address", a.Address, &searchAddress)
+			if &searchAddress != nil {
+				log.Info(a.Address, "is existed, skip import wallet.")
+				continue
+			}
 
-		searchAddress.WatchOnly = false
+			searchAddress.WatchOnly = false
 
-		err = tx.Save(&searchAddress)
-		if err != nil {
-			continue
+			err = tx.Save(&searchAddress)
+			if err != nil {
+				continue
+			}
 		}
-	}
 
-	return tx.Commit()
+		return nil
+	})
 
 }
 
 //SaveAssetsAccount 更新账户信息
 func (wrapper *WalletWrapper) SaveAssetsAccount(account *openwallet.AssetsAccount) error {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
@@ -471,15 +412,102 @@ func (wrapper *WalletWrapper) SaveAssetsAccount(account *openwallet.AssetsAccoun
 	return db.Save(account)
 }
 
-func (wrapper *WalletWrapper) UnlockWallet(password string, time time.Duration) error {
+//UnlockWallet 解锁钱包，解密的密钥保存在包装器中，duration后清零，重复解锁会清零原来的密钥
+func (wrapper *WalletWrapper) UnlockWallet(password string, duration time.Duration) error {
+
+	if duration <= 0 {
+		return fmt.Errorf("unlock duration must be greater than 0")
+	}
+
 	key, err := wrapper.HDKey(password)
 	if err != nil {
 		return err
 	}
+
+	wrapper.keyMu.Lock()
+	defer wrapper.keyMu.Unlock()
+
+	wrapper.lockKey()
 	wrapper.key = key
+	wrapper.keyTimer = time.AfterFunc(duration, func() {
+		wrapper.keyMu.Lock()
+		defer wrapper.keyMu.Unlock()
+		//只清零本次解锁的密钥
+		if wrapper.key == key {
+			wrapper.lockKey()
+		}
+	})
 	return nil
 }
 
+//LockWallet 锁定钱包，清零UnlockWallet解锁的密钥
+func (wrapper *WalletWrapper) LockWallet() {
+	wrapper.keyMu.Lock()
+	defer wrapper.keyMu.Unlock()
+	wrapper.lockKey()
+}
+
+//lockKey 停止定时器并清零解锁的密钥，调用者需持有keyMu
+func (wrapper *WalletWrapper) lockKey() {
+	if wrapper.keyTimer == nil {
+		return
+	}
+	wrapper.keyTimer.Stop()
+	wrapper.keyTimer = nil
+	if wrapper.key != nil {
+		wrapper.key.Zero()
+		wrapper.key = nil
+	}
+}
+
+//withKey 在fn执行期间使用key作为钱包密钥，HDKey()不需要密码，key由调用者负责清零
+func (wrapper *WalletWrapper) withKey(key *hdkeystore.HDKey, fn func() error) error {
+	wrapper.keyMu.Lock()
+	wrapper.signKey = key
+	wrapper.keyMu.Unlock()
+
+	defer func() {
+		wrapper.keyMu.Lock()
+		wrapper.signKey = nil
+		wrapper.keyMu.Unlock()
+	}()
+
+	return fn()
+}
+
+//withSigner 在fn执行期间使用签名提供者签名，SignHash由provider签名，HDKey()不可用
+func (wrapper *WalletWrapper) withSigner(provider openwallet.SignerProvider, auth string, fn func() error) error {
+	wrapper.keyMu.Lock()
+	wrapper.signer, wrapper.signAuth = provider, auth
+	wrapper.keyMu.Unlock()
+
+	defer func() {
+		wrapper.keyMu.Lock()
+		wrapper.signer, wrapper.signAuth = nil, ""
+		wrapper.keyMu.Unlock()
+	}()
+
+	return fn()
+}
+
+//SignHash 使用钱包hdPath路径的私钥签名消息哈希，钱包使用签名提供者时由提供者签名，否则使用HDKey()的密钥
+func (wrapper *WalletWrapper) SignHash(hdPath string, eccType uint32, hash []byte) ([]byte, byte, error) {
+
+	wrapper.keyMu.Lock()
+	signer, auth := wrapper.signer, wrapper.signAuth
+	wrapper.keyMu.Unlock()
+
+	if signer != nil {
+		return signer.SignHash(wrapper.wallet, hdPath, eccType, hash, auth)
+	}
+
+	key, err := wrapper.HDKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	return signHashWithKey(key, hdPath, eccType, hash)
+}
+
 //HDKey 获取钱包密钥，需要密码
 func (wrapper *WalletWrapper) HDKey(password ...string) (*hdkeystore.HDKey, error) {
 
@@ -488,7 +516,13 @@ func (wrapper *WalletWrapper) HDKey(password ...string) (*hdkeystore.HDKey, erro
 	if len(password) > 0 {
 		pw = password[0]
 	} else {
-		if wrapper.key != nil {
+		wrapper.keyMu.Lock()
+		defer wrapper.keyMu.Unlock()
+		if wrapper.signer != nil {
+			return nil, fmt.Errorf("the wallet uses signer provider, HDKey is not available. ")
+		} else if wrapper.signKey != nil {
+			return wrapper.signKey, nil
+		} else if wrapper.key != nil {
 			return wrapper.key, nil
 		} else {
 			return nil, fmt.Errorf("the wallet is locked. ")
@@ -517,7 +551,7 @@ func (wrapper *WalletWrapper) HDKey(password ...string) (*hdkeystore.HDKey, erro
 //设置地址的扩展字段
 func (wrapper *WalletWrapper) SetAddressExtParam(address string, key string, val interface{}) error {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return err
 	}
@@ -553,7 +587,7 @@ func (wrapper *WalletWrapper) SetAddressExtParam(address string, key string, val
 //获取地址的扩展字段
 func (wrapper *WalletWrapper) GetAddressExtParam(address string, key string) (interface{}, error) {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDataStore()
 	if err != nil {
 		return nil, err
 	}
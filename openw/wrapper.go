@@ -16,11 +16,11 @@
 package openw
 
 import (
-	"github.com/blocktree/openwallet/v2/openwallet"
 	"sync"
 	"time"
 
 	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/v2/openwallet"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -29,7 +29,7 @@ type WrapperSourceFile string
 // Wrapper 基于OpenWallet钱包体系模型，专门处理钱包的持久化问题，关系数据查询
 type Wrapper struct {
 	openwallet.WalletDAIBase
-	sourceDB     *StormDB     //存储钱包相关数据的数据库，目前使用boltdb作为持久方案
+	sourceDB     DataStore    //存储钱包相关数据的数据存储，默认使用boltdb作为持久方案
 	mu           sync.RWMutex //锁
 	isExternalDB bool         //是否外部加载的数据库，非内部打开，内部打开需要关闭
 	sourceFile   string       //钱包数据库文件路径，用于内部打开
@@ -43,10 +43,11 @@ func NewWrapper(args ...interface{}) *Wrapper {
 		switch obj := arg.(type) {
 		case *StormDB:
 			if obj != nil {
-				//if !obj.Opened {
-				//	return nil, fmt.Errorf("wallet db is close")
-				//}
-
+				wrapper.isExternalDB = true
+				wrapper.sourceDB = NewStormDataStore(obj)
+			}
+		case DataStore:
+			if obj != nil {
 				wrapper.isExternalDB = true
 				wrapper.sourceDB = obj
 			}
@@ -58,16 +59,15 @@ func NewWrapper(args ...interface{}) *Wrapper {
 	return &wrapper
 }
 
-//OpenStormDB 打开数据库
-func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
+//OpenDataStore 打开数据存储，没有外部数据存储时，打开sourceFile的storm数据库
+func (wrapper *Wrapper) OpenDataStore() (DataStore, error) {
 
-	var (
-		db  *StormDB
-		err error
-	)
+	wrapper.mu.RLock()
+	db := wrapper.sourceDB
+	wrapper.mu.RUnlock()
 
-	if wrapper.sourceDB != nil && wrapper.sourceDB.Opened {
-		return wrapper.sourceDB, nil
+	if db != nil {
+		return db, nil
 	}
 
 	//保证数据库文件并发下不被同时打开
@@ -75,12 +75,12 @@ func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
 	defer wrapper.mu.Unlock()
 
 	//解锁进入后，再次确认是否已经存在
-	if wrapper.sourceDB != nil && wrapper.sourceDB.Opened {
+	if wrapper.sourceDB != nil {
 		return wrapper.sourceDB, nil
 	}
 
 	//log.Debugf("sourceFile :%v", wrapper.sourceFile)
-	db, err = OpenStormDB(
+	stormDB, err := OpenStormDB(
 		wrapper.sourceFile,
 		storm.BoltOptions(0600, &bolt.Options{Timeout: 3 * time.Second}),
 	)
@@ -90,13 +90,31 @@ func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
 	}
 
 	wrapper.isExternalDB = false
-	wrapper.sourceDB = db
+	wrapper.sourceDB = NewStormDataStore(stormDB)
+
+	return wrapper.sourceDB, nil
+}
+
+//OpenStormDB 打开数据库
+//Deprecated: 只支持storm数据存储，使用OpenDataStore
+func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
+
+	db, err := wrapper.OpenDataStore()
+	if err != nil {
+		return nil, err
+	}
 
-	return db, nil
+	return stormDBOf(db)
 }
 
 //SetStormDB 设置钱包的应用数据库
+//Deprecated: 使用SetExternalDataStore
 func (wrapper *Wrapper) SetExternalDB(db *StormDB) error {
+	return wrapper.SetExternalDataStore(NewStormDataStore(db))
+}
+
+//SetExternalDataStore 设置钱包的应用数据存储
+func (wrapper *Wrapper) SetExternalDataStore(db DataStore) error {
 
 	//关闭之前的数据库
 	wrapper.CloseDB()
@@ -113,10 +131,13 @@ func (wrapper *Wrapper) SetExternalDB(db *StormDB) error {
 
 //CloseDB 关闭数据库
 func (wrapper *Wrapper) CloseDB() {
+	wrapper.mu.Lock()
+	defer wrapper.mu.Unlock()
 	// 如果是外部引入的数据库不进行关闭，因为这样会外部无法再操作同一个数据库实力
 	if wrapper.isExternalDB == false {
-		if wrapper.sourceDB != nil && wrapper.sourceDB.Opened {
+		if wrapper.sourceDB != nil {
 			wrapper.sourceDB.Close()
+			wrapper.sourceDB = nil
 		}
 	}
 }
@@ -16,7 +16,14 @@
 package openwallet
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/blocktree/go-owcdrivers/owkeychain"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
@@ -52,16 +59,105 @@ type AssetsAccount struct {
 	Symbol          string `json:"symbol"`          //资产币种类别
 	AddressIndex    int    `json:"addressIndex"`
 	Balance         string `json:"balance"`
-	IsTrust         bool   `json:"isTrust"`   //是否托管密钥
-	ExtParam        string `json:"extParam"`  //扩展参数，用于调用智能合约，json结构
-	ModelType       uint64 `json:"modelType"` //模型类别, 1: utxo模型(BTC), 2: account模型（ETH），3: 账户别名模型(EOS)
+	IsTrust         bool   `json:"isTrust"`    //是否托管密钥
+	ExtParam        string `json:"extParam"`   //扩展参数，用于调用智能合约，json结构
+	ModelType       uint64 `json:"modelType"`  //模型类别, 1: utxo模型(BTC), 2: account模型（ETH），3: 账户别名模型(EOS)
+	IsMultiSig      bool   `json:"isMultiSig"` //是否通过NewMultiSigAccount创建的多重签名账户，地址由赎回脚本生成
 
 	core interface{} //核心账户指针
 }
 
+//NewMultiSigAccount 创建M-of-N多重签名账户
+//wallets的RootPub为各拥有者提供的扩展公钥（OW编码），creator为创建者钱包，其RootPub应是RootPath路径下的扩展公钥。
+//拥有者公钥按公钥字节排序，所以不同拥有者以相同参数创建的账户，AccountID和地址都一致。
 func NewMultiSigAccount(wallets []*Wallet, required uint, creator *Wallet) (*AssetsAccount, error) {
 
-	return nil, nil
+	if creator == nil {
+		return nil, fmt.Errorf("creator wallet is nil")
+	}
+
+	if len(creator.RootPub) == 0 {
+		return nil, fmt.Errorf("creator wallet[%s] public key is empty", creator.WalletID)
+	}
+
+	ownerKeys := []string{creator.RootPub}
+	for _, w := range wallets {
+		if w == nil {
+			continue
+		}
+		if len(w.RootPub) == 0 {
+			return nil, fmt.Errorf("wallet[%s] public key is empty", w.WalletID)
+		}
+		//创建者已在拥有者列表中
+		if w.RootPub == creator.RootPub {
+			continue
+		}
+		ownerKeys = append(ownerKeys, w.RootPub)
+	}
+
+	if len(ownerKeys) < 2 {
+		return nil, fmt.Errorf("multi-signature account needs at least 2 owners")
+	}
+
+	if required == 0 || int(required) > len(ownerKeys) {
+		return nil, fmt.Errorf("required signatures %d is out of range [1, %d]", required, len(ownerKeys))
+	}
+
+	ownerKeys, err := SortOwnerKeys(ownerKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	accountID, err := GenMultiSigAccountID(ownerKeys, uint64(required))
+	if err != nil {
+		return nil, err
+	}
+
+	account := &AssetsAccount{
+		WalletID:     creator.WalletID,
+		AccountID:    accountID,
+		HDPath:       creator.RootPath,
+		PublicKey:    creator.RootPub,
+		OwnerKeys:    ownerKeys,
+		Required:     uint64(required),
+		AddressIndex: -1,
+		IsTrust:      false,
+		IsMultiSig:   true,
+	}
+
+	return account, nil
+}
+
+//SortOwnerKeys 拥有者公钥按公钥字节升序排列，并检查重复
+func SortOwnerKeys(ownerKeys []string) ([]string, error) {
+
+	type ownerKey struct {
+		encoded string
+		pub     []byte
+	}
+
+	keys := make([]ownerKey, 0, len(ownerKeys))
+	for _, k := range ownerKeys {
+		pub, err := owkeychain.OWDecode(k)
+		if err != nil {
+			return nil, fmt.Errorf("owner key %s is invalid, unexpected error: %v", k, err)
+		}
+		keys = append(keys, ownerKey{encoded: k, pub: pub.GetPublicKeyBytes()})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i].pub, keys[j].pub) < 0
+	})
+
+	sorted := make([]string, 0, len(keys))
+	for i, k := range keys {
+		if i > 0 && bytes.Equal(keys[i-1].pub, k.pub) {
+			return nil, fmt.Errorf("owner key %s is duplicated", k.encoded)
+		}
+		sorted = append(sorted, k.encoded)
+	}
+
+	return sorted, nil
 }
 
 //NewUserAccount 创建账户
@@ -98,6 +194,34 @@ func GenAccountID(publicKey string) string {
 	return genAccountID(pub.GetPublicKeyBytes())
 }
 
+//GenMultiSigAccountID 计算多重签名账户的AccountID
+//ownerKeys为OW编码后，需要已排序，必要签名数以8字节大端序参与计算
+func GenMultiSigAccountID(ownerKeys []string, required uint64) (string, error) {
+
+	if len(ownerKeys) < 2 {
+		return "", fmt.Errorf("multi-signature account needs at least 2 owners")
+	}
+
+	if required == 0 || required > uint64(len(ownerKeys)) {
+		return "", fmt.Errorf("required signatures %d is out of range [1, %d]", required, len(ownerKeys))
+	}
+
+	data := make([]byte, 0)
+	for _, k := range ownerKeys {
+		pub, err := owkeychain.OWDecode(k)
+		if err != nil {
+			return "", fmt.Errorf("owner key %s is invalid, unexpected error: %v", k, err)
+		}
+		data = append(data, pub.GetPublicKeyBytes()...)
+	}
+
+	requiredBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(requiredBytes, required)
+	data = append(data, requiredBytes...)
+
+	return genAccountID(data), nil
+}
+
 //GenAccountIDByHex 计算publicKey的AccountID
 //publickey为HEX传
 func GenAccountIDByHex(publicKeyHex string) string {
@@ -116,3 +240,29 @@ func genAccountID(pub []byte) string {
 	accountID := owkeychain.Encode(hash, owkeychain.BitcoinAlphabet)
 	return accountID
 }
+
+//DerivePublicKey 通过账户扩展公钥衍生hdPath路径的公钥，hdPath在账户路径之后只能是普通衍生
+func (a *AssetsAccount) DerivePublicKey(hdPath string) ([]byte, error) {
+
+	if !strings.HasPrefix(hdPath, a.HDPath+"/") {
+		return nil, fmt.Errorf("path: %s is not derived from account path: %s", hdPath, a.HDPath)
+	}
+
+	childKey, err := owkeychain.OWDecode(a.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("account public key is invalid, unexpected error: %v", err)
+	}
+
+	for _, index := range strings.Split(strings.TrimPrefix(hdPath, a.HDPath+"/"), "/") {
+		i, err := strconv.ParseUint(index, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("path: %s is invalid", hdPath)
+		}
+		childKey, err = childKey.GenPublicChild(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return childKey.GetPublicKeyBytes(), nil
+}
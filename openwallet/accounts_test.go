@@ -0,0 +1,91 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+func testMultiSigWallet(t *testing.T, walletID, seedHex string) *Wallet {
+	seed, _ := hex.DecodeString(seedHex)
+	key, err := hdkeystore.NewHDKey(seed, walletID, hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed unexpected error: %v", err)
+	}
+	rootPath := key.RootPath + "/0'"
+	childKey, err := key.DerivedKeyWithPath(rootPath, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath failed unexpected error: %v", err)
+	}
+	return &Wallet{
+		WalletID: walletID,
+		RootPath: rootPath,
+		RootPub:  childKey.GetPublicKey().OWEncode(),
+	}
+}
+
+func TestNewMultiSigAccount(t *testing.T) {
+
+	w1 := testMultiSigWallet(t, "w1", "4b68b20a5d3ac671a61e6e94b4de309530a12439b7c3ee548d20966674696656")
+	w2 := testMultiSigWallet(t, "w2", "dfac3098fd7c3cd9b9cdf44e3e1ae912e3d2ce05795a857a53ebff6111b1580b")
+	w3 := testMultiSigWallet(t, "w3", "a6e7b1a2c6d1f6e0c7c9f0b1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1")
+
+	a1, err := NewMultiSigAccount([]*Wallet{w1, w2, w3}, 2, w1)
+	if err != nil {
+		t.Fatalf("NewMultiSigAccount failed unexpected error: %v", err)
+	}
+
+	//不同拥有者，不同顺序，得到相同账户
+	a2, err := NewMultiSigAccount([]*Wallet{w3, w1}, 2, w2)
+	if err != nil {
+		t.Fatalf("NewMultiSigAccount failed unexpected error: %v", err)
+	}
+
+	if a1.AccountID != a2.AccountID {
+		t.Errorf("multi-signature accountID is not deterministic: %s != %s", a1.AccountID, a2.AccountID)
+	}
+	if len(a1.OwnerKeys) != 3 || a1.Required != 2 {
+		t.Errorf("owners = %d, required = %d, want 3 and 2", len(a1.OwnerKeys), a1.Required)
+	}
+	for i := range a1.OwnerKeys {
+		if a1.OwnerKeys[i] != a2.OwnerKeys[i] {
+			t.Errorf("owner key[%d] order is not deterministic", i)
+		}
+	}
+	if a1.AccountID == GenAccountID(w1.RootPub) {
+		t.Errorf("multi-signature accountID should not equal the creator single accountID")
+	}
+
+	//必要签名数不同，账户不同
+	a3, err := NewMultiSigAccount([]*Wallet{w1, w2, w3}, 3, w1)
+	if err != nil {
+		t.Fatalf("NewMultiSigAccount failed unexpected error: %v", err)
+	}
+	if a3.AccountID == a1.AccountID {
+		t.Errorf("accounts with different required signatures have the same accountID")
+	}
+
+	if _, err = NewMultiSigAccount([]*Wallet{w1, w2}, 3, w1); err == nil {
+		t.Errorf("required greater than owners should fail")
+	}
+	if _, err = NewMultiSigAccount([]*Wallet{w1}, 1, w1); err == nil {
+		t.Errorf("single owner should fail")
+	}
+}
@@ -204,10 +204,27 @@ func CreateAddressByAccountWithIndex(account *AssetsAccount, adapter AssetsAdapt
 	var err error
 	var address, publicKey string
 
-	if decoderV2 != nil {
-		address, err = decoderV2.AddressEncode(newKeys[0])
+	if len(newKeys) == 0 {
+		result.Success = false
+		result.Err = fmt.Errorf("account owner keys is empty")
+		return result
+	}
+
+	if account.IsMultiSig {
+		//多重签名账户，通过全部拥有者公钥的赎回脚本生成地址
+		if decoderV2 != nil {
+			address, err = decoderV2.RedeemScriptToAddress(newKeys, account.Required, false)
+		} else {
+			address, err = decoderV1.RedeemScriptToAddress(newKeys, account.Required, false)
+		}
+		publicKey = ""
 	} else {
-		address, err = decoderV1.PublicKeyToAddress(newKeys[0], false)
+		if decoderV2 != nil {
+			address, err = decoderV2.AddressEncode(newKeys[0])
+		} else {
+			address, err = decoderV1.PublicKeyToAddress(newKeys[0], false)
+		}
+		publicKey = hex.EncodeToString(newKeys[0])
 	}
 	//address, err = decoder.PublicKeyToAddress(newKeys[0], false)
 	if err != nil {
@@ -215,7 +232,6 @@ func CreateAddressByAccountWithIndex(account *AssetsAccount, adapter AssetsAdapt
 		result.Err = err
 		return result
 	}
-	publicKey = hex.EncodeToString(newKeys[0])
 
 	if len(address) == 0 {
 		result.Success = false
@@ -72,6 +72,10 @@ type AssetsAdapter interface {
 	//GetNFTContractDecoder 获取NFT智能合约解析器
 	//@optional
 	GetNFTContractDecoder() NFTContractDecoder
+
+	//GetMessageSigner 获取消息签名器，没有实现时使用通用的DefaultMessageSigner
+	//@optional
+	GetMessageSigner() MessageSigner
 }
 
 type AssetsAdapterBase struct {
@@ -127,3 +131,9 @@ func (a *AssetsAdapterBase) GetJsonRPCEndpoint() JsonRPCEndpoint {
 func (a *AssetsAdapterBase) GetNFTContractDecoder() NFTContractDecoder {
 	return nil
 }
+
+//GetMessageSigner 获取消息签名器
+//@optional
+func (a *AssetsAdapterBase) GetMessageSigner() MessageSigner {
+	return nil
+}
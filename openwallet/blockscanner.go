@@ -39,6 +39,9 @@ type BlockScanTargetFunc func(target ScanTarget) (string, bool)
 // @return 对象所属源标识，是否存在
 type BlockScanTargetFuncV2 func(target ScanTargetParam) ScanTargetResult
 
+// BlockHeaderByHeightFunc 获取主链指定高度的区块头，用于分叉回溯
+type BlockHeaderByHeightFunc func(height uint64) (*BlockHeader, error)
+
 type ScanTarget struct {
 	Address          string           //地址字符串
 	PublicKey        string           //地址公钥
@@ -189,6 +192,14 @@ type BlockScanner interface {
 	//GetBlockchainSyncStatus 获取当前区块链同步状态
 	//@optional
 	GetBlockchainSyncStatus() (*BlockchainSyncStatus, error)
+
+	//SupportScanMemPool 支持扫描交易内存池
+	//@optional
+	SupportScanMemPool() bool
+
+	//ScanMemPool 扫描交易内存池，未确认的交易单以Pending标记推送给观察者
+	//@optional
+	ScanMemPool() error
 }
 
 // BlockScanNotificationObject 扫描被通知对象
@@ -220,6 +231,9 @@ type TxExtractData struct {
 
 	//交易记录
 	Transaction *Transaction
+
+	//未确认的交易单，从交易内存池中提取，上链后会再推送一次确认的提取结果
+	Pending bool
 }
 
 func NewBlockExtractData() *TxExtractData {
@@ -231,25 +245,35 @@ func NewBlockExtractData() *TxExtractData {
 }
 
 const (
-	periodOfTask = 5 * time.Second //定时任务执行隔间
+	periodOfTask         = 5 * time.Second //定时任务执行隔间
+	periodOfMemPoolTask  = 5 * time.Second //交易内存池扫描间隔
+	pendingTxExpiration  = 1 * time.Hour   //已推送的未确认交易单记录保留时间，过期后还在交易池会再推送
+	defaultMaxReorgDepth = 12              //默认分叉回溯的最大深度
 )
 
 // BlockScannerBase 区块链扫描器基本结构实现
 type BlockScannerBase struct {
-	AddressInScanning map[string]string                    //加入扫描的地址
-	scanTask          *timer.TaskTimer                     //扫描定时器
-	Mu                sync.RWMutex                         //读写锁
-	Observers         map[BlockScanNotificationObject]bool //观察者
-	Scanning          bool                                 //是否扫描中
-	PeriodOfTask      time.Duration
-	ScanAddressFunc   BlockScanAddressFunc  //区块扫描查询地址算法
-	ScanTargetFunc    BlockScanTargetFunc   //区块扫描查询地址算法
-	ScanTargetFuncV2  BlockScanTargetFuncV2 //区块扫描查询地址算法
-	blockProducer     chan interface{}
-	blockConsumer     chan interface{}
-	isClose           bool //是否已关闭
-	WalletDAI         WalletDAI
-	BlockchainDAI     BlockchainDAI
+	AddressInScanning   map[string]string                    //加入扫描的地址
+	scanTask            *timer.TaskTimer                     //扫描定时器
+	Mu                  sync.RWMutex                         //读写锁
+	Observers           map[BlockScanNotificationObject]bool //观察者
+	Scanning            bool                                 //是否扫描中
+	PeriodOfTask        time.Duration
+	ScanAddressFunc     BlockScanAddressFunc  //区块扫描查询地址算法
+	ScanTargetFunc      BlockScanTargetFunc   //区块扫描查询地址算法
+	ScanTargetFuncV2    BlockScanTargetFuncV2 //区块扫描查询地址算法
+	blockProducer       chan interface{}
+	blockConsumer       chan interface{}
+	isClose             bool //是否已关闭
+	WalletDAI           WalletDAI
+	BlockchainDAI       BlockchainDAI
+	MaxReorgDepth       uint64                  //分叉回溯的最大深度
+	BlockHeaderFunc     BlockHeaderByHeightFunc //获取主链指定高度的区块头，没有设置时分叉每次只回退一个区块
+	IsScanMemPool       bool                    //是否扫描交易内存池
+	PeriodOfMemPoolTask time.Duration           //交易内存池扫描间隔
+	memPoolTask         *timer.TaskTimer        //交易内存池扫描定时器
+	pendingTxs          map[string]time.Time    //已推送的未确认交易单，sourceKey:txid -> 推送时间
+	pendingMu           sync.Mutex
 }
 
 // NewBTCBlockScanner 创建区块链扫描器
@@ -258,6 +282,9 @@ func NewBlockScannerBase() *BlockScannerBase {
 	bs.AddressInScanning = make(map[string]string)
 	bs.Observers = make(map[BlockScanNotificationObject]bool)
 	bs.PeriodOfTask = periodOfTask
+	bs.MaxReorgDepth = defaultMaxReorgDepth
+	bs.PeriodOfMemPoolTask = periodOfMemPoolTask
+	bs.pendingTxs = make(map[string]time.Time)
 
 	bs.InitBlockScanner()
 	return &bs
@@ -376,6 +403,26 @@ func (bs *BlockScannerBase) SetTask(task func()) {
 	bs.scanTask = taskTimer
 }
 
+// SetMemPoolTask 设置交易内存池扫描任务，IsScanMemPool开启时随区块扫描一起运行
+func (bs *BlockScannerBase) SetMemPoolTask(task func()) {
+
+	//运行中先关闭定时器
+	if bs.memPoolTask != nil && bs.memPoolTask.Running() {
+		bs.memPoolTask.Stop()
+		bs.memPoolTask = nil
+	}
+	period := bs.PeriodOfMemPoolTask
+	if period == 0 {
+		period = periodOfMemPoolTask
+	}
+	bs.memPoolTask = timer.NewTask(period, task)
+}
+
+// memPoolTaskEnabled 是否运行交易内存池扫描任务
+func (bs *BlockScannerBase) memPoolTaskEnabled() bool {
+	return bs.IsScanMemPool && bs.memPoolTask != nil
+}
+
 // Run 运行
 func (bs *BlockScannerBase) Run() error {
 
@@ -397,6 +444,9 @@ func (bs *BlockScannerBase) Run() error {
 	}
 	bs.Scanning = true
 	bs.scanTask.Start()
+	if bs.memPoolTaskEnabled() {
+		bs.memPoolTask.Start()
+	}
 	return nil
 }
 
@@ -408,6 +458,9 @@ func (bs *BlockScannerBase) Stop() error {
 	}
 
 	bs.scanTask.Stop()
+	if bs.memPoolTask != nil {
+		bs.memPoolTask.Stop()
+	}
 	bs.Scanning = false
 	return nil
 }
@@ -420,6 +473,9 @@ func (bs *BlockScannerBase) Pause() error {
 	}
 
 	bs.scanTask.Pause()
+	if bs.memPoolTaskEnabled() {
+		bs.memPoolTask.Pause()
+	}
 	bs.Scanning = false
 	return nil
 }
@@ -432,6 +488,9 @@ func (bs *BlockScannerBase) Restart() error {
 	}
 
 	bs.scanTask.Restart()
+	if bs.memPoolTaskEnabled() {
+		bs.memPoolTask.Restart()
+	}
 	bs.Scanning = true
 	return nil
 }
@@ -510,7 +569,15 @@ func (bs *BlockScannerBase) SetBlockchainDAI(dai BlockchainDAI) error {
 }
 
 // NewBlockNotify 获得新区块后，发送到通知通道
+// 设置了BlockchainDAI时，先通过DetectBlockReorg检查新区块是否分叉，孤立区块的Fork通知先于新区块发送
 func (bs *BlockScannerBase) NewBlockNotify(block *BlockHeader) error {
+
+	if block != nil && !block.Fork {
+		if _, err := bs.DetectBlockReorg(block); err != nil {
+			log.Errorf("detect block reorganization on height: %d failed, unexpected error: %v", block.Height, err)
+		}
+	}
+
 	bs.Mu.RLock()
 	defer bs.Mu.RUnlock()
 	if !bs.IsClose() {
@@ -520,6 +587,186 @@ func (bs *BlockScannerBase) NewBlockNotify(block *BlockHeader) error {
 	return nil
 }
 
+// DetectBlockReorg 检查新区块是否与本地已扫描的区块链分叉
+// 比较新区块的Previousblockhash与BlockchainDAI.GetLocalBlockHeadByHeight保存的区块头，
+// 分叉时回溯到共同祖先区块（不超过MaxReorgDepth），每个孤立的本地区块发送一次Fork通知，
+// 并删除其未扫记录，保存共同祖先为当前区块头。
+// NewBlockNotify会自动调用，适配器需要遵守以下约定：
+// 1. 每扫描完一个区块，通过BlockchainDAI.SaveLocalBlockHead保存区块头，再调用NewBlockNotify；
+// 2. 每轮扫描任务开始时，通过BlockchainDAI.GetCurrentBlockHead读取扫描起点，分叉后从共同祖先的下一个高度重新扫描；
+// 3. 设置BlockHeaderFunc才能回溯多个区块，没有设置时只回退一个区块，回退的区块在下次扫描时再次检查。
+// @return 共同祖先区块，没有分叉返回nil，扫描器应从祖先的下一个高度重新扫描
+func (bs *BlockScannerBase) DetectBlockReorg(header *BlockHeader) (*BlockHeader, error) {
+
+	if bs.BlockchainDAI == nil || header == nil || header.Height == 0 {
+		return nil, nil
+	}
+
+	symbol := header.Symbol
+
+	parent, err := bs.BlockchainDAI.GetLocalBlockHeadByHeight(header.Height-1, symbol)
+	if err != nil || parent == nil || len(parent.Hash) == 0 {
+		//没有本地区块记录，无法判断
+		return nil, nil
+	}
+
+	if parent.Hash == header.Previousblockhash {
+		return nil, nil
+	}
+
+	maxDepth := bs.MaxReorgDepth
+	if maxDepth == 0 {
+		maxDepth = defaultMaxReorgDepth
+	}
+
+	var (
+		ancestor   *BlockHeader
+		orphans    = make([]*BlockHeader, 0)
+		parentHash = header.Previousblockhash
+		height     = header.Height - 1
+	)
+
+	for ancestor == nil {
+
+		local, err := bs.BlockchainDAI.GetLocalBlockHeadByHeight(height, symbol)
+		if err != nil || local == nil {
+			return nil, fmt.Errorf("can not find local block header on height: %d", height)
+		}
+
+		if local.Hash == parentHash {
+			ancestor = local
+			break
+		}
+
+		if uint64(len(orphans)) >= maxDepth {
+			return nil, fmt.Errorf("block reorganization on height: %d is deeper than max depth: %d", header.Height, maxDepth)
+		}
+
+		if height == 0 {
+			return nil, fmt.Errorf("can not find common ancestor block of height: %d", header.Height)
+		}
+
+		orphans = append(orphans, local)
+
+		if bs.BlockHeaderFunc == nil {
+			//无法获取主链区块，只回退一个区块，本地区块需要与孤立区块相连，
+			//下一个高度重新扫描时，新区块会再次与它比较，不在主链上会继续回退
+			ancestor, err = bs.BlockchainDAI.GetLocalBlockHeadByHeight(height-1, symbol)
+			if err != nil || ancestor == nil {
+				return nil, fmt.Errorf("can not find local block header on height: %d", height-1)
+			}
+			if ancestor.Hash != local.Previousblockhash {
+				return nil, fmt.Errorf("local block header on height: %d is not the parent of orphaned block: %s", height-1, local.Hash)
+			}
+			break
+		}
+
+		mainBlock, err := bs.BlockHeaderFunc(height)
+		if err != nil {
+			return nil, err
+		}
+		if mainBlock == nil {
+			return nil, fmt.Errorf("can not get main chain block header on height: %d", height)
+		}
+
+		parentHash = mainBlock.Previousblockhash
+		height--
+	}
+
+	log.Infof("block has been fork on height: %d, common ancestor height: %d, orphaned blocks: %d", header.Height, ancestor.Height, len(orphans))
+
+	for _, orphan := range orphans {
+
+		log.Infof("orphaned block height: %d, hash: %s", orphan.Height, orphan.Hash)
+
+		//删除孤立区块的未扫记录
+		bs.BlockchainDAI.DeleteUnscanRecordByHeight(orphan.Height, symbol)
+
+		fork := *orphan
+		fork.Fork = true
+		if len(fork.Symbol) == 0 {
+			fork.Symbol = symbol
+		}
+
+		//每个孤立区块通知一次分叉
+		bs.NewBlockNotify(&fork)
+	}
+
+	//重新记录扫描起点
+	err = bs.BlockchainDAI.SaveCurrentBlockHead(ancestor)
+	if err != nil {
+		return nil, err
+	}
+
+	return ancestor, nil
+}
+
+// SupportScanMemPool 支持扫描交易内存池
+// @optional
+func (bs *BlockScannerBase) SupportScanMemPool() bool {
+	return false
+}
+
+// ScanMemPool 扫描交易内存池
+// @optional
+func (bs *BlockScannerBase) ScanMemPool() error {
+	return fmt.Errorf("ScanMemPool is not implemented")
+}
+
+// NewPendingExtractDataNotify 推送交易内存池中提取的未确认交易单给观察者
+// 同一笔交易单在pendingTxExpiration内只推送一次，上链后扫描器按正常流程推送确认的提取结果，
+// 观察者以交易单的WxID合并未确认的记录。
+// @return 是否已推送
+func (bs *BlockScannerBase) NewPendingExtractDataNotify(sourceKey string, data *TxExtractData) (bool, error) {
+
+	if data == nil || data.Transaction == nil {
+		return false, nil
+	}
+
+	key := sourceKey + ":" + data.Transaction.TxID
+	now := time.Now()
+
+	bs.pendingMu.Lock()
+	if bs.pendingTxs == nil {
+		bs.pendingTxs = make(map[string]time.Time)
+	}
+	//清理过期的记录
+	for k, t := range bs.pendingTxs {
+		if now.Sub(t) > pendingTxExpiration {
+			delete(bs.pendingTxs, k)
+		}
+	}
+	_, notified := bs.pendingTxs[key]
+	if !notified {
+		bs.pendingTxs[key] = now
+	}
+	bs.pendingMu.Unlock()
+
+	if notified {
+		return false, nil
+	}
+
+	data.Pending = true
+
+	bs.Mu.RLock()
+	defer bs.Mu.RUnlock()
+	for o, _ := range bs.Observers {
+		err := o.BlockExtractDataNotify(sourceKey, data)
+		if err != nil {
+			log.Errorf("BlockExtractDataNotify unexpected error: %v", err)
+		}
+	}
+
+	return true, nil
+}
+
+// ConfirmPendingTx 交易单已上链，移除未确认交易单的推送记录
+func (bs *BlockScannerBase) ConfirmPendingTx(sourceKey, txid string) {
+	bs.pendingMu.Lock()
+	defer bs.pendingMu.Unlock()
+	delete(bs.pendingTxs, sourceKey+":"+txid)
+}
+
 // CloseBlockScanner 关闭扫描器
 func (bs *BlockScannerBase) CloseBlockScanner() error {
 
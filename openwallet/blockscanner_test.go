@@ -0,0 +1,198 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testForkObserver struct {
+	headers  chan *BlockHeader
+	extracts []*TxExtractData
+}
+
+func (o *testForkObserver) BlockScanNotify(header *BlockHeader) error {
+	o.headers <- header
+	return nil
+}
+
+func (o *testForkObserver) BlockExtractDataNotify(sourceKey string, data *TxExtractData) error {
+	o.extracts = append(o.extracts, data)
+	return nil
+}
+
+func (o *testForkObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *SmartContractReceipt) error {
+	return nil
+}
+
+func TestBlockScannerBase_DetectBlockReorg(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "openwallet_reorg")
+	if err != nil {
+		t.Fatalf("TempDir failed, unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dai, err := NewBlockchainLocal(filepath.Join(dir, "blockchain.db"), false)
+	if err != nil {
+		t.Fatalf("NewBlockchainLocal failed, unexpected error: %v", err)
+	}
+
+	//本地链 100 <- 101a <- 102a <- 103a，主链 100 <- 101b <- 102b <- 103b <- 104b
+	hash := func(height uint64, branch string) string {
+		if height <= 100 {
+			return fmt.Sprintf("%d", height)
+		}
+		return fmt.Sprintf("%d%s", height, branch)
+	}
+	for h := uint64(98); h <= 103; h++ {
+		dai.SaveLocalBlockHead(&BlockHeader{Height: h, Hash: hash(h, "a"), Previousblockhash: hash(h-1, "a"), Symbol: "BTC"})
+	}
+	mainChain := func(height uint64) (*BlockHeader, error) {
+		return &BlockHeader{Height: height, Hash: hash(height, "b"), Previousblockhash: hash(height-1, "b"), Symbol: "BTC"}, nil
+	}
+
+	bs := NewBlockScannerBase()
+	bs.SetTask(func() {})
+	defer bs.CloseBlockScanner()
+	bs.SetBlockchainDAI(dai)
+	bs.BlockHeaderFunc = mainChain
+	observer := &testForkObserver{headers: make(chan *BlockHeader, 10)}
+	bs.AddObserver(observer)
+
+	//没有分叉
+	ancestor, err := bs.DetectBlockReorg(&BlockHeader{Height: 101, Hash: hash(101, "a"), Previousblockhash: hash(100, "a"), Symbol: "BTC"})
+	if err != nil || ancestor != nil {
+		t.Fatalf("DetectBlockReorg without fork = %v, err: %v", ancestor, err)
+	}
+
+	//超过回溯深度
+	bs.MaxReorgDepth = 2
+	newBlock, _ := mainChain(104)
+	if _, err = bs.DetectBlockReorg(newBlock); err == nil {
+		t.Fatalf("reorg deeper than max depth should fail")
+	}
+
+	bs.MaxReorgDepth = 5
+	ancestor, err = bs.DetectBlockReorg(newBlock)
+	if err != nil {
+		t.Fatalf("DetectBlockReorg failed, unexpected error: %v", err)
+	}
+	if ancestor == nil || ancestor.Height != 100 {
+		t.Fatalf("common ancestor = %v, want height 100", ancestor)
+	}
+
+	for _, want := range []uint64{103, 102, 101} {
+		select {
+		case header := <-observer.headers:
+			if !header.Fork || header.Height != want || header.Hash != hash(want, "a") {
+				t.Errorf("fork notify = %+v, want orphaned block %d", header, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("fork notify of height %d timeout", want)
+		}
+	}
+
+	current, _ := dai.GetCurrentBlockHead("BTC")
+	if current.Height != 100 {
+		t.Errorf("current block head = %d, want 100", current.Height)
+	}
+
+	//主链区块头不存在
+	bs.BlockHeaderFunc = func(height uint64) (*BlockHeader, error) {
+		return nil, nil
+	}
+	if _, err = bs.DetectBlockReorg(newBlock); err == nil {
+		t.Errorf("reorg without main chain block header should fail")
+	}
+
+	//没有设置BlockHeaderFunc，新区块通知时回退一个区块
+	bs.BlockHeaderFunc = nil
+	if err = bs.NewBlockNotify(newBlock); err != nil {
+		t.Fatalf("NewBlockNotify failed, unexpected error: %v", err)
+	}
+	for _, want := range []uint64{103, 104} {
+		select {
+		case header := <-observer.headers:
+			if header.Height != want || header.Fork != (want == 103) {
+				t.Errorf("block notify = %+v, want height %d", header, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("block notify of height %d timeout", want)
+		}
+	}
+	current, _ = dai.GetCurrentBlockHead("BTC")
+	if current.Height != 102 {
+		t.Errorf("current block head = %d, want 102", current.Height)
+	}
+
+	//本地区块不相连，无法确认共同祖先
+	dai.SaveLocalBlockHead(&BlockHeader{Height: 102, Hash: "102c", Previousblockhash: hash(101, "a"), Symbol: "BTC"})
+	if _, err = bs.DetectBlockReorg(newBlock); err == nil {
+		t.Errorf("reorg with unlinked local block header should fail")
+	}
+}
+
+func TestBlockScannerBase_NewPendingExtractDataNotify(t *testing.T) {
+
+	bs := NewBlockScannerBase()
+	bs.SetTask(func() {})
+	defer bs.CloseBlockScanner()
+	observer := &testForkObserver{headers: make(chan *BlockHeader, 10)}
+	bs.AddObserver(observer)
+
+	newData := func() *TxExtractData {
+		data := NewBlockExtractData()
+		data.Transaction = &Transaction{TxID: "tx1", Coin: Coin{Symbol: "BTC"}}
+		return data
+	}
+
+	notified, err := bs.NewPendingExtractDataNotify("app:A1", newData())
+	if err != nil || !notified {
+		t.Fatalf("NewPendingExtractDataNotify = %v, err: %v", notified, err)
+	}
+	if len(observer.extracts) != 1 || !observer.extracts[0].Pending {
+		t.Fatalf("observer received %d pending extract data, want 1", len(observer.extracts))
+	}
+
+	//同一笔交易单不重复推送
+	notified, _ = bs.NewPendingExtractDataNotify("app:A1", newData())
+	if notified || len(observer.extracts) != 1 {
+		t.Errorf("pending transaction notified again")
+	}
+
+	//其他账户的同一笔交易单需要推送
+	notified, _ = bs.NewPendingExtractDataNotify("app:A2", newData())
+	if !notified || len(observer.extracts) != 2 {
+		t.Errorf("pending transaction of other account is not notified")
+	}
+
+	//上链后移除记录，如果再次进入交易池，重新推送
+	bs.ConfirmPendingTx("app:A1", "tx1")
+	notified, _ = bs.NewPendingExtractDataNotify("app:A1", newData())
+	if !notified || len(observer.extracts) != 3 {
+		t.Errorf("pending transaction is not notified after confirmed")
+	}
+
+	if bs.SupportScanMemPool() {
+		t.Errorf("BlockScannerBase should not support scanning mempool")
+	}
+}
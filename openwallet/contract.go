@@ -90,27 +90,48 @@ type SmartContractRawTransaction struct {
 }
 
 type SmartContractReceipt struct {
-	Coin        Coin                  `json:"coin"`            //@required 区块链类型标识
-	WxID        string                `json:"wxid" storm:"id"` //@required 通过GenTransactionWxID计算
-	TxID        string                `json:"txid"`            //@required
-	From        string                `json:"from"`            //@required 调用者
-	To          string                `json:"to"`              //@required 调用地址，与合约地址一致
-	Value       string                `json:"value"`           //主币数量
-	Fees        string                `json:"fees"`            //手续费
-	RawReceipt  string                `json:"rawReceipt"`      //@required 原始交易回执，一般为json
-	Events      []*SmartContractEvent `json:"events"`          //@required 执行事件, 例如：event Transfer
-	BlockHash   string                `json:"blockHash"`       //@required
-	BlockHeight uint64                `json:"blockHeight"`     //@required
-	ConfirmTime int64                 `json:"confirmTime"`     //@required
-	Status      string                `json:"status"`          //@required 链上状态，0：失败，1：成功
-	Reason      string                `json:"reason"`          //失败原因，失败状态码
-	ExtParam    string                `json:"extParam"`        //扩展参数，用于调用智能合约，json结构
+	Coin        Coin                  `json:"coin"`                      //@required 区块链类型标识
+	WxID        string                `json:"wxid" storm:"id"`           //@required 通过GenTransactionWxID计算
+	TxID        string                `json:"txid" storm:"index"`        //@required
+	From        string                `json:"from"`                      //@required 调用者
+	To          string                `json:"to"`                        //@required 调用地址，与合约地址一致
+	Value       string                `json:"value"`                     //主币数量
+	Fees        string                `json:"fees"`                      //手续费
+	RawReceipt  string                `json:"rawReceipt"`                //@required 原始交易回执，一般为json
+	Events      []*SmartContractEvent `json:"events"`                    //@required 执行事件, 例如：event Transfer
+	BlockHash   string                `json:"blockHash"`                 //@required
+	BlockHeight uint64                `json:"blockHeight" storm:"index"` //@required
+	ConfirmTime int64                 `json:"confirmTime"`               //@required
+	Status      string                `json:"status"`                    //@required 链上状态，0：失败，1：成功
+	Reason      string                `json:"reason"`                    //失败原因，失败状态码
+	ExtParam    string                `json:"extParam"`                  //扩展参数，用于调用智能合约，json结构
 }
 
 func (tx *SmartContractReceipt) GenWxID() {
 	tx.WxID = GenTransactionWxID2(tx.TxID, tx.Coin.Symbol, tx.Coin.ContractID)
 }
 
+// SmartContractReceiptRecord 合约交易回执的持久化记录，以WxID为主键，按ContractID建立索引
+type SmartContractReceiptRecord struct {
+	SmartContractReceipt `storm:"inline"`
+	ContractID           string `json:"contractID" storm:"index"` //回执所属的合约ID
+}
+
+// NewSmartContractReceiptRecord 创建回执记录，contractID为空时使用回执的Coin.ContractID，不修改传入的回执
+func NewSmartContractReceiptRecord(contractID string, receipt *SmartContractReceipt) *SmartContractReceiptRecord {
+	if len(contractID) == 0 {
+		contractID = receipt.Coin.ContractID
+	}
+	record := &SmartContractReceiptRecord{
+		SmartContractReceipt: *receipt,
+		ContractID:           contractID,
+	}
+	if len(record.WxID) == 0 {
+		record.GenWxID()
+	}
+	return record
+}
+
 // SmartContractEvent 事件记录
 type SmartContractEvent struct {
 	Contract *SmartContract `json:"contract"` //合约
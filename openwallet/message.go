@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//messagePrefix 通用消息签名的前缀，避免签名的消息被当作交易单哈希使用
+const messagePrefix = "\x19OpenWallet Signed Message:\n"
+
+//MessageSigner 消息签名器，定义链的消息哈希和签名格式，用于证明地址的所有权
+//签名由openw使用地址衍生路径的私钥（或钱包的签名提供者）完成，消息签名器不接触私钥
+type MessageSigner interface {
+
+	//HashMessage 计算待签名的消息哈希
+	HashMessage(message []byte) ([]byte, error)
+
+	//EncodeSignature 编码消息签名
+	//@param signature 签名结果，v 签名的恢复值，publicKey 签名地址的公钥（压缩格式）
+	EncodeSignature(signature []byte, v byte, publicKey []byte) (string, error)
+
+	//VerifyMessage 校验消息签名是否由地址的拥有者签名
+	VerifyMessage(address string, message []byte, signature string) (bool, error)
+}
+
+//DefaultMessageSigner 通用消息签名器，支持secp256k1，secp256r1，ed25519
+//消息哈希为 sha256(sha256(前缀 | 消息长度 | 消息))，签名格式为 base64(公钥 | 签名)，
+//校验时通过资产适配器的地址解析器把签名中的公钥转为地址，与签名地址比较。
+type DefaultMessageSigner struct {
+	adapter AssetsAdapter
+}
+
+//NewDefaultMessageSigner 创建资产适配器的通用消息签名器
+func NewDefaultMessageSigner(adapter AssetsAdapter) *DefaultMessageSigner {
+	return &DefaultMessageSigner{adapter: adapter}
+}
+
+//GetMessageSigner 获取资产适配器的消息签名器，没有实现时使用通用消息签名器
+func GetMessageSigner(adapter AssetsAdapter) MessageSigner {
+	if signer := adapter.GetMessageSigner(); signer != nil {
+		return signer
+	}
+	return NewDefaultMessageSigner(adapter)
+}
+
+//HashMessage 计算待签名的消息哈希
+func (s *DefaultMessageSigner) HashMessage(message []byte) ([]byte, error) {
+	if _, err := messagePublicKeyLen(s.adapter.CurveType()); err != nil {
+		return nil, err
+	}
+	data := append([]byte(messagePrefix+strconv.Itoa(len(message))), message...)
+	return owcrypt.Hash(data, 0, owcrypt.HASH_ALG_DOUBLE_SHA256), nil
+}
+
+//EncodeSignature 编码为 base64(公钥 | 签名)，公钥已包含在签名中，忽略恢复值
+func (s *DefaultMessageSigner) EncodeSignature(signature []byte, v byte, publicKey []byte) (string, error) {
+	pubLen, err := messagePublicKeyLen(s.adapter.CurveType())
+	if err != nil {
+		return "", err
+	}
+	if len(publicKey) != pubLen || len(signature) != 64 {
+		return "", fmt.Errorf("message signature is invalid")
+	}
+	return base64.StdEncoding.EncodeToString(append(append([]byte{}, publicKey...), signature...)), nil
+}
+
+//VerifyMessage 校验签名，并检查签名的公钥对应的地址
+func (s *DefaultMessageSigner) VerifyMessage(address string, message []byte, signature string) (bool, error) {
+
+	curveType := s.adapter.CurveType()
+	pubLen, err := messagePublicKeyLen(curveType)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(data) != pubLen+64 {
+		return false, fmt.Errorf("message signature is invalid")
+	}
+	publicKey, sig := data[:pubLen], data[pubLen:]
+
+	hash, err := s.HashMessage(message)
+	if err != nil {
+		return false, err
+	}
+
+	verifyKey := publicKey
+	if curveType != owcrypt.ECC_CURVE_ED25519 {
+		verifyKey = owcrypt.PointDecompress(publicKey, curveType)
+		if len(verifyKey) != 65 {
+			return false, fmt.Errorf("message signature public key is invalid")
+		}
+		verifyKey = verifyKey[1:]
+	}
+	if owcrypt.Verify(verifyKey, nil, hash, sig, curveType) != owcrypt.SUCCESS {
+		return false, nil
+	}
+
+	//公钥转地址，主网和测试网地址都可以
+	var decoder AddressDecoder
+	if decoderV2 := s.adapter.GetAddressDecoderV2(); decoderV2 != nil {
+		decoder = decoderV2
+	} else {
+		decoder = s.adapter.GetAddressDecode()
+	}
+	if decoder == nil {
+		return false, fmt.Errorf("assets-adapter not support AddressDecoder interface")
+	}
+	for _, isTestnet := range []bool{false, true} {
+		addr, err := decoder.PublicKeyToAddress(publicKey, isTestnet)
+		if err != nil {
+			return false, err
+		}
+		if addr == address {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//messagePublicKeyLen 通用消息签名支持的曲线的公钥长度
+func messagePublicKeyLen(curveType uint32) (int, error) {
+	switch curveType {
+	case owcrypt.ECC_CURVE_SECP256K1, owcrypt.ECC_CURVE_SECP256R1:
+		return 33, nil
+	case owcrypt.ECC_CURVE_ED25519:
+		return 32, nil
+	}
+	return 0, fmt.Errorf("message signer is not support curve type: %x", curveType)
+}
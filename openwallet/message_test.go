@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+type testMessageAddressDecoder struct {
+	AddressDecoderV2Base
+}
+
+func (dec *testMessageAddressDecoder) PublicKeyToAddress(pub []byte, isTestnet bool) (string, error) {
+	return "addr_" + hex.EncodeToString(pub), nil
+}
+
+type testMessageAdapter struct {
+	AssetsAdapterBase
+	curveType uint32
+}
+
+func (a *testMessageAdapter) CurveType() uint32 {
+	return a.curveType
+}
+
+func (a *testMessageAdapter) GetAddressDecoderV2() AddressDecoderV2 {
+	return &testMessageAddressDecoder{}
+}
+
+func TestDefaultMessageSigner(t *testing.T) {
+
+	seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+	key, _ := hdkeystore.NewHDKey(seed, "msg", hdkeystore.OpenwCoinTypePath)
+	hdPath := hdkeystore.OpenwCoinTypePath + "/0'/0/1"
+	message := []byte("withdrawal to exchange #1024")
+
+	for _, curveType := range []uint32{owcrypt.ECC_CURVE_SECP256K1, owcrypt.ECC_CURVE_SECP256R1, owcrypt.ECC_CURVE_ED25519} {
+
+		signer := GetMessageSigner(&testMessageAdapter{curveType: curveType})
+
+		childKey, err := key.DerivedKeyWithPath(hdPath, curveType)
+		if err != nil {
+			t.Fatalf("DerivedKeyWithPath failed, unexpected error: %v", err)
+		}
+		priv, _ := childKey.GetPrivateKeyBytes()
+		pub := childKey.GetPublicKeyBytes()
+		address := "addr_" + hex.EncodeToString(pub)
+
+		hash, err := signer.HashMessage(message)
+		if err != nil {
+			t.Fatalf("[%x] HashMessage failed, unexpected error: %v", curveType, err)
+		}
+		sig, v, ret := owcrypt.Signature(priv, nil, hash, curveType)
+		if ret != owcrypt.SUCCESS {
+			t.Fatalf("[%x] Signature failed", curveType)
+		}
+		signature, err := signer.EncodeSignature(sig, v, pub)
+		if err != nil {
+			t.Fatalf("[%x] EncodeSignature failed, unexpected error: %v", curveType, err)
+		}
+
+		if ok, err := signer.VerifyMessage(address, message, signature); !ok || err != nil {
+			t.Errorf("[%x] VerifyMessage = %v, err: %v", curveType, ok, err)
+		}
+		if ok, _ := signer.VerifyMessage(address, []byte("withdrawal to exchange #1025"), signature); ok {
+			t.Errorf("[%x] VerifyMessage should fail with other message", curveType)
+		}
+		if ok, _ := signer.VerifyMessage("addr_other", message, signature); ok {
+			t.Errorf("[%x] VerifyMessage should fail with other address", curveType)
+		}
+	}
+
+	if _, err := GetMessageSigner(&testMessageAdapter{curveType: owcrypt.ECC_CURVE_SM2_STANDARD}).HashMessage(message); err == nil {
+		t.Errorf("HashMessage should fail with unsupported curve")
+	}
+}
@@ -0,0 +1,488 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+//离线签名信封
+//
+// Workflow：
+// 	1. 在线主机构建交易单后，通过 NewOfflineTxEnvelope 打包，Encode 导出为文本，可用 SplitOfflineTxEnvelope 分割为二维码分片
+// 	2. 离线主机 DecodeOfflineTxEnvelope 导入，核对交易内容后，使用钱包密钥 Sign 签名，再 Encode 导出
+// 	3. 在线主机导入已签名的信封，校验交易内容摘要和签名后，把签名合并到原交易单，再广播
+//
+// 编码格式为base64的：version(1) | gzip(json) | checksum(4)，checksum为前面所有字节的double sha256的前4字节。
+const (
+	//OfflineTxEnvelopeVersion 信封编码的版本号
+	OfflineTxEnvelopeVersion = 1
+
+	//信封的交易单类型
+	OfflineTxKindRaw           = "rawTransaction"
+	OfflineTxKindSmartContract = "smartContractRawTransaction"
+
+	//offlineChunkPrefix 二维码分片的前缀，格式：owtx:序号/总数:信封标识:内容
+	offlineChunkPrefix = "owtx"
+)
+
+//OfflineTxEnvelope 离线签名信封，包含构建完成的交易单，交易单中有账户，每个被签消息的哈希，衍生路径和曲线类型
+type OfflineTxEnvelope struct {
+	Version    uint8                        `json:"version"`              //编码版本
+	Kind       string                       `json:"kind"`                 //交易单类型
+	WalletID   string                       `json:"walletID"`             //签名的钱包ID
+	AccountID  string                       `json:"accountID"`            //创建交易单的账户
+	Digest     string                       `json:"digest"`               //交易单去掉签名后的摘要，合并签名时校验交易内容没有被修改
+	RawTx      *RawTransaction              `json:"rawTx,omitempty"`      //原始交易单
+	ContractTx *SmartContractRawTransaction `json:"contractTx,omitempty"` //智能合约交易单
+}
+
+//NewOfflineTxEnvelope 打包构建完成的交易单
+func NewOfflineTxEnvelope(rawTx *RawTransaction) (*OfflineTxEnvelope, error) {
+	if rawTx == nil || !rawTx.IsBuilt {
+		return nil, fmt.Errorf("transaction is not built")
+	}
+	env := &OfflineTxEnvelope{
+		Version: OfflineTxEnvelopeVersion,
+		Kind:    OfflineTxKindRaw,
+		RawTx:   rawTx,
+	}
+	return env, env.init()
+}
+
+//NewOfflineContractTxEnvelope 打包构建完成的智能合约交易单
+func NewOfflineContractTxEnvelope(rawTx *SmartContractRawTransaction) (*OfflineTxEnvelope, error) {
+	if rawTx == nil || !rawTx.IsBuilt {
+		return nil, fmt.Errorf("smart contract transaction is not built")
+	}
+	env := &OfflineTxEnvelope{
+		Version:    OfflineTxEnvelopeVersion,
+		Kind:       OfflineTxKindSmartContract,
+		ContractTx: rawTx,
+	}
+	return env, env.init()
+}
+
+//init 检查被签消息，计算摘要
+func (env *OfflineTxEnvelope) init() error {
+
+	account := env.Account()
+	if account == nil {
+		return fmt.Errorf("transaction account is empty")
+	}
+	env.WalletID = account.WalletID
+	env.AccountID = account.AccountID
+
+	keySignatures := env.Signatures()[SignatureOwnerKey(account)]
+	if len(keySignatures) == 0 {
+		return fmt.Errorf("transaction has no key signatures of account: %s", account.AccountID)
+	}
+	for _, ks := range keySignatures {
+		if ks == nil || ks.Address == nil || len(ks.Address.HDPath) == 0 {
+			return fmt.Errorf("key signature hdPath is empty")
+		}
+		if _, err := hex.DecodeString(ks.Message); err != nil || len(ks.Message) == 0 {
+			return fmt.Errorf("key signature message is invalid")
+		}
+	}
+
+	digest, err := env.digest()
+	if err != nil {
+		return err
+	}
+	env.Digest = digest
+	return nil
+}
+
+//Account 交易单的账户
+func (env *OfflineTxEnvelope) Account() *AssetsAccount {
+	switch {
+	case env.RawTx != nil:
+		return env.RawTx.Account
+	case env.ContractTx != nil:
+		return env.ContractTx.Account
+	}
+	return nil
+}
+
+//Signatures 交易单的被签消息
+func (env *OfflineTxEnvelope) Signatures() map[string][]*KeySignature {
+	switch {
+	case env.RawTx != nil:
+		return env.RawTx.Signatures
+	case env.ContractTx != nil:
+		return env.ContractTx.Signatures
+	}
+	return nil
+}
+
+//digest 交易单去掉签名值后的json的sha256
+func (env *OfflineTxEnvelope) digest() (string, error) {
+
+	var tx interface{}
+	switch env.Kind {
+	case OfflineTxKindRaw:
+		tx = env.RawTx
+	case OfflineTxKindSmartContract:
+		tx = env.ContractTx
+	default:
+		return "", fmt.Errorf("unknown offline transaction kind: %s", env.Kind)
+	}
+
+	//复制交易单，清空签名
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return "", err
+	}
+	unsigned := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&unsigned); err != nil {
+		return "", err
+	}
+	for _, field := range []string{"sigParts", "signatures"} {
+		owners, _ := unsigned[field].(map[string]interface{})
+		for _, keySignatures := range owners {
+			list, _ := keySignatures.([]interface{})
+			for _, ks := range list {
+				if m, ok := ks.(map[string]interface{}); ok {
+					delete(m, "signed")
+				}
+			}
+		}
+	}
+	data, err = json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+//Encode 编码为可传输的文本
+func (env *OfflineTxEnvelope) Encode() (string, error) {
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(env.Version)
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(data); err != nil {
+		return "", err
+	}
+	if err = zw.Close(); err != nil {
+		return "", err
+	}
+
+	payload := buf.Bytes()
+	payload = append(payload, offlineChecksum(payload)...)
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+//DecodeOfflineTxEnvelope 解码信封文本，校验校验和，版本，交易单类型和交易内容摘要
+func DecodeOfflineTxEnvelope(text string) (*OfflineTxEnvelope, error) {
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(raw) < 1+4 {
+		return nil, fmt.Errorf("offline transaction envelope is invalid")
+	}
+
+	payload, checksum := raw[:len(raw)-4], raw[len(raw)-4:]
+	if !bytes.Equal(offlineChecksum(payload), checksum) {
+		return nil, fmt.Errorf("offline transaction envelope checksum is invalid")
+	}
+
+	if payload[0] != OfflineTxEnvelopeVersion {
+		return nil, fmt.Errorf("unsupported offline transaction envelope version: %d", payload[0])
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(payload[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("offline transaction envelope is invalid, unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("offline transaction envelope is invalid, unexpected error: %v", err)
+	}
+
+	var env OfflineTxEnvelope
+	if err = json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("offline transaction envelope is invalid, unexpected error: %v", err)
+	}
+
+	if env.Version != payload[0] {
+		return nil, fmt.Errorf("offline transaction envelope version is mismatched")
+	}
+
+	switch env.Kind {
+	case OfflineTxKindRaw:
+		if env.RawTx == nil || env.ContractTx != nil {
+			return nil, fmt.Errorf("offline transaction envelope content is mismatched with kind: %s", env.Kind)
+		}
+	case OfflineTxKindSmartContract:
+		if env.ContractTx == nil || env.RawTx != nil {
+			return nil, fmt.Errorf("offline transaction envelope content is mismatched with kind: %s", env.Kind)
+		}
+	default:
+		return nil, fmt.Errorf("unknown offline transaction kind: %s", env.Kind)
+	}
+
+	account := env.Account()
+	if account == nil || account.WalletID != env.WalletID || account.AccountID != env.AccountID {
+		return nil, fmt.Errorf("offline transaction envelope account is mismatched")
+	}
+
+	digest, err := env.digest()
+	if err != nil {
+		return nil, err
+	}
+	if digest != env.Digest {
+		return nil, fmt.Errorf("offline transaction envelope digest is mismatched, the transaction has been modified")
+	}
+
+	return &env, nil
+}
+
+//Sign 离线主机使用钱包密钥签名账户的全部被签消息
+func (env *OfflineTxEnvelope) Sign(key *hdkeystore.HDKey) error {
+
+	if key.KeyID != env.WalletID {
+		return fmt.Errorf("wallet key: %s is not the signer of wallet: %s", key.KeyID, env.WalletID)
+	}
+
+	account := env.Account()
+	for _, ks := range env.Signatures()[SignatureOwnerKey(account)] {
+
+		childKey, err := key.DerivedKeyWithPath(ks.Address.HDPath, ks.EccType)
+		if err != nil {
+			return err
+		}
+
+		keyBytes, err := childKey.GetPrivateKeyBytes()
+		if err != nil {
+			return err
+		}
+
+		msg, err := hex.DecodeString(ks.Message)
+		if err != nil {
+			return fmt.Errorf("key signature message is invalid, unexpected error: %v", err)
+		}
+
+		signature, v, sigErr := owcrypt.Signature(keyBytes, nil, msg, ks.EccType)
+		if sigErr != owcrypt.SUCCESS {
+			return fmt.Errorf("transaction hash sign failed")
+		}
+
+		if ks.RSV {
+			signature = append(signature, v)
+		}
+
+		ks.Signature = hex.EncodeToString(signature)
+	}
+
+	return nil
+}
+
+//VerifyKeySignatures 校验账户的被签消息都已签名，且签名公钥是账户扩展公钥按衍生路径衍生的公钥
+func VerifyKeySignatures(account *AssetsAccount, keySignatures []*KeySignature) error {
+
+	if len(keySignatures) == 0 {
+		return fmt.Errorf("transaction has no key signatures of account: %s", account.AccountID)
+	}
+
+	for _, ks := range keySignatures {
+
+		if ks == nil || ks.Address == nil {
+			return fmt.Errorf("key signature address is empty")
+		}
+
+		if len(ks.Signature) == 0 {
+			return fmt.Errorf("key signature of path: %s is not signed", ks.Address.HDPath)
+		}
+
+		pub, err := account.DerivePublicKey(ks.Address.HDPath)
+		if err != nil {
+			return err
+		}
+
+		err = verifyKeySignature(pub, ks)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//verifyKeySignature 以衍生公钥校验被签消息的签名
+func verifyKeySignature(pub []byte, ks *KeySignature) error {
+
+	if len(pub) == 33 {
+		pub = owcrypt.PointDecompress(pub, ks.EccType)[1:]
+	}
+
+	msg, err := hex.DecodeString(ks.Message)
+	if err != nil {
+		return fmt.Errorf("key signature message is invalid, unexpected error: %v", err)
+	}
+
+	signature, err := hex.DecodeString(ks.Signature)
+	if err != nil {
+		return fmt.Errorf("key signature is invalid, unexpected error: %v", err)
+	}
+	if ks.RSV && len(signature) > 0 {
+		signature = signature[:len(signature)-1]
+	}
+
+	if owcrypt.Verify(pub, nil, msg, signature, ks.EccType) != owcrypt.SUCCESS {
+		return fmt.Errorf("key signature of path: %s verify failed", ks.Address.HDPath)
+	}
+
+	return nil
+}
+
+//MergeSignatures 校验已签名的信封，并把签名合并到本地的交易单，本地交易单必须与信封的交易内容一致
+//rawTx为本地保存的*RawTransaction或*SmartContractRawTransaction
+func (env *OfflineTxEnvelope) MergeSignatures(rawTx interface{}) error {
+
+	var local *OfflineTxEnvelope
+	var err error
+	switch tx := rawTx.(type) {
+	case *RawTransaction:
+		local, err = NewOfflineTxEnvelope(tx)
+	case *SmartContractRawTransaction:
+		local, err = NewOfflineContractTxEnvelope(tx)
+	default:
+		return fmt.Errorf("unsupported transaction type: %T", rawTx)
+	}
+	if err != nil {
+		return err
+	}
+
+	if local.Kind != env.Kind || local.WalletID != env.WalletID || local.AccountID != env.AccountID {
+		return fmt.Errorf("offline transaction envelope does not belong to the transaction")
+	}
+
+	digest, err := env.digest()
+	if err != nil {
+		return err
+	}
+	if digest != local.Digest || env.Digest != local.Digest {
+		return fmt.Errorf("offline transaction envelope digest is mismatched, the transaction has been modified")
+	}
+
+	owner := SignatureOwnerKey(local.Account())
+	signed := env.Signatures()[owner]
+	if err = VerifyKeySignatures(local.Account(), signed); err != nil {
+		return err
+	}
+
+	//摘要一致，被签消息的顺序和内容一致
+	for i, ks := range local.Signatures()[owner] {
+		ks.Signature = signed[i].Signature
+	}
+
+	return nil
+}
+
+//SplitOfflineTxEnvelope 把信封文本分割为多个分片，用于二维码传输
+func SplitOfflineTxEnvelope(text string, chunkSize int) ([]string, error) {
+
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be greater than 0")
+	}
+
+	id := hex.EncodeToString(offlineChecksum([]byte(text)))
+	total := (len(text) + chunkSize - 1) / chunkSize
+	chunks := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(text) {
+			end = len(text)
+		}
+		chunks = append(chunks, fmt.Sprintf("%s:%d/%d:%s:%s", offlineChunkPrefix, i+1, total, id, text[i*chunkSize:end]))
+	}
+	return chunks, nil
+}
+
+//CombineOfflineTxChunks 合并二维码分片为信封文本，分片可以乱序和重复
+func CombineOfflineTxChunks(chunks []string) (string, error) {
+
+	var (
+		id    string
+		total int
+		parts = make(map[int]string)
+	)
+
+	for _, chunk := range chunks {
+		fields := strings.SplitN(strings.TrimSpace(chunk), ":", 4)
+		if len(fields) != 4 || fields[0] != offlineChunkPrefix {
+			return "", fmt.Errorf("offline transaction chunk is invalid")
+		}
+		var index, n int
+		if _, err := fmt.Sscanf(fields[1], "%d/%d", &index, &n); err != nil || index < 1 || index > n {
+			return "", fmt.Errorf("offline transaction chunk index is invalid: %s", fields[1])
+		}
+		if len(id) == 0 {
+			id, total = fields[2], n
+		}
+		if fields[2] != id || n != total {
+			return "", fmt.Errorf("offline transaction chunks do not belong to the same envelope")
+		}
+		parts[index] = fields[3]
+	}
+
+	if total == 0 {
+		return "", fmt.Errorf("offline transaction chunks is empty")
+	}
+
+	var text strings.Builder
+	for i := 1; i <= total; i++ {
+		part, ok := parts[i]
+		if !ok {
+			return "", fmt.Errorf("offline transaction chunk %d/%d is missing", i, total)
+		}
+		text.WriteString(part)
+	}
+
+	if hex.EncodeToString(offlineChecksum([]byte(text.String()))) != id {
+		return "", fmt.Errorf("offline transaction chunks checksum is invalid")
+	}
+
+	return text.String(), nil
+}
+
+//offlineChecksum double sha256的前4字节
+func offlineChecksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
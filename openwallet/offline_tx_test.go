@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+func testOfflineRawTransaction(t *testing.T) (*hdkeystore.HDKey, *RawTransaction) {
+	seed, _ := hdkeystore.GenerateSeed(hdkeystore.SeedLen)
+	key, err := hdkeystore.NewHDKey(seed, "cold", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed, unexpected error: %v", err)
+	}
+	accountPath := hdkeystore.OpenwCoinTypePath + "/0'"
+	accountKey, err := key.DerivedKeyWithPath(accountPath, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath failed, unexpected error: %v", err)
+	}
+	account := &AssetsAccount{
+		WalletID:  key.KeyID,
+		AccountID: "A1",
+		HDPath:    accountPath,
+		PublicKey: accountKey.GetPublicKey().OWEncode(),
+		Symbol:    "BTC",
+	}
+	account.OwnerKeys = []string{account.PublicKey}
+
+	rawTx := &RawTransaction{
+		Coin:    Coin{Symbol: "BTC"},
+		RawHex:  "0100000001",
+		To:      map[string]string{"1BoatSLRHtKNngkdXEeobR76b53LETtpyT": "0.1"},
+		Account: account,
+		IsBuilt: true,
+		Signatures: map[string][]*KeySignature{
+			"A1": {
+				{
+					EccType: owcrypt.ECC_CURVE_SECP256K1,
+					Address: &Address{HDPath: accountPath + "/0/1"},
+					Message: hex.EncodeToString(owcrypt.Hash([]byte("input 0"), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)),
+				},
+				{
+					EccType: owcrypt.ECC_CURVE_SECP256K1,
+					Address: &Address{HDPath: accountPath + "/1/2"},
+					Message: hex.EncodeToString(owcrypt.Hash([]byte("input 1"), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)),
+					RSV:     true,
+				},
+			},
+		},
+	}
+	return key, rawTx
+}
+
+func TestOfflineTxEnvelope(t *testing.T) {
+
+	key, rawTx := testOfflineRawTransaction(t)
+
+	//在线主机导出，分割为二维码分片
+	env, err := NewOfflineTxEnvelope(rawTx)
+	if err != nil {
+		t.Fatalf("NewOfflineTxEnvelope failed, unexpected error: %v", err)
+	}
+	text, err := env.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed, unexpected error: %v", err)
+	}
+	chunks, err := SplitOfflineTxEnvelope(text, 100)
+	if err != nil {
+		t.Fatalf("SplitOfflineTxEnvelope failed, unexpected error: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("SplitOfflineTxEnvelope got %d chunks", len(chunks))
+	}
+
+	//离线主机乱序扫描，重复的分片忽略
+	scanned := append([]string{chunks[len(chunks)-1], chunks[0]}, chunks...)
+	combined, err := CombineOfflineTxChunks(scanned)
+	if err != nil || combined != text {
+		t.Fatalf("CombineOfflineTxChunks failed, unexpected error: %v", err)
+	}
+	if _, err = CombineOfflineTxChunks(chunks[1:]); err == nil {
+		t.Errorf("CombineOfflineTxChunks should fail with missing chunk")
+	}
+
+	offline, err := DecodeOfflineTxEnvelope(combined)
+	if err != nil {
+		t.Fatalf("DecodeOfflineTxEnvelope failed, unexpected error: %v", err)
+	}
+	if err = offline.Sign(key); err != nil {
+		t.Fatalf("Sign failed, unexpected error: %v", err)
+	}
+	signed, err := offline.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed, unexpected error: %v", err)
+	}
+
+	//在线主机合并签名
+	signedEnv, err := DecodeOfflineTxEnvelope(signed)
+	if err != nil {
+		t.Fatalf("DecodeOfflineTxEnvelope failed, unexpected error: %v", err)
+	}
+	if err = signedEnv.MergeSignatures(rawTx); err != nil {
+		t.Fatalf("MergeSignatures failed, unexpected error: %v", err)
+	}
+	if !rawTx.IsOwnerSigned("A1") {
+		t.Errorf("MergeSignatures did not merge all signatures")
+	}
+	if sig := rawTx.Signatures["A1"][1].Signature; len(sig) != 130 {
+		t.Errorf("RSV signature = %s, want 65 bytes", sig)
+	}
+
+	//离线主机修改了交易内容
+	_, other := testOfflineRawTransaction(t)
+	other.Account = rawTx.Account
+	other.To = map[string]string{"1BoatSLRHtKNngkdXEeobR76b53LETtpyT": "100"}
+	for i, ks := range other.Signatures["A1"] {
+		ks.Message = rawTx.Signatures["A1"][i].Message
+		ks.Address = rawTx.Signatures["A1"][i].Address
+		ks.Signature = rawTx.Signatures["A1"][i].Signature
+		ks.RSV = rawTx.Signatures["A1"][i].RSV
+	}
+	modified, _ := NewOfflineTxEnvelope(other)
+	modifiedText, _ := modified.Encode()
+	modifiedEnv, _ := DecodeOfflineTxEnvelope(modifiedText)
+	if err = modifiedEnv.MergeSignatures(rawTx); err == nil {
+		t.Errorf("MergeSignatures should fail with modified transaction")
+	}
+
+	//信封内容被篡改，但保留了原摘要
+	var tampered OfflineTxEnvelope
+	data, _ := json.Marshal(signedEnv)
+	json.Unmarshal(data, &tampered)
+	tampered.RawTx.RawHex = "0200000001"
+	tamperedText, _ := tampered.Encode()
+	if _, err = DecodeOfflineTxEnvelope(tamperedText); err == nil {
+		t.Errorf("DecodeOfflineTxEnvelope should fail with tampered transaction")
+	}
+
+	//签名错误
+	json.Unmarshal(data, &tampered)
+	tampered.RawTx.Signatures["A1"][0].Signature = tampered.RawTx.Signatures["A1"][1].Signature[:128]
+	if err = tampered.MergeSignatures(rawTx); err == nil {
+		t.Errorf("MergeSignatures should fail with wrong signature")
+	}
+
+	//校验和错误
+	broken := []byte(signed)
+	broken[20] ^= 0x01
+	if _, err = DecodeOfflineTxEnvelope(string(broken)); err == nil {
+		t.Errorf("DecodeOfflineTxEnvelope should fail with broken text")
+	}
+
+	//其他钱包的密钥不能签名
+	otherKey, _ := testOfflineRawTransaction(t)
+	if err = offline.Sign(otherKey); err == nil {
+		t.Errorf("Sign should fail with other wallet key")
+	}
+}
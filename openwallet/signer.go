@@ -34,4 +34,30 @@ type TransactionSignerBase struct {
 // required
 func (singer *TransactionSignerBase) SignTransactionHash(msg []byte, privateKey []byte, eccType uint32) ([]byte, error) {
 	return nil, fmt.Errorf("SignTransactionHash not implement")
-}
\ No newline at end of file
+}
+
+//SignerProvider 签名提供者，私钥由提供者保管（本地密钥文件，HSM等），调用方只得到签名结果。
+//钱包通过Wallet.Signer选择已注册的提供者，签名时openw不需要解密钱包种子。
+type SignerProvider interface {
+
+	// SignHash 使用钱包hdPath路径的私钥签名消息哈希
+	// @param auth 解锁凭证，本地密钥文件为钱包密码，HSM等已配置凭证的提供者可以忽略
+	// @return signature 签名，v 签名的恢复值，签名需要合并V时使用
+	SignHash(wallet *Wallet, hdPath string, eccType uint32, hash []byte, auth string) (signature []byte, v byte, err error)
+}
+
+//WalletHashSigner 钱包哈希签名，由WalletDAI选择实现。
+//钱包使用签名提供者时HDKey不可用，交易单解析器通过它签名，签名结果可以继续由解析器处理
+type WalletHashSigner interface {
+
+	// SignHash 使用钱包hdPath路径的私钥签名消息哈希
+	// @return signature 签名，v 签名的恢复值，签名需要合并V时使用
+	SignHash(hdPath string, eccType uint32, hash []byte) (signature []byte, v byte, err error)
+}
+
+//SignerProviderDecoder 由交易单解析器选择实现，支持使用签名提供者的钱包。
+//SupportSignerProvider返回true时，钱包使用签名提供者也调用SignRawTransaction，
+//解析器需要通过WalletHashSigner签名，不调用HDKey
+type SignerProviderDecoder interface {
+	SupportSignerProvider() bool
+}
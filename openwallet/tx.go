@@ -19,7 +19,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
 
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/tidwall/gjson"
 )
@@ -56,6 +59,25 @@ type RawTransaction struct {
 	TxTo     []string `json:"txTo"`     //格式："地址":"数量"，备注订单使用
 }
 
+//业务订单号的广播状态
+const (
+	TxSidStateSubmitting = "submitting" //正在广播
+	TxSidStateUnknown    = "unknown"    //广播出错或中断，节点可能已接收交易单，需要按交易单ID核对
+	TxSidStateSubmitted  = "submitted"  //已广播
+)
+
+//TransactionSidRecord 业务订单号与广播交易单的关联记录，同一个业务订单号只广播一次
+type TransactionSidRecord struct {
+	Sid       string `json:"sid" storm:"id"`          //业务订单号，RawTransaction.Sid
+	AccountID string `json:"accountID" storm:"index"` //创建交易单的账户
+	TxID      string `json:"txid"`                    //交易单ID，广播前已知时登记，用于核对广播结果
+	WxID      string `json:"wxid"`                    //广播后的交易单WxID
+	Submitted bool   `json:"submitted"`               //是否已广播
+	State     string `json:"state"`                   //广播状态
+	CreateAt  int64  `json:"createdAt"`               //记录创建时间，正在广播超时用于判断进程中断
+	UpdateAt  int64  `json:"updatedAt"`               //状态更新时间
+}
+
 //KeySignature 签名信息
 type KeySignature struct {
 	EccType   uint32   `json:"eccType"` //曲线类型
@@ -96,6 +118,194 @@ func (rawtx *RawTransaction) GetExtParam() gjson.Result {
 	return gjson.ParseBytes([]byte(rawtx.ExtParam))
 }
 
+//SignatureOwnerKey 签名者在Signatures中的拥有者标识
+//多重签名账户以拥有者公钥标识，其他账户以accountID标识
+func SignatureOwnerKey(account *AssetsAccount) string {
+	if account == nil {
+		return ""
+	}
+	if account.IsMultiSig {
+		return account.PublicKey
+	}
+	return account.AccountID
+}
+
+//IsOwnerSigned 拥有者是否已完成全部签名
+func (rawtx *RawTransaction) IsOwnerSigned(owner string) bool {
+	keySignatures, ok := rawtx.Signatures[owner]
+	if !ok || len(keySignatures) == 0 {
+		return false
+	}
+	for _, ks := range keySignatures {
+		if ks == nil || len(ks.Signature) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//SignedOwners 已完成签名的拥有者列表
+func (rawtx *RawTransaction) SignedOwners() []string {
+	owners := make([]string, 0)
+	for owner := range rawtx.Signatures {
+		if rawtx.IsOwnerSigned(owner) {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+//CheckCompleted 已签名的拥有者数量达到必要签名数，标记IsCompleted
+func (rawtx *RawTransaction) CheckCompleted() bool {
+	required := rawtx.Required
+	if required == 0 {
+		required = 1
+	}
+	rawtx.IsCompleted = uint64(len(rawtx.SignedOwners())) >= required
+	return rawtx.IsCompleted
+}
+
+//MergeSignatures 合并其他来源的签名，以拥有者和被签消息匹配签名位置
+//每个签名都以拥有者公钥校验，全部校验通过后才写入交易单，任一失败交易单保持不变
+func (rawtx *RawTransaction) MergeSignatures(account *AssetsAccount, signatures map[string][]*KeySignature) error {
+
+	if account == nil {
+		return fmt.Errorf("account is nil")
+	}
+
+	merged := make(map[*KeySignature]string)
+
+	for owner, others := range signatures {
+		keySignatures, ok := rawtx.Signatures[owner]
+		if !ok {
+			return fmt.Errorf("owner %s is not in the transaction signatures", owner)
+		}
+
+		for _, other := range others {
+			if other == nil || len(other.Signature) == 0 {
+				continue
+			}
+
+			var target *KeySignature
+			for _, ks := range keySignatures {
+				if ks != nil && ks.Message == other.Message && ks.EccType == other.EccType {
+					target = ks
+					break
+				}
+			}
+
+			if target == nil {
+				return fmt.Errorf("owner %s message %s is not in the transaction signatures", owner, other.Message)
+			}
+
+			signature := target.Signature
+			if pending, exist := merged[target]; exist {
+				signature = pending
+			}
+			if len(signature) > 0 && signature != other.Signature {
+				return fmt.Errorf("owner %s message %s has conflicting signatures", owner, other.Message)
+			}
+
+			//以本地交易单的签名位置校验，不信任来源的地址
+			signed := *target
+			signed.Signature = other.Signature
+			if err := verifyOwnerSignature(account, owner, &signed); err != nil {
+				return err
+			}
+
+			merged[target] = other.Signature
+		}
+	}
+
+	for target, signature := range merged {
+		target.Signature = signature
+	}
+
+	rawtx.CheckCompleted()
+
+	return nil
+}
+
+//VerifySignatures 校验交易单中全部已签名的签名，并重新计算IsCompleted
+func (rawtx *RawTransaction) VerifySignatures(account *AssetsAccount) error {
+
+	if account == nil {
+		return fmt.Errorf("account is nil")
+	}
+
+	for owner, keySignatures := range rawtx.Signatures {
+		for _, ks := range keySignatures {
+			if ks == nil || len(ks.Signature) == 0 {
+				continue
+			}
+			if err := verifyOwnerSignature(account, owner, ks); err != nil {
+				return err
+			}
+		}
+	}
+
+	rawtx.CheckCompleted()
+
+	return nil
+}
+
+//verifyOwnerSignature 以拥有者公钥校验签名
+//多重签名账户的拥有者公钥按地址的change/index衍生，其他账户以账户扩展公钥按地址路径衍生
+func verifyOwnerSignature(account *AssetsAccount, owner string, ks *KeySignature) error {
+
+	if ks.Address == nil {
+		return fmt.Errorf("owner %s key signature address is empty", owner)
+	}
+
+	var (
+		pub []byte
+		err error
+	)
+
+	if account.IsMultiSig {
+		isOwner := false
+		for _, k := range account.OwnerKeys {
+			if k == owner {
+				isOwner = true
+				break
+			}
+		}
+		if !isOwner {
+			return fmt.Errorf("owner %s is not an owner of account: %s", owner, account.AccountID)
+		}
+
+		ownerKey, err := owkeychain.OWDecode(owner)
+		if err != nil {
+			return fmt.Errorf("owner key %s is invalid, unexpected error: %v", owner, err)
+		}
+		changeKey, err := ownerKey.GenPublicChild(uint32(common.BoolToUInt(ks.Address.IsChange)))
+		if err != nil {
+			return err
+		}
+		childKey, err := changeKey.GenPublicChild(uint32(ks.Address.Index))
+		if err != nil {
+			return err
+		}
+		pub = childKey.GetPublicKeyBytes()
+	} else {
+		if owner != SignatureOwnerKey(account) {
+			return fmt.Errorf("owner %s is not an owner of account: %s", owner, account.AccountID)
+		}
+		pub, err = account.DerivePublicKey(ks.Address.HDPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = verifyKeySignature(pub, ks)
+	if err != nil {
+		return fmt.Errorf("owner %s %v", owner, err)
+	}
+
+	return nil
+}
+
 //交易单状态
 const (
 	TxStatusSuccess = "1" //成功
@@ -116,8 +326,8 @@ type Transaction struct {
 	TxType      uint64   `json:"txType"`   // @required 0:转账, 1:合约调用(发生于主链), >100: 自定义，可以在TxAction填说明
 	TxAction    string   `json:"txAction"` // 执行事件, 例如：合约的Transfer事件
 	Confirm     int64    `json:"confirm"`
-	BlockHash   string   `json:"blockHash"`   //@required
-	BlockHeight uint64   `json:"blockHeight"` //@required
+	BlockHash   string   `json:"blockHash"`                 //@required
+	BlockHeight uint64   `json:"blockHeight" storm:"index"` //@required
 	IsMemo      bool     `json:"isMemo"`
 	Memo        string   `json:"memo"` //deprecated, 使用ExtParam扩展
 	Fees        string   `json:"fees"` //@required
@@ -39,6 +39,13 @@ type TransactionDecoder interface {
 	CreateSummaryRawTransactionWithError(wrapper WalletDAI, sumRawTx *SummaryRawTransaction) ([]*RawTransactionWithError, error)
 }
 
+//TransactionMessageVerifier 被签消息校验，由交易单解析器选择实现
+//从RawHex重新计算每个KeySignature.Message并比对，多重签名的拥有者联署签名前调用，避免签署与交易内容不符的消息
+type TransactionMessageVerifier interface {
+	//VerifyTransactionMessages 校验被签消息与RawHex一致
+	VerifyTransactionMessages(wrapper WalletDAI, rawTx *RawTransaction) error
+}
+
 //TransactionDecoderBase 实现TransactionDecoder的基类
 type TransactionDecoderBase struct {
 }
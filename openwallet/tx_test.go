@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+//testCoSigner 多重签名的拥有者，账户扩展公钥为拥有者公钥
+type testCoSigner struct {
+	wallet *Wallet
+	key    *hdkeystore.HDKey
+}
+
+func newTestCoSigner(t *testing.T, walletID, seedHex string) *testCoSigner {
+	seed, _ := hex.DecodeString(seedHex)
+	key, err := hdkeystore.NewHDKey(seed, walletID, hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey failed unexpected error: %v", err)
+	}
+	return &testCoSigner{wallet: testMultiSigWallet(t, walletID, seedHex), key: key}
+}
+
+//sign 以拥有者账户路径/0/0的私钥签名
+func (s *testCoSigner) sign(t *testing.T, msg string) string {
+	childKey, err := s.key.DerivedKeyWithPath(s.wallet.RootPath+"/0/0", owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath failed unexpected error: %v", err)
+	}
+	priv, _ := childKey.GetPrivateKeyBytes()
+	hash, _ := hex.DecodeString(msg)
+	sig, _, ret := owcrypt.Signature(priv, nil, hash, owcrypt.ECC_CURVE_SECP256K1)
+	if ret != owcrypt.SUCCESS {
+		t.Fatalf("Signature failed")
+	}
+	return hex.EncodeToString(sig)
+}
+
+func testPendingRawTransaction(account *AssetsAccount) *RawTransaction {
+	rawTx := &RawTransaction{
+		RawHex:     "0100",
+		Required:   account.Required,
+		IsBuilt:    true,
+		Signatures: make(map[string][]*KeySignature),
+	}
+	for _, owner := range account.OwnerKeys {
+		rawTx.Signatures[owner] = []*KeySignature{{
+			Message: testCoSignMessage,
+			EccType: owcrypt.ECC_CURVE_SECP256K1,
+			Address: &Address{Index: 0},
+		}}
+	}
+	return rawTx
+}
+
+const testCoSignMessage = "3f2c0d9f6b1e4a7c8d5e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c"
+
+func TestRawTransaction_MergeSignatures(t *testing.T) {
+
+	a := newTestCoSigner(t, "w1", "4b68b20a5d3ac671a61e6e94b4de309530a12439b7c3ee548d20966674696656")
+	b := newTestCoSigner(t, "w2", "dfac3098fd7c3cd9b9cdf44e3e1ae912e3d2ce05795a857a53ebff6111b1580b")
+	c := newTestCoSigner(t, "w3", "a6e7b1a2c6d1f6e0c7c9f0b1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1")
+
+	account, err := NewMultiSigAccount([]*Wallet{b.wallet, c.wallet}, 2, a.wallet)
+	if err != nil {
+		t.Fatalf("NewMultiSigAccount failed unexpected error: %v", err)
+	}
+
+	rawTx := testPendingRawTransaction(account)
+	rawTx.Signatures[a.wallet.RootPub][0].Signature = a.sign(t, testCoSignMessage)
+
+	if rawTx.CheckCompleted() {
+		t.Fatalf("one of two required signatures should not be completed")
+	}
+
+	//伪造的签名，校验失败，交易单不变
+	forged := testPendingRawTransaction(account)
+	forged.Signatures[b.wallet.RootPub][0].Signature = c.sign(t, testCoSignMessage)
+	if err = rawTx.MergeSignatures(account, forged.Signatures); err == nil {
+		t.Fatalf("forged signature should fail")
+	}
+	if rawTx.IsOwnerSigned(b.wallet.RootPub) || rawTx.IsCompleted {
+		t.Fatalf("forged signature should not be merged")
+	}
+
+	//部分签名无效，有效的签名也不合并
+	partial := testPendingRawTransaction(account)
+	partial.Signatures[b.wallet.RootPub][0].Signature = b.sign(t, testCoSignMessage)
+	partial.Signatures[c.wallet.RootPub][0].Signature = "3044"
+	if err = rawTx.MergeSignatures(account, partial.Signatures); err == nil {
+		t.Fatalf("invalid signature should fail")
+	}
+	if rawTx.IsOwnerSigned(b.wallet.RootPub) {
+		t.Fatalf("transaction should not be half merged")
+	}
+
+	fromB := testPendingRawTransaction(account)
+	fromB.Signatures[b.wallet.RootPub][0].Signature = b.sign(t, testCoSignMessage)
+	if err = rawTx.MergeSignatures(account, fromB.Signatures); err != nil {
+		t.Fatalf("MergeSignatures failed unexpected error: %v", err)
+	}
+	if len(rawTx.SignedOwners()) != 2 || !rawTx.IsCompleted {
+		t.Errorf("signed owners = %v, completed: %v", rawTx.SignedOwners(), rawTx.IsCompleted)
+	}
+	if err = rawTx.VerifySignatures(account); err != nil || !rawTx.IsCompleted {
+		t.Errorf("VerifySignatures failed unexpected error: %v", err)
+	}
+
+	//同一位置不同签名，视为冲突
+	conflict := testPendingRawTransaction(account)
+	conflict.Signatures[b.wallet.RootPub][0].Signature = b.sign(t, testCoSignMessage) + "00"
+	if err = rawTx.MergeSignatures(account, conflict.Signatures); err == nil {
+		t.Errorf("conflicting signatures should fail")
+	}
+
+	//未知的拥有者
+	unknown := map[string][]*KeySignature{"ownerD": {{Message: testCoSignMessage, Signature: "d1"}}}
+	if err = rawTx.MergeSignatures(account, unknown); err == nil {
+		t.Errorf("unknown owner should fail")
+	}
+
+	//篡改已签名的交易单，广播前校验失败
+	rawTx.Signatures[c.wallet.RootPub][0].Signature = a.sign(t, testCoSignMessage)
+	if err = rawTx.VerifySignatures(account); err == nil {
+		t.Errorf("tampered signature should fail")
+	}
+}
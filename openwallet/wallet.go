@@ -133,6 +133,7 @@ type Wallet struct {
 	IsTrust      bool                `json:"isTrust"`      //是否托管密钥
 	AccountIndex int                 `json:"accountIndex"` //账户索引数，-1代表未创建账户
 	ExtParam     string              `json:"extParam"`     //扩展参数，用于调用智能合约，json结构
+	Signer       string              `json:"signer"`       //签名提供者名称，为空使用钱包密钥文件签名
 	key          *hdkeystore.HDKey   //Deprecated
 	fileName     string              //钱包文件命名，所有与钱包相关的都以这个filename命名
 	core         interface{}         //核心钱包指针 Deprecated
@@ -427,6 +428,10 @@ func GetWalletsByKeyDir(dir string) ([]*Wallet, error) {
 		path := filepath.Join(dir, fi.Name())
 
 		w := ReadWalletByKey(path)
+		if w == nil {
+			log.Warningf("key file: %s can not be read, run wallet audit to check it", path)
+			continue
+		}
 		w.KeyFile = path
 		w.fileName = fileName
 		wallets = append(wallets, w)
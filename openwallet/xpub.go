@@ -0,0 +1,243 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+)
+
+const (
+	owPubPrefix = "owpub"
+
+	//BIP32序列化的扩展公钥长度：version(4) | depth(1) | parentFP(4) | childNumber(4) | chainCode(32) | key(33)
+	xpubSerializedLen = 78
+)
+
+//XPubVersion 扩展公钥BIP32序列化的版本字节
+type XPubVersion struct {
+	MainNet [4]byte //主网版本字节
+	TestNet [4]byte //测试网版本字节
+}
+
+var (
+	//owPubVersion OW编码扩展公钥的前缀字节，与owkeychain一致
+	owPubVersion = []byte{0x07, 0xa8, 0x10, 0x31, 0xa2}
+
+	//xpubVersions 曲线类型对应的版本字节，版本字节需全局唯一，解码时据此确定曲线，
+	//除SECP256K1外没有BIP32标准版本字节，默认值由openwallet定义，与SLIP-0132已登记的版本字节不冲突，
+	//ED25519_NORMAL不支持子密钥衍生，没有默认版本字节
+	xpubVersions = map[uint32]XPubVersion{
+		//BIP32的xpub/tpub
+		owcrypt.ECC_CURVE_SECP256K1: {
+			MainNet: [4]byte{0x04, 0x88, 0xb2, 0x1e},
+			TestNet: [4]byte{0x04, 0x35, 0x87, 0xcf},
+		},
+		owcrypt.ECC_CURVE_SECP256R1: {
+			MainNet: [4]byte{0x04, 0x88, 0xc2, 0x1e},
+			TestNet: [4]byte{0x04, 0x35, 0x97, 0xcf},
+		},
+		owcrypt.ECC_CURVE_SM2_STANDARD: {
+			MainNet: [4]byte{0x04, 0x88, 0xd2, 0x1e},
+			TestNet: [4]byte{0x04, 0x35, 0xa7, 0xcf},
+		},
+		owcrypt.ECC_CURVE_ED25519: {
+			MainNet: [4]byte{0x04, 0x88, 0xe2, 0x1e},
+			TestNet: [4]byte{0x04, 0x35, 0xb7, 0xcf},
+		},
+		owcrypt.ECC_CURVE_X25519: {
+			MainNet: [4]byte{0x04, 0x88, 0xf2, 0x1e},
+			TestNet: [4]byte{0x04, 0x35, 0xc7, 0xcf},
+		},
+	}
+	xpubVersionsMu sync.RWMutex
+)
+
+//RegisterXPubVersion 注册曲线的扩展公钥版本字节，资产适配器可覆盖曲线的默认版本字节，
+//没有注册版本字节的曲线只能使用OW编码（owpub）
+func RegisterXPubVersion(curveType uint32, version XPubVersion) error {
+	xpubVersionsMu.Lock()
+	defer xpubVersionsMu.Unlock()
+
+	for curve, v := range xpubVersions {
+		if curve == curveType {
+			continue
+		}
+		if v.MainNet == version.MainNet || v.TestNet == version.TestNet ||
+			v.MainNet == version.TestNet || v.TestNet == version.MainNet {
+			return fmt.Errorf("xpub version %x is registered by curve %x", version, curve)
+		}
+	}
+	xpubVersions[curveType] = version
+	return nil
+}
+
+//XPubInfo 扩展公钥的解析结果
+type XPubInfo struct {
+	OWPub       string //OW编码的扩展公钥，可直接作为AssetsAccount.PublicKey
+	CurveType   uint32 //曲线类型
+	Depth       uint8  //衍生深度
+	ChildNumber uint32 //衍生序号，强化衍生大于等于0x80000000
+	IsTestNet   bool   //是否测试网版本字节
+}
+
+//EncodeXPub 把OW编码的扩展公钥导出为BIP32序列化的扩展公钥，版本字节按曲线类型选择
+func EncodeXPub(owpub string, isTestNet bool) (string, error) {
+
+	data, err := decodeOWPub(owpub)
+	if err != nil {
+		return "", err
+	}
+
+	curveType := binary.BigEndian.Uint32(data[:4])
+
+	xpubVersionsMu.RLock()
+	version, ok := xpubVersions[curveType]
+	xpubVersionsMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("curve %x has no registered xpub version, use owpub instead", curveType)
+	}
+
+	prefix := version.MainNet
+	if isTestNet {
+		prefix = version.TestNet
+	}
+
+	//OW编码去掉曲线类型后，与BIP32序列化的内容一致
+	return owkeychain.Base58checkEncode(data[4:], prefix[:]), nil
+}
+
+//ParseXPub 解析BIP32序列化的扩展公钥或OW编码的扩展公钥
+func ParseXPub(xpub string) (*XPubInfo, error) {
+
+	xpub = strings.TrimSpace(xpub)
+
+	if strings.HasPrefix(xpub, owPubPrefix) {
+		data, err := decodeOWPub(xpub)
+		if err != nil {
+			return nil, err
+		}
+		return newXPubInfo(xpub, data[:4], data[4:], false), nil
+	}
+
+	raw, err := owkeychain.Decode(xpub, owkeychain.BitcoinAlphabet)
+	if err != nil || len(raw) != xpubSerializedLen+4 {
+		return nil, fmt.Errorf("xpub is invalid")
+	}
+
+	var prefix [4]byte
+	copy(prefix[:], raw[:4])
+
+	var (
+		curveType uint32
+		isTestNet bool
+		found     bool
+	)
+	xpubVersionsMu.RLock()
+	for curve, v := range xpubVersions {
+		if v.MainNet == prefix || v.TestNet == prefix {
+			curveType, isTestNet, found = curve, v.TestNet == prefix, true
+			break
+		}
+	}
+	xpubVersionsMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("xpub version %x is not supported", prefix)
+	}
+
+	data, err := owkeychain.Base58checkDecode(xpub, prefix[:])
+	if err != nil {
+		return nil, fmt.Errorf("xpub checksum is invalid")
+	}
+
+	curve := make([]byte, 4)
+	binary.BigEndian.PutUint32(curve, curveType)
+
+	//重新编码为OW格式，并用OWDecode校验公钥格式
+	owpub := owkeychain.Base58checkEncode(append(curve, data...), owPubVersion)
+	if _, err := owkeychain.OWDecode(owpub); err != nil {
+		return nil, fmt.Errorf("xpub is invalid")
+	}
+
+	return newXPubInfo(owpub, curve, data, isTestNet), nil
+}
+
+//CheckPath 检查衍生路径的深度和最后一级序号是否与扩展公钥一致
+func (info *XPubInfo) CheckPath(hdPath string) error {
+
+	path := strings.Replace(hdPath, " ", "", -1)
+	if path != "m" && !strings.HasPrefix(path, "m/") {
+		return fmt.Errorf("hdPath: %s is invalid", hdPath)
+	}
+
+	var (
+		depth       int
+		childNumber uint32
+	)
+	if path != "m" {
+		elements := strings.Split(path[2:], "/")
+		for _, elem := range elements {
+			hardened := strings.HasSuffix(elem, "'")
+			index, err := strconv.ParseUint(strings.TrimSuffix(elem, "'"), 10, 31)
+			if err != nil {
+				return fmt.Errorf("hdPath: %s is invalid", hdPath)
+			}
+			childNumber = uint32(index)
+			if hardened {
+				childNumber += owkeychain.HardenedKeyStart
+			}
+		}
+		depth = len(elements)
+	}
+
+	if depth != int(info.Depth) || childNumber != info.ChildNumber {
+		return fmt.Errorf("hdPath: %s is not match xpub depth: %d, child number: %d", hdPath, info.Depth, info.ChildNumber)
+	}
+	return nil
+}
+
+//newXPubInfo data为BIP32序列化去掉版本字节的内容
+func newXPubInfo(owpub string, curve, data []byte, isTestNet bool) *XPubInfo {
+	return &XPubInfo{
+		OWPub:       owpub,
+		CurveType:   binary.BigEndian.Uint32(curve),
+		Depth:       data[0],
+		ChildNumber: binary.BigEndian.Uint32(data[5:9]),
+		IsTestNet:   isTestNet,
+	}
+}
+
+//decodeOWPub 解码OW编码的扩展公钥，返回去掉前缀和校验和的内容：curveType(4) | BIP32序列化去掉版本字节的内容
+func decodeOWPub(owpub string) ([]byte, error) {
+
+	raw, err := owkeychain.Decode(owpub, owkeychain.BitcoinAlphabet)
+	if err != nil || len(raw) != len(owPubVersion)+4+xpubSerializedLen-4+4 {
+		return nil, fmt.Errorf("owpub is invalid")
+	}
+
+	data, err := owkeychain.Base58checkDecode(owpub, owPubVersion)
+	if err != nil {
+		return nil, fmt.Errorf("owpub is invalid, unexpected error: %v", err)
+	}
+
+	return data, nil
+}
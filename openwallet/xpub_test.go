@@ -0,0 +1,141 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+)
+
+func TestParseXPub(t *testing.T) {
+
+	//BIP32 test vector 1: m/0H 和 m/0H/1
+	xpub := "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+	childXPub := "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ"
+
+	info, err := ParseXPub(xpub)
+	if err != nil {
+		t.Fatalf("ParseXPub failed unexpected error: %v", err)
+	}
+	if info.CurveType != owcrypt.ECC_CURVE_SECP256K1 || info.Depth != 1 || info.ChildNumber != owkeychain.HardenedKeyStart || info.IsTestNet {
+		t.Errorf("ParseXPub = %+v", info)
+	}
+
+	exported, err := EncodeXPub(info.OWPub, false)
+	if err != nil || exported != xpub {
+		t.Errorf("EncodeXPub = %s, err: %v", exported, err)
+	}
+
+	//OW编码的扩展公钥衍生的子公钥与BIP32一致
+	key, err := owkeychain.OWDecode(info.OWPub)
+	if err != nil {
+		t.Fatalf("OWDecode failed unexpected error: %v", err)
+	}
+	child, err := key.GenPublicChild(1)
+	if err != nil {
+		t.Fatalf("GenPublicChild failed unexpected error: %v", err)
+	}
+	exported, err = EncodeXPub(child.OWEncode(), false)
+	if err != nil || exported != childXPub {
+		t.Errorf("EncodeXPub child = %s, err: %v", exported, err)
+	}
+
+	tpub, err := EncodeXPub(info.OWPub, true)
+	if err != nil {
+		t.Fatalf("EncodeXPub testnet failed unexpected error: %v", err)
+	}
+	testInfo, err := ParseXPub(tpub)
+	if err != nil || !testInfo.IsTestNet || testInfo.OWPub != info.OWPub {
+		t.Errorf("ParseXPub testnet = %+v, err: %v", testInfo, err)
+	}
+
+	//OW编码直接解析
+	owInfo, err := ParseXPub(info.OWPub)
+	if err != nil || owInfo.OWPub != info.OWPub || owInfo.Depth != 1 {
+		t.Errorf("ParseXPub owpub = %+v, err: %v", owInfo, err)
+	}
+
+	//扩展私钥不能作为扩展公钥
+	xprv := "xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"
+	if _, err = ParseXPub(xprv); err == nil {
+		t.Errorf("ParseXPub should fail with xprv")
+	}
+	if _, err = ParseXPub(xpub[:len(xpub)-1] + "x"); err == nil {
+		t.Errorf("ParseXPub should fail with bad checksum")
+	}
+
+	//支持衍生的曲线都有默认版本字节
+	seed := make([]byte, 32)
+	for _, curve := range []uint32{owcrypt.ECC_CURVE_SECP256K1, owcrypt.ECC_CURVE_SECP256R1, owcrypt.ECC_CURVE_SM2_STANDARD, owcrypt.ECC_CURVE_ED25519, owcrypt.ECC_CURVE_X25519} {
+		key, err := owkeychain.DerivedPrivateKeyWithPath(seed, "m/44'/88'/1'", curve)
+		if err != nil {
+			t.Fatalf("DerivedPrivateKeyWithPath %x failed unexpected error: %v", curve, err)
+		}
+		owpub := key.GetPublicKey().OWEncode()
+		for _, isTestNet := range []bool{false, true} {
+			exported, err := EncodeXPub(owpub, isTestNet)
+			if err != nil {
+				t.Fatalf("EncodeXPub %x failed unexpected error: %v", curve, err)
+			}
+			curveInfo, err := ParseXPub(exported)
+			if err != nil || curveInfo.CurveType != curve || curveInfo.OWPub != owpub || curveInfo.IsTestNet != isTestNet {
+				t.Errorf("ParseXPub %x = %+v, err: %v", curve, curveInfo, err)
+			}
+		}
+	}
+
+	//没有注册版本字节的曲线只能使用owpub
+	normalKey := owkeychain.NewExtendedKey(make([]byte, 33), make([]byte, 32), make([]byte, 4), 3, owkeychain.HardenedKeyStart+1, false, owcrypt.ECC_CURVE_ED25519_NORMAL)
+	if _, err = EncodeXPub(normalKey.OWEncode(), false); err == nil {
+		t.Errorf("EncodeXPub should fail with unregistered curve")
+	}
+	if err = RegisterXPubVersion(owcrypt.ECC_CURVE_ED25519_NORMAL, XPubVersion{MainNet: [4]byte{0x04, 0x88, 0xb2, 0x1e}}); err == nil {
+		t.Errorf("RegisterXPubVersion should fail with registered version")
+	}
+}
+
+func TestXPubInfo_CheckPath(t *testing.T) {
+
+	info := &XPubInfo{Depth: 3, ChildNumber: owkeychain.HardenedKeyStart + 1}
+
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"m/44'/88'/1'", true},
+		{" m/44'/88'/1' ", true},
+		{"m/44'/88'/1", false},
+		{"m/44'/88'/0'", false},
+		{"m/44'/1'", false},
+		{"m/44'/88'/2'/1'", false},
+		{"m", false},
+		{"44'/88'/1'", false},
+		{"m/44'/x/1'", false},
+		{"m/44'//1'", false},
+	}
+	for _, test := range tests {
+		if err := info.CheckPath(test.path); (err == nil) != test.valid {
+			t.Errorf("CheckPath(%s) = %v, want valid: %v", test.path, err, test.valid)
+		}
+	}
+
+	root := &XPubInfo{}
+	if err := root.CheckPath("m"); err != nil {
+		t.Errorf("CheckPath(m) of root xpub failed, unexpected error: %v", err)
+	}
+}
@@ -24,7 +24,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/mr-tron/base58/base58"
@@ -223,6 +222,8 @@ type OWTPAuth struct {
 	localPrivateKey []byte
 	//远程节点的公钥
 	remotePublicKey []byte
+	//主动连接的远程节点ID，对方公钥未知时，用于校验对方推送的请求
+	remoteID string
 	//是否开启
 	enable bool
 	//是否协商
@@ -254,6 +255,9 @@ func NewOWTPAuthWithCertificate(cert Certificate, enable bool) (*OWTPAuth, error
 
 // RemotePID 远程节点ID
 func (auth *OWTPAuth) RemotePID() string {
+	if len(auth.remotePublicKey) == 0 && len(auth.remoteID) > 0 {
+		return auth.remoteID
+	}
 	//nodeID := crypto.SHA256(auth.remotePublicKey)
 	nodeID := owcrypt.Hash(auth.remotePublicKey, 0, owcrypt.HASH_ALG_SHA256)
 	return base58.Encode(nodeID)
@@ -273,8 +277,11 @@ func (auth *OWTPAuth) LocalPID() string {
 func (auth *OWTPAuth) GenerateSignature(data *DataPacket) bool {
 	if auth.EnableAuth() {
 		pub := base58.Encode(auth.localPublicKey)
-		//给数据包生成签名
-		dataString := common.NewString(data.Data)
+		//给数据包生成签名，签名内容与接收方解析的数据主体一致，与数据包编码无关
+		dataString, err := packetDataString(data.Data)
+		if err != nil {
+			return false
+		}
 		plainText := fmt.Sprintf("%d%s%d%d%s", data.Req, data.Method, data.Nonce, data.Timestamp, dataString)
 		hash := owcrypt.Hash([]byte(plainText), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
 		nodeID := owcrypt.Hash(auth.localPublicKey, 0, owcrypt.HASH_ALG_SHA256)
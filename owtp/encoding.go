@@ -0,0 +1,264 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 数据包编码
+const (
+	EncodingJSON     = "json"     //默认
+	EncodingProtobuf = "protobuf" //二进制编码，结构定义在packet.proto
+)
+
+// 数据包编码的Content-Type，HTTP和MQ通过它识别数据包的编码
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// EncodingHeader websocket连接时，发起方在HTTP头部声明数据包编码
+const EncodingHeader = "e"
+
+// protobuf字段编号，与packet.proto一致
+const (
+	packetFieldReq        protowire.Number = 1
+	packetFieldMethod     protowire.Number = 2
+	packetFieldNonce      protowire.Number = 3
+	packetFieldTimestamp  protowire.Number = 4
+	packetFieldData       protowire.Number = 5
+	packetFieldSignature  protowire.Number = 6
+	packetFieldSecretData protowire.Number = 7
+	packetFieldVersion    protowire.Number = 8
+)
+
+// normalizeEncoding 检查编码名，空为JSON
+func normalizeEncoding(encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "", EncodingJSON:
+		return EncodingJSON, nil
+	case EncodingProtobuf:
+		return EncodingProtobuf, nil
+	}
+	return "", fmt.Errorf("unsupported data packet encoding: %s", encoding)
+}
+
+// encodingContentType 编码对应的Content-Type
+func encodingContentType(encoding string) string {
+	if encoding == EncodingProtobuf {
+		return ContentTypeProtobuf
+	}
+	return ContentTypeJSON
+}
+
+// contentTypeEncoding 通过Content-Type识别编码，无法识别的视为JSON
+func contentTypeEncoding(contentType string) string {
+	if strings.HasPrefix(strings.ToLower(contentType), ContentTypeProtobuf) {
+		return EncodingProtobuf
+	}
+	return EncodingJSON
+}
+
+// packetDataString 数据主体的文本，与JSON编码时接收方解析得到的d一致：
+// 字符串（加密后的密文）保持原样，其他类型为JSON文本，空值为空字符串。
+// 签名和protobuf编码都使用它，保证两种编码的签名内容相同。
+func packetDataString(data interface{}) (string, error) {
+	switch v := data.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	if string(b) == "null" {
+		return "", nil
+	}
+	return string(b), nil
+}
+
+// EncodeDataPacket 按编码序列化数据包
+func EncodeDataPacket(packet *DataPacket, encoding string) ([]byte, error) {
+
+	encoding, err := normalizeEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoding == EncodingJSON {
+		return json.Marshal(packet)
+	}
+
+	data, err := packetDataString(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = appendVarintField(b, packetFieldReq, packet.Req)
+	b = appendStringField(b, packetFieldMethod, packet.Method)
+	b = appendVarintField(b, packetFieldNonce, packet.Nonce)
+	b = appendVarintField(b, packetFieldTimestamp, uint64(packet.Timestamp))
+	b = appendStringField(b, packetFieldData, data)
+	b = appendStringField(b, packetFieldSignature, packet.Signature)
+	if secret := encodeSecretData(&packet.SecretData); len(secret) > 0 {
+		b = protowire.AppendTag(b, packetFieldSecretData, protowire.BytesType)
+		b = protowire.AppendBytes(b, secret)
+	}
+	b = appendVarintField(b, packetFieldVersion, uint64(packet.Version))
+
+	return b, nil
+}
+
+// DecodeDataPacket 按编码解析数据包，数据主体d解析为字符串，与NewDataPacket一致
+func DecodeDataPacket(b []byte, encoding string) (*DataPacket, error) {
+
+	encoding, err := normalizeEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoding == EncodingJSON {
+		if !gjson.ValidBytes(b) {
+			return nil, fmt.Errorf("data packet is not valid json")
+		}
+		return NewDataPacket(gjson.ParseBytes(b)), nil
+	}
+
+	dp := &DataPacket{Data: ""}
+	err = consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, s []byte) error {
+		switch num {
+		case packetFieldReq:
+			dp.Req = v
+		case packetFieldMethod:
+			dp.Method = string(s)
+		case packetFieldNonce:
+			dp.Nonce = v
+		case packetFieldTimestamp:
+			dp.Timestamp = int64(v)
+		case packetFieldData:
+			dp.Data = string(s)
+		case packetFieldSignature:
+			dp.Signature = string(s)
+		case packetFieldSecretData:
+			return decodeSecretData(s, &dp.SecretData)
+		case packetFieldVersion:
+			dp.Version = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dp, nil
+}
+
+// encodeSecretData 协商密码数据包，字段编号按json标签的顺序
+func encodeSecretData(sd *SecretData) []byte {
+	var b []byte
+	for i, s := range secretDataFields(sd) {
+		b = appendStringField(b, protowire.Number(i+1), *s)
+	}
+	return b
+}
+
+// decodeSecretData 解析协商密码数据包
+func decodeSecretData(b []byte, sd *SecretData) error {
+	fields := secretDataFields(sd)
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, s []byte) error {
+		if num >= 1 && int(num) <= len(fields) {
+			*fields[num-1] = string(s)
+		}
+		return nil
+	})
+}
+
+// secretDataFields 协商密码数据包的字段，顺序即protobuf字段编号
+func secretDataFields(sd *SecretData) []*string {
+	return []*string{
+		&sd.PublicKeyInitiator,
+		&sd.TmpPublicKeyInitiator,
+		&sd.EncryptType,
+		&sd.PublicKeyResponder,
+		&sd.TmpPublicKeyResponder,
+		&sd.SB,
+		&sd.SA,
+		&sd.S2,
+	}
+}
+
+// appendVarintField proto3默认值不编码
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendStringField proto3默认值不编码
+func appendStringField(b []byte, num protowire.Number, s string) []byte {
+	if len(s) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// consumeFields 逐个解析字段，varint字段传入v，bytes字段传入s，其他类型和未知字段跳过
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, s []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("data packet is not valid protobuf: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var (
+			v uint64
+			s []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			s, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("data packet is not valid protobuf: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+		if err := fn(num, typ, v, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
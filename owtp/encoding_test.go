@@ -0,0 +1,125 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDataPacketEncoding(t *testing.T) {
+
+	packet := DataPacket{
+		Req:       WSRequest,
+		Method:    "syncAddress",
+		Nonce:     1234567890123,
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"addresses": []string{"addr1", "addr2"},
+			"symbol":    "BTC",
+		},
+		Version: CurrentDataPacketVersion,
+		SecretData: SecretData{
+			PublicKeyInitiator: "pk",
+			EncryptType:        "aes",
+			SA:                 "sa",
+			S2:                 "s2",
+		},
+	}
+
+	auth, _ := NewOWTPAuthWithCertificate(NewRandomCertificate(), true)
+	if !auth.GenerateSignature(&packet) {
+		t.Fatalf("GenerateSignature failed")
+	}
+
+	var decoded []*DataPacket
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf} {
+		b, err := EncodeDataPacket(&packet, encoding)
+		if err != nil {
+			t.Fatalf("EncodeDataPacket %s failed, unexpected error: %v", encoding, err)
+		}
+		dp, err := DecodeDataPacket(b, encoding)
+		if err != nil {
+			t.Fatalf("DecodeDataPacket %s failed, unexpected error: %v", encoding, err)
+		}
+		decoded = append(decoded, dp)
+	}
+
+	//两种编码解析的数据包一致，签名都能校验
+	jsonPacket, pbPacket := decoded[0], decoded[1]
+	if *jsonPacket != *pbPacket {
+		t.Errorf("protobuf packet = %+v, want %+v", pbPacket, jsonPacket)
+	}
+	for _, dp := range decoded {
+		verifier := &OWTPAuth{remotePublicKey: auth.localPublicKey, enable: true}
+		if !verifier.VerifySignature(dp) {
+			t.Errorf("VerifySignature failed: %+v", dp)
+		}
+	}
+
+	if _, err := DecodeDataPacket([]byte{0x0a, 0xff}, EncodingProtobuf); err == nil {
+		t.Errorf("DecodeDataPacket should fail with truncated data")
+	}
+	if _, err := EncodeDataPacket(&packet, "xml"); err == nil {
+		t.Errorf("EncodeDataPacket should fail with unsupported encoding")
+	}
+}
+
+func TestDataPacketEncodingCall(t *testing.T) {
+
+	host := RandomOWTPNode()
+	defer host.Close()
+	host.HandleFunc("echo", func(ctx *Context) {
+		ctx.Response(map[string]interface{}{
+			"name":     ctx.Params().Get("name").String(),
+			"encoding": ctx.Peer.ConnectConfig().Encoding,
+		}, StatusSuccess, "success")
+	})
+
+	configs := []ConnectConfig{
+		{Address: "127.0.0.1:8431", ConnectType: Websocket, EnableSignature: true},
+		{Address: "127.0.0.1:8432", ConnectType: HTTP, EnableSignature: true},
+	}
+	for _, config := range configs {
+		if err := host.Listen(config); err != nil {
+			t.Fatalf("Listen failed, unexpected error: %v", err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	//每个连接由发起方选择编码，服务端同时支持两种编码
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf} {
+		for _, config := range configs {
+			client := RandomOWTPNode()
+			config.Encoding = encoding
+			config.EnableKeyAgreement = config.ConnectType == Websocket
+			if _, err := client.Connect(host.NodeID(), config); err != nil {
+				t.Fatalf("%s %s Connect failed, unexpected error: %v", config.ConnectType, encoding, err)
+			}
+
+			resp, err := client.CallSync(host.NodeID(), "echo", map[string]interface{}{"name": "chance"})
+			if err != nil {
+				t.Fatalf("%s %s CallSync failed, unexpected error: %v", config.ConnectType, encoding, err)
+			}
+			result := resp.JsonData()
+			if resp.Status != StatusSuccess || result.Get("name").String() != "chance" ||
+				result.Get("encoding").String() != encoding {
+				t.Errorf("%s %s response = %+v", config.ConnectType, encoding, resp)
+			}
+			client.Close()
+		}
+	}
+}
@@ -16,7 +16,6 @@
 package owtp
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -29,7 +28,6 @@ import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/imroc/req"
 	"github.com/mr-tron/base58/base58"
-	"github.com/tidwall/gjson"
 )
 
 const (
@@ -112,6 +110,8 @@ func NewHTTPClientWithHeader(responseWriter http.ResponseWriter, request *http.R
 		| t        | uint32 | 1528520843 | 时间戳。限制请求在特定时间范围内有效，如10分钟。                                     |
 		| c        | string | 是         | 协商密码，生成的密钥类别，aes-128-ctr，aes-128-cbc，aes-256-ecb等，为空不进行协商      |
 		| s        | string | 是         | 组合[a+n+t]并sha256两次，使用钱包工具配置的本地私钥签名，最后base58编码             |
+
+		数据包编码通过Content-Type识别：application/x-protobuf为protobuf，其他为json，响应使用相同的编码。
 	*/
 
 	var (
@@ -160,6 +160,10 @@ func NewHTTPClientWithHeader(responseWriter http.ResponseWriter, request *http.R
 	//}
 
 	client, err := NewHTTPClient(auth.RemotePID(), responseWriter, request, hander, auth)
+	if err != nil {
+		return nil, err
+	}
+	client.config.Encoding = contentTypeEncoding(header.Get("Content-Type"))
 
 	return client, nil
 }
@@ -282,7 +286,13 @@ func (c *HTTPClient) sendHTTPRequest(data DataPacket) error {
 		return errors.New("API url is not setup. ")
 	}
 
-	r, err := c.httpClient.Post(c.baseURL, req.BodyJSON(&data), req.Header(c.authHeader))
+	body, err := EncodeDataPacket(&data, c.config.Encoding)
+	if err != nil {
+		return err
+	}
+
+	contentType := req.Header{"Content-Type": encodingContentType(c.config.Encoding)}
+	r, err := c.httpClient.Post(c.baseURL, body, contentType, req.Header(c.authHeader))
 
 	if Debug {
 		log.Std.Info("%+v", r)
@@ -300,9 +310,11 @@ func (c *HTTPClient) sendHTTPRequest(data DataPacket) error {
 		return fmt.Errorf("%s", r.Response().Status)
 	}
 
-	resp := gjson.ParseBytes(r.Bytes())
-
-	packet := NewDataPacket(resp)
+	//响应的编码与请求一致，通过Content-Type识别
+	packet, err := DecodeDataPacket(r.Bytes(), contentTypeEncoding(r.Response().Header.Get("Content-Type")))
+	if err != nil {
+		return err
+	}
 
 	//有可能存在数据已返回，上层才添加请求
 	go c.handler.OnPeerNewDataPacketReceived(c, packet)
@@ -320,7 +332,7 @@ func (c *HTTPClient) openPipe() error {
 
 // writeResponse 输出数据
 func (c *HTTPClient) writeResponse(data DataPacket) error {
-	respBytes, err := json.Marshal(data)
+	respBytes, err := EncodeDataPacket(&data, c.config.Encoding)
 	if err != nil {
 		return err
 	}
@@ -333,7 +345,7 @@ func (c *HTTPClient) writeResponse(data DataPacket) error {
 		return fmt.Errorf("responseWriter is nil")
 	}
 	w := c.responseWriter
-	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Content-type", encodingContentType(c.config.Encoding))
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	w.Header().Set("Access-Control-Allow-Origin", c.request.Header.Get("Origin"))
 
@@ -361,7 +373,10 @@ func (c *HTTPClient) HandleRequest() error {
 		return fmt.Errorf("body is empty")
 	}
 
-	packet := NewDataPacket(gjson.ParseBytes(s))
+	packet, err := DecodeDataPacket(s, c.config.Encoding)
+	if err != nil {
+		return err
+	}
 
 	//转交给处理器处理数据包
 	c.handler.OnPeerNewDataPacketReceived(c, packet)
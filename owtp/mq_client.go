@@ -16,13 +16,11 @@
 package owtp
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/gorilla/websocket"
 	"github.com/streadway/amqp"
-	"github.com/tidwall/gjson"
 	"net"
 	"sync"
 )
@@ -182,7 +180,7 @@ func (c *MQClient) RemoteAddr() net.Addr {
 //Send 发送消息
 func (c *MQClient) send(data DataPacket) error {
 
-	respBytes, err := json.Marshal(data)
+	respBytes, err := EncodeDataPacket(&data, c.config.Encoding)
 	if err != nil {
 		return err
 	}
@@ -240,8 +238,13 @@ func (c *MQClient) write(mt int, message []byte) error {
 	}
 	exchange := c.ConnectConfig().Exchange
 	queueName := c.ConnectConfig().WriteQueueName
+	//json编码保持原来的text/plain，接收方通过ContentType识别编码
+	contentType := "text/plain"
+	if c.ConnectConfig().Encoding == EncodingProtobuf {
+		contentType = ContentTypeProtobuf
+	}
 	err := c.channel.Publish(exchange, queueName, false, false, amqp.Publishing{
-		ContentType: "text/plain",
+		ContentType: contentType,
 		Body:        []byte(message),
 	})
 	return err
@@ -275,7 +278,11 @@ func (c *MQClient) readPump() {
 	go func() {
 		//fmt.Println(*msgs)
 		for d := range messages {
-			packet := NewDataPacket(gjson.ParseBytes(d.Body))
+			packet, err := DecodeDataPacket(d.Body, contentTypeEncoding(d.ContentType))
+			if err != nil {
+				log.Error("peer:", c.PID(), "decode data packet unexpected error: ", err)
+				continue
+			}
 			fmt.Printf("packet：%s", string(d.Body))
 			//开一个goroutine处理消息
 			go c.handler.OnPeerNewDataPacketReceived(c, packet)
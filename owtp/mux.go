@@ -23,13 +23,16 @@ import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
+	"sort"
 	"sync"
 	"time"
 )
 
 const (
-	WSRequest  = 1 //请求标识
-	WSResponse = 2 //响应标识
+	WSRequest   = 1 //请求标识
+	WSResponse  = 2 //响应标识
+	WSStream    = 3 //数据流块标识，数据流响应的数据块
+	WSStreamAck = 4 //数据流确认标识，接收方确认已处理的数据块
 )
 
 const (
@@ -62,6 +65,17 @@ type requestEntry struct {
 	h        RequestFunc
 	respChan chan Response
 	time     int64
+	params   interface{} //请求参数，断线重连后重发请求使用
+	stream   bool        //是否数据流请求，数据流请求不重发
+}
+
+//callback 返回响应结果给调用方
+func (r requestEntry) callback(resp Response) {
+	if r.sync {
+		r.respChan <- resp
+	} else {
+		r.h(resp)
+	}
 }
 
 type Response struct {
@@ -99,6 +113,8 @@ type Context struct {
 	Peer Peer
 	//数据包版本
 	Version int64
+	//节点，数据流响应使用
+	node *OWTPNode
 }
 
 //NewContext
@@ -192,6 +208,14 @@ type ServeMux struct {
 	peerRequestCache cache.Cache
 	//请求nonce的市场限制
 	requestNonceLimit time.Duration
+	//限流的锁
+	limitMu sync.Mutex
+	//请求限流配置
+	rateLimit RateLimitConfig
+	//令牌桶的到期时间，节点ID -> 令牌桶key
+	limitExpire map[string]map[string]time.Time
+	//上次清理到期令牌桶的时间
+	limitSweepAt time.Time
 }
 
 func NewServeMux(timeoutSEC int) *ServeMux {
@@ -255,6 +279,17 @@ func (mux *ServeMux) handleFunc(method string, handler HandlerFunc, inner bool)
 //@param respChan 同步请求的响应通道
 //@param sync 是否同步
 func (mux *ServeMux) AddRequest(peer Peer, nonce uint64, time int64, method string, reqFunc RequestFunc, respChan chan Response, sync bool) error {
+	return mux.addRequest(peer, nonce, requestEntry{
+		sync:     sync,
+		method:   method,
+		h:        reqFunc,
+		respChan: respChan,
+		time:     time,
+	})
+}
+
+//addRequest 添加请求到队列
+func (mux *ServeMux) addRequest(peer Peer, nonce uint64, entry requestEntry) error {
 
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
@@ -280,7 +315,7 @@ func (mux *ServeMux) AddRequest(peer Peer, nonce uint64, time int64, method stri
 		return errors.New("OWTP: nonce exist. ")
 	}
 
-	requestQueue[nonce] = requestEntry{sync, method, reqFunc, respChan, time}
+	requestQueue[nonce] = entry
 
 	mux.peerRequest[pid] = requestQueue
 	return nil
@@ -306,29 +341,62 @@ func (mux *ServeMux) RemoveRequest(pid string, nonce uint64) error {
 	return nil
 }
 
+//touchRequest 更新请求时间，数据流收到数据块时调用，避免传输中的数据流请求超时
+func (mux *ServeMux) touchRequest(pid string, nonce uint64) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	requestQueue := mux.peerRequest[pid]
+	if requestQueue == nil {
+		return
+	}
+
+	if r, exist := requestQueue[nonce]; exist {
+		r.time = time.Now().Unix()
+		requestQueue[nonce] = r
+	}
+}
+
 //ResetRequestQueue 重置请求队列
 func (mux *ServeMux) ResetRequestQueue(pid string) {
+	mux.resetRequestQueue(pid, nil)
+}
+
+//resetRequestQueue 重置请求队列，keep返回true的请求不返回异常，按发起顺序返回，由调用方重发
+func (mux *ServeMux) resetRequestQueue(pid string, keep func(r requestEntry) bool) []requestEntry {
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
 
 	requestQueue := mux.peerRequest[pid]
 
 	if requestQueue == nil {
-		return
+		return nil
 	}
 
+	keepNonces := make([]uint64, 0)
+	keepEntries := make(map[uint64]requestEntry)
+
 	//处理所有未完成的请求，返回连接断开的异常
 	for n, r := range requestQueue {
-		resp := responseError("network disconnected", ErrNetworkDisconnected)
-		if r.sync {
-			r.respChan <- resp
+		if keep != nil && keep(r) {
+			keepNonces = append(keepNonces, n)
+			keepEntries[n] = r
 		} else {
-			r.h(resp)
+			r.callback(responseError("network disconnected", ErrNetworkDisconnected))
 		}
 		delete(requestQueue, n)
 	}
 
 	mux.peerRequest[pid] = requestQueue
+
+	//nonce是递增的，按nonce排序即发起顺序
+	sort.Slice(keepNonces, func(i, j int) bool { return keepNonces[i] < keepNonces[j] })
+	kept := make([]requestEntry, 0, len(keepNonces))
+	for _, n := range keepNonces {
+		kept = append(kept, keepEntries[n])
+	}
+
+	return kept
 }
 
 //ServeOWTP OWTP协议消息监听方法
@@ -340,6 +408,9 @@ func (mux *ServeMux) ServeOWTP(pid string, ctx *Context) {
 		//重复攻击检查
 		if !mux.checkNonceReplay(ctx) {
 			log.Error("nonce duplicate: ", ctx)
+		} else if !mux.checkRateLimit(ctx) {
+			//超过限流，拒绝服务
+			log.Warningf("peer: %s call method: %s is rate limited", ctx.PID, ctx.Method)
 		} else {
 			f, ok := mux.m[ctx.Method]
 
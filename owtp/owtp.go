@@ -93,25 +93,30 @@ var (
 
 // 节点主配置 作为json解析工具
 type ConnectConfig struct {
-	Address            string `json:"address"`            //@required 连接IP地址
-	ConnectType        string `json:"connectType"`        //@required 连接方式
-	EnableSignature    bool   `json:"enableSignature"`    //是否开启owtp协议内签名，防重放
-	Account            string `json:"account"`            //mq账户名
-	Password           string `json:"password"`           //mq账户密码
-	Exchange           string `json:"exchange"`           //mq需要字段
-	WriteQueueName     string `json:"writeQueueName"`     //mq写入通道名
-	ReadQueueName      string `json:"readQueueName"`      //mq读取通道名
-	EnableSSL          bool   `json:"enableSSL"`          //是否开启链接SSL，https，wss
-	ReadBufferSize     int    `json:"readBufferSize"`     //socket读取缓存
-	WriteBufferSize    int    `json:"writeBufferSize"`    //socket写入缓存
-	EnableKeyAgreement bool   `json:"enableKeyAgreement"` //是否开启协商密码
+	Address            string          `json:"address"`            //@required 连接IP地址
+	ConnectType        string          `json:"connectType"`        //@required 连接方式
+	EnableSignature    bool            `json:"enableSignature"`    //是否开启owtp协议内签名，防重放
+	Account            string          `json:"account"`            //mq账户名
+	Password           string          `json:"password"`           //mq账户密码
+	Exchange           string          `json:"exchange"`           //mq需要字段
+	WriteQueueName     string          `json:"writeQueueName"`     //mq写入通道名
+	ReadQueueName      string          `json:"readQueueName"`      //mq读取通道名
+	EnableSSL          bool            `json:"enableSSL"`          //是否开启链接SSL，https，wss
+	ReadBufferSize     int             `json:"readBufferSize"`     //socket读取缓存
+	WriteBufferSize    int             `json:"writeBufferSize"`    //socket写入缓存
+	EnableKeyAgreement bool            `json:"enableKeyAgreement"` //是否开启协商密码
+	Encoding           string          `json:"encoding"`           //数据包编码：json（默认），protobuf，由发起连接方选择
+	Reconnect          ReconnectPolicy `json:"reconnect"`          //断线重连策略，websocket和mq客户端可用
 }
 
 // 节点主配置 作为json解析工具
 type NodeConfig struct {
-	TimeoutSEC int         `json:"timeoutSEC"` //超时时间
-	Cert       Certificate `json:"cert"`       //证书
-	Peerstore  Peerstore   //会话缓存
+	TimeoutSEC       int             `json:"timeoutSEC"` //超时时间
+	Cert             Certificate     `json:"cert"`       //证书
+	Peerstore        Peerstore       //会话缓存
+	StreamWindow     int             `json:"streamWindow"`     //数据流发送窗口，未确认的数据块达到窗口时等待接收方确认，默认16，最大1024
+	StreamTimeoutSEC int             `json:"streamTimeoutSEC"` //数据流超时时间，等待数据块或确认超过时终止数据流，默认与请求超时时间一致
+	RateLimit        RateLimitConfig `json:"rateLimit"`        //请求限流，超过限制返回ErrDenialOfService
 }
 
 // OWTPNode 实现OWTP协议的节点
@@ -152,6 +157,28 @@ type OWTPNode struct {
 	//ReadBufferSize, WriteBufferSize int
 	//重新加载节点连接信息
 	reloadPeerInfoHandler func(n *OWTPNode, peerID string) PeerInfo
+	//断线重连状态的锁
+	reconnectMu sync.Mutex
+	//正在重连的节点
+	reconnecting map[string]bool
+	//主动关闭的节点，断开后不重连
+	manualClosed map[string]bool
+	//节点关闭时通知重连中断
+	closing chan struct{}
+	//主题订阅的锁
+	topicMu sync.RWMutex
+	//主题消息的处理方法
+	topicHandlers map[string]TopicHandlerFunc
+	//数据流的锁
+	streamMu sync.Mutex
+	//发送中的数据流
+	streamWriters map[string]*StreamWriter
+	//接收中的数据流
+	streamReaders map[string]*StreamReader
+	//数据流发送窗口
+	streamWindow int
+	//数据流超时时间
+	streamTimeout time.Duration
 }
 
 // RandomOWTPNode 创建随机密钥节点
@@ -203,15 +230,31 @@ func NewNode(config NodeConfig) *OWTPNode {
 
 	node.nonceGen, _ = snowflake.NewNode(1)
 
+	node.serveMux.SetRateLimit(config.RateLimit)
+
+	node.streamWindow = config.StreamWindow
+	node.streamTimeout = time.Duration(config.StreamTimeoutSEC) * time.Second
+
 	node.Join = make(chan Peer)
 	node.Leave = make(chan Peer)
 	node.Stop = make(chan struct{})
 	node.onlinePeers = make(map[string]Peer)
 	node.listeners = make(map[string]Listener)
+	node.reconnecting = make(map[string]bool)
+	node.manualClosed = make(map[string]bool)
+	node.closing = make(chan struct{})
+	node.topicHandlers = make(map[string]TopicHandlerFunc)
+	node.streamWriters = make(map[string]*StreamWriter)
+	node.streamReaders = make(map[string]*StreamReader)
 
 	//内部配置一个协商密码处理过程
 	node.serveMux.handleFuncInner(KeyAgreementMethod, node.keyAgreement)
 
+	//主题订阅和推送
+	node.serveMux.HandleFunc(SubscribeMethod, node.subscribeReceived)
+	node.serveMux.HandleFunc(UnsubscribeMethod, node.unsubscribeReceived)
+	node.serveMux.HandleFunc(PublishMethod, node.publishReceived)
+
 	//马上执行
 	go node.Run()
 
@@ -233,10 +276,21 @@ func NewOWTPNode(cert Certificate, readBufferSize, writeBufferSize int) *OWTPNod
 	node.Stop = make(chan struct{})
 	node.onlinePeers = make(map[string]Peer)
 	node.listeners = make(map[string]Listener)
+	node.reconnecting = make(map[string]bool)
+	node.manualClosed = make(map[string]bool)
+	node.closing = make(chan struct{})
+	node.topicHandlers = make(map[string]TopicHandlerFunc)
+	node.streamWriters = make(map[string]*StreamWriter)
+	node.streamReaders = make(map[string]*StreamReader)
 
 	//内部配置一个协商密码处理过程
 	node.serveMux.handleFuncInner(KeyAgreementMethod, node.keyAgreement)
 
+	//主题订阅和推送
+	node.serveMux.HandleFunc(SubscribeMethod, node.subscribeReceived)
+	node.serveMux.HandleFunc(UnsubscribeMethod, node.unsubscribeReceived)
+	node.serveMux.HandleFunc(PublishMethod, node.publishReceived)
+
 	//马上执行
 	go node.Run()
 
@@ -395,10 +449,18 @@ func (node *OWTPNode) connect(pid string, config ConnectConfig) (Peer, error) {
 		return nil, err
 	}
 
+	//对方节点ID由公钥生成，用于校验对方推送请求的签名
+	auth.remoteID = pid
+
 	if len(connectType) == 0 {
 		return nil, fmt.Errorf("connectType must contain by config")
 	}
 
+	config.Encoding, err = normalizeEncoding(config.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
 	//websocket类型
 	if connectType == Websocket {
 
@@ -410,8 +472,15 @@ func (node *OWTPNode) connect(pid string, config ConnectConfig) (Peer, error) {
 
 		url := protocol + strings.TrimSuffix(addr, "/") + "/"
 
+		//连接时声明数据包编码
+		header := auth.HTTPAuthHeader()
+		if header == nil {
+			header = make(map[string]string)
+		}
+		header[EncodingHeader] = config.Encoding
+
 		//建立链接，记录默认的客户端
-		client, err := Dial(pid, url, node, auth.HTTPAuthHeader(), readBufferSize, writeBufferSize)
+		client, err := Dial(pid, url, node, header, readBufferSize, writeBufferSize)
 		if err != nil {
 			return nil, err
 		}
@@ -517,7 +586,8 @@ func (node *OWTPNode) Run() error {
 				continue
 			}
 
-			if node.connectHandler != nil {
+			//重连成功的事件在重连完成后回调
+			if node.connectHandler != nil && !node.isReconnecting(peer.PID()) {
 				go node.connectHandler(node, node.Peerstore().PeerInfo(peer.PID()))
 			}
 
@@ -525,10 +595,16 @@ func (node *OWTPNode) Run() error {
 			//客户端离开
 			log.Debug("Node Leave:", peer.PID())
 
+			//开启断线重连，后台重新连接
+			if node.shouldReconnect(peer) {
+				node.startReconnect(peer)
+				break
+			}
+
 			node.serveMux.ResetRequestQueue(peer.PID())
 			node.RemoveOfflinePeer(peer.PID())
 
-			if node.disconnectHandler != nil {
+			if node.disconnectHandler != nil && !node.isReconnecting(peer.PID()) {
 				go node.disconnectHandler(node, node.Peerstore().PeerInfo(peer.PID()))
 			}
 
@@ -561,14 +637,19 @@ func (node *OWTPNode) ClosePeer(pid string) {
 	//检查是否已经连接服务
 	peer := node.GetOnlinePeer(pid)
 	if peer == nil {
+		//正在重连的节点，中断重连
+		node.markManualClosed(pid, false)
 		return
 	}
+	node.markManualClosed(pid, true)
 	peer.close()
 }
 
 // Close 关闭节点
 func (node *OWTPNode) Close() {
 
+	node.stopReconnect()
+
 	for _, listener := range node.listeners {
 		listener.Close()
 	}
@@ -648,33 +729,81 @@ func (node *OWTPNode) Call(
 	reqFunc RequestFunc) error {
 
 	var (
-		err      error
 		respChan = make(chan Response)
 	)
 
+	peer, err := node.onlinePeerOrConnect(pid)
+	if err != nil {
+		return err
+	}
+
+	entry := requestEntry{
+		sync:     sync,
+		method:   method,
+		h:        reqFunc,
+		respChan: respChan,
+		params:   params,
+	}
+
+	err = node.sendRequest(peer, node.newNonce(), entry)
+	if err != nil {
+		return err
+	}
+
+	if sync {
+		//等待返回
+		result := <-respChan
+		reqFunc(result)
+	}
+
+	return nil
+}
+
+// onlinePeerOrConnect 获取在线节点，节点不在线时，使用保存的连接信息重新连接
+func (node *OWTPNode) onlinePeerOrConnect(pid string) (Peer, error) {
+
 	//检查是否已经连接服务
 	peer := node.GetOnlinePeer(pid)
-	if peer == nil {
-
-		peerInfo := node.peerstore.PeerInfo(pid)
+	if peer != nil {
+		return peer, nil
+	}
 
-		peer, err = node.Connect(pid, peerInfo.Config) //重新连接
-		if err != nil {
-			return err
-		}
+	//正在重连，等待重连完成，避免重复建立连接
+	if node.isReconnecting(pid) {
+		return nil, fmt.Errorf("peer: %s is reconnecting", pid)
 	}
 
-	//添加请求队列到Map，处理完成回调方法
-	nonce := uint64(node.nonceGen.Generate().Int64())
-	time := time.Now().Unix()
+	peerInfo := node.peerstore.PeerInfo(pid)
+
+	return node.Connect(pid, peerInfo.Config) //重新连接
+}
+
+// newNonce 生成请求的nonce，递增不可重复
+func (node *OWTPNode) newNonce() uint64 {
+	return uint64(node.nonceGen.Generate().Int64())
+}
+
+// sendRequest 封装数据包，添加请求到队列并发送，断线重连后重发的请求也通过它发送
+func (node *OWTPNode) sendRequest(peer Peer, nonce uint64, entry requestEntry) error {
+
+	var (
+		err error
+	)
+
+	now := time.Now().Unix()
+
+	//重发的请求保留首次请求的时间，超时时间从首次请求开始计算
+	if entry.time == 0 {
+		entry.time = now
+	}
 
 	//封装数据包
 	packet := DataPacket{
-		Method:    method,
+		Method:    entry.method,
 		Req:       WSRequest,
 		Nonce:     nonce,
-		Timestamp: time,
-		Data:      params,
+		Timestamp: now,
+		Data:      entry.params,
 		Version:   CurrentDataPacketVersion,
 	}
 
@@ -716,7 +845,7 @@ func (node *OWTPNode) Call(
 	}
 
 	//添加请求到队列，异步或同步等待结果，应该在发送前就添加请求，如果发送失败，删除请求
-	err = node.serveMux.AddRequest(peer, nonce, time, method, reqFunc, respChan, sync)
+	err = node.serveMux.addRequest(peer, nonce, entry)
 	if err != nil {
 		return err
 	}
@@ -729,12 +858,6 @@ func (node *OWTPNode) Call(
 		return err
 	}
 
-	if sync {
-		//等待返回
-		result := <-respChan
-		reqFunc(result)
-	}
-
 	return nil
 }
 
@@ -783,6 +906,11 @@ func (node *OWTPNode) encryptPacket(peer Peer, packet *DataPacket, key string) e
 	return nil
 }
 
+// SetRateLimit 设置请求限流，按节点ID和方法限制对方节点的请求
+func (node *OWTPNode) SetRateLimit(config RateLimitConfig) {
+	node.serveMux.SetRateLimit(config)
+}
+
 // HandleFunc 绑定路由器方法
 func (node *OWTPNode) HandleFunc(method string, handler HandlerFunc) {
 	node.serveMux.HandleFunc(method, handler)
@@ -1055,6 +1183,8 @@ func (node *OWTPNode) OnPeerOpen(peer Peer) {
 
 // OnPeerClose 节点关闭
 func (node *OWTPNode) OnPeerClose(peer Peer, reason string) {
+	node.clearSubscribers(peer.PID())
+	node.closeStreamWriters(peer.PID())
 	node.Leave <- peer
 }
 
@@ -1097,6 +1227,7 @@ func (node *OWTPNode) OnPeerNewDataPacketReceived(peer Peer, packet *DataPacket)
 			Method:        packet.Method,
 			peerstore:     node.Peerstore(),
 			Peer:          peer,
+			node:          node,
 		}
 
 		//授权检查，只检查请求过来的签名
@@ -1121,8 +1252,6 @@ func (node *OWTPNode) OnPeerNewDataPacketReceived(peer Peer, packet *DataPacket)
 	} else if packet.Req == WSResponse {
 
 		//创建上下面指针，处理响应
-		var resp Response
-
 		ctx := Context{
 			Version:       packet.Version,
 			Req:           packet.Req,
@@ -1135,53 +1264,50 @@ func (node *OWTPNode) OnPeerNewDataPacketReceived(peer Peer, packet *DataPacket)
 			Peer:      peer,
 		}
 
-		//处理协商密码的响应方结果
-		secretKey, err = node.handleKeyAgreementForResponse(peer, packet)
-		if err != nil {
-			log.Critical("keyAgreement failed: ", packet)
-			resp = responseError(err.Error(), ErrKeyAgreementFailed)
-			ctx.Resp = resp
-			node.serveMux.ServeOWTP(peer.PID(), &ctx)
-			return
-		}
+		ctx.Resp = node.decodeResponse(peer, packet)
+		node.serveMux.ServeOWTP(peer.PID(), &ctx)
 
-		//rawData, ok := packet.Data.(string)
-		//if !ok {
-		//	log.Critical("Data type error")
-		//	resp = responseError("Data type error", ErrBadRequest)
-		//	ctx.Resp = resp
-		//	node.serveMux.ServeOWTP(peer.PID(), &ctx)
-		//	return
-		//}
-		//log.Debug("rawData:", rawData)
-		//decryptData, cryptErr := peer.auth().DecryptData([]byte(rawData), secretKey)
-		//log.Debug("decryptData:", string(decryptData))
+	} else if packet.Req == WSStream {
 
-		cryptErr := peer.auth().DecryptDataPacket(packet, secretKey)
-		if cryptErr != nil {
-			log.Critical("OWTP: DecryptData failed")
-			resp = responseError("Decrypt data error", ErrKeyAgreementFailed)
-			ctx.Resp = resp
-			node.serveMux.ServeOWTP(peer.PID(), &ctx)
-			return
-		}
+		//数据流的数据块与响应的封装方式一致
+		node.receiveStreamChunk(peer, packet, node.decodeResponse(peer, packet))
 
-		decryptData := packet.Data.([]byte)
-		runErr := JsonUnmarshal(decryptData, &resp)
-		//runErr := mapstructure.Decode(decryptData, &resp)
-		if runErr != nil {
-			log.Error("Response decode error: ", runErr)
-			resp = responseError("Response decode error", ErrBadRequest)
-			ctx.Resp = resp
-			node.serveMux.ServeOWTP(peer.PID(), &ctx)
-			return
-		}
+	} else if packet.Req == WSStreamAck {
 
-		ctx.Resp = resp
-		node.serveMux.ServeOWTP(peer.PID(), &ctx)
+		node.receiveStreamAck(peer, packet)
+	}
+
+}
 
+// decodeResponse 解密和解析响应数据包，失败时返回错误的响应
+func (node *OWTPNode) decodeResponse(peer Peer, packet *DataPacket) Response {
+
+	var (
+		resp Response
+	)
+
+	//处理协商密码的响应方结果
+	secretKey, err := node.handleKeyAgreementForResponse(peer, packet)
+	if err != nil {
+		log.Critical("keyAgreement failed: ", packet)
+		return responseError(err.Error(), ErrKeyAgreementFailed)
 	}
 
+	cryptErr := peer.auth().DecryptDataPacket(packet, secretKey)
+	if cryptErr != nil {
+		log.Critical("OWTP: DecryptData failed")
+		return responseError("Decrypt data error", ErrKeyAgreementFailed)
+	}
+
+	decryptData := packet.Data.([]byte)
+	runErr := JsonUnmarshal(decryptData, &resp)
+	//runErr := mapstructure.Decode(decryptData, &resp)
+	if runErr != nil {
+		log.Error("Response decode error: ", runErr)
+		return responseError("Response decode error", ErrBadRequest)
+	}
+
+	return resp
 }
 
 // Peers 节点列表
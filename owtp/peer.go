@@ -30,7 +30,7 @@ const (
 type DataPacket struct {
 	/*
 
-		本协议传输数据，格式编码采用json。消息接收与发送，都遵循数据包规范定义字段内容。
+		本协议传输数据，格式编码默认采用json，可在连接配置中选择protobuf（定义见packet.proto）。消息接收与发送，都遵循数据包规范定义字段内容。
 
 		| 参数名 | 类型   | 示例             | 描述                                                                                |
 		|--------|--------|------------------|-----------------------------------------------------------------------------------|
@@ -97,8 +97,9 @@ func NewDataPacket(json gjson.Result) *DataPacket {
 }
 
 type PeerInfo struct {
-	ID     string
-	Config ConnectConfig
+	ID        string
+	Config    ConnectConfig
+	Reconnect *ReconnectEvent //断线重连事件，非重连引起的开启和关闭为nil
 }
 
 type PeerAttribute map[string]interface{}
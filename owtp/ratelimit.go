@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	//节点所有方法合计的令牌桶
+	rateLimitPeerKey = "internal_key_rateLimit"
+	//节点每个方法的令牌桶，后接方法名
+	rateLimitMethodKeyPrefix = "internal_key_rateLimit_"
+	//清理到期令牌桶的间隔
+	rateLimitSweepInterval = time.Minute
+)
+
+// RateLimit 令牌桶限流，每秒补充Rate个令牌，最多累积Burst个，每个请求消耗一个令牌。
+// Rate <= 0 不限制，Burst <= 0 时为Rate向上取整（至少为1）。
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  //每秒补充的令牌数
+	Burst int     `json:"burst"` //令牌桶容量，允许的突发请求数
+}
+
+// RateLimitConfig 请求限流配置，超过限制的请求返回ErrDenialOfService和重试等待时间。
+// 令牌桶状态保存在节点的Peerstore，只在当前进程内加锁更新，多个节点共享Peerstore时不保证限流准确。
+// 令牌补满后的状态与新建的相同，超过补满时间没有请求的令牌桶会从Peerstore删除。
+// HTTP请求没有授权公钥时每次生成随机的节点ID，需要开启签名授权，按授权公钥计算节点ID才能限流。
+type RateLimitConfig struct {
+	Peer    RateLimit            `json:"peer"`    //每个节点所有方法合计的限制
+	Methods map[string]RateLimit `json:"methods"` //每个节点每个方法的限制
+}
+
+// enabled 是否开启
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// burst 令牌桶容量
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// ttl 令牌从0补满所需的时间，超过该时间没有请求的令牌桶可以删除
+func (l RateLimit) ttl() time.Duration {
+	return time.Duration(l.burst() / l.Rate * float64(time.Second))
+}
+
+// tokenBucket 令牌桶状态，以json文本保存在Peerstore
+type tokenBucket struct {
+	Tokens  float64 `json:"t"` //剩余令牌
+	Updated int64   `json:"u"` //上次更新时间（纳秒）
+}
+
+// RetryAfter 限流响应的重试等待时间，其他响应为0
+func (resp *Response) RetryAfter() time.Duration {
+	if resp.Status != ErrDenialOfService {
+		return 0
+	}
+	ms := resp.JsonData().Get("retryAfterMS").Int()
+	return time.Duration(ms) * time.Millisecond
+}
+
+// SetRateLimit 设置请求限流，配置为空时不限流
+func (mux *ServeMux) SetRateLimit(config RateLimitConfig) {
+	mux.limitMu.Lock()
+	defer mux.limitMu.Unlock()
+	mux.rateLimit = config
+}
+
+// checkRateLimit 检查请求限流，超过限制时设置响应并返回false
+func (mux *ServeMux) checkRateLimit(ctx *Context) bool {
+
+	if ctx.peerstore == nil {
+		return true
+	}
+
+	retryAfter, ok := mux.allowRequest(ctx.peerstore, ctx.PID, ctx.Method, time.Now())
+	if ok {
+		return true
+	}
+
+	ms := int64(math.Ceil(float64(retryAfter) / float64(time.Millisecond)))
+	ctx.Response(map[string]interface{}{"retryAfterMS": ms}, ErrDenialOfService,
+		fmt.Sprintf("too many requests, retry after %s", time.Duration(ms)*time.Millisecond))
+
+	return false
+}
+
+// allowRequest 节点和方法的令牌桶都有令牌时，消耗令牌并允许请求，否则返回需要等待的时间
+func (mux *ServeMux) allowRequest(store Peerstore, pid, method string, now time.Time) (time.Duration, bool) {
+
+	mux.limitMu.Lock()
+	defer mux.limitMu.Unlock()
+
+	type bucketLimit struct {
+		key    string
+		limit  RateLimit
+		bucket tokenBucket
+	}
+
+	limits := make([]*bucketLimit, 0, 2)
+	if mux.rateLimit.Peer.enabled() {
+		limits = append(limits, &bucketLimit{key: rateLimitPeerKey, limit: mux.rateLimit.Peer})
+	}
+	if l, exist := mux.rateLimit.Methods[method]; exist && l.enabled() {
+		limits = append(limits, &bucketLimit{key: rateLimitMethodKeyPrefix + method, limit: l})
+	}
+
+	if len(limits) == 0 {
+		return 0, true
+	}
+
+	var wait time.Duration
+	for _, bl := range limits {
+		bl.bucket = loadTokenBucket(store, pid, bl.key, bl.limit, now)
+		if bl.bucket.Tokens < 1 {
+			need := time.Duration((1 - bl.bucket.Tokens) / bl.limit.Rate * float64(time.Second))
+			if need > wait {
+				wait = need
+			}
+		}
+	}
+
+	//任一令牌桶不足时，不消耗令牌
+	if wait > 0 {
+		return wait, false
+	}
+
+	for _, bl := range limits {
+		bl.bucket.Tokens--
+		b, _ := json.Marshal(bl.bucket)
+		store.Put(pid, bl.key, string(b))
+		mux.setBucketExpire(pid, bl.key, now.Add(bl.limit.ttl()))
+	}
+
+	mux.sweepBuckets(store, now)
+
+	return 0, true
+}
+
+// setBucketExpire 记录令牌桶的到期时间，调用者需持有limitMu
+func (mux *ServeMux) setBucketExpire(pid, key string, expire time.Time) {
+	if mux.limitExpire == nil {
+		mux.limitExpire = make(map[string]map[string]time.Time)
+	}
+	keys := mux.limitExpire[pid]
+	if keys == nil {
+		keys = make(map[string]time.Time)
+		mux.limitExpire[pid] = keys
+	}
+	keys[key] = expire
+}
+
+// sweepBuckets 定期从Peerstore删除到期的令牌桶，调用者需持有limitMu
+func (mux *ServeMux) sweepBuckets(store Peerstore, now time.Time) {
+	if now.Sub(mux.limitSweepAt) < rateLimitSweepInterval {
+		return
+	}
+	mux.limitSweepAt = now
+
+	for pid, keys := range mux.limitExpire {
+		for key, expire := range keys {
+			if now.After(expire) {
+				store.Delete(pid, key)
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(mux.limitExpire, pid)
+		}
+	}
+}
+
+// loadTokenBucket 读取令牌桶，并按流逝的时间补充令牌
+func loadTokenBucket(store Peerstore, pid, key string, limit RateLimit, now time.Time) tokenBucket {
+
+	burst := limit.burst()
+	bucket := tokenBucket{Tokens: burst, Updated: now.UnixNano()}
+
+	b := store.GetString(pid, key)
+	if len(b) == 0 || json.Unmarshal([]byte(b), &bucket) != nil {
+		return tokenBucket{Tokens: burst, Updated: now.UnixNano()}
+	}
+
+	elapsed := time.Duration(now.UnixNano() - bucket.Updated)
+	if elapsed > 0 {
+		bucket.Tokens = math.Min(burst, bucket.Tokens+elapsed.Seconds()*limit.Rate)
+		bucket.Updated = now.UnixNano()
+	}
+
+	return bucket
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllowRequest(t *testing.T) {
+
+	mux := NewServeMux(120)
+	mux.SetRateLimit(RateLimitConfig{
+		Peer: RateLimit{Rate: 10, Burst: 3},
+		Methods: map[string]RateLimit{
+			"sendTransaction": {Rate: 1, Burst: 1},
+		},
+	})
+	store := NewOWTPPeerstore()
+	now := time.Now()
+
+	//方法令牌桶只有1个令牌
+	if _, ok := mux.allowRequest(store, "1", "sendTransaction", now); !ok {
+		t.Fatalf("first sendTransaction should be allowed")
+	}
+	wait, ok := mux.allowRequest(store, "1", "sendTransaction", now)
+	if ok {
+		t.Fatalf("second sendTransaction should be limited")
+	}
+	if wait != time.Second {
+		t.Errorf("retry after = %v, want 1s", wait)
+	}
+
+	//被拒绝的请求不消耗节点令牌，节点令牌桶还剩2个
+	for i := 0; i < 2; i++ {
+		if _, ok := mux.allowRequest(store, "1", "getBalance", now); !ok {
+			t.Fatalf("getBalance %d should be allowed", i)
+		}
+	}
+	if wait, ok := mux.allowRequest(store, "1", "getBalance", now); ok || wait != 100*time.Millisecond {
+		t.Fatalf("getBalance should be limited, retry after = %v", wait)
+	}
+
+	//其他节点不受影响
+	if _, ok := mux.allowRequest(store, "2", "sendTransaction", now); !ok {
+		t.Fatalf("other peer should be allowed")
+	}
+
+	//补充令牌后恢复
+	now = now.Add(time.Second)
+	if _, ok := mux.allowRequest(store, "1", "sendTransaction", now); !ok {
+		t.Fatalf("sendTransaction should be allowed after refill")
+	}
+
+	//取消限流
+	mux.SetRateLimit(RateLimitConfig{})
+	for i := 0; i < 10; i++ {
+		if _, ok := mux.allowRequest(store, "1", "sendTransaction", now); !ok {
+			t.Fatalf("request should be allowed without rate limit")
+		}
+	}
+}
+
+func TestAllowRequest_Expire(t *testing.T) {
+
+	mux := NewServeMux(120)
+	mux.SetRateLimit(RateLimitConfig{
+		Peer: RateLimit{Rate: 1, Burst: 2},
+	})
+	store := NewOWTPPeerstore()
+	now := time.Now()
+
+	//每次请求的节点ID都不同
+	for i := 0; i < 10; i++ {
+		mux.allowRequest(store, fmt.Sprintf("http_%d", i), "getBalance", now)
+	}
+	if len(store.GetString("http_0", rateLimitPeerKey)) == 0 {
+		t.Fatalf("token bucket is not saved")
+	}
+
+	//超过补满时间后清理
+	now = now.Add(rateLimitSweepInterval + time.Second)
+	mux.allowRequest(store, "other", "getBalance", now)
+	for i := 0; i < 10; i++ {
+		if b := store.GetString(fmt.Sprintf("http_%d", i), rateLimitPeerKey); len(b) > 0 {
+			t.Errorf("expired token bucket of http_%d is not deleted", i)
+		}
+	}
+	if len(mux.limitExpire) != 1 {
+		t.Errorf("token bucket expire records = %d, want 1", len(mux.limitExpire))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+
+	host := NewNode(NodeConfig{
+		RateLimit: RateLimitConfig{
+			Methods: map[string]RateLimit{
+				"hello": {Rate: 2, Burst: 2},
+			},
+		},
+	})
+	defer host.Close()
+	host.HandleFunc("hello", func(ctx *Context) {
+		ctx.Response("hi", StatusSuccess, "success")
+	})
+
+	config := ConnectConfig{Address: "127.0.0.1:8436", ConnectType: Websocket, EnableSignature: true}
+	if err := host.Listen(config); err != nil {
+		t.Fatalf("Listen failed, unexpected error: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	client := RandomOWTPNode()
+	defer client.Close()
+	if _, err := client.Connect(host.NodeID(), config); err != nil {
+		t.Fatalf("Connect failed, unexpected error: %v", err)
+	}
+
+	call := func() *Response {
+		var resp *Response
+		err := client.Call(host.NodeID(), "hello", nil, true, func(r Response) {
+			resp = &r
+		})
+		if err != nil {
+			t.Fatalf("Call failed, unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := call(); resp.Status != StatusSuccess {
+			t.Fatalf("call %d status = %d, msg = %s", i, resp.Status, resp.Msg)
+		}
+	}
+
+	resp := call()
+	if resp.Status != ErrDenialOfService {
+		t.Fatalf("status = %d, want %d", resp.Status, ErrDenialOfService)
+	}
+	retryAfter := resp.RetryAfter()
+	if retryAfter <= 0 || retryAfter > 500*time.Millisecond {
+		t.Fatalf("retry after = %v", retryAfter)
+	}
+
+	time.Sleep(retryAfter)
+	if resp := call(); resp.Status != StatusSuccess {
+		t.Fatalf("call after retry status = %d, msg = %s", resp.Status, resp.Msg)
+	}
+}
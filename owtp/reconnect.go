@@ -0,0 +1,289 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+// 断线重连的默认参数
+const (
+	DefaultReconnectInitialDelayMS = 1000
+	DefaultReconnectMaxDelayMS     = 60000
+	DefaultReconnectMultiplier     = 2
+)
+
+// 断线重连事件的状态
+const (
+	ReconnectStateReconnecting = "reconnecting" //连接断开，开始重连，通过关闭回调通知
+	ReconnectStateReconnected  = "reconnected"  //重连成功，通过开启回调通知
+	ReconnectStateFailed       = "failed"       //达到最大重连次数或节点关闭，放弃重连，通过关闭回调通知
+)
+
+// ReconnectPolicy 断线重连策略，只对主动发起连接的websocket和mq客户端生效。
+// 重连等待时间按指数退避：InitialDelayMS * Multiplier^(n-1)，不超过MaxDelayMS。
+// 重连时重新协商密码，未完成的请求中，幂等方法在重连成功后重发，其他请求返回连接断开。
+type ReconnectPolicy struct {
+	Enable            bool     `json:"enable"`            //是否开启断线重连
+	MaxAttempts       int      `json:"maxAttempts"`       //最大重连次数，0为不限制
+	InitialDelayMS    int      `json:"initialDelayMS"`    //首次重连等待时间（毫秒），默认1000
+	MaxDelayMS        int      `json:"maxDelayMS"`        //最长重连等待时间（毫秒），默认60000
+	Multiplier        float64  `json:"multiplier"`        //等待时间的增长倍数，默认2
+	IdempotentMethods []string `json:"idempotentMethods"` //幂等的方法，断线时未完成的请求重连后重发
+}
+
+// ReconnectEvent 断线重连事件，通过PeerInfo.Reconnect传给开启和关闭连接的回调
+type ReconnectEvent struct {
+	State   string //重连状态
+	Attempt int    //第几次重连，开始重连时为0
+	Err     error  //放弃重连时，最后一次重连的错误
+}
+
+// Delay 第attempt次重连前的等待时间
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+
+	initial := p.InitialDelayMS
+	if initial <= 0 {
+		initial = DefaultReconnectInitialDelayMS
+	}
+
+	max := p.MaxDelayMS
+	if max <= 0 {
+		max = DefaultReconnectMaxDelayMS
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultReconnectMultiplier
+	}
+
+	delay := float64(initial)
+	for i := 1; i < attempt && delay < float64(max); i++ {
+		delay *= multiplier
+	}
+
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+
+	return time.Duration(delay) * time.Millisecond
+}
+
+// isIdempotent 方法是否幂等，可以重发
+func (p ReconnectPolicy) isIdempotent(method string) bool {
+	for _, m := range p.IdempotentMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldReconnect 节点断开后是否需要重连，主动关闭的节点不重连
+func (node *OWTPNode) shouldReconnect(peer Peer) bool {
+
+	node.reconnectMu.Lock()
+	defer node.reconnectMu.Unlock()
+
+	pid := peer.PID()
+
+	if node.manualClosed[pid] {
+		delete(node.manualClosed, pid)
+		return false
+	}
+
+	//重连中协商密码失败关闭的连接
+	if node.reconnecting[pid] {
+		return false
+	}
+
+	select {
+	case <-node.closing:
+		return false
+	default:
+	}
+
+	config := peer.ConnectConfig()
+	if !peer.IsHost() || !config.Reconnect.Enable {
+		return false
+	}
+
+	return config.ConnectType == Websocket || config.ConnectType == MQ
+}
+
+// isReconnecting 节点是否正在重连
+func (node *OWTPNode) isReconnecting(pid string) bool {
+	node.reconnectMu.Lock()
+	defer node.reconnectMu.Unlock()
+	return node.reconnecting[pid]
+}
+
+// markManualClosed 标记主动关闭的节点，离线的节点只在重连中标记，用于中断重连
+func (node *OWTPNode) markManualClosed(pid string, online bool) {
+	node.reconnectMu.Lock()
+	defer node.reconnectMu.Unlock()
+	if online || node.reconnecting[pid] {
+		node.manualClosed[pid] = true
+	}
+}
+
+// takeManualClosed 节点是否已被主动关闭，并清除标记
+func (node *OWTPNode) takeManualClosed(pid string) bool {
+	node.reconnectMu.Lock()
+	defer node.reconnectMu.Unlock()
+	closed := node.manualClosed[pid]
+	delete(node.manualClosed, pid)
+	return closed
+}
+
+// stopReconnect 节点关闭，中断所有重连
+func (node *OWTPNode) stopReconnect() {
+	node.reconnectMu.Lock()
+	defer node.reconnectMu.Unlock()
+	select {
+	case <-node.closing:
+	default:
+		close(node.closing)
+	}
+}
+
+// startReconnect 保留幂等的未完成请求，移除离线节点，后台重连
+func (node *OWTPNode) startReconnect(peer Peer) {
+
+	pid := peer.PID()
+	config := peer.ConnectConfig()
+	policy := config.Reconnect
+
+	pending := node.serveMux.resetRequestQueue(pid, func(r requestEntry) bool {
+		return !r.stream && policy.isIdempotent(r.method)
+	})
+	node.RemoveOfflinePeer(pid)
+
+	node.reconnectMu.Lock()
+	node.reconnecting[pid] = true
+	node.reconnectMu.Unlock()
+
+	node.notifyReconnect(node.disconnectHandler, pid, &ReconnectEvent{State: ReconnectStateReconnecting})
+
+	go node.reconnect(pid, config, pending)
+}
+
+// reconnect 按退避策略重新连接，成功后重发未完成的请求，放弃时返回连接断开
+func (node *OWTPNode) reconnect(pid string, config ConnectConfig, pending []requestEntry) {
+
+	var (
+		err     error
+		peer    Peer
+		attempt int
+		policy  = config.Reconnect
+	)
+
+	defer func() {
+		node.reconnectMu.Lock()
+		delete(node.reconnecting, pid)
+		node.reconnectMu.Unlock()
+	}()
+
+	for policy.MaxAttempts <= 0 || attempt < policy.MaxAttempts {
+
+		attempt++
+		timer := time.NewTimer(policy.Delay(attempt))
+		select {
+		case <-timer.C:
+		case <-node.closing:
+			timer.Stop()
+			err = fmt.Errorf("node has been closed")
+		}
+		if err != nil {
+			break
+		}
+
+		//等待期间已超时的请求
+		pending = node.expireRequests(pending)
+
+		if node.takeManualClosed(pid) {
+			err = fmt.Errorf("peer has been closed")
+			break
+		}
+
+		log.Debugf("peer: %s reconnect attempt %d", pid, attempt)
+
+		//新的连接会重新协商密码
+		peer, err = node.Connect(pid, config)
+		if err == nil {
+			for _, entry := range pending {
+				if sendErr := node.sendRequest(peer, node.newNonce(), entry); sendErr != nil {
+					entry.callback(responseError(sendErr.Error(), ErrNetworkDisconnected))
+				}
+			}
+			//新的连接没有订阅状态，重新订阅主题
+			if subErr := node.resubscribe(pid); subErr != nil {
+				log.Warningf("peer: %s resubscribe topics failed, unexpected error: %v", pid, subErr)
+			}
+			node.notifyReconnect(node.connectHandler, pid, &ReconnectEvent{State: ReconnectStateReconnected, Attempt: attempt})
+			return
+		}
+
+		log.Warningf("peer: %s reconnect attempt %d failed, unexpected error: %v", pid, attempt, err)
+
+		//连接成功但协商密码失败，关闭连接等待下次重连
+		if p := node.GetOnlinePeer(pid); p != nil {
+			p.close()
+		}
+	}
+
+	log.Warningf("peer: %s give up reconnecting after %d attempts", pid, attempt)
+
+	for _, entry := range pending {
+		entry.callback(responseError("network disconnected", ErrNetworkDisconnected))
+	}
+
+	node.notifyReconnect(node.disconnectHandler, pid, &ReconnectEvent{State: ReconnectStateFailed, Attempt: attempt, Err: err})
+}
+
+// expireRequests 返回超时的请求，保留未超时的请求
+func (node *OWTPNode) expireRequests(pending []requestEntry) []requestEntry {
+
+	timeout := node.serveMux.timeout
+	if timeout == 0 {
+		timeout = DefaultTimoutSEC * time.Second
+	}
+
+	now := time.Now().Unix()
+	kept := make([]requestEntry, 0, len(pending))
+	for _, entry := range pending {
+		if now > time.Unix(entry.time, 0).Add(timeout).Unix() {
+			errInfo := fmt.Sprintf("request timeout over %s", timeout.String())
+			entry.callback(responseError(errInfo, ErrRequestTimeout))
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+// notifyReconnect 通过开启或关闭连接的回调通知重连事件
+func (node *OWTPNode) notifyReconnect(h func(n *OWTPNode, peerInfo PeerInfo), pid string, event *ReconnectEvent) {
+	if h == nil {
+		return
+	}
+	peerInfo := node.Peerstore().PeerInfo(pid)
+	peerInfo.Reconnect = event
+	go h(node, peerInfo)
+}
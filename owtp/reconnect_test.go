@@ -0,0 +1,167 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+
+	policy := ReconnectPolicy{InitialDelayMS: 100, MaxDelayMS: 500, Multiplier: 2}
+	tests := map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		4:  500 * time.Millisecond,
+		10: 500 * time.Millisecond,
+	}
+	for attempt, want := range tests {
+		if got := policy.Delay(attempt); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	//默认参数
+	var defaults ReconnectPolicy
+	if got := defaults.Delay(1); got != time.Second {
+		t.Errorf("default Delay(1) = %v, want 1s", got)
+	}
+	if got := defaults.Delay(7); got != time.Minute {
+		t.Errorf("default Delay(7) = %v, want 1m", got)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+
+	var queryCalls int32
+
+	host := RandomOWTPNode()
+	host.HandleFunc("query", func(ctx *Context) {
+		//第一次请求时断开连接，不返回结果
+		if atomic.AddInt32(&queryCalls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			host.ClosePeer(ctx.PID)
+			return
+		}
+		ctx.Response("pong", StatusSuccess, "success")
+	})
+	host.HandleFunc("transfer", func(ctx *Context) {
+		time.Sleep(time.Second)
+		ctx.Response("done", StatusSuccess, "success")
+	})
+
+	hostConfig := ConnectConfig{Address: "127.0.0.1:8433", ConnectType: Websocket, EnableSignature: true}
+	if err := host.Listen(hostConfig); err != nil {
+		t.Fatalf("Listen failed, unexpected error: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	events := make(chan ReconnectEvent, 10)
+	onEvent := func(n *OWTPNode, peer PeerInfo) {
+		if peer.Reconnect != nil {
+			events <- *peer.Reconnect
+		}
+	}
+
+	client := RandomOWTPNode()
+	defer client.Close()
+	client.SetOpenHandler(onEvent)
+	client.SetCloseHandler(onEvent)
+
+	config := hostConfig
+	config.EnableKeyAgreement = true
+	config.Reconnect = ReconnectPolicy{
+		Enable:            true,
+		MaxAttempts:       2,
+		InitialDelayMS:    100,
+		IdempotentMethods: []string{"query"},
+	}
+	if _, err := client.Connect(host.NodeID(), config); err != nil {
+		t.Fatalf("Connect failed, unexpected error: %v", err)
+	}
+	if err := client.Subscribe(host.NodeID(), "blockScan"); err != nil {
+		t.Fatalf("Subscribe failed, unexpected error: %v", err)
+	}
+
+	//非幂等的请求在断线时返回连接断开
+	transferResp := make(chan Response, 1)
+	err := client.Call(host.NodeID(), "transfer", nil, false, func(resp Response) {
+		transferResp <- resp
+	})
+	if err != nil {
+		t.Fatalf("Call transfer failed, unexpected error: %v", err)
+	}
+
+	//幂等的请求在重连后重发
+	resp, err := client.CallSync(host.NodeID(), "query", nil)
+	if err != nil {
+		t.Fatalf("CallSync query failed, unexpected error: %v", err)
+	}
+	if resp.Status != StatusSuccess || resp.JsonData().String() != "pong" {
+		t.Errorf("query response = %+v", resp)
+	}
+	if n := atomic.LoadInt32(&queryCalls); n != 2 {
+		t.Errorf("query called %d times, want 2", n)
+	}
+
+	select {
+	case r := <-transferResp:
+		if r.Status != ErrNetworkDisconnected {
+			t.Errorf("transfer response status = %d, want %d", r.Status, ErrNetworkDisconnected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("transfer response timeout")
+	}
+
+	for _, want := range []string{ReconnectStateReconnecting, ReconnectStateReconnected} {
+		select {
+		case e := <-events:
+			if e.State != want {
+				t.Errorf("reconnect event = %+v, want %s", e, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait reconnect event %s timeout", want)
+		}
+	}
+
+	//重连后重新订阅主题
+	if got := host.Subscribers("blockScan"); len(got) != 1 || got[0] != client.NodeID() {
+		t.Errorf("Subscribers after reconnect = %v", got)
+	}
+
+	//服务端关闭，达到最大重连次数后放弃
+	host.Close()
+
+	for _, want := range []string{ReconnectStateReconnecting, ReconnectStateFailed} {
+		select {
+		case e := <-events:
+			if e.State != want {
+				t.Errorf("reconnect event = %+v, want %s", e, want)
+			}
+			if want == ReconnectStateFailed && (e.Attempt != 2 || e.Err == nil) {
+				t.Errorf("failed event = %+v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait reconnect event %s timeout", want)
+		}
+	}
+	if client.IsConnectPeer(host.NodeID()) {
+		t.Errorf("client should be disconnected")
+	}
+}
@@ -0,0 +1,536 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultStreamWindow 数据流默认的发送窗口
+	DefaultStreamWindow = 16
+	// MaxStreamWindow 数据流最大的发送窗口，接收方最多缓存窗口内的乱序数据块
+	MaxStreamWindow = 1024
+)
+
+/*
+数据流响应：
+
+请求方通过CallStream发起请求，处理方通过Context.ResponseStream逐个发送数据块，
+数据块与响应的封装方式一致（协商密码时加密），Req = WSStream，nonce与请求相同，
+结果为 {"seq": 序号（从1开始）, "window": 发送窗口, "data": 数据}。
+数据块在不同的goroutine中处理，可能乱序到达，接收方按序号重新排序。
+
+流量控制：发送方未确认的数据块达到窗口时等待，接收方每处理半个窗口的数据块，
+发送 Req = WSStreamAck 的确认数据包，数据为 {"seq": 已处理的序号, "cancel": 是否取消}。
+
+结束：全部数据块发送后或处理出错时，处理方按普通响应返回 {"chunks": 已发送的数据块数量}，
+结束响应可能先于数据块到达，接收方读取完已发送的数据块后，Next返回io.EOF或处理方的错误。
+
+超时：发送方等待确认，接收方等待数据块，超过数据流超时时间终止数据流。
+*/
+
+// StreamFunc 数据流响应的处理方法，返回错误时终止数据流，错误作为结束响应返回给请求方
+type StreamFunc func(w *StreamWriter) error
+
+// StreamWriter 数据流发送方
+type StreamWriter struct {
+	node    *OWTPNode
+	peer    Peer
+	ctx     *Context
+	key     string
+	window  uint64
+	timeout time.Duration
+
+	mu    sync.Mutex
+	seq   uint64        //已发送的序号
+	acked uint64        //已确认的序号
+	ackCh chan struct{} //收到确认的通知
+	err   error         //取消或连接关闭
+}
+
+// Send 发送数据块，未确认的数据块达到窗口时，等待接收方确认
+func (w *StreamWriter) Send(data interface{}) error {
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	for {
+		w.mu.Lock()
+		if w.err != nil {
+			err := w.err
+			w.mu.Unlock()
+			return err
+		}
+		if w.seq-w.acked < w.window {
+			w.seq++
+			seq := w.seq
+			w.mu.Unlock()
+			return w.send(seq, data)
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.ackCh:
+		case <-timer.C:
+			return fmt.Errorf("stream wait ack timeout over %s", w.timeout.String())
+		}
+	}
+}
+
+// Sent 已发送的数据块数量
+func (w *StreamWriter) Sent() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// send 封装并发送数据块
+func (w *StreamWriter) send(seq uint64, data interface{}) error {
+
+	chunkCtx := *w.ctx
+	chunkCtx.Resp = Response{
+		Status: StatusSuccess,
+		Msg:    "success",
+		Result: map[string]interface{}{
+			"seq":    seq,
+			"window": w.window,
+			"data":   data,
+		},
+	}
+
+	packet := w.node.wrapDataPacketForResponse(w.peer, &chunkCtx)
+	packet.Req = WSStream
+
+	return w.peer.send(*packet)
+}
+
+// ack 接收方确认
+func (w *StreamWriter) ack(seq uint64, cancel bool) {
+	w.mu.Lock()
+	if seq > w.acked {
+		w.acked = seq
+	}
+	if cancel && w.err == nil {
+		w.err = fmt.Errorf("stream has been cancelled by receiver")
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.ackCh <- struct{}{}:
+	default:
+	}
+}
+
+// abort 终止数据流
+func (w *StreamWriter) abort(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.ackCh <- struct{}{}:
+	default:
+	}
+}
+
+// ResponseStream 以数据流响应，fn通过StreamWriter逐个发送数据块，结束后返回数据块数量。
+// 只支持websocket和mq连接。
+func (ctx *Context) ResponseStream(fn StreamFunc) {
+
+	if ctx.node == nil || ctx.Peer == nil {
+		ctx.Response(nil, ErrInternalServerError, "stream response is not supported")
+		return
+	}
+
+	if ctx.Peer.ConnectConfig().ConnectType == HTTP {
+		ctx.Response(nil, ErrBadRequest, "http connection can not response stream")
+		return
+	}
+
+	w := ctx.node.openStreamWriter(ctx)
+	defer ctx.node.removeStreamWriter(w)
+
+	err := fn(w)
+	result := map[string]interface{}{"chunks": w.Sent()}
+	if err != nil {
+		ctx.Response(result, ErrCustomError, err.Error())
+		return
+	}
+
+	ctx.Response(result, StatusSuccess, "success")
+}
+
+// StreamReader 数据流接收方，使用完必须Close
+type StreamReader struct {
+	node    *OWTPNode
+	peer    Peer
+	key     string
+	method  string
+	nonce   uint64
+	timeout time.Duration
+
+	mu     sync.Mutex
+	chunks map[uint64]gjson.Result //乱序到达的数据块
+	next   uint64                  //下一个数据块的序号
+	acked  uint64                  //已确认的序号
+	window uint64                  //发送方窗口
+	resp   *Response               //结束响应
+	err    error                   //终止的原因
+	notify chan struct{}
+}
+
+// Next 按顺序读取下一个数据块，数据流结束返回io.EOF
+func (r *StreamReader) Next() (gjson.Result, error) {
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	for {
+		r.mu.Lock()
+
+		if r.err != nil {
+			err := r.err
+			r.mu.Unlock()
+			return gjson.Result{}, err
+		}
+
+		if chunk, ok := r.chunks[r.next]; ok {
+			delete(r.chunks, r.next)
+			seq := r.next
+			r.next++
+			ack := r.shouldAck(seq)
+			r.mu.Unlock()
+			if ack {
+				r.node.sendStreamAck(r.peer, r.method, r.nonce, seq, false)
+			}
+			return chunk, nil
+		}
+
+		//结束响应可能先于数据块到达，读取完已发送的数据块后才结束
+		if r.resp != nil {
+			resp := *r.resp
+			chunks := resp.JsonData().Get("chunks")
+			if !chunks.Exists() || r.next > chunks.Uint() {
+				if resp.Status != StatusSuccess {
+					r.err = fmt.Errorf("[%d]%s", resp.Status, resp.Msg)
+				} else {
+					r.err = io.EOF
+				}
+			}
+			if r.err != nil {
+				err := r.err
+				r.mu.Unlock()
+				r.node.removeStreamReader(r)
+				return gjson.Result{}, err
+			}
+		}
+
+		r.mu.Unlock()
+
+		select {
+		case <-r.notify:
+		case <-timer.C:
+			err := fmt.Errorf("stream wait chunk timeout over %s", r.timeout.String())
+			r.cancel(err)
+			return gjson.Result{}, err
+		}
+	}
+}
+
+// Response 数据流的结束响应，Next返回io.EOF后有效
+func (r *StreamReader) Response() *Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resp
+}
+
+// Close 关闭数据流，未结束时通知发送方取消
+func (r *StreamReader) Close() {
+	r.cancel(fmt.Errorf("stream has been closed"))
+}
+
+// cancel 终止数据流，未结束时通知发送方取消，移除请求
+func (r *StreamReader) cancel(err error) {
+
+	r.mu.Lock()
+	finished := r.err != nil || r.resp != nil
+	if r.err == nil {
+		r.err = err
+	}
+	seq := r.next - 1
+	r.mu.Unlock()
+
+	r.node.removeStreamReader(r)
+
+	if !finished {
+		r.node.sendStreamAck(r.peer, r.method, r.nonce, seq, true)
+		r.node.serveMux.RemoveRequest(r.peer.PID(), r.nonce)
+	}
+}
+
+// shouldAck 每处理半个窗口的数据块确认一次
+func (r *StreamReader) shouldAck(seq uint64) bool {
+	step := r.window / 2
+	if step == 0 {
+		step = 1
+	}
+	if seq-r.acked >= step {
+		r.acked = seq
+		return true
+	}
+	return false
+}
+
+// push 收到数据块，只缓存发送窗口内的数据块，发送方的窗口超过MaxStreamWindow时终止数据流
+func (r *StreamReader) push(seq, window uint64, data gjson.Result) {
+
+	if window == 0 {
+		window = DefaultStreamWindow
+	}
+	if window > MaxStreamWindow {
+		r.cancel(fmt.Errorf("stream window: %d is over max window: %d", window, MaxStreamWindow))
+		return
+	}
+
+	r.mu.Lock()
+	if r.err == nil {
+		r.window = window
+		if seq >= r.next && seq < r.next+window {
+			r.chunks[seq] = data
+		} else if seq >= r.next {
+			log.Warningf("peer: %s stream chunk seq: %d is out of window [%d, %d)", r.peer.PID(), seq, r.next, r.next+window)
+		}
+	}
+	r.mu.Unlock()
+	r.wakeup()
+}
+
+// finish 收到结束响应，请求队列的回调
+func (r *StreamReader) finish(resp Response) {
+	r.mu.Lock()
+	r.resp = &resp
+	r.mu.Unlock()
+	r.wakeup()
+}
+
+func (r *StreamReader) wakeup() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// CallStream 发起数据流请求，处理方通过Context.ResponseStream响应，
+// 返回的StreamReader按顺序读取数据块，只支持websocket和mq连接。
+func (node *OWTPNode) CallStream(pid string, method string, params interface{}) (*StreamReader, error) {
+
+	peer, err := node.onlinePeerOrConnect(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if peer.ConnectConfig().ConnectType == HTTP {
+		return nil, fmt.Errorf("http connection can not call stream")
+	}
+
+	nonce := node.newNonce()
+	r := &StreamReader{
+		node:    node,
+		peer:    peer,
+		key:     streamKey(peer.PID(), nonce),
+		method:  method,
+		nonce:   nonce,
+		timeout: node.getStreamTimeout(),
+		chunks:  make(map[uint64]gjson.Result),
+		next:    1,
+		window:  DefaultStreamWindow,
+		notify:  make(chan struct{}, 1),
+	}
+
+	//发送前登记，数据块可能在请求返回前到达
+	node.streamMu.Lock()
+	node.streamReaders[r.key] = r
+	node.streamMu.Unlock()
+
+	entry := requestEntry{
+		method: method,
+		h:      r.finish,
+		params: params,
+		stream: true,
+	}
+
+	err = node.sendRequest(peer, nonce, entry)
+	if err != nil {
+		node.removeStreamReader(r)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// receiveStreamChunk 收到数据块
+func (node *OWTPNode) receiveStreamChunk(peer Peer, packet *DataPacket, resp Response) {
+
+	node.streamMu.Lock()
+	r := node.streamReaders[streamKey(peer.PID(), packet.Nonce)]
+	node.streamMu.Unlock()
+
+	if r == nil {
+		log.Warningf("peer: %s stream chunk of nonce: %d is not found", peer.PID(), packet.Nonce)
+		return
+	}
+
+	if resp.Status != StatusSuccess {
+		log.Errorf("peer: %s stream chunk of nonce: %d decode failed: %s", peer.PID(), packet.Nonce, resp.Msg)
+		r.cancel(fmt.Errorf("[%d]%s", resp.Status, resp.Msg))
+		return
+	}
+
+	//数据流传输中，刷新请求的超时时间
+	node.serveMux.touchRequest(peer.PID(), packet.Nonce)
+
+	result := resp.JsonData()
+	r.push(result.Get("seq").Uint(), result.Get("window").Uint(), result.Get("data"))
+}
+
+// receiveStreamAck 收到接收方的确认
+func (node *OWTPNode) receiveStreamAck(peer Peer, packet *DataPacket) {
+
+	if peer.auth() != nil && !peer.auth().VerifySignature(packet) {
+		log.Errorf("peer: %s stream ack verify signature failed", peer.PID())
+		return
+	}
+
+	node.streamMu.Lock()
+	w := node.streamWriters[streamKey(peer.PID(), packet.Nonce)]
+	node.streamMu.Unlock()
+
+	if w == nil {
+		return
+	}
+
+	data, _ := packet.Data.(string)
+	ack := gjson.Parse(data)
+	w.ack(ack.Get("seq").Uint(), ack.Get("cancel").Bool())
+}
+
+// sendStreamAck 发送确认
+func (node *OWTPNode) sendStreamAck(peer Peer, method string, nonce uint64, seq uint64, cancel bool) {
+
+	packet := DataPacket{
+		Method:    method,
+		Req:       WSStreamAck,
+		Nonce:     nonce,
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"seq": seq, "cancel": cancel},
+		Version:   CurrentDataPacketVersion,
+	}
+
+	if peer.auth() != nil && !peer.auth().GenerateSignature(&packet) {
+		log.Errorf("peer: %s stream ack generate signature failed", peer.PID())
+		return
+	}
+
+	if err := peer.send(packet); err != nil {
+		log.Errorf("peer: %s send stream ack failed, unexpected error: %v", peer.PID(), err)
+	}
+}
+
+// openStreamWriter 登记数据流发送方
+func (node *OWTPNode) openStreamWriter(ctx *Context) *StreamWriter {
+
+	window := node.streamWindow
+	if window <= 0 {
+		window = DefaultStreamWindow
+	} else if window > MaxStreamWindow {
+		window = MaxStreamWindow
+	}
+
+	w := &StreamWriter{
+		node:    node,
+		peer:    ctx.Peer,
+		ctx:     ctx,
+		key:     streamKey(ctx.PID, ctx.nonce),
+		window:  uint64(window),
+		timeout: node.getStreamTimeout(),
+		ackCh:   make(chan struct{}, 1),
+	}
+
+	node.streamMu.Lock()
+	node.streamWriters[w.key] = w
+	node.streamMu.Unlock()
+
+	return w
+}
+
+// removeStreamWriter 移除数据流发送方
+func (node *OWTPNode) removeStreamWriter(w *StreamWriter) {
+	node.streamMu.Lock()
+	defer node.streamMu.Unlock()
+	if node.streamWriters[w.key] == w {
+		delete(node.streamWriters, w.key)
+	}
+}
+
+// removeStreamReader 移除数据流接收方
+func (node *OWTPNode) removeStreamReader(r *StreamReader) {
+	node.streamMu.Lock()
+	defer node.streamMu.Unlock()
+	if node.streamReaders[r.key] == r {
+		delete(node.streamReaders, r.key)
+	}
+}
+
+// closeStreamWriters 连接关闭，终止发送给节点的数据流，接收的数据流由请求队列返回连接断开
+func (node *OWTPNode) closeStreamWriters(pid string) {
+
+	node.streamMu.Lock()
+	writers := make([]*StreamWriter, 0)
+	for _, w := range node.streamWriters {
+		if w.peer.PID() == pid {
+			writers = append(writers, w)
+		}
+	}
+	node.streamMu.Unlock()
+
+	for _, w := range writers {
+		w.abort(fmt.Errorf("peer has been closed"))
+	}
+}
+
+// getStreamTimeout 数据流超时时间，默认与请求超时时间一致
+func (node *OWTPNode) getStreamTimeout() time.Duration {
+	if node.streamTimeout > 0 {
+		return node.streamTimeout
+	}
+	if node.timeoutSEC > 0 {
+		return time.Duration(node.timeoutSEC) * time.Second
+	}
+	return DefaultTimoutSEC * time.Second
+}
+
+func streamKey(pid string, nonce uint64) string {
+	return fmt.Sprintf("%s_%d", pid, nonce)
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCallStream(t *testing.T) {
+
+	//窗口小于数据块数量，发送方需要等待确认
+	host := NewNode(NodeConfig{StreamWindow: 4, StreamTimeoutSEC: 1})
+	defer host.Close()
+
+	sendErrs := make(chan error, 10)
+	host.HandleFunc("listAddresses", func(ctx *Context) {
+		total := ctx.Params().Get("total").Int()
+		failAt := ctx.Params().Get("failAt").Int()
+		ctx.ResponseStream(func(w *StreamWriter) error {
+			for i := int64(0); i < total; i++ {
+				if failAt > 0 && i == failAt {
+					return fmt.Errorf("address index %d is broken", i)
+				}
+				if err := w.Send(map[string]interface{}{"index": i}); err != nil {
+					sendErrs <- err
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	config := ConnectConfig{Address: "127.0.0.1:8435", ConnectType: Websocket, EnableSignature: true}
+	if err := host.Listen(config); err != nil {
+		t.Fatalf("Listen failed, unexpected error: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf} {
+
+		client := RandomOWTPNode()
+		config.Encoding = encoding
+		config.EnableKeyAgreement = true
+		if _, err := client.Connect(host.NodeID(), config); err != nil {
+			t.Fatalf("%s Connect failed, unexpected error: %v", encoding, err)
+		}
+
+		//按顺序读取全部数据块
+		stream, err := client.CallStream(host.NodeID(), "listAddresses", map[string]interface{}{"total": 100})
+		if err != nil {
+			t.Fatalf("%s CallStream failed, unexpected error: %v", encoding, err)
+		}
+		count := int64(0)
+		for {
+			chunk, err := stream.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s Next failed, unexpected error: %v", encoding, err)
+			}
+			if chunk.Get("index").Int() != count {
+				t.Fatalf("%s chunk = %s, want index %d", encoding, chunk.Raw, count)
+			}
+			count++
+		}
+		stream.Close()
+		if count != 100 {
+			t.Errorf("%s received %d chunks, want 100", encoding, count)
+		}
+		if resp := stream.Response(); resp == nil || resp.JsonData().Get("chunks").Int() != 100 {
+			t.Errorf("%s stream response = %+v", encoding, resp)
+		}
+
+		client.Close()
+	}
+
+	client := RandomOWTPNode()
+	defer client.Close()
+	config.Encoding = EncodingJSON
+	if _, err := client.Connect(host.NodeID(), config); err != nil {
+		t.Fatalf("Connect failed, unexpected error: %v", err)
+	}
+
+	//处理方出错，已发送的数据块读取后返回错误
+	stream, err := client.CallStream(host.NodeID(), "listAddresses", map[string]interface{}{"total": 10, "failAt": 3})
+	if err != nil {
+		t.Fatalf("CallStream failed, unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := stream.Next(); err != nil {
+			t.Fatalf("Next %d failed, unexpected error: %v", i, err)
+		}
+	}
+	if _, err := stream.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next should fail after handler error, got: %v", err)
+	}
+	stream.Close()
+
+	//接收方取消，发送方停止发送
+	stream, err = client.CallStream(host.NodeID(), "listAddresses", map[string]interface{}{"total": 1000})
+	if err != nil {
+		t.Fatalf("CallStream failed, unexpected error: %v", err)
+	}
+	if _, err := stream.Next(); err != nil {
+		t.Fatalf("Next failed, unexpected error: %v", err)
+	}
+	stream.Close()
+	select {
+	case err := <-sendErrs:
+		if err == nil {
+			t.Errorf("Send should fail after receiver cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait sender stopped timeout")
+	}
+
+	//接收方不再读取，发送方等待确认超时
+	stream, err = client.CallStream(host.NodeID(), "listAddresses", map[string]interface{}{"total": 1000})
+	if err != nil {
+		t.Fatalf("CallStream failed, unexpected error: %v", err)
+	}
+	select {
+	case err := <-sendErrs:
+		if err == nil {
+			t.Errorf("Send should timeout without ack")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait sender timeout")
+	}
+	stream.Close()
+}
+
+type testStreamPeer struct {
+	Peer
+}
+
+func (p testStreamPeer) PID() string {
+	return "testStreamPeer"
+}
+
+func TestStreamReader_PushWindow(t *testing.T) {
+
+	node := RandomOWTPNode()
+	defer node.Close()
+
+	reader := &StreamReader{
+		node:   node,
+		peer:   testStreamPeer{},
+		chunks: make(map[uint64]gjson.Result),
+		next:   1,
+		notify: make(chan struct{}, 1),
+	}
+
+	//窗口内的数据块保存，窗口外和已读取的丢弃
+	reader.push(1, 4, gjson.Parse(`1`))
+	reader.push(4, 4, gjson.Parse(`4`))
+	reader.push(5, 4, gjson.Parse(`5`))
+	reader.push(1<<40, 4, gjson.Parse(`0`))
+	reader.push(0, 4, gjson.Parse(`0`))
+	if len(reader.chunks) != 2 {
+		t.Errorf("stored chunks = %d, want 2", len(reader.chunks))
+	}
+	if _, ok := reader.chunks[5]; ok {
+		t.Errorf("chunk 5 is out of window and should be dropped")
+	}
+
+	//窗口为0使用默认窗口
+	reader.push(DefaultStreamWindow, 0, gjson.Parse(`16`))
+	if _, ok := reader.chunks[DefaultStreamWindow]; !ok || reader.window != DefaultStreamWindow {
+		t.Errorf("chunk in default window should be stored, window = %d", reader.window)
+	}
+
+	//窗口超过上限，终止数据流
+	reader.resp = &Response{}
+	reader.push(2, MaxStreamWindow+1, gjson.Parse(`2`))
+	if reader.err == nil {
+		t.Errorf("stream should be cancelled when window is over max")
+	}
+	if _, ok := reader.chunks[2]; ok {
+		t.Errorf("chunk should not be stored after stream cancelled")
+	}
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// 主题订阅的方法，订阅和取消订阅会执行prepare和finish，服务端可以在prepare中校验订阅权限
+const (
+
+	//订阅主题，参数：{"topics": ["topic"]}
+	SubscribeMethod = "internal_subscribe"
+
+	//取消订阅，参数：{"topics": ["topic"]}
+	UnsubscribeMethod = "internal_unsubscribe"
+
+	//推送主题消息，参数：{"topic": "topic", "data": {}}
+	PublishMethod = "internal_publish"
+)
+
+const (
+	//对方节点订阅了我方的主题，连接关闭时清除
+	topicSubscribersKey = "internal_key_topicSubscribers"
+	//我方向对方节点订阅的主题，断线重连后重新订阅
+	topicSubscriptionsKey = "internal_key_topicSubscriptions"
+)
+
+// TopicHandlerFunc 主题消息的处理方法，开启确认的推送，返回的错误会作为失败结果响应给发布方
+type TopicHandlerFunc func(pid string, topic string, data gjson.Result) error
+
+// PublishResult 推送结果，没有开启确认时，只包含发送的错误
+type PublishResult struct {
+	PID string //订阅节点
+	Err error  //推送失败的原因
+}
+
+// Subscribe 向节点订阅主题，节点推送的消息由HandleTopic设置的方法处理。
+// 订阅只对websocket和mq的长连接有效，开启断线重连时，重连成功后会重新订阅。
+func (node *OWTPNode) Subscribe(pid string, topics ...string) error {
+
+	if len(topics) == 0 {
+		return fmt.Errorf("topics is empty")
+	}
+
+	if err := node.callTopics(pid, SubscribeMethod, topics); err != nil {
+		return err
+	}
+
+	node.updateTopics(pid, topicSubscriptionsKey, topics, true)
+
+	return nil
+}
+
+// Unsubscribe 取消向节点订阅的主题
+func (node *OWTPNode) Unsubscribe(pid string, topics ...string) error {
+
+	if len(topics) == 0 {
+		return fmt.Errorf("topics is empty")
+	}
+
+	if err := node.callTopics(pid, UnsubscribeMethod, topics); err != nil {
+		return err
+	}
+
+	node.updateTopics(pid, topicSubscriptionsKey, topics, false)
+
+	return nil
+}
+
+// Subscriptions 我方向节点订阅的主题
+func (node *OWTPNode) Subscriptions(pid string) []string {
+	return node.getTopics(pid, topicSubscriptionsKey)
+}
+
+// HandleTopic 设置主题消息的处理方法
+func (node *OWTPNode) HandleTopic(topic string, handler TopicHandlerFunc) {
+	node.topicMu.Lock()
+	defer node.topicMu.Unlock()
+	node.topicHandlers[topic] = handler
+}
+
+// Subscribers 订阅了主题的在线节点
+func (node *OWTPNode) Subscribers(topic string) []string {
+
+	subscribers := make([]string, 0)
+	for _, peer := range node.OnlinePeers() {
+		for _, t := range node.getTopics(peer.PID(), topicSubscribersKey) {
+			if t == topic {
+				subscribers = append(subscribers, peer.PID())
+				break
+			}
+		}
+	}
+
+	sort.Strings(subscribers)
+
+	return subscribers
+}
+
+// Publish 向订阅了主题的节点推送消息。
+// ack = true，等待所有订阅节点的确认，结果包含每个节点处理失败的原因；
+// ack = false，发送后不等待确认，结果只包含发送失败的原因。
+func (node *OWTPNode) Publish(topic string, data interface{}, ack bool) []PublishResult {
+
+	var (
+		wg          sync.WaitGroup
+		params      = map[string]interface{}{"topic": topic, "data": data}
+		subscribers = node.Subscribers(topic)
+		results     = make([]PublishResult, len(subscribers))
+	)
+
+	for i, pid := range subscribers {
+
+		results[i].PID = pid
+
+		wg.Add(1)
+		go func(r *PublishResult) {
+			defer wg.Done()
+
+			err := node.Call(r.PID, PublishMethod, params, ack, func(resp Response) {
+				if ack && resp.Status != StatusSuccess {
+					r.Err = fmt.Errorf("[%d]%s", resp.Status, resp.Msg)
+				}
+			})
+			if err != nil {
+				r.Err = err
+			}
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// callTopics 发送订阅或取消订阅的请求
+func (node *OWTPNode) callTopics(pid, method string, topics []string) error {
+
+	resp, err := node.CallSync(pid, method, map[string]interface{}{"topics": topics})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != StatusSuccess {
+		return fmt.Errorf("[%d]%s", resp.Status, resp.Msg)
+	}
+
+	return nil
+}
+
+// resubscribe 断线重连后重新订阅主题
+func (node *OWTPNode) resubscribe(pid string) error {
+
+	topics := node.getTopics(pid, topicSubscriptionsKey)
+	if len(topics) == 0 {
+		return nil
+	}
+
+	return node.callTopics(pid, SubscribeMethod, topics)
+}
+
+// clearSubscribers 连接关闭，清除对方节点的订阅
+func (node *OWTPNode) clearSubscribers(pid string) {
+	node.topicMu.Lock()
+	defer node.topicMu.Unlock()
+	node.Peerstore().Delete(pid, topicSubscribersKey)
+}
+
+// subscribeReceived 处理对方节点的订阅请求
+func (node *OWTPNode) subscribeReceived(ctx *Context) {
+	node.handleTopics(ctx, true)
+}
+
+// unsubscribeReceived 处理对方节点的取消订阅请求
+func (node *OWTPNode) unsubscribeReceived(ctx *Context) {
+	node.handleTopics(ctx, false)
+}
+
+// handleTopics 更新对方节点订阅的主题
+func (node *OWTPNode) handleTopics(ctx *Context, subscribe bool) {
+
+	topics := make([]string, 0)
+	for _, t := range ctx.Params().Get("topics").Array() {
+		if len(t.String()) > 0 {
+			topics = append(topics, t.String())
+		}
+	}
+
+	if len(topics) == 0 {
+		ctx.Response(nil, ErrBadRequest, "topics is empty")
+		return
+	}
+
+	//HTTP是短连接，无法推送消息
+	if ctx.Peer != nil && ctx.Peer.ConnectConfig().ConnectType == HTTP {
+		ctx.Response(nil, ErrBadRequest, "http connection can not subscribe topics")
+		return
+	}
+
+	node.updateTopics(ctx.PID, topicSubscribersKey, topics, subscribe)
+
+	ctx.Response(map[string]interface{}{
+		"topics": node.getTopics(ctx.PID, topicSubscribersKey),
+	}, StatusSuccess, "success")
+}
+
+// publishReceived 处理对方节点推送的主题消息，只接受我方向该节点订阅了的主题
+func (node *OWTPNode) publishReceived(ctx *Context) {
+
+	topic := ctx.Params().Get("topic").String()
+
+	subscribed := false
+	for _, t := range node.Subscriptions(ctx.PID) {
+		if t == topic {
+			subscribed = true
+			break
+		}
+	}
+
+	if !subscribed {
+		ctx.Response(nil, ErrUnauthorized, fmt.Sprintf("topic: %s is not subscribed", topic))
+		return
+	}
+
+	node.topicMu.RLock()
+	handler := node.topicHandlers[topic]
+	node.topicMu.RUnlock()
+
+	if handler == nil {
+		ctx.Response(nil, ErrNotFoundMethod, fmt.Sprintf("can not find topic: %s handler", topic))
+		return
+	}
+
+	err := handler(ctx.PID, topic, ctx.Params().Get("data"))
+	if err != nil {
+		ctx.Response(nil, ErrCustomError, err.Error())
+		return
+	}
+
+	ctx.Response(nil, StatusSuccess, "success")
+}
+
+// getTopics 读取节点存储的主题
+func (node *OWTPNode) getTopics(pid, key string) []string {
+
+	topics := make([]string, 0)
+	b := node.Peerstore().GetString(pid, key)
+	if len(b) == 0 {
+		return topics
+	}
+
+	if err := json.Unmarshal([]byte(b), &topics); err != nil {
+		return make([]string, 0)
+	}
+
+	return topics
+}
+
+// updateTopics 添加或移除节点存储的主题，主题列表以json文本保存，兼容各种Peerstore
+func (node *OWTPNode) updateTopics(pid, key string, topics []string, add bool) {
+
+	node.topicMu.Lock()
+	defer node.topicMu.Unlock()
+
+	set := make(map[string]bool)
+	for _, t := range node.getTopics(pid, key) {
+		set[t] = true
+	}
+	for _, t := range topics {
+		if add {
+			set[t] = true
+		} else {
+			delete(set, t)
+		}
+	}
+
+	if len(set) == 0 {
+		node.Peerstore().Delete(pid, key)
+		return
+	}
+
+	list := make([]string, 0, len(set))
+	for t := range set {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+
+	b, _ := json.Marshal(list)
+	node.Peerstore().Put(pid, key, string(b))
+}
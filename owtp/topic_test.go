@@ -0,0 +1,141 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTopicPublish(t *testing.T) {
+
+	host := RandomOWTPNode()
+	defer host.Close()
+
+	config := ConnectConfig{Address: "127.0.0.1:8434", ConnectType: Websocket, EnableSignature: true}
+	if err := host.Listen(config); err != nil {
+		t.Fatalf("Listen failed, unexpected error: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	received := make(chan string, 10)
+	clients := make([]*OWTPNode, 2)
+	for i := range clients {
+		client := RandomOWTPNode()
+		defer client.Close()
+		name := fmt.Sprintf("client%d", i)
+		client.HandleTopic("blockScan", func(pid string, topic string, data gjson.Result) error {
+			if data.Get("height").Uint() == 0 {
+				return fmt.Errorf("%s: invalid height", name)
+			}
+			received <- fmt.Sprintf("%s:%d", name, data.Get("height").Uint())
+			return nil
+		})
+		if _, err := client.Connect(host.NodeID(), config); err != nil {
+			t.Fatalf("Connect failed, unexpected error: %v", err)
+		}
+		if err := client.Subscribe(host.NodeID(), "blockScan", "txFinalized"); err != nil {
+			t.Fatalf("Subscribe failed, unexpected error: %v", err)
+		}
+		clients[i] = client
+	}
+
+	if got := clients[0].Subscriptions(host.NodeID()); !reflect.DeepEqual(got, []string{"blockScan", "txFinalized"}) {
+		t.Errorf("Subscriptions = %v", got)
+	}
+	if got := host.Subscribers("blockScan"); len(got) != 2 {
+		t.Fatalf("Subscribers = %v, want 2 peers", got)
+	}
+
+	//开启确认，等待所有订阅节点处理完成
+	results := host.Publish("blockScan", map[string]interface{}{"height": 100}, true)
+	if len(results) != 2 {
+		t.Fatalf("Publish results = %+v", results)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("Publish to %s failed, unexpected error: %v", r.PID, r.Err)
+		}
+	}
+	if len(received) != 2 {
+		t.Errorf("received %d messages, want 2", len(received))
+	}
+	for len(received) > 0 {
+		<-received
+	}
+
+	//处理失败的结果返回给发布方
+	for _, r := range host.Publish("blockScan", map[string]interface{}{"height": 0}, true) {
+		if r.Err == nil {
+			t.Errorf("Publish to %s should fail", r.PID)
+		}
+	}
+
+	//没有处理方法的主题
+	for _, r := range host.Publish("txFinalized", "tx", true) {
+		if r.Err == nil {
+			t.Errorf("Publish txFinalized to %s should fail without handler", r.PID)
+		}
+	}
+
+	//取消订阅
+	if err := clients[1].Unsubscribe(host.NodeID(), "blockScan"); err != nil {
+		t.Fatalf("Unsubscribe failed, unexpected error: %v", err)
+	}
+	if got := host.Subscribers("blockScan"); !reflect.DeepEqual(got, []string{clients[0].NodeID()}) {
+		t.Errorf("Subscribers after unsubscribe = %v", got)
+	}
+
+	//拒绝没有订阅的主题
+	resp, err := host.CallSync(clients[1].NodeID(), PublishMethod, map[string]interface{}{"topic": "blockScan", "data": map[string]interface{}{"height": 100}})
+	if err != nil || resp.Status != ErrUnauthorized {
+		t.Errorf("publish unsubscribed topic status = %d, err: %v", resp.Status, err)
+	}
+
+	//不等待确认
+	results = host.Publish("blockScan", map[string]interface{}{"height": 101}, false)
+	if len(results) != 1 || results[0].Err != nil {
+		t.Errorf("Publish without ack results = %+v", results)
+	}
+	select {
+	case msg := <-received:
+		if msg != "client0:101" {
+			t.Errorf("received = %s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait published message timeout")
+	}
+
+	//连接关闭后清除订阅
+	if host.Peerstore().GetString(clients[0].NodeID(), topicSubscribersKey) == "" {
+		t.Errorf("subscribers should be saved in peerstore")
+	}
+	clients[0].ClosePeer(host.NodeID())
+	time.Sleep(500 * time.Millisecond)
+	if host.Peerstore().GetString(clients[0].NodeID(), topicSubscribersKey) != "" {
+		t.Errorf("subscribers should be cleared after peer closed")
+	}
+	if got := host.Subscribers("blockScan"); len(got) != 0 {
+		t.Errorf("Subscribers after close = %v", got)
+	}
+	if got := host.Publish("blockScan", map[string]interface{}{"height": 102}, true); len(got) != 0 {
+		t.Errorf("Publish without subscribers results = %+v", got)
+	}
+}
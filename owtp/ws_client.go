@@ -16,14 +16,12 @@
 package owtp
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/gorilla/websocket"
 	"github.com/mr-tron/base58/base58"
-	"github.com/tidwall/gjson"
 	"net"
 	"net/http"
 	"sync"
@@ -95,6 +93,7 @@ func Dial(
 	if err != nil {
 		return nil, err
 	}
+	client.config.Encoding = httpHeader.Get(EncodingHeader)
 
 	client.isConnect = true
 	client.isHost = true //我方主动连接
@@ -112,6 +111,7 @@ func NewWSClientWithHeader(header http.Header, cert Certificate, conn *websocket
 		| n        | uint32 | (websocket必填) | 请求序号。为了保证请求对应响应按序执行，并防御重放攻击，序号可以为随机数，但不可重复。 |
 		| t        | uint32 | (websocket必填) | 时间戳。限制请求在特定时间范围内有效，如10分钟。                                     |
 		| s        | string | (websocket必填) | 组合[a+n+t]并sha256两次，使用钱包工具配置的本地私钥签名，最后base58编码         |
+		| e        | string | 是              | 数据包编码，json，protobuf，为空使用json                                            |
 	*/
 
 	var (
@@ -127,6 +127,11 @@ func NewWSClientWithHeader(header http.Header, cert Certificate, conn *websocket
 
 	a := header.Get("a")
 
+	encoding, err := normalizeEncoding(header.Get(EncodingHeader))
+	if err != nil {
+		return nil, err
+	}
+
 	if len(a) == 0 {
 
 		//HTTP的节点ID都采用随机生成，因为是短连接
@@ -158,6 +163,10 @@ func NewWSClientWithHeader(header http.Header, cert Certificate, conn *websocket
 	}
 
 	client, err := NewWSClient(auth.RemotePID(), conn, handler, auth, done)
+	if err != nil {
+		return nil, err
+	}
+	client.config.Encoding = encoding
 
 	return client, nil
 }
@@ -262,7 +271,7 @@ func (c *WSClient) RemoteAddr() net.Addr {
 func (c *WSClient) send(data DataPacket) error {
 
 	//log.Emergency("Send DataPacket:", data)
-	respBytes, err := json.Marshal(data)
+	respBytes, err := EncodeDataPacket(&data, c.config.Encoding)
 	if err != nil {
 		return err
 	}
@@ -315,7 +324,12 @@ func (c *WSClient) writePump() {
 			if Debug {
 				log.Debug("Send: ", string(message))
 			}
-			if err := c.write(websocket.TextMessage, message); err != nil {
+			//protobuf编码使用二进制消息
+			mt := websocket.TextMessage
+			if c.config.Encoding == EncodingProtobuf {
+				mt = websocket.BinaryMessage
+			}
+			if err := c.write(mt, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -350,7 +364,7 @@ func (c *WSClient) readPump() {
 	}()
 
 	for {
-		_, message, err := c.ws.ReadMessage()
+		mt, message, err := c.ws.ReadMessage()
 		if err != nil {
 			log.Error("peer:", c.PID(), "Read unexpected error: ", err)
 			//close(c.send) //读取通道异常，关闭读通道
@@ -361,7 +375,16 @@ func (c *WSClient) readPump() {
 			log.Debug("Read: ", string(message))
 		}
 
-		packet := NewDataPacket(gjson.ParseBytes(message))
+		//二进制消息为protobuf编码，文本消息为json编码
+		encoding := EncodingJSON
+		if mt == websocket.BinaryMessage {
+			encoding = EncodingProtobuf
+		}
+		packet, err := DecodeDataPacket(message, encoding)
+		if err != nil {
+			log.Error("peer:", c.PID(), "decode data packet unexpected error: ", err)
+			continue
+		}
 
 		//开一个goroutine处理消息
 		go c.handler.OnPeerNewDataPacketReceived(c, packet)